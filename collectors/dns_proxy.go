@@ -0,0 +1,496 @@
+package collectors
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/processors"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+type DnsProxy struct {
+	doneRun      chan bool
+	stopRun      chan bool
+	doneUdp      chan bool
+	udpConn      *net.UDPConn
+	listen       net.Listener
+	conns        []net.Conn
+	wgConns      sync.WaitGroup
+	wgQueries    sync.WaitGroup
+	loggers      []dnsutils.Worker
+	config       *dnsutils.Config
+	configChan   chan *dnsutils.Config
+	logger       *logger.Logger
+	name         string
+	identity     string
+	upstreams    []string
+	timeout      time.Duration
+	idleTimeout  time.Duration
+	querySlots   chan struct{}
+	connId       int
+	dnsProcessor processors.DnsProcessor
+	sync.RWMutex
+}
+
+func NewDnsProxy(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *DnsProxy {
+	logger.Info("[%s] collector=dnsproxy - enabled", name)
+	s := &DnsProxy{
+		doneRun:    make(chan bool),
+		stopRun:    make(chan bool),
+		doneUdp:    make(chan bool),
+		config:     config,
+		configChan: make(chan *dnsutils.Config),
+		loggers:    loggers,
+		logger:     logger,
+		name:       name,
+	}
+	s.ReadConfig()
+	return s
+}
+
+func (c *DnsProxy) GetName() string { return c.name }
+
+func (c *DnsProxy) SetLoggers(loggers []dnsutils.Worker) {
+	c.loggers = loggers
+}
+
+func (c *DnsProxy) Loggers() ([]chan dnsutils.DnsMessage, []string) {
+	channels := []chan dnsutils.DnsMessage{}
+	names := []string{}
+	for _, p := range c.loggers {
+		channels = append(channels, p.Channel())
+		names = append(names, p.GetName())
+	}
+	return channels, names
+}
+
+func (c *DnsProxy) ReadConfig() {
+	if len(c.config.Collectors.DnsProxy.Upstreams) == 0 {
+		c.logger.Fatal("collector=dnsproxy - no upstream configured")
+	}
+	for _, upstream := range c.config.Collectors.DnsProxy.Upstreams {
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			c.logger.Fatal("collector=dnsproxy - invalid upstream: ", upstream)
+		}
+	}
+	if c.config.Collectors.DnsProxy.Timeout <= 0 {
+		c.logger.Fatal("collector=dnsproxy - invalid timeout")
+	}
+	if c.config.Collectors.DnsProxy.MaxQueries < 1 {
+		c.logger.Fatal("collector=dnsproxy - max-concurrent-queries must be greater than 0")
+	}
+	if c.config.Collectors.DnsProxy.IdleTimeout <= 0 {
+		c.logger.Fatal("collector=dnsproxy - invalid idle timeout")
+	}
+
+	c.Lock()
+	c.identity = c.config.GetServerIdentity()
+	c.upstreams = c.config.Collectors.DnsProxy.Upstreams
+	c.timeout = time.Duration(c.config.Collectors.DnsProxy.Timeout) * time.Second
+	c.idleTimeout = time.Duration(c.config.Collectors.DnsProxy.IdleTimeout) * time.Second
+	c.Unlock()
+}
+
+func (c *DnsProxy) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.configChan <- config
+}
+
+func (c *DnsProxy) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] collector=dnsproxy - "+msg, v...)
+}
+
+func (c *DnsProxy) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] collector=dnsproxy - "+msg, v...)
+}
+
+func (c *DnsProxy) LogConnInfo(connId int, msg string, v ...interface{}) {
+	prefix := fmt.Sprintf("[%s] collector=dnsproxy#%d - ", c.name, connId)
+	c.logger.Info(prefix+msg, v...)
+}
+
+func (c *DnsProxy) LogConnError(connId int, msg string, v ...interface{}) {
+	prefix := fmt.Sprintf("[%s] collector=dnsproxy#%d - ", c.name, connId)
+	c.logger.Error(prefix+msg, v...)
+}
+
+// Record sends the dns payload to the processor, addresses are provided in the
+// capture order, from the sender to the receiver of the payload
+func (c *DnsProxy) Record(payload []byte, protocol string, src net.Addr, dst net.Addr, ts time.Time, latency time.Duration) {
+	c.RLock()
+	identity := c.identity
+	c.RUnlock()
+
+	dm := dnsutils.DnsMessage{}
+	dm.Init()
+
+	dm.DnsTap.Identity = identity
+	dm.DnsTap.TimeSec = int(ts.Unix())
+	dm.DnsTap.TimeNsec = ts.Nanosecond()
+	dm.DnsTap.Latency = latency.Seconds()
+
+	srcIp, srcPort, _ := net.SplitHostPort(src.String())
+	dstIp, dstPort, _ := net.SplitHostPort(dst.String())
+	dm.NetworkInfo.Family = dnsutils.PROTO_IPV4
+	if ip := net.ParseIP(srcIp); ip != nil && ip.To4() == nil {
+		dm.NetworkInfo.Family = dnsutils.PROTO_IPV6
+	}
+	dm.NetworkInfo.Protocol = protocol
+	dm.NetworkInfo.QueryIp = srcIp
+	dm.NetworkInfo.QueryPort = srcPort
+	dm.NetworkInfo.ResponseIp = dstIp
+	dm.NetworkInfo.ResponsePort = dstPort
+
+	dm.DNS.Payload = payload
+	dm.DNS.Length = len(payload)
+
+	c.dnsProcessor.GetChannel() <- dm
+}
+
+// Exchange forwards the query to the upstreams, in the configured order,
+// until a reply is received
+func (c *DnsProxy) Exchange(network string, query []byte) ([]byte, error) {
+	c.RLock()
+	upstreams := c.upstreams
+	timeout := c.timeout
+	c.RUnlock()
+
+	var err error
+	var reply []byte
+	for _, upstream := range upstreams {
+		reply, err = c.ExchangeUpstream(network, upstream, query, timeout)
+		if err == nil {
+			return reply, nil
+		}
+		c.LogError("upstream %s/%s error: %s", network, upstream, err)
+	}
+	return nil, err
+}
+
+func (c *DnsProxy) ExchangeUpstream(network string, upstream string, query []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout(network, upstream, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if network == dnsutils.SOCKET_TCP {
+		if err := writeTcpDns(conn, query); err != nil {
+			return nil, err
+		}
+		return readTcpDns(conn)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	// ignore replies with an unexpected id
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n >= 2 && len(query) >= 2 && buf[0] == query[0] && buf[1] == query[1] {
+			reply := make([]byte, n)
+			copy(reply, buf[:n])
+			return reply, nil
+		}
+	}
+}
+
+// ServerFailure builds a SERVFAIL reply for the query,
+// returns nil if the query can not be decoded
+func ServerFailure(query []byte) []byte {
+	req := new(dns.Msg)
+	if err := req.Unpack(query); err != nil {
+		return nil
+	}
+	reply := new(dns.Msg)
+	reply.SetRcode(req, dns.RcodeServerFailure)
+	payload, err := reply.Pack()
+	if err != nil {
+		return nil
+	}
+	return payload
+}
+
+func readTcpDns(conn net.Conn) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func writeTcpDns(conn net.Conn, payload []byte) error {
+	data := make([]byte, 2+len(payload))
+	binary.BigEndian.PutUint16(data, uint16(len(payload)))
+	copy(data[2:], payload)
+	_, err := conn.Write(data)
+	return err
+}
+
+func (c *DnsProxy) HandleUdpQuery(query []byte, client *net.UDPAddr, recvAt time.Time) {
+	defer c.wgQueries.Done()
+
+	// release the slot of the query
+	defer func() { <-c.querySlots }()
+
+	local := c.udpConn.LocalAddr()
+	c.Record(query, dnsutils.PROTO_UDP, client, local, recvAt, 0)
+
+	reply, err := c.Exchange(dnsutils.SOCKET_UDP, query)
+	if err != nil {
+		reply = ServerFailure(query)
+	}
+	if reply == nil {
+		return
+	}
+
+	if _, err := c.udpConn.WriteToUDP(reply, client); err != nil {
+		c.LogError("write error to %s: %s", client, err)
+		return
+	}
+	sentAt := time.Now()
+	c.Record(reply, dnsutils.PROTO_UDP, local, client, sentAt, sentAt.Sub(recvAt))
+}
+
+func (c *DnsProxy) ServeUdp() {
+	buf := make([]byte, 65535)
+	for {
+		n, client, err := c.udpConn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				c.LogError("udp read error: %s", err)
+			}
+			break
+		}
+		recvAt := time.Now()
+
+		// ignore packets too short to be a dns query
+		if n < 12 {
+			continue
+		}
+
+		// copy the query from buffer
+		query := make([]byte, n)
+		copy(query, buf[:n])
+
+		// wait a free slot, the next queries are kept in the socket buffer in the meantime
+		c.querySlots <- struct{}{}
+		c.wgQueries.Add(1)
+		go c.HandleUdpQuery(query, client, recvAt)
+	}
+	c.LogInfo("udp listener terminated")
+	c.doneUdp <- true
+}
+
+func (c *DnsProxy) HandleConn(conn net.Conn) {
+	defer c.wgConns.Done()
+
+	// close connection on function exit
+	defer conn.Close()
+
+	var connId int
+	c.Lock()
+	c.connId++
+	connId = c.connId
+	c.Unlock()
+
+	// get peer address
+	peer := conn.RemoteAddr().String()
+	c.LogConnInfo(connId, "new connection from %s", peer)
+
+	for {
+		// the complete query must be received before the idle timeout
+		c.RLock()
+		idleTimeout := c.idleTimeout
+		c.RUnlock()
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+
+		query, err := readTcpDns(conn)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				c.LogConnInfo(connId, "connection closed with peer %s", peer)
+			} else if errors.Is(err, os.ErrDeadlineExceeded) {
+				c.LogConnInfo(connId, "idle connection closed with peer %s", peer)
+			} else {
+				c.LogConnError(connId, "read error: %s", err)
+			}
+			break
+		}
+		recvAt := time.Now()
+		c.Record(query, dnsutils.PROTO_TCP, conn.RemoteAddr(), conn.LocalAddr(), recvAt, 0)
+
+		reply, err := c.Exchange(dnsutils.SOCKET_TCP, query)
+		if err != nil {
+			reply = ServerFailure(query)
+		}
+		if reply == nil {
+			break
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(idleTimeout))
+		if err := writeTcpDns(conn, reply); err != nil {
+			c.LogConnError(connId, "write error: %s", err)
+			break
+		}
+		sentAt := time.Now()
+		c.Record(reply, dnsutils.PROTO_TCP, conn.LocalAddr(), conn.RemoteAddr(), sentAt, sentAt.Sub(recvAt))
+	}
+
+	// finnaly removes the current connection from the list
+	c.Lock()
+	for j, cn := range c.conns {
+		if cn == conn {
+			c.conns = append(c.conns[:j], c.conns[j+1:]...)
+			break
+		}
+	}
+	c.Unlock()
+
+	c.LogConnInfo(connId, "connection handler terminated")
+}
+
+func (c *DnsProxy) Channel() chan dnsutils.DnsMessage {
+	return nil
+}
+
+func (c *DnsProxy) Stop() {
+	// close the listeners to unblock accept and read
+	c.LogInfo("stop listening...")
+	c.listen.Close()
+	c.udpConn.Close()
+
+	// read done channel and block until run is terminated
+	c.LogInfo("stopping run...")
+	c.stopRun <- true
+	<-c.doneRun
+	<-c.doneUdp
+
+	// closing properly current connections if exists
+	c.LogInfo("closing connected peers...")
+	c.Lock()
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+	c.Unlock()
+
+	// wait the end of connection handlers and pending queries
+	c.wgConns.Wait()
+	c.wgQueries.Wait()
+
+	// stop the dns processor
+	c.dnsProcessor.Stop()
+}
+
+func (c *DnsProxy) Listen() error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.LogInfo("running in background...")
+
+	addrlisten := c.config.Collectors.DnsProxy.ListenIP + ":" + strconv.Itoa(c.config.Collectors.DnsProxy.ListenPort)
+	udpAddr, err := net.ResolveUDPAddr(dnsutils.SOCKET_UDP, addrlisten)
+	if err != nil {
+		return err
+	}
+	udpConn, err := net.ListenUDP(dnsutils.SOCKET_UDP, udpAddr)
+	if err != nil {
+		return err
+	}
+
+	// listen on the same port in tcp, the udp port is known if a random one is requested
+	_, port, _ := net.SplitHostPort(udpConn.LocalAddr().String())
+	listener, err := net.Listen(dnsutils.SOCKET_TCP, net.JoinHostPort(c.config.Collectors.DnsProxy.ListenIP, port))
+	if err != nil {
+		udpConn.Close()
+		return err
+	}
+
+	c.LogInfo("is listening on udp://%s and tcp://%s", udpConn.LocalAddr(), listener.Addr())
+	c.udpConn = udpConn
+	c.listen = listener
+	return nil
+}
+
+func (c *DnsProxy) Run() {
+	c.LogInfo("starting collector...")
+	if c.listen == nil {
+		if err := c.Listen(); err != nil {
+			prefixlog := fmt.Sprintf("[%s] ", c.name)
+			c.logger.Fatal(prefixlog+"collector=dnsproxy listening failed: ", err)
+		}
+	}
+
+	// start the dns processor to decode queries and replies
+	c.dnsProcessor = processors.NewDnsProcessor(c.config, c.logger, c.name, c.config.Collectors.DnsProxy.ChannelBufferSize)
+	go c.dnsProcessor.Run(c.Loggers())
+
+	// limit the number of udp queries forwarded at the same time
+	c.querySlots = make(chan struct{}, c.config.Collectors.DnsProxy.MaxQueries)
+
+	// serve udp queries
+	go c.ServeUdp()
+
+	// goroutine to Accept() blocks waiting for new connection.
+	acceptChan := make(chan net.Conn)
+	acceptDone := make(chan bool)
+	go func() {
+		for {
+			conn, err := c.listen.Accept()
+			if err != nil {
+				return
+			}
+			select {
+			case acceptChan <- conn:
+			case <-acceptDone:
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+RUN_LOOP:
+	for {
+		select {
+		case <-c.stopRun:
+			close(acceptDone)
+			c.doneRun <- true
+			break RUN_LOOP
+
+		case cfg := <-c.configChan:
+
+			// save the new config
+			c.config = cfg
+			c.ReadConfig()
+
+			c.dnsProcessor.ConfigChan <- cfg
+
+		case conn := <-acceptChan:
+			c.Lock()
+			c.conns = append(c.conns, conn)
+			c.wgConns.Add(1)
+			c.Unlock()
+			go c.HandleConn(conn)
+		}
+
+	}
+	c.LogInfo("run terminated")
+}
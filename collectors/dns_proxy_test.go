@@ -0,0 +1,215 @@
+package collectors
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
+)
+
+// stub upstream resolver, replies to all A queries with 127.0.0.2
+func startStubUpstream(t *testing.T) (string, func()) {
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN A 127.0.0.2")
+		m.Answer = append(m.Answer, rr)
+		w.WriteMsg(m)
+	})
+
+	pc, err := net.ListenPacket(dnsutils.SOCKET_UDP, dnsutils.LOCALHOST_IP+":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen(dnsutils.SOCKET_TCP, pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	udpServer := &dns.Server{PacketConn: pc, Handler: handler}
+	tcpServer := &dns.Server{Listener: ln, Handler: handler}
+	go udpServer.ActivateAndServe()
+	go tcpServer.ActivateAndServe()
+
+	return pc.LocalAddr().String(), func() {
+		udpServer.Shutdown()
+		tcpServer.Shutdown()
+	}
+}
+
+func Test_DnsProxyCollector(t *testing.T) {
+	upstream, shutdown := startStubUpstream(t)
+	defer shutdown()
+
+	for _, network := range []string{dnsutils.SOCKET_UDP, dnsutils.SOCKET_TCP} {
+		t.Run(network, func(t *testing.T) {
+			g := loggers.NewFakeLogger()
+
+			config := dnsutils.GetFakeConfig()
+			config.Collectors.DnsProxy.ListenIP = dnsutils.LOCALHOST_IP
+			config.Collectors.DnsProxy.ListenPort = 0
+			config.Collectors.DnsProxy.Upstreams = []string{upstream}
+
+			c := NewDnsProxy([]dnsutils.Worker{g}, config, logger.New(false), "test")
+			if err := c.Listen(); err != nil {
+				t.Fatal("collector listening error: ", err)
+			}
+			go c.Run()
+			defer c.Stop()
+
+			// send a query to the proxy
+			m := new(dns.Msg)
+			m.SetQuestion("dns.collector.", dns.TypeA)
+			client := &dns.Client{Net: network, Timeout: 5 * time.Second}
+			r, _, err := client.Exchange(m, c.listen.Addr().String())
+			if err != nil {
+				t.Fatal("query error: ", err)
+			}
+			if len(r.Answer) != 1 {
+				t.Fatalf("want one answer, got %d", len(r.Answer))
+			}
+
+			// the query is recorded first, then the response
+			for _, operation := range []string{dnsutils.DNSTAP_CLIENT_QUERY, dnsutils.DNSTAP_CLIENT_RESPONSE} {
+				select {
+				case dm := <-g.Channel():
+					if dm.DnsTap.Operation != operation {
+						t.Errorf("want operation %s, got %s", operation, dm.DnsTap.Operation)
+					}
+					if dm.DNS.Qname != "dns.collector" {
+						t.Errorf("invalid qname: %s", dm.DNS.Qname)
+					}
+					if dm.NetworkInfo.QueryIp != dnsutils.LOCALHOST_IP {
+						t.Errorf("invalid query ip: %s", dm.NetworkInfo.QueryIp)
+					}
+					if operation == dnsutils.DNSTAP_CLIENT_RESPONSE && dm.DnsTap.Latency <= 0 {
+						t.Errorf("invalid latency: %f", dm.DnsTap.Latency)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("%s not received", operation)
+				}
+			}
+		})
+	}
+}
+
+func Test_DnsProxyCollector_UpstreamFailure(t *testing.T) {
+	// reserve a port without listener
+	pc, _ := net.ListenPacket(dnsutils.SOCKET_UDP, dnsutils.LOCALHOST_IP+":0")
+	upstream := pc.LocalAddr().String()
+	pc.Close()
+
+	g := loggers.NewFakeLogger()
+
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.DnsProxy.ListenIP = dnsutils.LOCALHOST_IP
+	config.Collectors.DnsProxy.ListenPort = 0
+	config.Collectors.DnsProxy.Upstreams = []string{upstream}
+	config.Collectors.DnsProxy.Timeout = 1
+
+	c := NewDnsProxy([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		t.Fatal("collector listening error: ", err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	m := new(dns.Msg)
+	m.SetQuestion("dns.collector.", dns.TypeA)
+	client := &dns.Client{Net: dnsutils.SOCKET_UDP, Timeout: 5 * time.Second}
+	r, _, err := client.Exchange(m, c.udpConn.LocalAddr().String())
+	if err != nil {
+		t.Fatal("query error: ", err)
+	}
+	if r.Rcode != dns.RcodeServerFailure {
+		t.Errorf("want SERVFAIL, got %s", dns.RcodeToString[r.Rcode])
+	}
+}
+
+func Test_DnsProxyCollector_MaxConcurrentQueries(t *testing.T) {
+	// upstream without reply, the forwarded queries are counted
+	pc, err := net.ListenPacket(dnsutils.SOCKET_UDP, dnsutils.LOCALHOST_IP+":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	g := loggers.NewFakeLogger()
+
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.DnsProxy.ListenPort = 0
+	config.Collectors.DnsProxy.Upstreams = []string{pc.LocalAddr().String()}
+	config.Collectors.DnsProxy.Timeout = 1
+	config.Collectors.DnsProxy.MaxQueries = 2
+
+	c := NewDnsProxy([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		t.Fatal("collector listening error: ", err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	conn, err := net.Dial(dnsutils.SOCKET_UDP, c.udpConn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 5; i++ {
+		m := new(dns.Msg)
+		m.SetQuestion("dns.collector.", dns.TypeA)
+		query, _ := m.Pack()
+		conn.Write(query)
+	}
+
+	// only two queries are forwarded before the upstream timeout
+	forwarded := 0
+	buf := make([]byte, 512)
+	pc.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	for {
+		if _, _, err := pc.ReadFrom(buf); err != nil {
+			break
+		}
+		forwarded++
+	}
+	if forwarded != 2 {
+		t.Errorf("want 2 queries forwarded, got %d", forwarded)
+	}
+}
+
+func Test_DnsProxyCollector_IdleTimeout(t *testing.T) {
+	upstream, shutdown := startStubUpstream(t)
+	defer shutdown()
+
+	g := loggers.NewFakeLogger()
+
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.DnsProxy.ListenPort = 0
+	config.Collectors.DnsProxy.Upstreams = []string{upstream}
+	config.Collectors.DnsProxy.IdleTimeout = 1
+
+	c := NewDnsProxy([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		t.Fatal("collector listening error: ", err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	// the connection sends an incomplete query and is closed by the proxy
+	conn, err := net.Dial(dnsutils.SOCKET_TCP, c.listen.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte{0x00, 0x20, 0x01})
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("want connection closed by the proxy, got %v", err)
+	}
+}
@@ -25,6 +25,7 @@ type Dnstap struct {
 	stopRun       chan bool
 	stopMonitor   chan bool
 	listen        net.Listener
+	listeners     []net.Listener
 	conns         []net.Conn
 	sockPath      string
 	loggers       []dnsutils.Worker
@@ -81,6 +82,16 @@ func (c *Dnstap) ReadConfig() {
 
 	c.sockPath = c.config.Collectors.Dnstap.SockPath
 
+	if c.config.Collectors.Dnstap.Listeners < 1 || c.config.Collectors.Dnstap.Workers < 1 {
+		c.logger.Fatal("collector=dnstap - listeners and workers must be greater than 0")
+	}
+	if c.config.Collectors.Dnstap.Listeners > 1 && len(c.config.Collectors.Dnstap.SockPath) > 0 {
+		c.logger.Fatal("collector=dnstap - several listeners are not supported with a unix socket")
+	}
+	if c.config.Collectors.Dnstap.StatsInterval < 0 {
+		c.logger.Fatal("collector=dnstap - invalid stats interval")
+	}
+
 	if len(c.config.Collectors.Dnstap.SockPath) > 0 {
 		c.connMode = "unix"
 	} else if c.config.Collectors.Dnstap.TlsSupport {
@@ -128,7 +139,13 @@ func (c *Dnstap) HandleConn(conn net.Conn) {
 	c.LogConnInfo(connId, "new connection from %s", peer)
 
 	// start dnstap subprocessor
-	dnstapProcessor := processors.NewDnstapProcessor(connId, c.config, c.logger, c.name, c.config.Collectors.Dnstap.ChannelBufferSize)
+	dnstapProcessor := processors.NewDnstapProcessorWithOptions(connId, c.config, c.logger, c.name,
+		c.config.Collectors.Dnstap.ChannelBufferSize,
+		processors.DnstapProcessorOptions{
+			Workers:       c.config.Collectors.Dnstap.Workers,
+			Ordered:       c.config.Collectors.Dnstap.Ordered,
+			StatsInterval: time.Duration(c.config.Collectors.Dnstap.StatsInterval) * time.Second,
+		})
 	c.Lock()
 	c.tapProcessors = append(c.tapProcessors, dnstapProcessor)
 	c.Unlock()
@@ -224,9 +241,11 @@ func (c *Dnstap) Stop() {
 		netlib.Close(conn, c.config.Collectors.Dnstap.ResetConn)
 	}
 
-	// Finally close the listener to unblock accept
+	// Finally close the listeners to unblock accept
 	c.LogInfo("stop listening...")
-	c.listen.Close()
+	for _, listener := range c.listeners {
+		listener.Close()
+	}
 
 	// stop monitor goroutine
 	c.LogInfo("stopping monitor...")
@@ -245,8 +264,6 @@ func (c *Dnstap) Listen() error {
 
 	c.LogInfo("running in background...")
 
-	var err error
-	var listener net.Listener
 	addrlisten := c.config.Collectors.Dnstap.ListenIP + ":" + strconv.Itoa(c.config.Collectors.Dnstap.ListenPort)
 
 	if len(c.sockPath) > 0 {
@@ -254,43 +271,62 @@ func (c *Dnstap) Listen() error {
 	}
 
 	// listening with tls enabled ?
+	var tlsConfig *tls.Config
 	if c.config.Collectors.Dnstap.TlsSupport {
 		c.LogInfo("tls support enabled")
-		var cer tls.Certificate
-		cer, err = tls.LoadX509KeyPair(c.config.Collectors.Dnstap.CertFile, c.config.Collectors.Dnstap.KeyFile)
+		cer, err := tls.LoadX509KeyPair(c.config.Collectors.Dnstap.CertFile, c.config.Collectors.Dnstap.KeyFile)
 		if err != nil {
 			c.logger.Fatal("loading certificate failed:", err)
 		}
 
-		tlsConfig := &tls.Config{
+		tlsConfig = &tls.Config{
 			Certificates: []tls.Certificate{cer},
 			MinVersion:   tls.VersionTLS12,
 		}
 
 		// update tls min version according to the user config
 		tlsConfig.MinVersion = dnsutils.TLS_VERSION[c.config.Collectors.Dnstap.TlsMinVersion]
+	}
 
-		if len(c.sockPath) > 0 {
-			listener, err = tls.Listen(dnsutils.SOCKET_UNIX, c.sockPath, tlsConfig)
-		} else {
-			listener, err = tls.Listen(dnsutils.SOCKET_TCP, addrlisten, tlsConfig)
-		}
+	// several listeners on the same address with SO_REUSEPORT,
+	// the connections are balanced by the kernel
+	nbListeners := c.config.Collectors.Dnstap.Listeners
+	if nbListeners < 1 {
+		nbListeners = 1
+	}
 
-	} else {
-		// basic listening
-		if len(c.sockPath) > 0 {
+	for i := 0; i < nbListeners; i++ {
+		var err error
+		var listener net.Listener
+		switch {
+		case len(c.sockPath) > 0:
 			listener, err = net.Listen(dnsutils.SOCKET_UNIX, c.sockPath)
-		} else {
+		case nbListeners > 1:
+			listener, err = netlib.ListenReusePort(dnsutils.SOCKET_TCP, addrlisten)
+		default:
 			listener, err = net.Listen(dnsutils.SOCKET_TCP, addrlisten)
 		}
-	}
 
-	// something is wrong ?
-	if err != nil {
-		return err
+		// something is wrong ?
+		if err != nil {
+			for _, l := range c.listeners {
+				l.Close()
+			}
+			c.listeners = nil
+			return err
+		}
+		if tlsConfig != nil {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+
+		// with a random port, the next listeners use the port of the first one
+		if i == 0 && nbListeners > 1 {
+			addrlisten = listener.Addr().String()
+		}
+		c.LogInfo("is listening on %s://%s", c.connMode, listener.Addr())
+		c.listeners = append(c.listeners, listener)
 	}
-	c.LogInfo("is listening on %s://%s", c.connMode, listener.Addr())
-	c.listen = listener
+	c.listen = c.listeners[0]
 	return nil
 }
 
@@ -330,17 +366,19 @@ func (c *Dnstap) Run() {
 	// start goroutine to count dropped messsages
 	go c.MonitorCollector()
 
-	// goroutine to Accept() blocks waiting for new connection.
+	// goroutines to Accept() blocks waiting for new connection, one per listener.
 	acceptChan := make(chan net.Conn)
-	go func() {
-		for {
-			conn, err := c.listen.Accept()
-			if err != nil {
-				return
+	for _, listener := range c.listeners {
+		go func(listener net.Listener) {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				acceptChan <- conn
 			}
-			acceptChan <- conn
-		}
-	}()
+		}(listener)
+	}
 
 RUN_LOOP:
 	for {
@@ -21,6 +21,7 @@ func Test_DnstapCollector(t *testing.T) {
 		mode        string
 		address     string
 		listen_port int
+		listeners   int
 		operation   string
 	}{
 		{
@@ -37,6 +38,14 @@ func Test_DnstapCollector(t *testing.T) {
 			listen_port: 7000,
 			operation:   "CLIENT_QUERY",
 		},
+		{
+			name:        "tcp_listeners",
+			mode:        dnsutils.SOCKET_TCP,
+			address:     ":7001",
+			listen_port: 7001,
+			listeners:   2,
+			operation:   "CLIENT_QUERY",
+		},
 		{
 			name:        "unix_default",
 			mode:        dnsutils.SOCKET_UNIX,
@@ -54,6 +63,10 @@ func Test_DnstapCollector(t *testing.T) {
 			if tc.listen_port > 0 {
 				config.Collectors.Dnstap.ListenPort = tc.listen_port
 			}
+			if tc.listeners > 0 {
+				config.Collectors.Dnstap.Listeners = tc.listeners
+				config.Collectors.Dnstap.Workers = 2
+			}
 			if tc.mode == dnsutils.SOCKET_UNIX {
 				config.Collectors.Dnstap.SockPath = tc.address
 			}
@@ -62,6 +75,9 @@ func Test_DnstapCollector(t *testing.T) {
 			if err := c.Listen(); err != nil {
 				log.Fatal("collector listening  error: ", err)
 			}
+			if tc.listeners > 0 && len(c.listeners) != tc.listeners {
+				t.Errorf("want %d listeners, got %d", tc.listeners, len(c.listeners))
+			}
 
 			go c.Run()
 
@@ -19,7 +19,6 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcapgo"
 )
 
 var waitFor = 10 * time.Second
@@ -28,7 +27,8 @@ func IsValidMode(mode string) bool {
 	switch mode {
 	case
 		dnsutils.MODE_PCAP,
-		dnsutils.MODE_DNSTAP:
+		dnsutils.MODE_DNSTAP,
+		dnsutils.MODE_ZEEK:
 		return true
 	}
 	return false
@@ -46,6 +46,10 @@ type FileIngestor struct {
 	dnsProcessor    processors.DnsProcessor
 	dnstapProcessor processors.DnstapProcessor
 	filterDnsPort   int
+	tlsPorts        []int
+	tcpOptions      netlib.TcpAssemblerOptions
+	ipOptions       netlib.IpDefragOptions
+	decap           netlib.DecapOptions
 	identity        string
 	name            string
 	mu              sync.Mutex
@@ -88,9 +92,44 @@ func (c *FileIngestor) ReadConfig() {
 		c.logger.Fatal("collector file ingestor - invalid mode: ", c.config.Collectors.FileIngestor.WatchMode)
 	}
 
+	decap, err := netlib.NewDecapOptions(c.config.Collectors.FileIngestor.Decapsulation)
+	if err != nil {
+		c.logger.Fatal("collector file ingestor - ", err)
+	}
+	c.decap = decap
+
 	c.identity = c.config.GetServerIdentity()
 	c.filterDnsPort = c.config.Collectors.FileIngestor.PcapDnsPort
 
+	c.tlsPorts = c.config.Collectors.FileIngestor.PcapTlsPorts
+	for _, port := range c.tlsPorts {
+		if port <= 0 || port > 65535 || port == c.filterDnsPort {
+			c.logger.Fatal("collector file ingestor - invalid tls port: ", port)
+		}
+	}
+
+	// limits of the tcp reassembly
+	c.tcpOptions = netlib.TcpAssemblerOptions{
+		TlsPorts:         c.tlsPorts,
+		MaxConnections:   c.config.Collectors.FileIngestor.TcpMaxConnections,
+		MaxBufferedBytes: c.config.Collectors.FileIngestor.TcpMaxBufferedBytes,
+		FlushInterval:    time.Duration(c.config.Collectors.FileIngestor.TcpFlushInterval) * time.Second,
+		IdleTimeout:      time.Duration(c.config.Collectors.FileIngestor.TcpIdleTimeout) * time.Second,
+	}
+	if c.tcpOptions.MaxConnections < 0 || c.tcpOptions.MaxBufferedBytes < 0 || c.tcpOptions.FlushInterval < 0 || c.tcpOptions.IdleTimeout < 0 {
+		c.logger.Fatal("collector file ingestor - invalid tcp reassembly limits")
+	}
+
+	// limits of the ip defragmentation
+	c.ipOptions = netlib.IpDefragOptions{
+		MaxQueues:    c.config.Collectors.FileIngestor.IpDefragMaxQueues,
+		MaxFragments: c.config.Collectors.FileIngestor.IpDefragMaxFrags,
+		Timeout:      time.Duration(c.config.Collectors.FileIngestor.IpDefragTimeout) * time.Second,
+	}
+	if c.ipOptions.MaxQueues < 0 || c.ipOptions.MaxFragments < 0 || c.ipOptions.Timeout < 0 {
+		c.logger.Fatal("collector file ingestor - invalid ip defragmentation limits")
+	}
+
 	c.LogInfo("watching directory [%s] to find [%s] files",
 		c.config.Collectors.FileIngestor.WatchDir,
 		c.config.Collectors.FileIngestor.WatchMode)
@@ -127,8 +166,8 @@ func (c *FileIngestor) Stop() {
 func (c *FileIngestor) ProcessFile(filePath string) {
 	switch c.config.Collectors.FileIngestor.WatchMode {
 	case dnsutils.MODE_PCAP:
-		// process file with pcap extension only
-		if filepath.Ext(filePath) == ".pcap" || filepath.Ext(filePath) == ".pcap.gz" {
+		// process pcap and pcapng files only, compressed or not
+		if IsPcapFile(filePath) {
 			c.LogInfo("file ready to process %s", filePath)
 			go c.ProcessPcap(filePath)
 		}
@@ -138,6 +177,12 @@ func (c *FileIngestor) ProcessFile(filePath string) {
 			c.LogInfo("file ready to process %s", filePath)
 			go c.ProcessDnstap(filePath)
 		}
+	case dnsutils.MODE_ZEEK:
+		// process zeek dns logs only
+		if IsZeekDnsFile(filePath) {
+			c.LogInfo("file ready to process %s", filePath)
+			go c.ProcessZeek(filePath)
+		}
 	}
 }
 
@@ -150,8 +195,16 @@ func (c *FileIngestor) ProcessPcap(filePath string) {
 	}
 	defer f.Close()
 
-	// it is a pcap file ?
-	pcapHandler, err := pcapgo.NewReader(f)
+	// gzip or zstd compressed ?
+	r, err := DecompressReader(f)
+	if err != nil {
+		c.LogError("unable to decompress file: %s", err)
+		return
+	}
+	defer r.Close()
+
+	// it is a pcap or pcapng file ?
+	captureReader, err := NewCaptureReader(r, c.decap)
 	if err != nil {
 		c.LogError("unable to read pcap file: %s", err)
 		return
@@ -160,27 +213,32 @@ func (c *FileIngestor) ProcessPcap(filePath string) {
 	fileName := filepath.Base(filePath)
 	c.LogInfo("processing pcap file [%s]...", fileName)
 
-	if pcapHandler.LinkType() != layers.LinkTypeEthernet {
-		c.LogError("pcap file [%s] ignored: %s", filePath, pcapHandler.LinkType())
-		return
-	}
-
 	dnsChan := make(chan netlib.DnsPacket)
 	udpChan := make(chan gopacket.Packet)
 	tcpChan := make(chan gopacket.Packet)
 	fragIp4Chan := make(chan gopacket.Packet)
 	fragIp6Chan := make(chan gopacket.Packet)
 
-	packetSource := gopacket.NewPacketSource(pcapHandler, pcapHandler.LinkType())
-	packetSource.DecodeOptions.Lazy = true
-	packetSource.NoCopy = true
-
+	// counters of the ip defragmentation of the file
+	ipOptions := c.ipOptions
+	ipOptions.Stats = &netlib.IpDefragStats{}
+	var defraggers sync.WaitGroup
+	defraggers.Add(2)
 	// defrag ipv4
-	go netlib.IpDefragger(fragIp4Chan, udpChan, tcpChan)
+	go func() {
+		defer defraggers.Done()
+		netlib.IpDefraggerWithOptions(fragIp4Chan, udpChan, tcpChan, ipOptions)
+	}()
 	// defrag ipv6
-	go netlib.IpDefragger(fragIp6Chan, udpChan, tcpChan)
+	go func() {
+		defer defraggers.Done()
+		netlib.IpDefraggerWithOptions(fragIp6Chan, udpChan, tcpChan, ipOptions)
+	}()
 	// tcp assembly
-	go netlib.TcpAssembler(tcpChan, dnsChan, c.filterDnsPort)
+	// counters of the tcp reassembly of the file
+	tcpOptions := c.tcpOptions
+	tcpOptions.Stats = &netlib.TcpAssemblerStats{}
+	go netlib.TcpAssemblerWithOptions(tcpChan, dnsChan, tcpOptions, c.filterDnsPort)
 	// udp processor
 	go netlib.UdpProcessor(udpChan, dnsChan, c.filterDnsPort)
 
@@ -207,6 +265,25 @@ func (c *FileIngestor) ProcessPcap(filePath string) {
 				dm.NetworkInfo.Protocol = dnsPacket.TransportLayer.EndpointType().String()
 				dm.NetworkInfo.IpDefragmented = dnsPacket.IpDefragmented
 				dm.NetworkInfo.TcpReassembled = dnsPacket.TcpReassembled
+				if dnsPacket.Tunnel != nil {
+					dm.NetworkInfo.Tunnel = &dnsutils.DnsTunnel{
+						Encapsulations: dnsPacket.Tunnel.Encapsulations,
+						VlanIds:        dnsPacket.Tunnel.VlanIds,
+						Vni:            dnsPacket.Tunnel.Vni,
+						ErspanSession:  dnsPacket.Tunnel.ErspanSession,
+					}
+				}
+				if dnsPacket.Tls != nil {
+					dm.NetworkInfo.Protocol = dnsPacket.Tls.Protocol
+					dm.NetworkInfo.Tls = &dnsutils.DnsTls{
+						Event:       dnsPacket.Tls.Event,
+						ServerName:  dnsPacket.Tls.ServerName,
+						Alpn:        dnsPacket.Tls.Alpn,
+						Version:     dnsPacket.Tls.Version,
+						ClientBytes: dnsPacket.Tls.ClientBytes,
+						ServerBytes: dnsPacket.Tls.ServerBytes,
+					}
+				}
 
 				dm.DNS.Payload = dnsPacket.Payload
 				dm.DNS.Length = len(dnsPacket.Payload)
@@ -229,11 +306,19 @@ func (c *FileIngestor) ProcessPcap(filePath string) {
 		}
 	end:
 		c.LogInfo("pcap file [%s]: %d DNS packet(s) detected", fileName, nbPackets)
+		tcp := tcpOptions.Stats.Counters()
+		c.LogInfo("pcap file [%s]: tcp streams created: %d, evicted: %d, truncated: %d, packets rejected: %d", fileName,
+			tcp["tcp_streams_created"], tcp["tcp_streams_evicted"], tcp["tcp_streams_truncated"], tcp["tcp_packets_rejected"])
+		ip := ipOptions.Stats.Counters()
+		c.LogInfo("pcap file [%s]: ip fragments received: %d, invalid: %d, dropped queues full: %d - datagrams reassembled: %d, timed out: %d, too many fragments: %d", fileName,
+			ip["ipdefrag_fragments_received"], ip["ipdefrag_fragments_invalid"], ip["ipdefrag_fragments_queues_full"],
+			ip["ipdefrag_datagrams_reassembled"], ip["ipdefrag_datagrams_timedout"], ip["ipdefrag_datagrams_too_many_fragments"])
 	}()
 
 	nbPackets := 0
+	nbIgnored := 0
 	for {
-		packet, err := packetSource.NextPacket()
+		packet, linkType, err := captureReader.ReadPacket()
 
 		if errors.Is(err, io.EOF) {
 			break
@@ -245,6 +330,15 @@ func (c *FileIngestor) ProcessPcap(filePath string) {
 
 		nbPackets++
 
+		// link type not supported
+		if packet == nil {
+			if nbIgnored == 0 {
+				c.LogError("pcap file [%s]: packets ignored with unsupported link type %d", fileName, linkType)
+			}
+			nbIgnored++
+			continue
+		}
+
 		// some security checks
 		if packet.NetworkLayer() == nil {
 			continue
@@ -283,7 +377,7 @@ func (c *FileIngestor) ProcessPcap(filePath string) {
 
 	// remove it ?
 	//assembler.FlushAll()
-	c.LogInfo("pcap file [%s] processing terminated, %d packet(s) read", fileName, nbPackets)
+	c.LogInfo("pcap file [%s] processing terminated, %d packet(s) read, %d ignored", fileName, nbPackets, nbIgnored)
 
 	// remove it ?
 	if c.config.Collectors.FileIngestor.DeleteAfter {
@@ -294,6 +388,8 @@ func (c *FileIngestor) ProcessPcap(filePath string) {
 	//close chan
 	close(fragIp4Chan)
 	close(fragIp6Chan)
+	// the defraggers send to the tcp and udp processors
+	defraggers.Wait()
 	close(udpChan)
 	close(tcpChan)
 
@@ -398,8 +494,8 @@ func (c *FileIngestor) Run() {
 
 		switch c.config.Collectors.FileIngestor.WatchMode {
 		case dnsutils.MODE_PCAP:
-			// process file with pcap extension
-			if filepath.Ext(fn) == ".pcap" || filepath.Ext(fn) == ".pcap.gz" {
+			// process pcap and pcapng files, compressed or not
+			if IsPcapFile(fn) {
 				go c.ProcessPcap(fn)
 			}
 		case dnsutils.MODE_DNSTAP:
@@ -407,6 +503,11 @@ func (c *FileIngestor) Run() {
 			if filepath.Ext(fn) == ".fstrm" {
 				go c.ProcessDnstap(fn)
 			}
+		case dnsutils.MODE_ZEEK:
+			// process zeek dns logs
+			if IsZeekDnsFile(fn) {
+				go c.ProcessZeek(fn)
+			}
 		}
 	}
 
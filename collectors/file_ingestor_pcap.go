@@ -0,0 +1,253 @@
+package collectors
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/dmachard/go-dnscollector/netlib"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic   = []byte{0x1f, 0x8b}
+	zstdMagic   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+)
+
+// IsPcapFile returns true for the pcap and pcapng files, compressed with gzip or zstd or not
+func IsPcapFile(filePath string) bool {
+	name := filepath.Base(filePath)
+	for _, ext := range []string{".gz", ".zst", ".zstd"} {
+		if strings.HasSuffix(name, ext) {
+			name = strings.TrimSuffix(name, ext)
+			break
+		}
+	}
+	ext := filepath.Ext(name)
+	return ext == ".pcap" || ext == ".pcapng"
+}
+
+// DecompressReader detects the gzip or zstd compression with the magic bytes
+// and returns a reader on the decompressed content
+func DecompressReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return io.NopCloser(br), nil
+}
+
+// pcapng blocks with the link type of an interface or a packet
+const (
+	ngBlockInterface      = 1
+	ngBlockPacket         = 2
+	ngBlockSimplePacket   = 3
+	ngBlockEnhancedPacket = 6
+)
+
+// NgLinkTypes follows the blocks of a pcapng stream read by pcapgo to get the link types of the
+// interfaces on 16 bits, pcapgo truncates the values above 255 as LINKTYPE_LINUX_SLL2.
+// The link type of each packet block is queued, in the order the packets are returned by pcapgo.
+type NgLinkTypes struct {
+	r         io.Reader
+	header    []byte
+	skip      int
+	byteOrder binary.ByteOrder
+	ifaces    []int
+	packets   []int
+}
+
+func NewNgLinkTypes(r io.Reader) *NgLinkTypes {
+	return &NgLinkTypes{r: r, header: make([]byte, 0, 12)}
+}
+
+func (n *NgLinkTypes) Read(p []byte) (int, error) {
+	c, err := n.r.Read(p)
+	n.observe(p[:c])
+	return c, err
+}
+
+// Next returns the link type of the next packet, -1 if unknown
+func (n *NgLinkTypes) Next() int {
+	if len(n.packets) == 0 {
+		return -1
+	}
+	linkType := n.packets[0]
+	n.packets = n.packets[1:]
+	return linkType
+}
+
+// observe decodes the first 12 bytes of each block (type, length and the start of the body),
+// the rest of the block is skipped
+func (n *NgLinkTypes) observe(data []byte) {
+	for len(data) > 0 {
+		if n.skip > 0 {
+			k := n.skip
+			if k > len(data) {
+				k = len(data)
+			}
+			n.skip -= k
+			data = data[k:]
+			continue
+		}
+
+		k := cap(n.header) - len(n.header)
+		if k > len(data) {
+			k = len(data)
+		}
+		n.header = append(n.header, data[:k]...)
+		data = data[k:]
+		if len(n.header) < cap(n.header) {
+			return
+		}
+		n.block(n.header)
+		n.header = n.header[:0]
+	}
+}
+
+func (n *NgLinkTypes) block(header []byte) {
+	// section header, the byte order magic follows the length
+	if bytes.Equal(header[0:4], pcapngMagic) {
+		n.byteOrder = binary.LittleEndian
+		if header[8] == 0x1a {
+			n.byteOrder = binary.BigEndian
+		}
+		n.ifaces = n.ifaces[:0]
+	}
+	if n.byteOrder == nil {
+		return
+	}
+
+	switch n.byteOrder.Uint32(header[0:4]) {
+	case ngBlockInterface:
+		n.ifaces = append(n.ifaces, int(n.byteOrder.Uint16(header[8:10])))
+	case ngBlockPacket:
+		n.packets = append(n.packets, n.ifaceLinkType(int(n.byteOrder.Uint16(header[8:10]))))
+	case ngBlockSimplePacket:
+		n.packets = append(n.packets, n.ifaceLinkType(0))
+	case ngBlockEnhancedPacket:
+		n.packets = append(n.packets, n.ifaceLinkType(int(n.byteOrder.Uint32(header[8:12]))))
+	}
+
+	n.skip = int(n.byteOrder.Uint32(header[4:8])) - len(header)
+	if n.skip < 0 {
+		n.skip = 0
+	}
+}
+
+func (n *NgLinkTypes) ifaceLinkType(index int) int {
+	if index < 0 || index >= len(n.ifaces) {
+		return -1
+	}
+	return n.ifaces[index]
+}
+
+// CaptureReader reads the packets of a pcap or pcapng file,
+// with the link type of the interface on which each packet has been captured
+type CaptureReader struct {
+	pcap         *pcapgo.Reader
+	pcapLinkType int
+	pcapng       *pcapgo.NgReader
+	ngLinkTypes  *NgLinkTypes
+	decoders     map[int]*netlib.NetDecoder
+	decap        netlib.DecapOptions
+}
+
+// PcapLinkType returns the link type from the header of a pcap file,
+// pcapgo truncates the values above 255 as LINKTYPE_LINUX_SLL2
+func PcapLinkType(header []byte) int {
+	if len(header) < 24 {
+		return -1
+	}
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	if header[0] == 0xa1 {
+		byteOrder = binary.BigEndian
+	}
+	return int(byteOrder.Uint32(header[20:24]) & 0xffff)
+}
+
+func NewCaptureReader(r io.Reader, decap netlib.DecapOptions) (*CaptureReader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+
+	if bytes.Equal(magic, pcapngMagic) {
+		ngLinkTypes := NewNgLinkTypes(br)
+		ngReader, err := pcapgo.NewNgReader(ngLinkTypes, pcapgo.NgReaderOptions{WantMixedLinkType: true})
+		if err != nil {
+			return nil, err
+		}
+		return &CaptureReader{pcapng: ngReader, ngLinkTypes: ngLinkTypes, decoders: make(map[int]*netlib.NetDecoder), decap: decap}, nil
+	}
+
+	header, _ := br.Peek(24)
+	linkType := PcapLinkType(header)
+
+	pcapReader, err := pcapgo.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	return &CaptureReader{pcap: pcapReader, pcapLinkType: linkType, decoders: make(map[int]*netlib.NetDecoder), decap: decap}, nil
+}
+
+// ReadPacket returns the next packet decoded with the link type of its interface
+func (r *CaptureReader) ReadPacket() (gopacket.Packet, int, error) {
+	var data []byte
+	var ci gopacket.CaptureInfo
+	var err error
+	var linkType int
+
+	if r.pcapng != nil {
+		data, ci, err = r.pcapng.ReadPacketData()
+		if err != nil {
+			return nil, linkType, err
+		}
+		// the link type of the interface, on 16 bits
+		linkType = r.ngLinkTypes.Next()
+		if linkType == -1 && len(ci.AncillaryData) > 0 {
+			if lt, ok := ci.AncillaryData[0].(layers.LinkType); ok {
+				linkType = int(lt)
+			}
+		}
+	} else {
+		data, ci, err = r.pcap.ReadPacketData()
+		if err != nil {
+			return nil, linkType, err
+		}
+		linkType = r.pcapLinkType
+	}
+
+	// one decoder per link type, the interfaces of a pcapng file can differ
+	decoder, ok := r.decoders[linkType]
+	if !ok {
+		if !netlib.IsSupportedLinkType(linkType) {
+			return nil, linkType, nil
+		}
+		decoder, err = netlib.NewNetDecoder(linkType)
+		if err != nil {
+			return nil, linkType, err
+		}
+		decoder.Decap = r.decap
+		r.decoders[linkType] = decoder
+	}
+
+	packet := gopacket.NewPacket(data, decoder, gopacket.NoCopy)
+	packet.Metadata().CaptureInfo = ci
+	return packet, linkType, nil
+}
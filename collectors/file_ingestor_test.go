@@ -1,11 +1,20 @@
 package collectors
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-dnscollector/netlib"
 	"github.com/dmachard/go-logger"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
 )
 
 func Test_FileIngestor_Pcap(t *testing.T) {
@@ -30,3 +39,269 @@ func Test_FileIngestor_Pcap(t *testing.T) {
 		}
 	}
 }
+
+func Test_FileIngestor_CaptureFormats(t *testing.T) {
+	testcases := []struct {
+		file    string
+		packets int
+	}{
+		{file: "./../testsdata/pcap/dnsdump_udp.pcap", packets: 33},
+		{file: "./../testsdata/capture/dnsdump_udp.pcap.gz", packets: 33},
+		{file: "./../testsdata/capture/dnsdump_udp.pcapng", packets: 33},
+		{file: "./../testsdata/capture/dnsdump_udp.pcapng.zst", packets: 33},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.file, func(t *testing.T) {
+			if !IsPcapFile(tc.file) {
+				t.Fatalf("file not detected as a capture")
+			}
+
+			f, err := os.Open(tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			r, err := DecompressReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			captureReader, err := NewCaptureReader(r, netlib.DecapOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			nbPackets := 0
+			for {
+				packet, _, err := captureReader.ReadPacket()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if packet == nil || packet.NetworkLayer() == nil {
+					t.Fatalf("packet %d not decoded", nbPackets)
+				}
+				if packet.Metadata().Timestamp.IsZero() {
+					t.Errorf("packet %d without timestamp", nbPackets)
+				}
+				nbPackets++
+			}
+			if nbPackets != tc.packets {
+				t.Errorf("want %d packets, got %d", tc.packets, nbPackets)
+			}
+		})
+	}
+}
+
+func Test_FileIngestor_LinuxSLL2(t *testing.T) {
+	// convert the ethernet frames to linux cooked v2 (tcpdump -i any)
+	f, err := os.Open("./../testsdata/pcap/dnsdump_udp.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	pcapReader, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pcap header with the link type 276, not supported by the pcapgo writer
+	var buf bytes.Buffer
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], 65535)
+	binary.LittleEndian.PutUint32(header[20:24], netlib.LinkTypeLinuxSLL2)
+	buf.Write(header)
+
+	nbFrames := 0
+	for {
+		data, ci, err := pcapReader.ReadPacketData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		sll2 := make([]byte, 20)
+		copy(sll2[0:2], data[12:14])
+		frame := append(sll2, data[14:]...)
+
+		record := make([]byte, 16)
+		binary.LittleEndian.PutUint32(record[0:4], uint32(ci.Timestamp.Unix()))
+		binary.LittleEndian.PutUint32(record[4:8], uint32(ci.Timestamp.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(record[8:12], uint32(len(frame)))
+		binary.LittleEndian.PutUint32(record[12:16], uint32(len(frame)))
+		buf.Write(record)
+		buf.Write(frame)
+		nbFrames++
+	}
+
+	if linkType := PcapLinkType(buf.Bytes()); linkType != netlib.LinkTypeLinuxSLL2 {
+		t.Fatalf("want link type %d, got %d", netlib.LinkTypeLinuxSLL2, linkType)
+	}
+
+	captureReader, err := NewCaptureReader(&buf, netlib.DecapOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nbPackets := 0
+	for {
+		packet, _, err := captureReader.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if packet == nil || packet.TransportLayer() == nil {
+			t.Fatalf("packet %d not decoded", nbPackets)
+		}
+		nbPackets++
+	}
+	if nbPackets != nbFrames {
+		t.Errorf("want %d packets, got %d", nbFrames, nbPackets)
+	}
+}
+
+func Test_FileIngestor_PcapngLinuxSLL2(t *testing.T) {
+	// pcapng with an ethernet interface and a linux cooked v2 interface (tcpdump -i any),
+	// the packets alternate between the two interfaces
+	f, err := os.Open("./../testsdata/capture/dnsdump_udp_any.pcapng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	captureReader, err := NewCaptureReader(f, netlib.DecapOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nbPackets := 0
+	for {
+		packet, linkType, err := captureReader.ReadPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := int(layers.LinkTypeEthernet)
+		if nbPackets%2 == 1 {
+			want = netlib.LinkTypeLinuxSLL2
+		}
+		if linkType != want {
+			t.Errorf("packet %d: want link type %d, got %d", nbPackets, want, linkType)
+		}
+		if packet == nil || packet.TransportLayer() == nil {
+			t.Fatalf("packet %d not decoded", nbPackets)
+		}
+		nbPackets++
+	}
+	if nbPackets != 33 {
+		t.Errorf("want 33 packets, got %d", nbPackets)
+	}
+}
+
+func Test_FileIngestor_Pcapng(t *testing.T) {
+	g := loggers.NewFakeLogger()
+	config := dnsutils.GetFakeConfig()
+
+	// watch pcapng and compressed captures
+	config.Collectors.FileIngestor.WatchDir = "./../testsdata/capture/"
+
+	// init collector
+	c := NewFileIngestor([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	go c.Run()
+
+	// waiting message in channel
+	for {
+		msg := <-g.Channel()
+		if msg.DnsTap.Operation == dnsutils.DNSTAP_CLIENT_QUERY {
+			break
+		}
+	}
+}
+
+func Test_FileIngestor_Zeek(t *testing.T) {
+	g := loggers.NewFakeLogger()
+	config := dnsutils.GetFakeConfig()
+
+	// watch zeek logs in tsv and json formats
+	config.Collectors.FileIngestor.WatchDir = "./../testsdata/zeek/"
+	config.Collectors.FileIngestor.WatchMode = dnsutils.MODE_ZEEK
+
+	// init collector
+	c := NewFileIngestor([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	go c.Run()
+	defer c.Stop()
+
+	// read all dns messages, the uid is saved in the extra field
+	dms := map[string]dnsutils.DnsMessage{}
+	for len(dms) < 5 {
+		select {
+		case dm := <-g.Channel():
+			dms[dm.DnsTap.Extra] = dm
+		case <-time.After(5 * time.Second):
+			t.Fatalf("want 5 dns messages, got %d", len(dms))
+		}
+	}
+
+	testcases := []struct {
+		uid       string
+		operation string
+		qname     string
+		qtype     string
+		rcode     string
+		queryip   string
+		queryport string
+		protocol  string
+		answers   int
+		ttl       int
+		latency   string
+	}{
+		{uid: "CHhAvVGS1DHFjwGM9", operation: dnsutils.DNSTAP_CLIENT_RESPONSE, qname: "www.example.com", qtype: "A", rcode: "NOERROR",
+			queryip: "192.168.1.10", queryport: "53211", protocol: dnsutils.PROTO_UDP, answers: 2, ttl: 3600, latency: "0.012345"},
+		{uid: "C4J4Th3PJpwUYZZ6gc", operation: dnsutils.DNSTAP_CLIENT_RESPONSE, qname: "unknown.corp", qtype: "AAAA", rcode: "NXDOMAIN",
+			queryip: "2001:db8::10", queryport: "41000", protocol: dnsutils.PROTO_TCP, latency: "0.001000"},
+		{uid: "CtPZjS20MLrsMUOJi2", operation: dnsutils.DNSTAP_CLIENT_QUERY, qname: "slow.example.com", qtype: "MX", rcode: "NOERROR",
+			queryip: "192.168.1.11", queryport: "5353", protocol: dnsutils.PROTO_UDP, latency: "0.000000"},
+		{uid: "CJ3xTn1c4Zw9TmAE05", operation: dnsutils.DNSTAP_CLIENT_RESPONSE, qname: "example.org", qtype: "TXT", rcode: "NOERROR",
+			queryip: "192.168.1.20", queryport: "60000", protocol: dnsutils.PROTO_UDP, answers: 1, ttl: 60, latency: "0.500000"},
+		{uid: "CpXb2b3A1VQ2MnoV6d", operation: dnsutils.DNSTAP_CLIENT_QUERY, qname: "example.org", qtype: "NS", rcode: "NOERROR",
+			queryip: "192.168.1.21", queryport: "60001", protocol: dnsutils.PROTO_UDP, latency: "0.000000"},
+	}
+
+	for _, tc := range testcases {
+		dm, ok := dms[tc.uid]
+		if !ok {
+			t.Errorf("record %s not found", tc.uid)
+			continue
+		}
+		if dm.DnsTap.Operation != tc.operation {
+			t.Errorf("%s: want operation %s, got %s", tc.uid, tc.operation, dm.DnsTap.Operation)
+		}
+		if dm.DNS.Qname != tc.qname || dm.DNS.Qtype != tc.qtype || dm.DNS.Rcode != tc.rcode {
+			t.Errorf("%s: want %s/%s/%s, got %s/%s/%s", tc.uid, tc.qname, tc.qtype, tc.rcode, dm.DNS.Qname, dm.DNS.Qtype, dm.DNS.Rcode)
+		}
+		if dm.NetworkInfo.QueryIp != tc.queryip || dm.NetworkInfo.QueryPort != tc.queryport || dm.NetworkInfo.Protocol != tc.protocol {
+			t.Errorf("%s: want client %s/%s#%s, got %s/%s#%s", tc.uid, tc.protocol, tc.queryip, tc.queryport,
+				dm.NetworkInfo.Protocol, dm.NetworkInfo.QueryIp, dm.NetworkInfo.QueryPort)
+		}
+		if len(dm.DNS.DnsRRs.Answers) != tc.answers {
+			t.Errorf("%s: want %d answers, got %d", tc.uid, tc.answers, len(dm.DNS.DnsRRs.Answers))
+		} else if tc.answers > 0 && dm.DNS.DnsRRs.Answers[0].Ttl != tc.ttl {
+			t.Errorf("%s: want ttl %d, got %d", tc.uid, tc.ttl, dm.DNS.DnsRRs.Answers[0].Ttl)
+		}
+		if dm.DnsTap.LatencySec != tc.latency {
+			t.Errorf("%s: want latency %s, got %s", tc.uid, tc.latency, dm.DnsTap.LatencySec)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package collectors
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/miekg/dns"
+)
+
+// IsZeekDnsFile returns true for the dns logs, in tsv or json format
+func IsZeekDnsFile(filePath string) bool {
+	ext := filepath.Ext(filePath)
+	return strings.HasPrefix(filepath.Base(filePath), "dns") && (ext == ".log" || ext == ".json")
+}
+
+// ZeekDnsRecord is an entry of the dns.log file of Zeek,
+// the json tags are the field names of the log
+type ZeekDnsRecord struct {
+	RawTs     interface{} `json:"ts"`
+	Uid       string      `json:"uid"`
+	OrigH     string      `json:"id.orig_h"`
+	OrigP     int         `json:"id.orig_p"`
+	RespH     string      `json:"id.resp_h"`
+	RespP     int         `json:"id.resp_p"`
+	Proto     string      `json:"proto"`
+	TransId   int         `json:"trans_id"`
+	Rtt       *float64    `json:"rtt"`
+	Query     string      `json:"query"`
+	Qclass    int         `json:"qclass"`
+	Qtype     int         `json:"qtype"`
+	QtypeName string      `json:"qtype_name"`
+	Rcode     *int        `json:"rcode"`
+	RcodeName string      `json:"rcode_name"`
+	AA        bool        `json:"AA"`
+	TC        bool        `json:"TC"`
+	RD        bool        `json:"RD"`
+	RA        bool        `json:"RA"`
+	Answers   []string    `json:"answers"`
+	TTLs      []float64   `json:"TTLs"`
+}
+
+// ZeekTsvReader decodes the tab separated values format,
+// the header of the file describes the fields
+type ZeekTsvReader struct {
+	separator    string
+	setSeparator string
+	unsetField   string
+	emptyField   string
+	fields       map[string]int
+}
+
+func NewZeekTsvReader() *ZeekTsvReader {
+	return &ZeekTsvReader{separator: "\t", setSeparator: ",", unsetField: "-", emptyField: "(empty)", fields: map[string]int{}}
+}
+
+// ReadHeader reads the #directive lines
+func (r *ZeekTsvReader) ReadHeader(line string) {
+	// the separator is the only directive separated by a space
+	if strings.HasPrefix(line, "#separator ") {
+		separator := strings.TrimPrefix(line, "#separator ")
+		if unquoted, err := strconv.Unquote(`"` + separator + `"`); err == nil {
+			separator = unquoted
+		}
+		r.separator = separator
+		return
+	}
+
+	parts := strings.Split(line, r.separator)
+	switch parts[0] {
+	case "#set_separator":
+		if len(parts) > 1 {
+			r.setSeparator = parts[1]
+		}
+	case "#unset_field":
+		if len(parts) > 1 {
+			r.unsetField = parts[1]
+		}
+	case "#empty_field":
+		if len(parts) > 1 {
+			r.emptyField = parts[1]
+		}
+	case "#fields":
+		r.fields = map[string]int{}
+		for i, name := range parts[1:] {
+			r.fields[name] = i
+		}
+	}
+}
+
+func (r *ZeekTsvReader) ReadRecord(line string) (ZeekDnsRecord, error) {
+	record := ZeekDnsRecord{}
+	if len(r.fields) == 0 {
+		return record, fmt.Errorf("no fields definition")
+	}
+	values := strings.Split(line, r.separator)
+
+	get := func(name string) (string, bool) {
+		i, ok := r.fields[name]
+		if !ok || i >= len(values) || values[i] == r.unsetField {
+			return "", false
+		}
+		return values[i], true
+	}
+	getInt := func(name string) (int, bool) {
+		v, ok := get(name)
+		if !ok {
+			return 0, false
+		}
+		n, err := strconv.Atoi(v)
+		return n, err == nil
+	}
+	getBool := func(name string) bool {
+		v, _ := get(name)
+		return v == "T"
+	}
+	getSet := func(name string) []string {
+		v, ok := get(name)
+		if !ok || v == r.emptyField {
+			return nil
+		}
+		return strings.Split(v, r.setSeparator)
+	}
+
+	ts, ok := get("ts")
+	if !ok {
+		return record, fmt.Errorf("no timestamp")
+	}
+	record.RawTs = ts
+	record.Uid, _ = get("uid")
+	record.OrigH, _ = get("id.orig_h")
+	record.OrigP, _ = getInt("id.orig_p")
+	record.RespH, _ = get("id.resp_h")
+	record.RespP, _ = getInt("id.resp_p")
+	record.Proto, _ = get("proto")
+	record.TransId, _ = getInt("trans_id")
+	if v, ok := get("rtt"); ok {
+		if rtt, err := strconv.ParseFloat(v, 64); err == nil {
+			record.Rtt = &rtt
+		}
+	}
+	record.Query, _ = get("query")
+	record.Qclass, _ = getInt("qclass")
+	record.Qtype, _ = getInt("qtype")
+	record.QtypeName, _ = get("qtype_name")
+	if rcode, ok := getInt("rcode"); ok {
+		record.Rcode = &rcode
+	}
+	record.RcodeName, _ = get("rcode_name")
+	record.AA = getBool("AA")
+	record.TC = getBool("TC")
+	record.RD = getBool("RD")
+	record.RA = getBool("RA")
+	record.Answers = getSet("answers")
+	for _, v := range getSet("TTLs") {
+		ttl, _ := strconv.ParseFloat(v, 64)
+		record.TTLs = append(record.TTLs, ttl)
+	}
+	return record, nil
+}
+
+// ParseZeekTimestamp supports the epoch and the iso8601 formats
+func ParseZeekTimestamp(value interface{}) (float64, error) {
+	switch ts := value.(type) {
+	case float64:
+		return ts, nil
+	case string:
+		if epoch, err := strconv.ParseFloat(ts, 64); err == nil {
+			return epoch, nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return 0, err
+		}
+		return float64(t.UnixNano()) / 1e9, nil
+	}
+	return 0, fmt.Errorf("invalid timestamp %v", value)
+}
+
+// ZeekAnswer converts an answer of the log to a resource record,
+// the zeek format only keeps the rdata so the record type is guessed
+func ZeekAnswer(qname string, qtype uint16, answer string, ttl uint32) dns.RR {
+	hdr := dns.RR_Header{Name: qname, Class: dns.ClassINET, Ttl: ttl}
+
+	if ip := net.ParseIP(answer); ip != nil {
+		if ip.To4() != nil {
+			hdr.Rrtype = dns.TypeA
+			return &dns.A{Hdr: hdr, A: ip}
+		}
+		hdr.Rrtype = dns.TypeAAAA
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}
+	}
+
+	// TXT <length> <text>
+	if strings.HasPrefix(answer, "TXT ") {
+		parts := strings.SplitN(answer, " ", 3)
+		if len(parts) == 3 {
+			hdr.Rrtype = dns.TypeTXT
+			return &dns.TXT{Hdr: hdr, Txt: []string{parts[2]}}
+		}
+	}
+
+	// ignore unknown types
+	if _, ok := dns.IsDomainName(answer); !ok || strings.ContainsAny(answer, " <>") {
+		return nil
+	}
+
+	target := dns.Fqdn(answer)
+	switch qtype {
+	case dns.TypeNS:
+		hdr.Rrtype = dns.TypeNS
+		return &dns.NS{Hdr: hdr, Ns: target}
+	case dns.TypePTR:
+		hdr.Rrtype = dns.TypePTR
+		return &dns.PTR{Hdr: hdr, Ptr: target}
+	case dns.TypeMX:
+		hdr.Rrtype = dns.TypeMX
+		return &dns.MX{Hdr: hdr, Mx: target}
+	default:
+		hdr.Rrtype = dns.TypeCNAME
+		return &dns.CNAME{Hdr: hdr, Target: target}
+	}
+}
+
+// ZeekToDnsMessage rebuilds the dns payload of the record, the record is
+// a reply if the response has been seen by zeek, otherwise a query
+func (c *FileIngestor) ZeekToDnsMessage(record ZeekDnsRecord) (dnsutils.DnsMessage, error) {
+	dm := dnsutils.DnsMessage{}
+	dm.Init()
+
+	if len(record.Query) == 0 {
+		return dm, fmt.Errorf("no query")
+	}
+	ts, err := ParseZeekTimestamp(record.RawTs)
+	if err != nil {
+		return dm, err
+	}
+
+	qtype := uint16(record.Qtype)
+	if qtype == 0 {
+		qtype = dns.StringToType[record.QtypeName]
+	}
+	qclass := uint16(record.Qclass)
+	if qclass == 0 {
+		qclass = dns.ClassINET
+	}
+
+	qname := dns.Fqdn(record.Query)
+	msg := new(dns.Msg)
+	msg.Id = uint16(record.TransId)
+	msg.Question = []dns.Question{{Name: qname, Qtype: qtype, Qclass: qclass}}
+	msg.RecursionDesired = record.RD
+
+	response := record.Rcode != nil || len(record.RcodeName) > 0
+	if response {
+		msg.Response = true
+		msg.Authoritative = record.AA
+		msg.Truncated = record.TC
+		msg.RecursionAvailable = record.RA
+		if record.Rcode != nil {
+			msg.Rcode = *record.Rcode
+		} else {
+			msg.Rcode = dns.StringToRcode[record.RcodeName]
+		}
+		for i, answer := range record.Answers {
+			var ttl uint32
+			if i < len(record.TTLs) {
+				ttl = uint32(record.TTLs[i])
+			}
+			if rr := ZeekAnswer(qname, qtype, answer, ttl); rr != nil {
+				msg.Answer = append(msg.Answer, rr)
+			}
+		}
+	}
+
+	dm.DNS.Payload, err = msg.Pack()
+	if err != nil {
+		return dm, err
+	}
+	dm.DNS.Length = len(dm.DNS.Payload)
+
+	dm.NetworkInfo.Family = dnsutils.PROTO_IPV4
+	if ip := net.ParseIP(record.OrigH); ip != nil && ip.To4() == nil {
+		dm.NetworkInfo.Family = dnsutils.PROTO_IPV6
+	}
+	dm.NetworkInfo.Protocol = strings.ToUpper(record.Proto)
+
+	// the addresses are in the capture order, from the sender to the receiver
+	dm.NetworkInfo.QueryIp = record.OrigH
+	dm.NetworkInfo.QueryPort = strconv.Itoa(record.OrigP)
+	dm.NetworkInfo.ResponseIp = record.RespH
+	dm.NetworkInfo.ResponsePort = strconv.Itoa(record.RespP)
+
+	// the timestamp of the record is the time of the query
+	if response {
+		dm.NetworkInfo.QueryIp, dm.NetworkInfo.ResponseIp = dm.NetworkInfo.ResponseIp, dm.NetworkInfo.QueryIp
+		dm.NetworkInfo.QueryPort, dm.NetworkInfo.ResponsePort = dm.NetworkInfo.ResponsePort, dm.NetworkInfo.QueryPort
+		if record.Rtt != nil {
+			ts += *record.Rtt
+			dm.DnsTap.Latency = *record.Rtt
+		}
+	}
+
+	sec := int64(ts)
+	dm.DnsTap.TimeSec = int(sec)
+	dm.DnsTap.TimeNsec = int((ts - float64(sec)) * 1e9)
+	dm.DnsTap.Identity = c.identity
+	dm.DnsTap.Extra = record.Uid
+	return dm, nil
+}
+
+func (c *FileIngestor) ProcessZeek(filePath string) {
+	// open the file
+	f, err := os.Open(filePath)
+	if err != nil {
+		c.LogError("unable to read file: %s", err)
+		return
+	}
+	defer f.Close()
+
+	fileName := filepath.Base(filePath)
+	c.LogInfo("processing zeek file [%s]...", fileName)
+
+	tsvReader := NewZeekTsvReader()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	nbRecords := 0
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) == 0 {
+			continue
+		}
+
+		var record ZeekDnsRecord
+		switch {
+		// json format, one object per line
+		case strings.HasPrefix(line, "{"):
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				c.LogError("zeek file [%s]: invalid json record: %s", fileName, err)
+				continue
+			}
+		case strings.HasPrefix(line, "#"):
+			tsvReader.ReadHeader(line)
+			continue
+		default:
+			if record, err = tsvReader.ReadRecord(line); err != nil {
+				c.LogError("zeek file [%s]: invalid record: %s", fileName, err)
+				continue
+			}
+		}
+
+		dm, err := c.ZeekToDnsMessage(record)
+		if err != nil {
+			c.LogError("zeek file [%s]: record %s ignored: %s", fileName, record.Uid, err)
+			continue
+		}
+		nbRecords++
+
+		// send DNS message to DNS processor
+		c.dnsProcessor.GetChannel() <- dm
+	}
+	if err := scanner.Err(); err != nil {
+		c.LogError("unable to read zeek file [%s]: %s", fileName, err)
+	}
+
+	c.LogInfo("zeek file [%s] processing terminated, %d record(s) read", fileName, nbRecords)
+
+	// remove it ?
+	if c.config.Collectors.FileIngestor.DeleteAfter {
+		c.LogInfo("delete file [%s]", fileName)
+		os.Remove(filePath)
+	}
+
+	// remove event timer for this file
+	c.RemoveEvent(filePath)
+}
@@ -3,9 +3,11 @@ package collectors
 import (
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -15,15 +17,89 @@ import (
 	"github.com/miekg/dns"
 )
 
+// TailPreset describes the log format of a dns server,
+// the named groups of the patterns are the same as the user defined patterns
+type TailPreset struct {
+	TimeLayout   string
+	PatternQuery string
+	PatternReply string
+}
+
+const (
+	// time layout for timestamps in seconds since epoch
+	TAIL_TIME_UNIX = "unix"
+
+	TAIL_SYSLOG_TIME = `(?P<timestamp>\w{3} [ \d]\d \d{2}:\d{2}:\d{2})`
+	TAIL_BIND_PREFIX = `^(?P<timestamp>\d{2}-\w{3}-\d{4} \d{2}:\d{2}:\d{2}\.\d{3}) (?:\S+: )*client (?:@0x[0-9a-f]+ )?` +
+		`(?P<queryip>[^ #]+)#(?P<queryport>\d+)(?: \([^)]*\))?: (?:view \S+: )?`
+	TAIL_UNBOUND_PREFIX = `^\[(?P<timestamp>\d+(?:\.\d+)?)\] \S+\[\d+:\w+\] `
+	TAIL_COREDNS_PREFIX = `^(?:(?P<timestamp>\d{4}-\d{2}-\d{2}T\S+) (?:\S+ \S+ )?)?\[INFO\] `
+
+	// default time layout of the windows dns debug log, depends on the regional settings
+	TAIL_WINDOWS_DNS_TIME = "1/2/2006 3:04:05 PM"
+)
+
+// packet lines of the windows dns debug log
+var windowsDnsPattern = regexp.MustCompile(`^(?P<timestamp>\d+[/.-]\d+[/.-]\d+ \d+:\d+:\d+(?: [AP]M)?) [0-9A-Fa-f]+ PACKET\s+` +
+	`[0-9A-Fa-f]+ (?P<protocol>UDP|TCP) (?P<direction>Snd|Rcv) (?P<ip>\S+)\s+(?P<xid>[0-9A-Fa-f]{4}) (?P<qr>[R ]) (?P<opcode>\S) ` +
+	`\[(?P<flags>[0-9A-Fa-f]{4})\s+(?P<flagchars>[ATDR ]*?)\s*(?P<rcode>[A-Z]+)\]\s+(?P<qtype>\S+)\s+(?P<qname>\S+)$`)
+
+// windowsDnsLabels matches the length of the labels in the (n)label(n) encoding
+var windowsDnsLabels = regexp.MustCompile(`\(\d+\)`)
+
+var TailPresets = map[string]TailPreset{
+	// querylog and query-errors categories
+	"bind": {
+		TimeLayout:   "02-Jan-2006 15:04:05.000",
+		PatternQuery: TAIL_BIND_PREFIX + `query: (?P<domain>\S+) IN (?P<qtype>\S+) \S+ \((?P<responseip>[^)]+)\)`,
+		PatternReply: TAIL_BIND_PREFIX + `query failed \((?P<rcode>[^)]+)\) for (?P<domain>[^/]+)/IN/(?P<qtype>\S+)`,
+	},
+	// log-queries and log-replies options, the lines are tagged with info: by default
+	// or with query: and reply: with the log-tag-queryreply option, the replies
+	// are the lines with the rcode, the time and the size
+	"unbound": {
+		TimeLayout: TAIL_TIME_UNIX,
+		PatternQuery: TAIL_UNBOUND_PREFIX + `(?:query|info): (?P<queryip>[^ #@]+)(?:[#@](?P<queryport>\d+))? ` +
+			`(?P<domain>\S+) (?P<qtype>\S+) IN$`,
+		PatternReply: TAIL_UNBOUND_PREFIX + `(?:reply|info): (?P<queryip>[^ #@]+)(?:[#@](?P<queryport>\d+))? ` +
+			`(?P<domain>\S+) (?P<qtype>\S+) IN (?P<rcode>\S+) (?P<latency>[\d.]+) \d+ (?P<length>\d+)`,
+	},
+	// log-queries option, with or without the extra format
+	"dnsmasq": {
+		TimeLayout: "Jan _2 15:04:05",
+		PatternQuery: `^` + TAIL_SYSLOG_TIME + ` (?:(?P<identity>\S+) )?dnsmasq\[\d+\]: (?:\d+ [^ /]+/(?P<queryport>\d+) )?` +
+			`query\[(?P<qtype>[^\]]+)\] (?P<domain>\S+) from (?P<queryip>\S+)$`,
+		PatternReply: `^` + TAIL_SYSLOG_TIME + ` (?:(?P<identity>\S+) )?dnsmasq\[\d+\]: (?:\d+ (?P<queryip>[^ /]+)/(?P<queryport>\d+) )?` +
+			`(?:reply|cached|config) (?P<domain>\S+) is (?:(?P<rcode>NXDOMAIN|SERVFAIL|REFUSED)|\S+)`,
+	},
+	// log plugin with the default format, one line per reply
+	"coredns": {
+		TimeLayout: time.RFC3339Nano,
+		PatternReply: TAIL_COREDNS_PREFIX + `\[?(?P<queryip>[0-9a-fA-F.:]+?)\]?:(?P<queryport>\d+) - \d+ ` +
+			`"(?P<qtype>\S+) IN (?P<domain>\S+) (?P<protocol>\S+) \d+ \S+ \d+" (?P<rcode>\S+) \S+ (?P<length>\d+) (?P<latency>[\d.]+)s`,
+	},
+	// plan lines of the debug logs, one line per query, the client and the rcode are not logged
+	"knot-resolver": {
+		TimeLayout: "Jan _2 15:04:05",
+		PatternQuery: `^(?:` + TAIL_SYSLOG_TIME + ` (?:(?P<identity>\S+) )?kresd(?:@\S+)?\[\d+\]: )?` +
+			`(?:\[plan\s*\] (?:\[[\d.]+\]\s*)?|\[[\d.]+\]\[plan\]\s*)plan '(?P<domain>[^']+)' type '(?P<qtype>[^']+)'`,
+	},
+}
+
 type Tail struct {
-	doneRun    chan bool
-	stopRun    chan bool
-	tailf      *tail.Tail
-	loggers    []dnsutils.Worker
-	config     *dnsutils.Config
-	configChan chan *dnsutils.Config
-	logger     *logger.Logger
-	name       string
+	doneRun      chan bool
+	stopRun      chan bool
+	tailf        *tail.Tail
+	loggers      []dnsutils.Worker
+	config       *dnsutils.Config
+	configChan   chan *dnsutils.Config
+	logger       *logger.Logger
+	name         string
+	mode         string
+	timeLayout   string
+	normalize    bool
+	patternQuery *regexp.Regexp
+	patternReply *regexp.Regexp
 }
 
 func NewTail(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *Tail {
@@ -55,7 +131,55 @@ func (c *Tail) Loggers() []chan dnsutils.DnsMessage {
 	return channels
 }
 
-func (c *Tail) ReadConfig() {}
+func (c *Tail) ReadConfig() {
+	c.mode = c.config.Collectors.Tail.Mode
+	c.timeLayout = c.config.Collectors.Tail.TimeLayout
+	c.normalize = false
+
+	switch c.mode {
+	case dnsutils.MODE_REGEX:
+	case dnsutils.MODE_WINDOWS_DNS:
+		if len(c.timeLayout) == 0 {
+			c.timeLayout = TAIL_WINDOWS_DNS_TIME
+		}
+		c.normalize = true
+		return
+	default:
+		c.logger.Fatal("collector=tail - invalid mode: ", c.mode)
+	}
+
+	patternQuery := c.config.Collectors.Tail.PatternQuery
+	patternReply := c.config.Collectors.Tail.PatternReply
+
+	// the preset is used for the options not provided
+	if len(c.config.Collectors.Tail.Preset) > 0 {
+		preset, ok := TailPresets[c.config.Collectors.Tail.Preset]
+		if !ok {
+			c.logger.Fatal("collector=tail - invalid preset: ", c.config.Collectors.Tail.Preset)
+		}
+		if len(c.timeLayout) == 0 {
+			c.timeLayout = preset.TimeLayout
+		}
+		if len(patternQuery) == 0 && len(patternReply) == 0 {
+			patternQuery = preset.PatternQuery
+			patternReply = preset.PatternReply
+			c.normalize = true
+		}
+	}
+
+	var err error
+	c.patternQuery, c.patternReply = nil, nil
+	if len(patternQuery) > 0 {
+		if c.patternQuery, err = regexp.Compile(patternQuery); err != nil {
+			c.logger.Fatal("collector=tail - invalid pattern-query: ", err)
+		}
+	}
+	if len(patternReply) > 0 {
+		if c.patternReply, err = regexp.Compile(patternReply); err != nil {
+			c.logger.Fatal("collector=tail - invalid pattern-reply: ", err)
+		}
+	}
+}
 
 func (c *Tail) ReloadConfig(config *dnsutils.Config) {
 	c.LogInfo("reload configuration...")
@@ -97,6 +221,291 @@ func (c *Tail) Follow() error {
 	return nil
 }
 
+// ParseTime parses the timestamp with the time layout. With the built-in formats,
+// the timestamps are in the local time and the current year is used when
+// the layout does not contain the year like with syslog, the timestamps
+// of the user defined patterns are in UTC if there is no time zone
+func (c *Tail) ParseTime(value string) (time.Time, error) {
+	if c.timeLayout == TAIL_TIME_UNIX {
+		epoch, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		sec := int64(epoch)
+		return time.Unix(sec, int64((epoch-float64(sec))*1e9)), nil
+	}
+	if !c.normalize {
+		return time.Parse(c.timeLayout, value)
+	}
+
+	t, err := time.ParseInLocation(c.timeLayout, value, time.Local)
+	if err != nil {
+		return t, err
+	}
+	if t.Year() == 0 {
+		t = t.AddDate(time.Now().Year(), 0, 0)
+	}
+	return t, nil
+}
+
+// ProcessLine decodes the line according to the mode,
+// returns false if the line does not contain a dns message
+func (c *Tail) ProcessLine(line string, dm *dnsutils.DnsMessage) bool {
+	if c.mode == dnsutils.MODE_WINDOWS_DNS {
+		return c.ProcessWindowsDnsLine(line, dm)
+	}
+	return c.ProcessRegexLine(line, dm)
+}
+
+// ProcessWindowsDnsLine decodes the packet lines of the windows dns debug log,
+// the dns payload is rebuilt from the id, the flags and the question
+func (c *Tail) ProcessWindowsDnsLine(line string, dm *dnsutils.DnsMessage) bool {
+	matches := windowsDnsPattern.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+	if len(matches) == 0 {
+		return false
+	}
+	group := func(name string) string {
+		return matches[windowsDnsPattern.SubexpIndex(name)]
+	}
+
+	t, err := c.ParseTime(group("timestamp"))
+	if err != nil {
+		return false
+	}
+	id, err := strconv.ParseUint(group("xid"), 16, 16)
+	if err != nil {
+		return false
+	}
+	// the flags are displayed in the host byte order
+	flags, err := strconv.ParseUint(group("flags"), 16, 16)
+	if err != nil {
+		return false
+	}
+
+	// (3)www(7)example(3)com(0) to www.example.com.
+	qname := strings.Trim(windowsDnsLabels.ReplaceAllString(group("qname"), "."), ".")
+	qtype, ok := dns.StringToType[group("qtype")]
+	if !ok {
+		value, err := strconv.ParseUint(strings.TrimPrefix(group("qtype"), "TYPE"), 10, 16)
+		if err != nil {
+			return false
+		}
+		qtype = uint16(value)
+	}
+
+	dnspkt := new(dns.Msg)
+	dnspkt.Id = uint16(id)
+	dnspkt.Question = []dns.Question{{Name: dns.Fqdn(qname), Qtype: qtype, Qclass: dns.ClassINET}}
+	payload, err := dnspkt.Pack()
+	if err != nil {
+		return false
+	}
+	payload[2] = byte(flags & 0xff)
+	payload[3] = byte(flags >> 8)
+
+	dm.DNS.Payload = payload
+	dm.DNS.Length = len(payload)
+	dm.DnsTap.TimeSec = int(t.Unix())
+	dm.DnsTap.TimeNsec = int(t.UnixNano() - t.Unix()*1e9)
+	dm.DnsTap.Timestamp = t.UnixNano()
+	dm.DnsTap.TimestampRFC3339 = t.UTC().Format(time.RFC3339Nano)
+
+	// the remote address is the client or the server queried by the dns server
+	received := group("direction") == "Rcv"
+	response := group("qr") == "R"
+	switch {
+	case received && !response:
+		dm.DnsTap.Operation = dnsutils.DNSTAP_CLIENT_QUERY
+	case !received && response:
+		dm.DnsTap.Operation = dnsutils.DNSTAP_CLIENT_RESPONSE
+	case !received && !response:
+		dm.DnsTap.Operation = "RESOLVER_QUERY"
+	default:
+		dm.DnsTap.Operation = "RESOLVER_RESPONSE"
+	}
+	if strings.HasPrefix(dm.DnsTap.Operation, "CLIENT_") {
+		dm.NetworkInfo.QueryIp = group("ip")
+	} else {
+		dm.NetworkInfo.ResponseIp = group("ip")
+	}
+	dm.NetworkInfo.QueryPort = "0"
+	dm.NetworkInfo.ResponsePort = "0"
+	dm.NetworkInfo.Protocol = group("protocol")
+	dm.NetworkInfo.Family = dnsutils.PROTO_IPV4
+	if ip := net.ParseIP(group("ip")); ip != nil && ip.To4() == nil {
+		dm.NetworkInfo.Family = dnsutils.PROTO_IPV6
+	}
+
+	// decode the rebuilt payload to set the flags and the rcode
+	dnsHeader, err := dnsutils.DecodeDns(dm.DNS.Payload)
+	if err != nil {
+		return false
+	}
+	dm.DNS.Type = dnsutils.DnsQuery
+	if dnsHeader.Qr == 1 {
+		dm.DNS.Type = dnsutils.DnsReply
+	}
+	if err := dnsutils.DecodePayload(dm, &dnsHeader, c.config); err != nil {
+		c.LogError("%v - %s", err, line)
+		return false
+	}
+	return true
+}
+
+// ProcessRegexLine decodes the line with the query or reply pattern,
+// returns false if the line does not match. The values of the built-in presets
+// are normalized, the values of the user defined patterns are kept as is
+func (c *Tail) ProcessRegexLine(line string, dm *dnsutils.DnsMessage) bool {
+	var matches []string
+	var re *regexp.Regexp
+
+	if c.patternQuery != nil {
+		re = c.patternQuery
+		matches = re.FindStringSubmatch(line)
+		dm.DNS.Type = dnsutils.DnsQuery
+		dm.DnsTap.Operation = dnsutils.DNSTAP_OPERATION_QUERY
+	}
+
+	if c.patternReply != nil && len(matches) == 0 {
+		re = c.patternReply
+		matches = re.FindStringSubmatch(line)
+		dm.DNS.Type = dnsutils.DnsReply
+		dm.DnsTap.Operation = dnsutils.DNSTAP_OPERATION_REPLY
+	}
+
+	if len(matches) == 0 {
+		return false
+	}
+
+	// returns the value of the named group, optional groups can be empty
+	group := func(name string) (string, bool) {
+		index := re.SubexpIndex(name)
+		if index == -1 || len(matches[index]) == 0 {
+			return "", false
+		}
+		return matches[index], true
+	}
+
+	if qr, ok := group("qr"); ok {
+		dm.DnsTap.Operation = qr
+	}
+
+	t := time.Now()
+	if timestamp, ok := group("timestamp"); ok {
+		var err error
+		if t, err = c.ParseTime(timestamp); err != nil {
+			return false
+		}
+	}
+	dm.DnsTap.TimeSec = int(t.Unix())
+	dm.DnsTap.TimeNsec = int(t.UnixNano() - t.Unix()*1e9)
+
+	if identity, ok := group("identity"); ok {
+		dm.DnsTap.Identity = identity
+	}
+
+	if rcode, ok := group("rcode"); ok {
+		dm.DNS.Rcode = rcode
+		if c.normalize {
+			dm.DNS.Rcode = strings.ToUpper(rcode)
+		}
+	} else if dm.DNS.Type == dnsutils.DnsReply && c.normalize {
+		dm.DNS.Rcode = dns.RcodeToString[dns.RcodeSuccess]
+	}
+
+	if queryip, ok := group("queryip"); ok {
+		dm.NetworkInfo.QueryIp = queryip
+	}
+
+	if queryport, ok := group("queryport"); ok {
+		dm.NetworkInfo.QueryPort = queryport
+	} else {
+		dm.NetworkInfo.QueryPort = "0"
+	}
+
+	if responseip, ok := group("responseip"); ok {
+		dm.NetworkInfo.ResponseIp = responseip
+	}
+
+	if responseport, ok := group("responseport"); ok {
+		dm.NetworkInfo.ResponsePort = responseport
+	} else {
+		dm.NetworkInfo.ResponsePort = "0"
+	}
+
+	if family, ok := group("family"); ok {
+		dm.NetworkInfo.Family = family
+	} else if ip := net.ParseIP(dm.NetworkInfo.QueryIp); c.normalize && ip != nil && ip.To4() == nil {
+		dm.NetworkInfo.Family = dnsutils.PROTO_IPV6
+	} else {
+		dm.NetworkInfo.Family = dnsutils.PROTO_IPV4
+	}
+
+	if protocol, ok := group("protocol"); ok {
+		dm.NetworkInfo.Protocol = protocol
+		if c.normalize {
+			dm.NetworkInfo.Protocol = strings.ToUpper(protocol)
+		}
+	} else {
+		dm.NetworkInfo.Protocol = dnsutils.PROTO_UDP
+	}
+
+	if domain, ok := group("domain"); ok {
+		dm.DNS.Qname = domain
+		if c.normalize {
+			dm.DNS.Qname = strings.TrimSuffix(domain, ".")
+		}
+	}
+
+	if qtype, ok := group("qtype"); ok {
+		dm.DNS.Qtype = qtype
+		if c.normalize {
+			dm.DNS.Qtype = strings.ToUpper(qtype)
+		}
+	}
+
+	if latency, ok := group("latency"); ok {
+		dm.DnsTap.LatencySec = latency
+		if value, err := strconv.ParseFloat(latency, 64); err == nil {
+			dm.DnsTap.Latency = value
+		}
+	}
+
+	// compute timestamp
+	ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
+	dm.DnsTap.Timestamp = ts.UnixNano()
+	dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
+
+	// fake dns packet
+	dnspkt := new(dns.Msg)
+	dnstype, ok := dns.StringToType[strings.ToUpper(dm.DNS.Qtype)]
+	if !ok {
+		dnstype = dns.TypeA
+	}
+	dnspkt.SetQuestion(dns.Fqdn(dm.DNS.Qname), dnstype)
+
+	if dm.DNS.Type == dnsutils.DnsReply {
+		dnspkt.Response = true
+		dnspkt.Rcode = dns.StringToRcode[strings.ToUpper(dm.DNS.Rcode)]
+
+		// add a fake answer to successful replies
+		if dnspkt.Rcode == dns.RcodeSuccess && (dnstype == dns.TypeA || dnstype == dns.TypeAAAA) {
+			rr, err := dns.NewRR(fmt.Sprintf("%s %s 0.0.0.0", dns.Fqdn(dm.DNS.Qname), dns.TypeToString[dnstype]))
+			if err == nil {
+				dnspkt.Answer = append(dnspkt.Answer, rr)
+			}
+		}
+	}
+
+	dm.DNS.Payload, _ = dnspkt.Pack()
+	if length, ok := group("length"); ok {
+		dm.DNS.Length, _ = strconv.Atoi(length)
+	} else {
+		dm.DNS.Length = len(dm.DNS.Payload)
+	}
+	return true
+}
+
 func (c *Tail) Run() {
 	c.LogInfo("starting collector...")
 	err := c.Follow()
@@ -107,18 +516,9 @@ func (c *Tail) Run() {
 	// prepare enabled transformers
 	subprocessors := transformers.NewTransforms(&c.config.IngoingTransformers, c.logger, c.name, c.Loggers(), 0)
 
-	// init dns message
-	dm := dnsutils.DnsMessage{}
-	dm.Init()
-
-	// init dns message with additionnals parts
-	subprocessors.InitDnsMessageFormat(&dm)
-
-	hostname, err := os.Hostname()
-	if err == nil {
-		dm.DnsTap.Identity = hostname
-	} else {
-		dm.DnsTap.Identity = "undefined"
+	identity, err := os.Hostname()
+	if err != nil {
+		identity = "undefined"
 	}
 
 RUN_LOOP:
@@ -142,146 +542,18 @@ RUN_LOOP:
 			break RUN_LOOP
 
 		case line := <-c.tailf.Lines:
-			var matches []string
-			var re *regexp.Regexp
-
-			if len(c.config.Collectors.Tail.PatternQuery) > 0 {
-				re = regexp.MustCompile(c.config.Collectors.Tail.PatternQuery)
-				matches = re.FindStringSubmatch(line.Text)
-				dm.DNS.Type = dnsutils.DnsQuery
-				dm.DnsTap.Operation = dnsutils.DNSTAP_OPERATION_QUERY
-			}
+			// init dns message
+			dm := dnsutils.DnsMessage{}
+			dm.Init()
 
-			if len(c.config.Collectors.Tail.PatternReply) > 0 && len(matches) == 0 {
-				re = regexp.MustCompile(c.config.Collectors.Tail.PatternReply)
-				matches = re.FindStringSubmatch(line.Text)
-				dm.DNS.Type = dnsutils.DnsReply
-				dm.DnsTap.Operation = dnsutils.DNSTAP_OPERATION_REPLY
-			}
+			// init dns message with additionnals parts
+			subprocessors.InitDnsMessageFormat(&dm)
+			dm.DnsTap.Identity = identity
 
-			if len(matches) == 0 {
+			if !c.ProcessLine(line.Text, &dm) {
 				continue
 			}
 
-			qrIndex := re.SubexpIndex("qr")
-			if qrIndex != -1 {
-				dm.DnsTap.Operation = matches[qrIndex]
-			}
-
-			var t time.Time
-			timestampIndex := re.SubexpIndex("timestamp")
-			if timestampIndex != -1 {
-				t, err = time.Parse(c.config.Collectors.Tail.TimeLayout, matches[timestampIndex])
-				if err != nil {
-					continue
-				}
-			} else {
-				t = time.Now()
-			}
-			dm.DnsTap.TimeSec = int(t.Unix())
-			dm.DnsTap.TimeNsec = int(t.UnixNano() - t.Unix()*1e9)
-
-			identityIndex := re.SubexpIndex("identity")
-			if identityIndex != -1 {
-				dm.DnsTap.Identity = matches[identityIndex]
-			}
-
-			rcodeIndex := re.SubexpIndex("rcode")
-			if rcodeIndex != -1 {
-				dm.DNS.Rcode = matches[rcodeIndex]
-			}
-
-			queryipIndex := re.SubexpIndex("queryip")
-			if queryipIndex != -1 {
-				dm.NetworkInfo.QueryIp = matches[queryipIndex]
-			}
-
-			queryportIndex := re.SubexpIndex("queryport")
-			if queryportIndex != -1 {
-				dm.NetworkInfo.QueryPort = matches[queryportIndex]
-			} else {
-				dm.NetworkInfo.ResponsePort = "0"
-			}
-
-			responseipIndex := re.SubexpIndex("responseip")
-			if responseipIndex != -1 {
-				dm.NetworkInfo.ResponseIp = matches[responseipIndex]
-			}
-
-			responseportIndex := re.SubexpIndex("responseport")
-			if responseportIndex != -1 {
-				dm.NetworkInfo.ResponsePort = matches[responseportIndex]
-			} else {
-				dm.NetworkInfo.ResponsePort = "0"
-			}
-
-			familyIndex := re.SubexpIndex("family")
-			if familyIndex != -1 {
-				dm.NetworkInfo.Family = matches[familyIndex]
-			} else {
-				dm.NetworkInfo.Family = dnsutils.PROTO_IPV4
-			}
-
-			protocolIndex := re.SubexpIndex("protocol")
-			if protocolIndex != -1 {
-				dm.NetworkInfo.Protocol = matches[protocolIndex]
-			} else {
-				dm.NetworkInfo.Protocol = dnsutils.PROTO_UDP
-			}
-
-			lengthIndex := re.SubexpIndex("length")
-			if lengthIndex != -1 {
-				length, err := strconv.Atoi(matches[lengthIndex])
-				if err == nil {
-					dm.DNS.Length = length
-				}
-			}
-
-			domainIndex := re.SubexpIndex("domain")
-			if domainIndex != -1 {
-				dm.DNS.Qname = matches[domainIndex]
-			}
-
-			qtypeIndex := re.SubexpIndex("qtype")
-			if qtypeIndex != -1 {
-				dm.DNS.Qtype = matches[qtypeIndex]
-			}
-
-			latencyIndex := re.SubexpIndex("latency")
-			if latencyIndex != -1 {
-				dm.DnsTap.LatencySec = matches[latencyIndex]
-			}
-
-			// compute timestamp
-			ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
-			dm.DnsTap.Timestamp = ts.UnixNano()
-			dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
-
-			// fake dns packet
-			dnspkt := new(dns.Msg)
-			var dnstype uint16
-			dnstype = dns.TypeA
-			if dm.DNS.Qtype == "AAAA" {
-				dnstype = dns.TypeAAAA
-			}
-			dnspkt.SetQuestion(dm.DNS.Qname, dnstype)
-
-			if dm.DNS.Type == dnsutils.DnsReply {
-				rr, _ := dns.NewRR(fmt.Sprintf("%s %s 0.0.0.0", dm.DNS.Qname, dm.DNS.Qtype))
-				if err == nil {
-					dnspkt.Answer = append(dnspkt.Answer, rr)
-				}
-				var rcode int
-				rcode = 0
-				if dm.DNS.Rcode == "NXDOMAIN" {
-					rcode = 3
-				}
-				dnspkt.Rcode = rcode
-			}
-
-			dm.DNS.Payload, _ = dnspkt.Pack()
-			dm.DNS.Length = len(dm.DNS.Payload)
-
 			// apply all enabled transformers
 			if subprocessors.ProcessMessage(&dm) == transformers.RETURN_DROP {
 				continue
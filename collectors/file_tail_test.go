@@ -49,3 +49,226 @@ func TestTailRun(t *testing.T) {
 		t.Errorf("want www.google.org, got %s", msg.DNS.Qname)
 	}
 }
+
+func TestTailPresets(t *testing.T) {
+	testcases := []struct {
+		preset    string
+		line      string
+		operation string
+		qname     string
+		qtype     string
+		queryip   string
+		queryport string
+		rcode     string
+		timestamp string
+	}{
+		{
+			preset:    "bind",
+			line:      "18-Oct-2023 11:22:33.123 queries: info: client @0x7f1c2c0a1b28 192.168.1.10#53211 (www.example.com): query: www.example.com IN A +E(0)K (192.168.1.1)",
+			operation: dnsutils.DNSTAP_OPERATION_QUERY,
+			qname:     "www.example.com", qtype: "A", queryip: "192.168.1.10", queryport: "53211", rcode: "-",
+			timestamp: "2023-10-18T11:22:33.123",
+		},
+		{
+			preset:    "bind",
+			line:      "18-Oct-2023 11:22:33.456 client @0x7f1c2c0a1b28 2001:db8::1#41000 (www.example.com): query failed (SERVFAIL) for www.example.com/IN/AAAA at query.c:7375",
+			operation: dnsutils.DNSTAP_OPERATION_REPLY,
+			qname:     "www.example.com", qtype: "AAAA", queryip: "2001:db8::1", queryport: "41000", rcode: "SERVFAIL",
+			timestamp: "2023-10-18T11:22:33.456",
+		},
+		{
+			preset:    "unbound",
+			line:      "[1697628153] unbound[1234:0] query: 192.168.1.10 www.example.com. MX IN",
+			operation: dnsutils.DNSTAP_OPERATION_QUERY,
+			qname:     "www.example.com", qtype: "MX", queryip: "192.168.1.10", queryport: "0", rcode: "-",
+		},
+		{
+			preset:    "unbound",
+			line:      "[1697628153] unbound[1234:0] reply: 192.168.1.10 www.example.com. A IN NXDOMAIN 0.000123 0 56",
+			operation: dnsutils.DNSTAP_OPERATION_REPLY,
+			qname:     "www.example.com", qtype: "A", queryip: "192.168.1.10", queryport: "0", rcode: "NXDOMAIN",
+		},
+		{
+			preset:    "unbound",
+			line:      "[1697628153] unbound[1234:0] info: 192.168.1.10 www.example.com. AAAA IN",
+			operation: dnsutils.DNSTAP_OPERATION_QUERY,
+			qname:     "www.example.com", qtype: "AAAA", queryip: "192.168.1.10", queryport: "0", rcode: "-",
+		},
+		{
+			preset:    "unbound",
+			line:      "[1697628153] unbound[1234:0] info: 192.168.1.10 www.example.com. AAAA IN NOERROR 0.000123 0 84",
+			operation: dnsutils.DNSTAP_OPERATION_REPLY,
+			qname:     "www.example.com", qtype: "AAAA", queryip: "192.168.1.10", queryport: "0", rcode: "NOERROR",
+		},
+		{
+			preset:    "dnsmasq",
+			line:      "Oct 18 11:22:33 dnsmasq[1234]: query[AAAA] www.example.com from 192.168.1.10",
+			operation: dnsutils.DNSTAP_OPERATION_QUERY,
+			qname:     "www.example.com", qtype: "AAAA", queryip: "192.168.1.10", queryport: "0", rcode: "-",
+		},
+		{
+			preset:    "dnsmasq",
+			line:      "Oct 18 11:22:33 myhost dnsmasq[1234]: 5 192.168.1.10/53211 reply www.example.com is NXDOMAIN",
+			operation: dnsutils.DNSTAP_OPERATION_REPLY,
+			qname:     "www.example.com", qtype: "-", queryip: "192.168.1.10", queryport: "53211", rcode: "NXDOMAIN",
+		},
+		{
+			preset:    "coredns",
+			line:      `[INFO] [::1]:50759 - 29008 "A IN www.example.com. udp 41 false 4096" NOERROR qr,aa,rd 106 0.000123s`,
+			operation: dnsutils.DNSTAP_OPERATION_REPLY,
+			qname:     "www.example.com", qtype: "A", queryip: "::1", queryport: "50759", rcode: "NOERROR",
+		},
+		{
+			preset:    "coredns",
+			line:      `2023-10-18T11:22:33.123456789Z stdout F [INFO] 10.0.0.5:53211 - 29008 "TXT IN example.org. tcp 41 false 65535" NXDOMAIN qr,rd 100 0.001s`,
+			operation: dnsutils.DNSTAP_OPERATION_REPLY,
+			qname:     "example.org", qtype: "TXT", queryip: "10.0.0.5", queryport: "53211", rcode: "NXDOMAIN",
+			timestamp: "2023-10-18T11:22:33.123456789Z",
+		},
+		{
+			preset:    "knot-resolver",
+			line:      "Oct 18 11:22:33 myhost kresd@1[1234]: [plan   ] [75512.00] plan 'www.example.com.' type 'A' uid [75512.00]",
+			operation: dnsutils.DNSTAP_OPERATION_QUERY,
+			qname:     "www.example.com", qtype: "A", queryip: "-", queryport: "0", rcode: "-",
+		},
+		{
+			preset:    "knot-resolver",
+			line:      "[75512.00][plan] plan 'example.org.' type 'AAAA' uid [75512.00]",
+			operation: dnsutils.DNSTAP_OPERATION_QUERY,
+			qname:     "example.org", qtype: "AAAA", queryip: "-", queryport: "0", rcode: "-",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.preset, func(t *testing.T) {
+			config := dnsutils.GetFakeConfig()
+			config.Collectors.Tail.Preset = tc.preset
+			c := NewTail(nil, config, logger.New(false), "test")
+
+			dm := dnsutils.DnsMessage{}
+			dm.Init()
+			if !c.ProcessLine(tc.line, &dm) {
+				t.Fatalf("line not matched: %s", tc.line)
+			}
+
+			if dm.DnsTap.Operation != tc.operation {
+				t.Errorf("want operation %s, got %s", tc.operation, dm.DnsTap.Operation)
+			}
+			if dm.DNS.Qname != tc.qname || dm.DNS.Qtype != tc.qtype {
+				t.Errorf("want %s/%s, got %s/%s", tc.qname, tc.qtype, dm.DNS.Qname, dm.DNS.Qtype)
+			}
+			if dm.NetworkInfo.QueryIp != tc.queryip || dm.NetworkInfo.QueryPort != tc.queryport {
+				t.Errorf("want client %s#%s, got %s#%s", tc.queryip, tc.queryport, dm.NetworkInfo.QueryIp, dm.NetworkInfo.QueryPort)
+			}
+			if dm.DNS.Rcode != tc.rcode {
+				t.Errorf("want rcode %s, got %s", tc.rcode, dm.DNS.Rcode)
+			}
+			if len(tc.timestamp) > 0 {
+				want, _ := time.ParseInLocation("2006-01-02T15:04:05.999999999Z07:00", tc.timestamp, time.Local)
+				if want.IsZero() {
+					want, _ = time.ParseInLocation("2006-01-02T15:04:05.999999999", tc.timestamp, time.Local)
+				}
+				if dm.DnsTap.Timestamp != want.UnixNano() {
+					t.Errorf("want timestamp %s, got %s", tc.timestamp, dm.DnsTap.TimestampRFC3339)
+				}
+			}
+			if len(dm.DNS.Payload) == 0 {
+				t.Errorf("dns payload not generated")
+			}
+		})
+	}
+}
+
+func TestTailUserPattern(t *testing.T) {
+	// the values of the user defined patterns are not normalized
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Tail.Preset = "unbound"
+	config.Collectors.Tail.TimeLayout = "2006-01-02 15:04:05"
+	config.Collectors.Tail.PatternReply = `^(?P<timestamp>\S+ \S+) reply: (?P<queryip>\S+) (?P<domain>\S+) (?P<qtype>\S+) (?P<rcode>\S+)$`
+	c := NewTail(nil, config, logger.New(false), "test")
+
+	dm := dnsutils.DnsMessage{}
+	dm.Init()
+	if !c.ProcessLine("2023-10-18 11:22:33 reply: 192.168.1.10 www.example.com. aaaa nxdomain", &dm) {
+		t.Fatal("line not matched")
+	}
+	if dm.DNS.Qname != "www.example.com." || dm.DNS.Qtype != "aaaa" || dm.DNS.Rcode != "nxdomain" {
+		t.Errorf("values should be kept as is, got %s/%s %s", dm.DNS.Qname, dm.DNS.Qtype, dm.DNS.Rcode)
+	}
+	if dm.DnsTap.TimestampRFC3339 != "2023-10-18T11:22:33Z" {
+		t.Errorf("want timestamp in UTC, got %s", dm.DnsTap.TimestampRFC3339)
+	}
+	if len(dm.DNS.Payload) == 0 {
+		t.Errorf("dns payload not generated")
+	}
+}
+
+func TestTailWindowsDns(t *testing.T) {
+	expected := []struct {
+		operation string
+		qname     string
+		qtype     string
+		protocol  string
+		ip        string
+		rcode     string
+		id        int
+		ra        bool
+		aa        bool
+		hour      int
+	}{
+		{operation: dnsutils.DNSTAP_CLIENT_QUERY, qname: "www.example.com", qtype: "A", protocol: dnsutils.PROTO_UDP, ip: "192.168.1.10", rcode: "NOERROR", id: 0xa1b2, hour: 11},
+		{operation: "RESOLVER_QUERY", qname: "www.example.com", qtype: "A", protocol: dnsutils.PROTO_UDP, ip: "8.8.8.8", rcode: "NOERROR", id: 0x5c3d, hour: 11},
+		{operation: "RESOLVER_RESPONSE", qname: "www.example.com", qtype: "A", protocol: dnsutils.PROTO_UDP, ip: "8.8.8.8", rcode: "NOERROR", id: 0x5c3d, ra: true, hour: 11},
+		{operation: dnsutils.DNSTAP_CLIENT_RESPONSE, qname: "www.example.com", qtype: "A", protocol: dnsutils.PROTO_UDP, ip: "192.168.1.10", rcode: "NOERROR", id: 0xa1b2, ra: true, hour: 11},
+		{operation: dnsutils.DNSTAP_CLIENT_QUERY, qname: "unknown.corp", qtype: "AAAA", protocol: dnsutils.PROTO_TCP, ip: "2001:db8::10", rcode: "NOERROR", id: 3, hour: 13},
+		{operation: dnsutils.DNSTAP_CLIENT_RESPONSE, qname: "unknown.corp", qtype: "AAAA", protocol: dnsutils.PROTO_TCP, ip: "2001:db8::10", rcode: "NXDOMAIN", id: 3, ra: true, aa: true, hour: 13},
+	}
+
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Tail.Mode = dnsutils.MODE_WINDOWS_DNS
+	c := NewTail(nil, config, logger.New(false), "test")
+
+	f, err := os.Open("./../testsdata/windowsdns/dns.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	dms := []dnsutils.DnsMessage{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		dm := dnsutils.DnsMessage{}
+		dm.Init()
+		if c.ProcessLine(scanner.Text(), &dm) {
+			dms = append(dms, dm)
+		}
+	}
+
+	if len(dms) != len(expected) {
+		t.Fatalf("want %d dns messages, got %d", len(expected), len(dms))
+	}
+	for i, want := range expected {
+		dm := dms[i]
+		if dm.DnsTap.Operation != want.operation {
+			t.Errorf("%d: want operation %s, got %s", i, want.operation, dm.DnsTap.Operation)
+		}
+		if dm.DNS.Qname != want.qname || dm.DNS.Qtype != want.qtype {
+			t.Errorf("%d: want %s/%s, got %s/%s", i, want.qname, want.qtype, dm.DNS.Qname, dm.DNS.Qtype)
+		}
+		if dm.NetworkInfo.Protocol != want.protocol {
+			t.Errorf("%d: want protocol %s, got %s", i, want.protocol, dm.NetworkInfo.Protocol)
+		}
+		if dm.NetworkInfo.QueryIp != want.ip && dm.NetworkInfo.ResponseIp != want.ip {
+			t.Errorf("%d: remote ip %s not found", i, want.ip)
+		}
+		if dm.DNS.Rcode != want.rcode || dm.DNS.Id != want.id {
+			t.Errorf("%d: want rcode %s and id %d, got %s and %d", i, want.rcode, want.id, dm.DNS.Rcode, dm.DNS.Id)
+		}
+		if dm.DNS.Flags.RA != want.ra || dm.DNS.Flags.AA != want.aa {
+			t.Errorf("%d: invalid flags %+v", i, dm.DNS.Flags)
+		}
+		if ts := time.Unix(int64(dm.DnsTap.TimeSec), 0); ts.Hour() != want.hour || ts.Year() != 2023 {
+			t.Errorf("%d: invalid timestamp %s", i, dm.DnsTap.TimestampRFC3339)
+		}
+	}
+}
@@ -0,0 +1,523 @@
+package collectors
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/processors"
+	"github.com/dmachard/go-logger"
+	"github.com/vmihailenco/msgpack"
+	"github.com/vmihailenco/msgpack/codes"
+)
+
+// msgpackRecorder keeps a copy of the bytes read by the msgpack decoder,
+// to extract the raw records without decoding them
+type msgpackRecorder struct {
+	*bufio.Reader
+	record *bytes.Buffer
+}
+
+func (r *msgpackRecorder) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if r.record != nil {
+		r.record.Write(p[:n])
+	}
+	return n, err
+}
+
+func (r *msgpackRecorder) ReadByte() (byte, error) {
+	b, err := r.Reader.ReadByte()
+	if err == nil && r.record != nil {
+		r.record.WriteByte(b)
+	}
+	return b, err
+}
+
+func (r *msgpackRecorder) UnreadByte() error {
+	err := r.Reader.UnreadByte()
+	if err == nil && r.record != nil && r.record.Len() > 0 {
+		r.record.Truncate(r.record.Len() - 1)
+	}
+	return err
+}
+
+// ReadRaw returns the next msgpack value as raw bytes
+func (r *msgpackRecorder) ReadRaw(d *msgpack.Decoder) ([]byte, error) {
+	r.record = new(bytes.Buffer)
+	defer func() { r.record = nil }()
+
+	if err := d.Skip(); err != nil {
+		return nil, err
+	}
+	return r.record.Bytes(), nil
+}
+
+func newMsgpackRecorder(r io.Reader) (*msgpackRecorder, *msgpack.Decoder) {
+	rec := &msgpackRecorder{Reader: bufio.NewReader(r)}
+	return rec, msgpack.NewDecoder(rec)
+}
+
+func msgpackToString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return ""
+}
+
+func sha512Hex(values ...string) string {
+	h := sha512.New()
+	for _, v := range values {
+		h.Write([]byte(v))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type Fluentd struct {
+	doneRun       chan bool
+	stopRun       chan bool
+	listen        net.Listener
+	conns         []net.Conn
+	wgConns       sync.WaitGroup
+	loggers       []dnsutils.Worker
+	config        *dnsutils.Config
+	configChan    chan *dnsutils.Config
+	logger        *logger.Logger
+	name          string
+	connMode      string
+	connId        int
+	msgProcessors []processors.DnsMessageProcessor
+	sync.RWMutex
+}
+
+func NewFluentd(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *Fluentd {
+	logger.Info("[%s] collector=fluentd - enabled", name)
+	s := &Fluentd{
+		doneRun:    make(chan bool),
+		stopRun:    make(chan bool),
+		config:     config,
+		configChan: make(chan *dnsutils.Config),
+		loggers:    loggers,
+		logger:     logger,
+		name:       name,
+	}
+	s.ReadConfig()
+	return s
+}
+
+func (c *Fluentd) GetName() string { return c.name }
+
+func (c *Fluentd) SetLoggers(loggers []dnsutils.Worker) {
+	c.loggers = loggers
+}
+
+func (c *Fluentd) Loggers() ([]chan dnsutils.DnsMessage, []string) {
+	channels := []chan dnsutils.DnsMessage{}
+	names := []string{}
+	for _, p := range c.loggers {
+		channels = append(channels, p.Channel())
+		names = append(names, p.GetName())
+	}
+	return channels, names
+}
+
+func (c *Fluentd) ReadConfig() {
+	if !dnsutils.IsValidTLS(c.config.Collectors.Fluentd.TlsMinVersion) {
+		c.logger.Fatal("collector=fluentd - invalid tls min version")
+	}
+
+	if c.config.Collectors.Fluentd.TlsSupport {
+		c.connMode = "tls"
+	} else {
+		c.connMode = "tcp"
+	}
+}
+
+func (c *Fluentd) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.configChan <- config
+}
+
+func (c *Fluentd) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] collector=fluentd - "+msg, v...)
+}
+
+func (c *Fluentd) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] collector=fluentd - "+msg, v...)
+}
+
+func (c *Fluentd) LogConnInfo(connId int, msg string, v ...interface{}) {
+	prefix := fmt.Sprintf("[%s] collector=fluentd#%d - ", c.name, connId)
+	c.logger.Info(prefix+msg, v...)
+}
+
+func (c *Fluentd) LogConnError(connId int, msg string, v ...interface{}) {
+	prefix := fmt.Sprintf("[%s] collector=fluentd#%d - ", c.name, connId)
+	c.logger.Error(prefix+msg, v...)
+}
+
+// Handshake authenticates the client with the shared key,
+// HELO is sent by the server, then PING by the client and PONG by the server
+func (c *Fluentd) Handshake(conn net.Conn, dec *msgpack.Decoder) error {
+	sharedKey := c.config.Collectors.Fluentd.SharedKey
+	enc := msgpack.NewEncoder(conn)
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	// user authentication is not supported, the auth salt is empty
+	helo := []interface{}{"HELO", map[string]interface{}{"nonce": nonce, "auth": []byte{}, "keepalive": true}}
+	if err := enc.Encode(helo); err != nil {
+		return err
+	}
+
+	// PING ::= [ "PING", hostname, shared_key_salt, shared_key_hexdigest, username, password_digest ]
+	var ping []interface{}
+	if err := dec.Decode(&ping); err != nil {
+		return err
+	}
+	if len(ping) != 6 || msgpackToString(ping[0]) != "PING" {
+		return errors.New("invalid ping message")
+	}
+	hostname := msgpackToString(ping[1])
+	salt := msgpackToString(ping[2])
+	digest := msgpackToString(ping[3])
+
+	// PONG ::= [ "PONG", auth_result, reason, self_hostname, shared_key_hexdigest ]
+	selfHostname := c.config.Collectors.Fluentd.SelfHostname
+	if digest != sha512Hex(salt, hostname, string(nonce), sharedKey) {
+		enc.Encode([]interface{}{"PONG", false, "shared key mismatch", selfHostname, ""})
+		return fmt.Errorf("shared key mismatch for %s", hostname)
+	}
+	return enc.Encode([]interface{}{"PONG", true, "", selfHostname, sha512Hex(salt, selfHostname, string(nonce), sharedKey)})
+}
+
+// ReadEntries reads a stream of entries, Entry ::= [ Time, Record ]
+func (c *Fluentd) ReadEntries(rec *msgpackRecorder, dec *msgpack.Decoder, count int, msgProcessor processors.DnsMessageProcessor) error {
+	for i := 0; count < 0 || i < count; i++ {
+		n, err := dec.DecodeArrayLen()
+		if err != nil {
+			if count < 0 && errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if n != 2 {
+			return fmt.Errorf("invalid entry with %d elements", n)
+		}
+
+		// the time is ignored, the record contains the timestamp of the dns message
+		if err := dec.Skip(); err != nil {
+			return err
+		}
+		record, err := rec.ReadRaw(dec)
+		if err != nil {
+			return err
+		}
+		msgProcessor.GetChannel() <- record
+	}
+	return nil
+}
+
+// ReadEvent decodes one event in the Message, Forward,
+// PackedForward or CompressedPackedForward mode
+func (c *Fluentd) ReadEvent(conn net.Conn, rec *msgpackRecorder, dec *msgpack.Decoder, msgProcessor processors.DnsMessageProcessor) error {
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return err
+	}
+	if n < 2 {
+		return fmt.Errorf("invalid event with %d elements", n)
+	}
+
+	// tag
+	if err := dec.Skip(); err != nil {
+		return err
+	}
+
+	code, err := dec.PeekCode()
+	if err != nil {
+		return err
+	}
+
+	var entries []byte
+	remaining := n - 2
+	switch {
+	// Forward ::= [ Tag, [ Entry... ], Option? ]
+	case codes.IsFixedArray(code) || code == codes.Array16 || code == codes.Array32:
+		count, err := dec.DecodeArrayLen()
+		if err != nil {
+			return err
+		}
+		if err := c.ReadEntries(rec, dec, count, msgProcessor); err != nil {
+			return err
+		}
+
+	// PackedForward ::= [ Tag, MessagePackEventStream, Option? ]
+	case codes.IsBin(code) || codes.IsString(code):
+		if entries, err = dec.DecodeBytes(); err != nil {
+			return err
+		}
+
+	// Message ::= [ Tag, Time, Record, Option? ]
+	default:
+		if n < 3 {
+			return fmt.Errorf("invalid message with %d elements", n)
+		}
+		if err := dec.Skip(); err != nil {
+			return err
+		}
+		record, err := rec.ReadRaw(dec)
+		if err != nil {
+			return err
+		}
+		msgProcessor.GetChannel() <- record
+		remaining--
+	}
+
+	// read options
+	options := map[string]interface{}{}
+	if remaining > 0 {
+		if err := dec.Decode(&options); err != nil {
+			return err
+		}
+	}
+
+	// decode packed entries, maybe compressed
+	if entries != nil {
+		var r io.Reader = bytes.NewReader(entries)
+		if msgpackToString(options["compressed"]) == "gzip" {
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				return err
+			}
+			defer gz.Close()
+			r = gz
+		}
+		packedRec, packedDec := newMsgpackRecorder(r)
+		if err := c.ReadEntries(packedRec, packedDec, -1, msgProcessor); err != nil {
+			return err
+		}
+	}
+
+	// acknowledge the chunk if requested
+	if chunk, ok := options["chunk"]; ok {
+		return msgpack.NewEncoder(conn).Encode(map[string]interface{}{"ack": msgpackToString(chunk)})
+	}
+	return nil
+}
+
+func (c *Fluentd) HandleConn(conn net.Conn) {
+	defer c.wgConns.Done()
+
+	// close connection on function exit
+	defer conn.Close()
+
+	var connId int
+	c.Lock()
+	c.connId++
+	connId = c.connId
+	c.Unlock()
+
+	// get peer address
+	peer := conn.RemoteAddr().String()
+	c.LogConnInfo(connId, "new connection from %s", peer)
+
+	rec, dec := newMsgpackRecorder(conn)
+
+	// authenticate the client
+	if len(c.config.Collectors.Fluentd.SharedKey) > 0 {
+		if err := c.Handshake(conn, dec); err != nil {
+			c.LogConnError(connId, "handshake error: %s", err)
+			return
+		}
+		c.LogConnInfo(connId, "client authenticated")
+	}
+
+	// start the processor to decode records from the fluentd logger
+	msgProcessor := processors.NewDnsMessageProcessor(connId, dnsutils.MODE_MSGPACK, []string{},
+		c.config, c.logger, c.name, c.config.Collectors.Fluentd.ChannelBufferSize)
+	c.Lock()
+	c.msgProcessors = append(c.msgProcessors, msgProcessor)
+	c.Unlock()
+	go msgProcessor.Run(c.Loggers())
+
+	for {
+		if err := c.ReadEvent(conn, rec, dec, msgProcessor); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				c.LogConnInfo(connId, "connection closed with peer %s", peer)
+			} else {
+				c.LogConnError(connId, "forward protocol error: %s", err)
+			}
+			break
+		}
+	}
+
+	// stop processor
+	msgProcessor.Stop()
+
+	// here the connection is closed,
+	// then removes the current processor from the list
+	c.Lock()
+	for i, p := range c.msgProcessors {
+		if p.ConnId == connId {
+			c.msgProcessors = append(c.msgProcessors[:i], c.msgProcessors[i+1:]...)
+			break
+		}
+	}
+
+	// finnaly removes the current connection from the list
+	for j, cn := range c.conns {
+		if cn == conn {
+			c.conns = append(c.conns[:j], c.conns[j+1:]...)
+			break
+		}
+	}
+	c.Unlock()
+
+	c.LogConnInfo(connId, "connection handler terminated")
+}
+
+func (c *Fluentd) Channel() chan dnsutils.DnsMessage {
+	return nil
+}
+
+func (c *Fluentd) Stop() {
+	// close the listener to unblock accept
+	c.LogInfo("stop listening...")
+	c.listen.Close()
+
+	// read done channel and block until run is terminated
+	c.LogInfo("stopping run...")
+	c.stopRun <- true
+	<-c.doneRun
+
+	// closing properly current connections if exists
+	c.LogInfo("closing connected peers...")
+	c.Lock()
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+	c.Unlock()
+
+	// wait the end of connection handlers, processors are stopped
+	c.wgConns.Wait()
+}
+
+func (c *Fluentd) Listen() error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.LogInfo("running in background...")
+
+	var err error
+	var listener net.Listener
+	addrlisten := c.config.Collectors.Fluentd.ListenIP + ":" + strconv.Itoa(c.config.Collectors.Fluentd.ListenPort)
+
+	// listening with tls enabled ?
+	if c.config.Collectors.Fluentd.TlsSupport {
+		c.LogInfo("tls support enabled")
+
+		tlsOptions := dnsutils.TlsOptions{
+			MinVersion: c.config.Collectors.Fluentd.TlsMinVersion,
+			CAFile:     c.config.Collectors.Fluentd.CAFile,
+			CertFile:   c.config.Collectors.Fluentd.CertFile,
+			KeyFile:    c.config.Collectors.Fluentd.KeyFile,
+		}
+
+		var tlsConfig *tls.Config
+		tlsConfig, err = dnsutils.TlsServerConfig(tlsOptions)
+		if err != nil {
+			c.logger.Fatal("collector=fluentd - tls config failed:", err)
+		}
+
+		listener, err = tls.Listen(dnsutils.SOCKET_TCP, addrlisten, tlsConfig)
+	} else {
+		// basic listening
+		listener, err = net.Listen(dnsutils.SOCKET_TCP, addrlisten)
+	}
+
+	// something is wrong ?
+	if err != nil {
+		return err
+	}
+	c.LogInfo("is listening on %s://%s", c.connMode, listener.Addr())
+	c.listen = listener
+	return nil
+}
+
+func (c *Fluentd) Run() {
+	c.LogInfo("starting collector...")
+	if c.listen == nil {
+		if err := c.Listen(); err != nil {
+			prefixlog := fmt.Sprintf("[%s] ", c.name)
+			c.logger.Fatal(prefixlog+"collector=fluentd listening failed: ", err)
+		}
+	}
+
+	// goroutine to Accept() blocks waiting for new connection.
+	acceptChan := make(chan net.Conn)
+	acceptDone := make(chan bool)
+	go func() {
+		for {
+			conn, err := c.listen.Accept()
+			if err != nil {
+				return
+			}
+			select {
+			case acceptChan <- conn:
+			case <-acceptDone:
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+RUN_LOOP:
+	for {
+		select {
+		case <-c.stopRun:
+			close(acceptDone)
+			c.doneRun <- true
+			break RUN_LOOP
+
+		case cfg := <-c.configChan:
+
+			// save the new config
+			c.config = cfg
+			c.ReadConfig()
+
+			// refresh config for all conns
+			c.RLock()
+			for i := range c.msgProcessors {
+				c.msgProcessors[i].ConfigChan <- cfg
+			}
+			c.RUnlock()
+
+		case conn := <-acceptChan:
+			c.Lock()
+			c.conns = append(c.conns, conn)
+			c.wgConns.Add(1)
+			c.Unlock()
+			go c.HandleConn(conn)
+		}
+
+	}
+	c.LogInfo("run terminated")
+}
@@ -0,0 +1,242 @@
+package collectors
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-logger"
+	"github.com/vmihailenco/msgpack"
+)
+
+func Test_FluentdCollector(t *testing.T) {
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = "fluentd.collector.test"
+	record, _ := msgpack.Marshal(dm)
+	now, _ := msgpack.Marshal(time.Now().Unix())
+	tag, _ := msgpack.Marshal("dns.collector")
+
+	// Entry ::= [ Time, Record ]
+	entry := append([]byte{0x92}, now...)
+	entry = append(entry, record...)
+
+	var stream bytes.Buffer
+	stream.Write(entry)
+	stream.Write(entry)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(stream.Bytes())
+	gz.Close()
+
+	testcases := []struct {
+		name     string
+		tls      bool
+		event    func() []byte
+		expected int
+		ack      bool
+	}{
+		{
+			name: "message",
+			event: func() []byte {
+				// same encoding as the fluentd logger
+				ev := append([]byte{0x93}, tag...)
+				ev = append(ev, now...)
+				return append(ev, record...)
+			},
+			expected: 1,
+		},
+		{
+			name: "forward",
+			event: func() []byte {
+				ev := append([]byte{0x92}, tag...)
+				ev = append(ev, 0x93)
+				ev = append(ev, entry...)
+				ev = append(ev, entry...)
+				return append(ev, entry...)
+			},
+			expected: 3,
+		},
+		{
+			name: "packed_forward",
+			event: func() []byte {
+				packed, _ := msgpack.Marshal(stream.Bytes())
+				ev := append([]byte{0x92}, tag...)
+				return append(ev, packed...)
+			},
+			expected: 2,
+		},
+		{
+			name: "compressed_packed_forward",
+			event: func() []byte {
+				packed, _ := msgpack.Marshal(compressed.Bytes())
+				option, _ := msgpack.Marshal(map[string]interface{}{"compressed": "gzip", "chunk": "p8n9gmxTQVC8/nh2wlKKeQ=="})
+				ev := append([]byte{0x93}, tag...)
+				ev = append(ev, packed...)
+				return append(ev, option...)
+			},
+			expected: 2,
+			ack:      true,
+		},
+		{
+			name: "tls",
+			tls:  true,
+			event: func() []byte {
+				ev := append([]byte{0x93}, tag...)
+				ev = append(ev, now...)
+				return append(ev, record...)
+			},
+			expected: 1,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := loggers.NewFakeLogger()
+
+			config := dnsutils.GetFakeConfig()
+			config.Collectors.Fluentd.ListenIP = dnsutils.LOCALHOST_IP
+			config.Collectors.Fluentd.ListenPort = 0
+			if tc.tls {
+				config.Collectors.Fluentd.TlsSupport = true
+				config.Collectors.Fluentd.CertFile = "./../testsdata/certs/server.crt"
+				config.Collectors.Fluentd.KeyFile = "./../testsdata/certs/server.key"
+			}
+
+			c := NewFluentd([]dnsutils.Worker{g}, config, logger.New(false), "test")
+			if err := c.Listen(); err != nil {
+				t.Fatal("collector listening error: ", err)
+			}
+			go c.Run()
+
+			var conn net.Conn
+			var err error
+			if tc.tls {
+				conn, err = tls.Dial(dnsutils.SOCKET_TCP, c.listen.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+			} else {
+				conn, err = net.Dial(dnsutils.SOCKET_TCP, c.listen.Addr().String())
+			}
+			if err != nil {
+				t.Fatal("could not connect: ", err)
+			}
+
+			if _, err := conn.Write(tc.event()); err != nil {
+				t.Fatal("could not send event: ", err)
+			}
+
+			// waiting messages in channel
+			for i := 0; i < tc.expected; i++ {
+				select {
+				case msg := <-g.Channel():
+					if msg.DNS.Qname != dm.DNS.Qname {
+						t.Errorf("want qname %s, got %s", dm.DNS.Qname, msg.DNS.Qname)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("dns message %d not received", i)
+				}
+			}
+
+			// read the acknowledgment
+			if tc.ack {
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				var resp map[string]interface{}
+				if err := msgpack.NewDecoder(conn).Decode(&resp); err != nil {
+					t.Fatal("could not read ack: ", err)
+				}
+				if resp["ack"] != "p8n9gmxTQVC8/nh2wlKKeQ==" {
+					t.Errorf("invalid ack: %v", resp)
+				}
+			}
+
+			conn.Close()
+			c.Stop()
+		})
+	}
+}
+
+func Test_FluentdCollector_SharedKey(t *testing.T) {
+	testcases := []struct {
+		name      string
+		sharedKey string
+		authOk    bool
+	}{
+		{name: "valid_key", sharedKey: "secret", authOk: true},
+		{name: "invalid_key", sharedKey: "badsecret", authOk: false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := loggers.NewFakeLogger()
+
+			config := dnsutils.GetFakeConfig()
+			config.Collectors.Fluentd.ListenIP = dnsutils.LOCALHOST_IP
+			config.Collectors.Fluentd.ListenPort = 0
+			config.Collectors.Fluentd.SharedKey = "secret"
+
+			c := NewFluentd([]dnsutils.Worker{g}, config, logger.New(false), "test")
+			if err := c.Listen(); err != nil {
+				t.Fatal("collector listening error: ", err)
+			}
+			go c.Run()
+			defer c.Stop()
+
+			conn, err := net.Dial(dnsutils.SOCKET_TCP, c.listen.Addr().String())
+			if err != nil {
+				t.Fatal("could not connect: ", err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			dec := msgpack.NewDecoder(conn)
+			enc := msgpack.NewEncoder(conn)
+
+			// read HELO
+			var helo []interface{}
+			if err := dec.Decode(&helo); err != nil {
+				t.Fatal("could not read helo: ", err)
+			}
+			options := helo[1].(map[string]interface{})
+			nonce := msgpackToString(options["nonce"])
+
+			// send PING
+			salt := "salt"
+			digest := sha512Hex(salt, "client", nonce, tc.sharedKey)
+			if err := enc.Encode([]interface{}{"PING", "client", salt, digest, "", ""}); err != nil {
+				t.Fatal("could not send ping: ", err)
+			}
+
+			// read PONG
+			var pong []interface{}
+			if err := dec.Decode(&pong); err != nil {
+				t.Fatal("could not read pong: ", err)
+			}
+			if pong[1] != tc.authOk {
+				t.Fatalf("want auth result %v, got %v", tc.authOk, pong)
+			}
+			if !tc.authOk {
+				return
+			}
+			if msgpackToString(pong[4]) != sha512Hex(salt, config.Collectors.Fluentd.SelfHostname, nonce, tc.sharedKey) {
+				t.Errorf("invalid server digest")
+			}
+
+			// send a message after authentication
+			dm := dnsutils.GetFakeDnsMessage()
+			enc.Encode([]interface{}{"dns.collector", time.Now().Unix(), dm})
+
+			select {
+			case msg := <-g.Channel():
+				if msg.DNS.Qname != dm.DNS.Qname {
+					t.Errorf("want qname %s, got %s", dm.DNS.Qname, msg.DNS.Qname)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("dns message not received")
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package collectors
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"unicode"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/processors"
+	"github.com/dmachard/go-logger"
+)
+
+// errBodyTooLarge is returned when the decompressed body exceeds the maximum size
+var errBodyTooLarge = errors.New("body too large")
+
+// maxSizeReader returns errBodyTooLarge when more than max bytes are read
+type maxSizeReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.read += int64(n)
+	if m.read > m.max {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+type HttpIngest struct {
+	doneRun      chan bool
+	stopRun      chan bool
+	doneServer   chan bool
+	listen       net.Listener
+	httpserver   *http.Server
+	loggers      []dnsutils.Worker
+	config       *dnsutils.Config
+	configChan   chan *dnsutils.Config
+	logger       *logger.Logger
+	name         string
+	msgProcessor processors.DnsMessageProcessor
+	sync.RWMutex
+}
+
+func NewHttpIngest(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *HttpIngest {
+	logger.Info("[%s] collector=httpingest - enabled", name)
+	s := &HttpIngest{
+		doneRun:    make(chan bool),
+		stopRun:    make(chan bool),
+		doneServer: make(chan bool),
+		config:     config,
+		configChan: make(chan *dnsutils.Config),
+		loggers:    loggers,
+		logger:     logger,
+		name:       name,
+	}
+	s.ReadConfig()
+	return s
+}
+
+func (c *HttpIngest) GetName() string { return c.name }
+
+func (c *HttpIngest) SetLoggers(loggers []dnsutils.Worker) {
+	c.loggers = loggers
+}
+
+func (c *HttpIngest) Loggers() ([]chan dnsutils.DnsMessage, []string) {
+	channels := []chan dnsutils.DnsMessage{}
+	names := []string{}
+	for _, p := range c.loggers {
+		channels = append(channels, p.Channel())
+		names = append(names, p.GetName())
+	}
+	return channels, names
+}
+
+func (c *HttpIngest) ReadConfig() {
+	switch c.config.Collectors.HttpIngest.Mode {
+	case dnsutils.MODE_JSON, dnsutils.MODE_FLATJSON:
+	default:
+		c.logger.Fatal("collector=httpingest - invalid mode: ", c.config.Collectors.HttpIngest.Mode)
+	}
+	if !dnsutils.IsValidTLS(c.config.Collectors.HttpIngest.TlsMinVersion) {
+		c.logger.Fatal("collector=httpingest - invalid tls min version")
+	}
+	if c.config.Collectors.HttpIngest.MaxBodySize < 1 {
+		c.logger.Fatal("collector=httpingest - max-body-size must be greater than 0")
+	}
+}
+
+func (c *HttpIngest) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.configChan <- config
+}
+
+func (c *HttpIngest) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] collector=httpingest - "+msg, v...)
+}
+
+func (c *HttpIngest) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] collector=httpingest - "+msg, v...)
+}
+
+func (c *HttpIngest) Channel() chan dnsutils.DnsMessage {
+	return nil
+}
+
+func (c *HttpIngest) Stop() {
+	c.LogInfo("stopping...")
+
+	// read done channel and block until run is terminated
+	c.stopRun <- true
+	<-c.doneRun
+}
+
+func (c *HttpIngest) BasicAuth(w http.ResponseWriter, r *http.Request) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	login, password, authOK := r.BasicAuth()
+	if !authOK {
+		return false
+	}
+
+	return (login == c.config.Collectors.HttpIngest.BasicAuthLogin) &&
+		(password == c.config.Collectors.HttpIngest.BasicAuthPwd)
+}
+
+// readJsonPayloads splits the body in json objects, the body can be a single object,
+// an array of objects or newline-delimited objects
+func readJsonPayloads(body io.Reader) ([]json.RawMessage, error) {
+	payloads := []json.RawMessage{}
+
+	// skip leading spaces to detect a json array
+	reader := bufio.NewReader(body)
+	for {
+		b, err := reader.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return payloads, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !unicode.IsSpace(rune(b[0])) {
+			break
+		}
+		reader.ReadByte()
+	}
+
+	decoder := json.NewDecoder(reader)
+	if b, _ := reader.Peek(1); b[0] == '[' {
+		if err := decoder.Decode(&payloads); err != nil {
+			return nil, err
+		}
+		return payloads, nil
+	}
+
+	// one or more json objects
+	for {
+		var payload json.RawMessage
+		if err := decoder.Decode(&payload); err != nil {
+			if errors.Is(err, io.EOF) {
+				return payloads, nil
+			}
+			return nil, err
+		}
+		payloads = append(payloads, payload)
+	}
+}
+
+// isBodyTooLarge returns true if the body or the decompressed body exceeds the maximum size
+func isBodyTooLarge(err error) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError) || errors.Is(err, errBodyTooLarge)
+}
+
+func (c *HttpIngest) PostHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.BasicAuth(w, r) {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c.RLock()
+	maxBodySize := int64(c.config.Collectors.HttpIngest.MaxBodySize)
+	c.RUnlock()
+
+	// limit the size of the body and of the decompressed body
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			if isBodyTooLarge(err) {
+				http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, fmt.Sprintf("invalid gzip body: %s", err), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = &maxSizeReader{r: io.LimitReader(gz, maxBodySize+1), max: maxBodySize}
+	}
+
+	payloads, err := readJsonPayloads(body)
+	if err != nil {
+		if isBodyTooLarge(err) {
+			http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("invalid json body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	for _, payload := range payloads {
+		c.msgProcessor.GetChannel() <- payload
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (c *HttpIngest) Listen() error {
+	c.LogInfo("running in background...")
+
+	var err error
+	var listener net.Listener
+	addrlisten := c.config.Collectors.HttpIngest.ListenIP + ":" + strconv.Itoa(c.config.Collectors.HttpIngest.ListenPort)
+
+	// listening with tls enabled ?
+	if c.config.Collectors.HttpIngest.TlsSupport {
+		c.LogInfo("tls support enabled")
+
+		tlsOptions := dnsutils.TlsOptions{
+			MinVersion: c.config.Collectors.HttpIngest.TlsMinVersion,
+			CAFile:     c.config.Collectors.HttpIngest.CAFile,
+			CertFile:   c.config.Collectors.HttpIngest.CertFile,
+			KeyFile:    c.config.Collectors.HttpIngest.KeyFile,
+		}
+
+		var tlsConfig *tls.Config
+		tlsConfig, err = dnsutils.TlsServerConfig(tlsOptions)
+		if err != nil {
+			c.logger.Fatal("collector=httpingest - tls config failed:", err)
+		}
+
+		listener, err = tls.Listen(dnsutils.SOCKET_TCP, addrlisten, tlsConfig)
+	} else {
+		// basic listening
+		listener, err = net.Listen(dnsutils.SOCKET_TCP, addrlisten)
+	}
+
+	// something is wrong ?
+	if err != nil {
+		return err
+	}
+	c.LogInfo("is listening on %s", listener.Addr())
+	c.listen = listener
+	return nil
+}
+
+func (c *HttpIngest) Run() {
+	c.LogInfo("starting collector...")
+	if c.listen == nil {
+		if err := c.Listen(); err != nil {
+			prefixlog := fmt.Sprintf("[%s] ", c.name)
+			c.logger.Fatal(prefixlog+"collector=httpingest listening failed: ", err)
+		}
+	}
+
+	// start the processor to decode posted messages
+	c.msgProcessor = processors.NewDnsMessageProcessor(0, c.config.Collectors.HttpIngest.Mode, []string{},
+		c.config, c.logger, c.name, c.config.Collectors.HttpIngest.ChannelBufferSize)
+	go c.msgProcessor.Run(c.Loggers())
+
+	// start http server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", c.PostHandler)
+	c.httpserver = &http.Server{Handler: mux}
+
+	go func() {
+		if err := c.httpserver.Serve(c.listen); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			c.LogError("http server error: %s", err)
+		}
+		c.LogInfo("http server terminated")
+		c.doneServer <- true
+	}()
+
+RUN_LOOP:
+	for {
+		select {
+		// new config provided?
+		case cfg, opened := <-c.configChan:
+			if !opened {
+				return
+			}
+			c.Lock()
+			c.config = cfg
+			c.ReadConfig()
+			c.Unlock()
+
+			c.msgProcessor.ConfigChan <- cfg
+
+		case <-c.stopRun:
+			// stop the http server
+			c.httpserver.Close()
+			<-c.doneServer
+
+			// stop the processor
+			c.msgProcessor.Stop()
+
+			c.doneRun <- true
+			break RUN_LOOP
+		}
+	}
+	c.LogInfo("run terminated")
+}
@@ -0,0 +1,196 @@
+package collectors
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-logger"
+)
+
+func Test_HttpIngestCollector(t *testing.T) {
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Qname = "http.collector.test"
+	payload, _ := json.Marshal(dm)
+	flat, _ := dm.Flatten()
+	flatPayload, _ := json.Marshal(flat)
+
+	testcases := []struct {
+		name     string
+		mode     string
+		tls      bool
+		gzip     bool
+		body     string
+		expected int
+	}{
+		{
+			name:     "single",
+			mode:     dnsutils.MODE_JSON,
+			body:     string(payload),
+			expected: 1,
+		},
+		{
+			name:     "array",
+			mode:     dnsutils.MODE_JSON,
+			body:     fmt.Sprintf(" [%s,%s]", payload, payload),
+			expected: 2,
+		},
+		{
+			name:     "ndjson",
+			mode:     dnsutils.MODE_JSON,
+			body:     fmt.Sprintf("%s\n%s\n%s\n", payload, payload, payload),
+			expected: 3,
+		},
+		{
+			name:     "gzip",
+			mode:     dnsutils.MODE_JSON,
+			gzip:     true,
+			body:     string(payload),
+			expected: 1,
+		},
+		{
+			name:     "flat-json",
+			mode:     dnsutils.MODE_FLATJSON,
+			body:     string(flatPayload),
+			expected: 1,
+		},
+		{
+			name:     "tls",
+			mode:     dnsutils.MODE_JSON,
+			tls:      true,
+			body:     string(payload),
+			expected: 1,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := loggers.NewFakeLogger()
+
+			config := dnsutils.GetFakeConfig()
+			config.Collectors.HttpIngest.ListenPort = 0
+			config.Collectors.HttpIngest.Mode = tc.mode
+			if tc.tls {
+				config.Collectors.HttpIngest.TlsSupport = true
+				config.Collectors.HttpIngest.CertFile = "./../testsdata/certs/server.crt"
+				config.Collectors.HttpIngest.KeyFile = "./../testsdata/certs/server.key"
+			}
+
+			c := NewHttpIngest([]dnsutils.Worker{g}, config, logger.New(false), "test")
+			if err := c.Listen(); err != nil {
+				t.Fatal("collector listening error: ", err)
+			}
+			go c.Run()
+			defer c.Stop()
+
+			// prepare the request
+			body := []byte(tc.body)
+			if tc.gzip {
+				var buf bytes.Buffer
+				gz := gzip.NewWriter(&buf)
+				gz.Write(body)
+				gz.Close()
+				body = buf.Bytes()
+			}
+
+			scheme := "http"
+			client := &http.Client{}
+			if tc.tls {
+				scheme = "https"
+				client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+			}
+
+			req, _ := http.NewRequest(http.MethodPost, scheme+"://"+c.listen.Addr().String()+"/", bytes.NewReader(body))
+			req.SetBasicAuth(config.Collectors.HttpIngest.BasicAuthLogin, config.Collectors.HttpIngest.BasicAuthPwd)
+			if tc.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusAccepted {
+				t.Fatalf("want status %d, got %d", http.StatusAccepted, resp.StatusCode)
+			}
+
+			// waiting messages in channel
+			for i := 0; i < tc.expected; i++ {
+				select {
+				case msg := <-g.Channel():
+					if msg.DNS.Qname != dm.DNS.Qname {
+						t.Errorf("want qname %s, got %s", dm.DNS.Qname, msg.DNS.Qname)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("dns message %d not received", i)
+				}
+			}
+		})
+	}
+}
+
+func Test_HttpIngestCollector_Errors(t *testing.T) {
+	testcases := []struct {
+		name     string
+		method   string
+		login    string
+		body     string
+		gzip     bool
+		expected int
+	}{
+		{name: "unauthorized", method: http.MethodPost, login: "baduser", body: "{}", expected: http.StatusUnauthorized},
+		{name: "method", method: http.MethodGet, login: "admin", expected: http.StatusMethodNotAllowed},
+		{name: "invalid_json", method: http.MethodPost, login: "admin", body: "{\"dns\":", expected: http.StatusBadRequest},
+		{name: "too_large", method: http.MethodPost, login: "admin", body: strings.Repeat(" ", 2048) + "{}", expected: http.StatusRequestEntityTooLarge},
+		{name: "gzip_too_large", method: http.MethodPost, login: "admin", body: strings.Repeat(" ", 1<<20) + "{}", gzip: true, expected: http.StatusRequestEntityTooLarge},
+	}
+
+	g := loggers.NewFakeLogger()
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.HttpIngest.ListenPort = 0
+	config.Collectors.HttpIngest.MaxBodySize = 1024
+
+	c := NewHttpIngest([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		t.Fatal("collector listening error: ", err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := []byte(tc.body)
+			if tc.gzip {
+				var buf bytes.Buffer
+				gz := gzip.NewWriter(&buf)
+				gz.Write(body)
+				gz.Close()
+				body = buf.Bytes()
+			}
+
+			req, _ := http.NewRequest(tc.method, "http://"+c.listen.Addr().String()+"/", bytes.NewReader(body))
+			req.SetBasicAuth(tc.login, config.Collectors.HttpIngest.BasicAuthPwd)
+			if tc.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.expected {
+				t.Errorf("want status %d, got %d", tc.expected, resp.StatusCode)
+			}
+		})
+	}
+}
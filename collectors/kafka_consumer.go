@@ -0,0 +1,246 @@
+package collectors
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/processors"
+	"github.com/dmachard/go-logger"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+type KafkaConsumer struct {
+	doneRun      chan bool
+	stopRun      chan bool
+	doneConsume  chan bool
+	loggers      []dnsutils.Worker
+	config       *dnsutils.Config
+	configChan   chan *dnsutils.Config
+	logger       *logger.Logger
+	name         string
+	textFormat   []string
+	msgProcessor processors.DnsMessageProcessor
+}
+
+func NewKafkaConsumer(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *KafkaConsumer {
+	logger.Info("[%s] collector=kafka - enabled", name)
+	s := &KafkaConsumer{
+		doneRun:     make(chan bool),
+		stopRun:     make(chan bool),
+		doneConsume: make(chan bool),
+		config:      config,
+		configChan:  make(chan *dnsutils.Config),
+		loggers:     loggers,
+		logger:      logger,
+		name:        name,
+	}
+	s.ReadConfig()
+	return s
+}
+
+func (c *KafkaConsumer) GetName() string { return c.name }
+
+func (c *KafkaConsumer) SetLoggers(loggers []dnsutils.Worker) {
+	c.loggers = loggers
+}
+
+func (c *KafkaConsumer) Loggers() ([]chan dnsutils.DnsMessage, []string) {
+	channels := []chan dnsutils.DnsMessage{}
+	names := []string{}
+	for _, p := range c.loggers {
+		channels = append(channels, p.Channel())
+		names = append(names, p.GetName())
+	}
+	return channels, names
+}
+
+func (c *KafkaConsumer) ReadConfig() {
+	if !dnsutils.IsValidMode(c.config.Collectors.KafkaConsumer.Mode) {
+		c.logger.Fatal("collector=kafka - invalid mode: ", c.config.Collectors.KafkaConsumer.Mode)
+	}
+	if !dnsutils.IsValidTLS(c.config.Collectors.KafkaConsumer.TlsMinVersion) {
+		c.logger.Fatal("collector=kafka - invalid tls min version")
+	}
+
+	if len(c.config.Collectors.KafkaConsumer.TextFormat) > 0 {
+		c.textFormat = strings.Fields(c.config.Collectors.KafkaConsumer.TextFormat)
+	} else {
+		c.textFormat = strings.Fields(c.config.Global.TextFormat)
+	}
+}
+
+func (c *KafkaConsumer) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.configChan <- config
+}
+
+func (c *KafkaConsumer) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] collector=kafka - "+msg, v...)
+}
+
+func (c *KafkaConsumer) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] collector=kafka - "+msg, v...)
+}
+
+func (c *KafkaConsumer) Channel() chan dnsutils.DnsMessage {
+	return nil
+}
+
+func (c *KafkaConsumer) Stop() {
+	c.LogInfo("stopping...")
+
+	// read done channel and block until run is terminated
+	c.stopRun <- true
+	<-c.doneRun
+}
+
+func (c *KafkaConsumer) NewReader() *kafka.Reader {
+	address := c.config.Collectors.KafkaConsumer.RemoteAddress + ":" + strconv.Itoa(c.config.Collectors.KafkaConsumer.RemotePort)
+
+	dialer := &kafka.Dialer{
+		Timeout:   time.Duration(c.config.Collectors.KafkaConsumer.ConnectTimeout) * time.Second,
+		DualStack: true,
+	}
+
+	// enable TLS
+	if c.config.Collectors.KafkaConsumer.TlsSupport {
+		tlsOptions := dnsutils.TlsOptions{
+			InsecureSkipVerify: c.config.Collectors.KafkaConsumer.TlsInsecure,
+			MinVersion:         c.config.Collectors.KafkaConsumer.TlsMinVersion,
+			CAFile:             c.config.Collectors.KafkaConsumer.CAFile,
+			CertFile:           c.config.Collectors.KafkaConsumer.CertFile,
+			KeyFile:            c.config.Collectors.KafkaConsumer.KeyFile,
+		}
+
+		tlsConfig, err := dnsutils.TlsClientConfig(tlsOptions)
+		if err != nil {
+			c.logger.Fatal("collector=kafka - tls config failed:", err)
+		}
+		dialer.TLS = tlsConfig
+	}
+
+	// SASL Support
+	if c.config.Collectors.KafkaConsumer.SaslSupport {
+		switch c.config.Collectors.KafkaConsumer.SaslMechanism {
+		case dnsutils.SASL_MECHANISM_PLAIN:
+			mechanism := plain.Mechanism{
+				Username: c.config.Collectors.KafkaConsumer.SaslUsername,
+				Password: c.config.Collectors.KafkaConsumer.SaslPassword,
+			}
+			dialer.SASLMechanism = mechanism
+		case dnsutils.SASL_MECHANISM_SCRAM:
+			mechanism, err := scram.Mechanism(
+				scram.SHA512,
+				c.config.Collectors.KafkaConsumer.SaslUsername,
+				c.config.Collectors.KafkaConsumer.SaslPassword,
+			)
+			if err != nil {
+				c.logger.Fatal("collector=kafka - sasl config failed:", err)
+			}
+			dialer.SASLMechanism = mechanism
+		}
+	}
+
+	readerConfig := kafka.ReaderConfig{
+		Brokers:        []string{address},
+		Topic:          c.config.Collectors.KafkaConsumer.Topic,
+		Dialer:         dialer,
+		MaxWait:        time.Second,
+		ReadBackoffMax: time.Duration(c.config.Collectors.KafkaConsumer.RetryInterval) * time.Second,
+		ErrorLogger:    kafka.LoggerFunc(c.LogError),
+	}
+
+	// the partition can only be selected without consumer group
+	if len(c.config.Collectors.KafkaConsumer.ConsumerGroup) > 0 {
+		readerConfig.GroupID = c.config.Collectors.KafkaConsumer.ConsumerGroup
+		c.LogInfo("consuming from kafka=%s topic=%s group=%s", address, readerConfig.Topic, readerConfig.GroupID)
+	} else {
+		readerConfig.Partition = c.config.Collectors.KafkaConsumer.Partition
+		c.LogInfo("consuming from kafka=%s topic=%s partition=%d", address, readerConfig.Topic, readerConfig.Partition)
+	}
+
+	return kafka.NewReader(readerConfig)
+}
+
+func (c *KafkaConsumer) Consume(ctx context.Context) {
+	reader := c.NewReader()
+	retryInterval := time.Duration(c.config.Collectors.KafkaConsumer.RetryInterval) * time.Second
+
+CONSUME_LOOP:
+	for {
+		// offsets are committed on read when a consumer group is configured
+		msg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break CONSUME_LOOP
+			}
+			c.LogError("read error: %s", err)
+			c.LogInfo("retry to read in %d seconds", c.config.Collectors.KafkaConsumer.RetryInterval)
+			select {
+			case <-ctx.Done():
+				break CONSUME_LOOP
+			case <-time.After(retryInterval):
+				continue
+			}
+		}
+
+		// send payload to the processor, blocking to not lose
+		// messages already read from the topic
+		select {
+		case c.msgProcessor.GetChannel() <- msg.Value:
+		case <-ctx.Done():
+			break CONSUME_LOOP
+		}
+	}
+
+	if err := reader.Close(); err != nil {
+		c.LogError("closing reader: %s", err)
+	}
+	c.LogInfo("consumer terminated")
+	c.doneConsume <- true
+}
+
+func (c *KafkaConsumer) Run() {
+	c.LogInfo("starting collector...")
+
+	// start the processor to decode messages in the producer format
+	c.msgProcessor = processors.NewDnsMessageProcessor(0, c.config.Collectors.KafkaConsumer.Mode, c.textFormat,
+		c.config, c.logger, c.name, c.config.Collectors.KafkaConsumer.ChannelBufferSize)
+	go c.msgProcessor.Run(c.Loggers())
+
+	ctx, cancelKafka := context.WithCancel(context.Background())
+	go c.Consume(ctx)
+
+RUN_LOOP:
+	for {
+		select {
+		// new config provided?
+		case cfg, opened := <-c.configChan:
+			if !opened {
+				cancelKafka()
+				return
+			}
+			c.config = cfg
+			c.ReadConfig()
+
+			c.msgProcessor.ConfigChan <- cfg
+
+		case <-c.stopRun:
+			// stop to consume and wait the end of the reader
+			cancelKafka()
+			<-c.doneConsume
+
+			// stop the processor
+			c.msgProcessor.Stop()
+
+			c.doneRun <- true
+			break RUN_LOOP
+		}
+	}
+	c.LogInfo("run terminated")
+}
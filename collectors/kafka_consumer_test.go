@@ -0,0 +1,160 @@
+package collectors
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-logger"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/fetch"
+	"github.com/segmentio/kafka-go/protocol/listoffsets"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+)
+
+// minimal kafka broker, serving one partition with the provided records
+func fakeKafkaBroker(t *testing.T, listener net.Listener, topic string, records [][]byte) {
+	addr := listener.Addr().(*net.TCPAddr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+			for {
+				version, correlationID, _, msg, err := protocol.ReadRequest(conn)
+				if err != nil {
+					return
+				}
+
+				var resp protocol.Message
+				switch req := msg.(type) {
+				case *apiversions.Request:
+					resp = &apiversions.Response{
+						ApiKeys: []apiversions.ApiKeyResponse{
+							{ApiKey: int16(protocol.Fetch), MinVersion: 0, MaxVersion: 5},
+							{ApiKey: int16(protocol.ListOffsets), MinVersion: 1, MaxVersion: 1},
+							{ApiKey: int16(protocol.Metadata), MinVersion: 0, MaxVersion: 6},
+						},
+					}
+				case *metadata.Request:
+					resp = &metadata.Response{
+						Brokers: []metadata.ResponseBroker{{NodeID: 1, Host: addr.IP.String(), Port: int32(addr.Port)}},
+						Topics: []metadata.ResponseTopic{{
+							Name:       topic,
+							Partitions: []metadata.ResponsePartition{{LeaderID: 1, ReplicaNodes: []int32{1}, IsrNodes: []int32{1}}},
+						}},
+					}
+				case *listoffsets.Request:
+					// the first offset is always 0, -1 is the last offset
+					offset := int64(0)
+					if req.Topics[0].Partitions[0].Timestamp == -1 {
+						offset = int64(len(records))
+					}
+					resp = &listoffsets.Response{
+						Topics: []listoffsets.ResponseTopic{{
+							Topic:      topic,
+							Partitions: []listoffsets.ResponsePartition{{Timestamp: -1, Offset: offset}},
+						}},
+					}
+				case *fetch.Request:
+					offset := req.Topics[0].Partitions[0].FetchOffset
+					batch := []protocol.Record{}
+					for i := offset; i < int64(len(records)); i++ {
+						batch = append(batch, protocol.Record{Offset: i, Time: time.Now(), Value: protocol.NewBytes(records[i])})
+					}
+					recordSet := protocol.RecordSet{Version: 2, Records: protocol.NewRecordReader(batch...)}
+					if len(batch) == 0 {
+						// nothing new, an empty message set is expected by the reader
+						recordSet = protocol.RecordSet{Version: 1, Records: protocol.NewRecordReader()}
+						time.Sleep(100 * time.Millisecond)
+					}
+					resp = &fetch.Response{
+						Topics: []fetch.ResponseTopic{{
+							Topic: topic,
+							Partitions: []fetch.ResponsePartition{{
+								HighWatermark:    int64(len(records)),
+								LastStableOffset: int64(len(records)),
+								RecordSet:        recordSet,
+							}},
+						}},
+					}
+				default:
+					t.Errorf("unexpected kafka request: %T", msg)
+					return
+				}
+
+				if err := protocol.WriteResponse(conn, version, correlationID, resp); err != nil {
+					return
+				}
+			}
+		}(conn)
+	}
+}
+
+func Test_KafkaConsumer(t *testing.T) {
+	testcases := []struct {
+		mode string
+	}{
+		{mode: dnsutils.MODE_JSON},
+		{mode: dnsutils.MODE_FLATJSON},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.mode, func(t *testing.T) {
+			// prepare payload in the kafka producer format
+			dm := dnsutils.GetFakeDnsMessage()
+			dm.DNS.Qname = "kafka.collector.test"
+
+			var payload []byte
+			switch tc.mode {
+			case dnsutils.MODE_JSON:
+				payload, _ = json.Marshal(dm)
+			case dnsutils.MODE_FLATJSON:
+				flat, err := dm.Flatten()
+				if err != nil {
+					t.Fatalf("flatten error: %s", err)
+				}
+				payload, _ = json.Marshal(flat)
+			}
+
+			// start the fake broker
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("unable to listen: %s", err)
+			}
+			defer listener.Close()
+			go fakeKafkaBroker(t, listener, "dnscollector", [][]byte{payload})
+
+			// init the collector
+			g := loggers.NewFakeLogger()
+
+			config := dnsutils.GetFakeConfig()
+			config.Collectors.KafkaConsumer.RemotePort = listener.Addr().(*net.TCPAddr).Port
+			config.Collectors.KafkaConsumer.Mode = tc.mode
+			config.Collectors.KafkaConsumer.ConsumerGroup = ""
+
+			c := NewKafkaConsumer([]dnsutils.Worker{g}, config, logger.New(false), "test")
+			go c.Run()
+
+			// waiting message in channel
+			select {
+			case msg := <-g.Channel():
+				if msg.DNS.Qname != dm.DNS.Qname {
+					t.Errorf("want qname %s, got %s", dm.DNS.Qname, msg.DNS.Qname)
+				}
+			case <-time.After(10 * time.Second):
+				t.Errorf("no dns message received from kafka")
+			}
+
+			c.Stop()
+		})
+	}
+}
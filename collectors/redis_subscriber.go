@@ -0,0 +1,325 @@
+package collectors
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/processors"
+	"github.com/dmachard/go-logger"
+)
+
+type RedisSub struct {
+	doneRun       chan bool
+	stopRun       chan bool
+	doneSubscribe chan bool
+	stopSubscribe chan bool
+	loggers       []dnsutils.Worker
+	config        *dnsutils.Config
+	configChan    chan *dnsutils.Config
+	logger        *logger.Logger
+	name          string
+	textFormat    []string
+	msgProcessor  processors.DnsMessageProcessor
+}
+
+func NewRedisSub(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *RedisSub {
+	logger.Info("[%s] collector=redissub - enabled", name)
+	s := &RedisSub{
+		doneRun:       make(chan bool),
+		stopRun:       make(chan bool),
+		doneSubscribe: make(chan bool),
+		stopSubscribe: make(chan bool),
+		config:        config,
+		configChan:    make(chan *dnsutils.Config),
+		loggers:       loggers,
+		logger:        logger,
+		name:          name,
+	}
+	s.ReadConfig()
+	return s
+}
+
+func (c *RedisSub) GetName() string { return c.name }
+
+func (c *RedisSub) SetLoggers(loggers []dnsutils.Worker) {
+	c.loggers = loggers
+}
+
+func (c *RedisSub) Loggers() ([]chan dnsutils.DnsMessage, []string) {
+	channels := []chan dnsutils.DnsMessage{}
+	names := []string{}
+	for _, p := range c.loggers {
+		channels = append(channels, p.Channel())
+		names = append(names, p.GetName())
+	}
+	return channels, names
+}
+
+func (c *RedisSub) ReadConfig() {
+	if !dnsutils.IsValidMode(c.config.Collectors.RedisSub.Mode) {
+		c.logger.Fatal("collector=redissub - invalid mode: ", c.config.Collectors.RedisSub.Mode)
+	}
+	if !dnsutils.IsValidTLS(c.config.Collectors.RedisSub.TlsMinVersion) {
+		c.logger.Fatal("collector=redissub - invalid tls min version")
+	}
+	if len(c.config.Collectors.RedisSub.RedisChannels) == 0 && len(c.config.Collectors.RedisSub.RedisPatterns) == 0 {
+		c.logger.Fatal("collector=redissub - no redis channels or patterns to subscribe")
+	}
+
+	if len(c.config.Collectors.RedisSub.TextFormat) > 0 {
+		c.textFormat = strings.Fields(c.config.Collectors.RedisSub.TextFormat)
+	} else {
+		c.textFormat = strings.Fields(c.config.Global.TextFormat)
+	}
+}
+
+func (c *RedisSub) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.configChan <- config
+}
+
+func (c *RedisSub) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] collector=redissub - "+msg, v...)
+}
+
+func (c *RedisSub) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] collector=redissub - "+msg, v...)
+}
+
+func (c *RedisSub) Channel() chan dnsutils.DnsMessage {
+	return nil
+}
+
+func (c *RedisSub) Stop() {
+	c.LogInfo("stopping...")
+
+	// read done channel and block until run is terminated
+	c.stopRun <- true
+	<-c.doneRun
+}
+
+func (c *RedisSub) Connect() (net.Conn, error) {
+	address := c.config.Collectors.RedisSub.RemoteAddress + ":" + strconv.Itoa(c.config.Collectors.RedisSub.RemotePort)
+	connTimeout := time.Duration(c.config.Collectors.RedisSub.ConnectTimeout) * time.Second
+
+	switch c.config.Collectors.RedisSub.Transport {
+	case dnsutils.SOCKET_UNIX:
+		address = c.config.Collectors.RedisSub.RemoteAddress
+		c.LogInfo("connecting to %s://%s", dnsutils.SOCKET_UNIX, address)
+		return net.DialTimeout(dnsutils.SOCKET_UNIX, address, connTimeout)
+
+	case dnsutils.SOCKET_TCP:
+		c.LogInfo("connecting to %s://%s", dnsutils.SOCKET_TCP, address)
+		return net.DialTimeout(dnsutils.SOCKET_TCP, address, connTimeout)
+
+	case dnsutils.SOCKET_TLS:
+		c.LogInfo("connecting to %s://%s", dnsutils.SOCKET_TLS, address)
+
+		tlsOptions := dnsutils.TlsOptions{
+			InsecureSkipVerify: c.config.Collectors.RedisSub.TlsInsecure,
+			MinVersion:         c.config.Collectors.RedisSub.TlsMinVersion,
+			CAFile:             c.config.Collectors.RedisSub.CAFile,
+			CertFile:           c.config.Collectors.RedisSub.CertFile,
+			KeyFile:            c.config.Collectors.RedisSub.KeyFile,
+		}
+
+		tlsConfig, err := dnsutils.TlsClientConfig(tlsOptions)
+		if err != nil {
+			return nil, err
+		}
+		dialer := &net.Dialer{Timeout: connTimeout}
+		return tls.DialWithDialer(dialer, dnsutils.SOCKET_TCP, address, tlsConfig)
+	}
+
+	return nil, fmt.Errorf("invalid transport: %s", c.config.Collectors.RedisSub.Transport)
+}
+
+// readRespReply reads one reply encoded with the redis serialization protocol,
+// bulk and simple strings are returned as string, arrays as []interface{}
+func readRespReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return nil, errors.New("empty resp reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis error: %s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, size)
+		for i := range items {
+			if items[i], err = readRespReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected resp reply: %q", line)
+}
+
+func (c *RedisSub) ReadMessages(conn net.Conn) error {
+	// send subscribe commands, inline like the redispub logger
+	writer := bufio.NewWriter(conn)
+	commands := []struct {
+		name  string
+		items []string
+	}{
+		{name: "SUBSCRIBE", items: c.config.Collectors.RedisSub.RedisChannels},
+		{name: "PSUBSCRIBE", items: c.config.Collectors.RedisSub.RedisPatterns},
+	}
+	for _, cmd := range commands {
+		if len(cmd.items) == 0 {
+			continue
+		}
+		writer.WriteString(cmd.name)
+		for _, item := range cmd.items {
+			writer.WriteString(" " + strconv.Quote(item))
+		}
+		writer.WriteString("\r\n")
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		reply, err := readRespReply(reader)
+		if err != nil {
+			return err
+		}
+
+		items, ok := reply.([]interface{})
+		if !ok || len(items) < 3 {
+			c.LogError("unexpected reply: %v", reply)
+			continue
+		}
+		kind, _ := items[0].(string)
+
+		var payload string
+		switch strings.ToLower(kind) {
+		case "subscribe", "psubscribe":
+			c.LogInfo("%s to %v", kind, items[1])
+			continue
+		case "message":
+			payload, _ = items[2].(string)
+		case "pmessage":
+			if len(items) < 4 {
+				c.LogError("unexpected pmessage: %v", items)
+				continue
+			}
+			payload, _ = items[3].(string)
+		default:
+			continue
+		}
+
+		c.msgProcessor.GetChannel() <- []byte(payload)
+	}
+}
+
+func (c *RedisSub) Subscribe() {
+	retryInterval := time.Duration(c.config.Collectors.RedisSub.RetryInterval) * time.Second
+
+	for {
+		conn, err := c.Connect()
+		if err == nil {
+			c.LogInfo("connected with success")
+
+			errRead := make(chan error, 1)
+			go func() { errRead <- c.ReadMessages(conn) }()
+
+			select {
+			case <-c.stopSubscribe:
+				conn.Close()
+				<-errRead
+				c.doneSubscribe <- true
+				return
+			case err = <-errRead:
+				conn.Close()
+			}
+		}
+
+		// something is wrong during connection or subscription ?
+		c.LogError("%s", err)
+		c.LogInfo("retry to connect in %d seconds", c.config.Collectors.RedisSub.RetryInterval)
+		select {
+		case <-c.stopSubscribe:
+			c.doneSubscribe <- true
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (c *RedisSub) Run() {
+	c.LogInfo("starting collector...")
+
+	// start the processor to decode messages in the redispub format
+	c.msgProcessor = processors.NewDnsMessageProcessor(0, c.config.Collectors.RedisSub.Mode, c.textFormat,
+		c.config, c.logger, c.name, c.config.Collectors.RedisSub.ChannelBufferSize)
+	go c.msgProcessor.Run(c.Loggers())
+
+	go c.Subscribe()
+
+RUN_LOOP:
+	for {
+		select {
+		// new config provided?
+		case cfg, opened := <-c.configChan:
+			if !opened {
+				return
+			}
+			c.config = cfg
+			c.ReadConfig()
+
+			c.msgProcessor.ConfigChan <- cfg
+
+		case <-c.stopRun:
+			// stop to subscribe
+			c.stopSubscribe <- true
+			<-c.doneSubscribe
+
+			// stop the processor
+			c.msgProcessor.Stop()
+
+			c.doneRun <- true
+			break RUN_LOOP
+		}
+	}
+	c.LogInfo("run terminated")
+}
@@ -0,0 +1,124 @@
+package collectors
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-logger"
+)
+
+func Test_RedisSubCollector(t *testing.T) {
+	testcases := []struct {
+		name     string
+		mode     string
+		channels []string
+		patterns []string
+		command  string
+	}{
+		{
+			name:     "text",
+			mode:     dnsutils.MODE_TEXT,
+			channels: []string{"dns_collector"},
+			command:  "SUBSCRIBE \"dns_collector\"",
+		},
+		{
+			name:     "json",
+			mode:     dnsutils.MODE_JSON,
+			channels: []string{"dns_collector"},
+			command:  "SUBSCRIBE \"dns_collector\"",
+		},
+		{
+			name:     "flat-json",
+			mode:     dnsutils.MODE_FLATJSON,
+			channels: []string{"dns_collector"},
+			command:  "SUBSCRIBE \"dns_collector\"",
+		},
+		{
+			name:     "pattern",
+			mode:     dnsutils.MODE_JSON,
+			patterns: []string{"dns_*"},
+			command:  "PSUBSCRIBE \"dns_*\"",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			// fake redis server
+			fakeRedis, err := net.Listen(dnsutils.SOCKET_TCP, "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fakeRedis.Close()
+
+			g := loggers.NewFakeLogger()
+
+			config := dnsutils.GetFakeConfig()
+			config.Collectors.RedisSub.RemotePort = fakeRedis.Addr().(*net.TCPAddr).Port
+			config.Collectors.RedisSub.Mode = tc.mode
+			config.Collectors.RedisSub.RedisChannels = tc.channels
+			config.Collectors.RedisSub.RedisPatterns = tc.patterns
+
+			c := NewRedisSub([]dnsutils.Worker{g}, config, logger.New(false), "test")
+			go c.Run()
+
+			// accept conn from the collector and read the subscribe command
+			conn, err := fakeRedis.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.TrimSpace(line) != tc.command {
+				t.Errorf("want command %s, got %s", tc.command, line)
+			}
+
+			// payload in the redispub format
+			dm := dnsutils.GetFakeDnsMessage()
+			dm.DNS.Qname = "redis.collector.test"
+
+			var payload string
+			switch tc.mode {
+			case dnsutils.MODE_TEXT:
+				payload = dm.String(strings.Fields(config.Global.TextFormat), config.Global.TextFormatDelimiter, config.Global.TextFormatBoundary)
+			case dnsutils.MODE_JSON:
+				data, _ := json.Marshal(dm)
+				payload = string(data) + "\n"
+			case dnsutils.MODE_FLATJSON:
+				flat, _ := dm.Flatten()
+				data, _ := json.Marshal(flat)
+				payload = string(data) + "\n"
+			}
+
+			if len(tc.patterns) > 0 {
+				fmt.Fprintf(conn, "*3\r\n$10\r\npsubscribe\r\n$5\r\ndns_*\r\n:1\r\n")
+				fmt.Fprintf(conn, "*4\r\n$8\r\npmessage\r\n$5\r\ndns_*\r\n$13\r\ndns_collector\r\n$%d\r\n%s\r\n", len(payload), payload)
+			} else {
+				fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$13\r\ndns_collector\r\n:1\r\n")
+				fmt.Fprintf(conn, "*3\r\n$7\r\nmessage\r\n$13\r\ndns_collector\r\n$%d\r\n%s\r\n", len(payload), payload)
+			}
+
+			// waiting message in channel
+			select {
+			case msg := <-g.Channel():
+				if msg.DNS.Qname != dm.DNS.Qname {
+					t.Errorf("want qname %s, got %s", dm.DNS.Qname, msg.DNS.Qname)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("no dns message received from redis")
+			}
+
+			c.Stop()
+		})
+	}
+}
@@ -6,8 +6,12 @@ package collectors
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
 	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
@@ -27,106 +31,26 @@ func Htons(v uint16) int {
 	return int((v << 8) | (v >> 8))
 }
 
-func GetBpfFilter_Ingress(port int) []bpf.Instruction {
-	// bpf filter: (ip  or ip6 ) and (udp or tcp) and port 53
-	// fragmented packets are ignored
-	var filter = []bpf.Instruction{
-		// Load eth.type (2 bytes at offset 12) and push-it in register A
-		bpf.LoadAbsolute{Off: 12, Size: 2},
-		// if eth.type == IPv4 continue with the next instruction
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x0800, SkipTrue: 0, SkipFalse: 8},
-		// Load ip.proto (1 byte at offset 23) and push-it in register A
-		bpf.LoadAbsolute{Off: 23, Size: 1},
-		// ip.proto == UDP ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x11, SkipTrue: 1, SkipFalse: 0},
-		// ip.proto == TCP ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x6, SkipTrue: 0, SkipFalse: 12},
-		// load flags and fragment offset (2 bytes at offset 20) to ignore fragmented packet
-		bpf.LoadAbsolute{Off: 20, Size: 2},
-		// Only look at the last 13 bits of the data saved in regiter A
-		//  0x1fff == 0001 1111 1111 1111 (fragment offset)
-		// If any of the data in fragment offset is true, ignore the packet
-		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x1fff, SkipTrue: 10, SkipFalse: 0},
-		// Load ip.length
-		// Register X = ip header len * 4
-		bpf.LoadMemShift{Off: 14},
-		// Load source port in tcp or udp (2 bytes at offset x+14)
-		bpf.LoadIndirect{Off: 14, Size: 2},
-		// source port equal to 53 ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(port), SkipTrue: 6, SkipFalse: 7},
-		// if eth.type == IPv6 continue with the next instruction
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x86dd, SkipTrue: 0, SkipFalse: 6},
-		// Load ipv6.nxt (2 bytes at offset 12) and push-it in register A
-		bpf.LoadAbsolute{Off: 20, Size: 1},
-		// ip.proto == UDP ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x11, SkipTrue: 1, SkipFalse: 0},
-		// ip.proto == TCP ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x6, SkipTrue: 0, SkipFalse: 3},
-		// Load source port tcp or udp (2 bytes at offset 54)
-		bpf.LoadAbsolute{Off: 54, Size: 2},
-		// source port equal to 53 ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(port), SkipTrue: 0, SkipFalse: 1},
-		// Keep the packet and send up to 65k of the packet to userspace
-		bpf.RetConstant{Val: 0xFFFF},
-		// Ignore packet
-		bpf.RetConstant{Val: 0},
-	}
-	return filter
-}
-
-func GetBpfFilter(port int) []bpf.Instruction {
-	// bpf filter: (ip  or ip6 ) and (udp or tcp) and port 53
-	// fragmented packets are ignored
-	var filter = []bpf.Instruction{
-		// Load eth.type (2 bytes at offset 12) and push-it in register A
-		bpf.LoadAbsolute{Off: 12, Size: 2},
-		// if eth.type == IPv4 continue with the next instruction
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x0800, SkipTrue: 0, SkipFalse: 10},
-		// Load ip.proto (1 byte at offset 23) and push-it in register A
-		bpf.LoadAbsolute{Off: 23, Size: 1},
-		// ip.proto == UDP ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x11, SkipTrue: 1, SkipFalse: 0},
-		// ip.proto == TCP ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x6, SkipTrue: 0, SkipFalse: 16},
-		// load flags and fragment offset (2 bytes at offset 20) to ignore fragmented packet
-		bpf.LoadAbsolute{Off: 20, Size: 2},
-		// Only look at the last 13 bits of the data saved in regiter A
-		//  0x1fff == 0001 1111 1111 1111 (fragment offset)
-		// If any of the data in fragment offset is true, ignore the packet
-		bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: 0x1fff, SkipTrue: 14, SkipFalse: 0},
-		// Load ip.length
-		// Register X = ip header len * 4
-		bpf.LoadMemShift{Off: 14},
-		// Load source port in tcp or udp (2 bytes at offset x+14)
-		bpf.LoadIndirect{Off: 14, Size: 2},
-		// source port equal to 53 ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(port), SkipTrue: 10, SkipFalse: 0},
-		// Load destination port in tcp or udp  (2 bytes at offset x+16)
-		bpf.LoadIndirect{Off: 16, Size: 2},
-		// destination port equal to 53 ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(port), SkipTrue: 8, SkipFalse: 9},
-		// if eth.type == IPv6 continue with the next instruction
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x86dd, SkipTrue: 0, SkipFalse: 8},
-		// Load ipv6.nxt (2 bytes at offset 12) and push-it in register A
-		bpf.LoadAbsolute{Off: 20, Size: 1},
-		// ip.proto == UDP ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x11, SkipTrue: 1, SkipFalse: 0},
-		// ip.proto == TCP ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x6, SkipTrue: 0, SkipFalse: 5},
-		// Load source port tcp or udp (2 bytes at offset 54)
-		bpf.LoadAbsolute{Off: 54, Size: 2},
-		// source port equal to 53 ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(port), SkipTrue: 2, SkipFalse: 0},
-		// Load destination port tcp or udp (2 bytes at offset 56)
-		bpf.LoadAbsolute{Off: 56, Size: 2},
-		// destination port equal to 53 ?
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(port), SkipTrue: 0, SkipFalse: 1},
-		// Keep the packet and send up to 65k of the packet to userspace
-		bpf.RetConstant{Val: 0xFFFF},
-		// Ignore packet
-		bpf.RetConstant{Val: 0},
-	}
-	return filter
+// GetBpfFilter compiles the filter of the dns packets on the ports, with the optional
+// user expression. With the decapsulation, the ports are checked in userspace and
+// the expression applies to the outer headers.
+func GetBpfFilter(ports []int, expression string, decap bool) ([]bpf.Instruction, error) {
+	node := netlib.BpfPorts(ports...)
+	if decap {
+		// ip, ip6, 802.1Q, 802.1ad and legacy QinQ
+		node = netlib.BpfEtherTypes(0x0800, 0x86dd, 0x8100, 0x88a8, 0x9100)
+	}
+
+	if len(expression) > 0 {
+		userNode, err := netlib.ParseBpfExpression(expression)
+		if err != nil {
+			return nil, err
+		}
+		node = netlib.BpfAnd(node, userNode)
+	}
+
+	// keep the packet and send up to 65k of the packet to userspace
+	return netlib.CompileBpf(node, 0xFFFF)
 }
 
 func ApplyBpfFilter(filter []bpf.Instruction, fd int) (err error) {
@@ -147,16 +71,94 @@ func RemoveBpfFilter(fd int) (err error) {
 	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_DETACH_FILTER, 0)
 }
 
-type AfpacketSniffer struct {
-	done       chan bool
-	exit       chan bool
-	fd         int
+// AfpacketSocket is a raw socket bound to a device, member of a fanout group
+// with the other sockets of the device
+type AfpacketSocket struct {
+	fd     int
+	device string
+	ring   *TpacketRing
+
+	// kernel counters, PACKET_STATISTICS resets them on each read
+	received uint64
+	dropped  uint64
+	freezes  uint64
+}
+
+// ReadStats adds the kernel counters since the last read
+func (s *AfpacketSocket) ReadStats() error {
+	if s.ring != nil {
+		stats, err := unix.GetsockoptTpacketStatsV3(s.fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+		if err != nil {
+			return err
+		}
+		s.received += uint64(stats.Packets)
+		s.dropped += uint64(stats.Drops)
+		s.freezes += uint64(stats.Freeze_q_cnt)
+		return nil
+	}
+
+	stats, err := unix.GetsockoptTpacketStats(s.fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+	if err != nil {
+		return err
+	}
+	s.received += uint64(stats.Packets)
+	s.dropped += uint64(stats.Drops)
+	return nil
+}
+
+func (s *AfpacketSocket) Close() {
+	RemoveBpfFilter(s.fd)
+	if s.ring != nil {
+		s.ring.Close()
+	}
+	syscall.Close(s.fd)
+}
+
+// afpacketSettings are the settings of the capture read from the configuration,
+// the current ones are kept on a reload with an invalid configuration
+type afpacketSettings struct {
 	identity   string
-	loggers    []dnsutils.Worker
-	config     *dnsutils.Config
-	configChan chan *dnsutils.Config
-	logger     *logger.Logger
-	name       string
+	devices    []string
+	decap      netlib.DecapOptions
+	ports      []int
+	tlsPorts   []int
+	tcpOptions netlib.TcpAssemblerOptions
+	ipOptions  netlib.IpDefragOptions
+	filter     []bpf.Instruction
+
+	// options of the sockets
+	fanoutSockets int
+	tpacketV3     bool
+	ringBlockSize int
+	ringBlocks    int
+
+	statsInterval time.Duration
+}
+
+// sameSockets returns true if the sockets opened with the settings are the same
+func (s *afpacketSettings) sameSockets(other *afpacketSettings) bool {
+	return slices.Equal(s.devices, other.devices) && s.fanoutSockets == other.fanoutSockets &&
+		s.tpacketV3 == other.tpacketV3 && s.ringBlockSize == other.ringBlockSize && s.ringBlocks == other.ringBlocks
+}
+
+// the fanout groups of the sockets, one per device, are unique in the process
+var afpacketFanoutGroups atomic.Uint32
+
+type AfpacketSniffer struct {
+	afpacketSettings
+	done         chan bool
+	exit         chan bool
+	sockets      []*AfpacketSocket
+	closedStats  AfpacketSocket
+	statsLock    sync.Mutex
+	identityLock sync.RWMutex
+	tcpStats     *netlib.TcpAssemblerStats
+	ipStats      *netlib.IpDefragStats
+	loggers      []dnsutils.Worker
+	config       *dnsutils.Config
+	configChan   chan *dnsutils.Config
+	logger       *logger.Logger
+	name         string
 }
 
 func NewAfpacketSniffer(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *AfpacketSniffer {
@@ -169,6 +171,8 @@ func NewAfpacketSniffer(loggers []dnsutils.Worker, config *dnsutils.Config, logg
 		loggers:    loggers,
 		logger:     logger,
 		name:       name,
+		tcpStats:   &netlib.TcpAssemblerStats{},
+		ipStats:    &netlib.IpDefragStats{},
 	}
 	s.ReadConfig()
 	return s
@@ -199,7 +203,143 @@ func (c *AfpacketSniffer) Loggers() ([]chan dnsutils.DnsMessage, []string) {
 }
 
 func (c *AfpacketSniffer) ReadConfig() {
-	c.identity = c.config.GetServerIdentity()
+	settings, err := c.parseConfig(c.config)
+	if err != nil {
+		c.logger.Fatal("collector=afpacket - ", err)
+	}
+	c.setSettings(settings)
+}
+
+// parseConfig validates the configuration and compiles the bpf filter
+func (c *AfpacketSniffer) parseConfig(config *dnsutils.Config) (afpacketSettings, error) {
+	settings := afpacketSettings{identity: config.GetServerIdentity()}
+
+	decap, err := netlib.NewDecapOptions(config.Collectors.AfpacketLiveCapture.Decapsulation)
+	if err != nil {
+		return settings, err
+	}
+	settings.decap = decap
+
+	// list of ports or the single port
+	settings.ports = config.Collectors.AfpacketLiveCapture.Ports
+	if len(settings.ports) == 0 {
+		settings.ports = []int{config.Collectors.AfpacketLiveCapture.Port}
+	}
+	for _, port := range settings.ports {
+		if port <= 0 || port > 65535 {
+			return settings, fmt.Errorf("invalid port: %d", port)
+		}
+	}
+
+	// encrypted dns ports, distinct from the dns ports
+	settings.tlsPorts = config.Collectors.AfpacketLiveCapture.TlsPorts
+	for _, port := range settings.tlsPorts {
+		if port <= 0 || port > 65535 || netlib.MatchPorts(port, port, settings.ports...) {
+			return settings, fmt.Errorf("invalid tls port: %d", port)
+		}
+	}
+
+	// limits of the tcp reassembly
+	settings.tcpOptions = netlib.TcpAssemblerOptions{
+		TlsPorts:         settings.tlsPorts,
+		MaxConnections:   config.Collectors.AfpacketLiveCapture.TcpMaxConnections,
+		MaxBufferedBytes: config.Collectors.AfpacketLiveCapture.TcpMaxBufferedBytes,
+		FlushInterval:    time.Duration(config.Collectors.AfpacketLiveCapture.TcpFlushInterval) * time.Second,
+		IdleTimeout:      time.Duration(config.Collectors.AfpacketLiveCapture.TcpIdleTimeout) * time.Second,
+		Stats:            c.tcpStats,
+	}
+	if settings.tcpOptions.MaxConnections < 0 || settings.tcpOptions.MaxBufferedBytes < 0 ||
+		settings.tcpOptions.FlushInterval < 0 || settings.tcpOptions.IdleTimeout < 0 {
+		return settings, errors.New("invalid tcp reassembly limits")
+	}
+
+	// limits of the ip defragmentation
+	settings.ipOptions = netlib.IpDefragOptions{
+		MaxQueues:    config.Collectors.AfpacketLiveCapture.IpDefragMaxQueues,
+		MaxFragments: config.Collectors.AfpacketLiveCapture.IpDefragMaxFrags,
+		Timeout:      time.Duration(config.Collectors.AfpacketLiveCapture.IpDefragTimeout) * time.Second,
+		Stats:        c.ipStats,
+	}
+	if settings.ipOptions.MaxQueues < 0 || settings.ipOptions.MaxFragments < 0 || settings.ipOptions.Timeout < 0 {
+		return settings, errors.New("invalid ip defragmentation limits")
+	}
+
+	// compile the bpf filter
+	ports := append(append([]int{}, settings.ports...), settings.tlsPorts...)
+	settings.filter, err = GetBpfFilter(ports, config.Collectors.AfpacketLiveCapture.Filter, settings.decap.Enabled())
+	if err != nil {
+		return settings, fmt.Errorf("invalid filter: %w", err)
+	}
+
+	// list of devices or the single device, "" for all interfaces
+	settings.devices = config.Collectors.AfpacketLiveCapture.Devices
+	if len(settings.devices) == 0 {
+		settings.devices = []string{config.Collectors.AfpacketLiveCapture.Device}
+	}
+
+	settings.fanoutSockets = config.Collectors.AfpacketLiveCapture.FanoutSockets
+	if settings.fanoutSockets < 1 {
+		return settings, fmt.Errorf("invalid number of fanout sockets: %d", settings.fanoutSockets)
+	}
+	settings.tpacketV3 = config.Collectors.AfpacketLiveCapture.TpacketV3
+	if settings.tpacketV3 {
+		settings.ringBlockSize = config.Collectors.AfpacketLiveCapture.RingBlockSize
+		if settings.ringBlockSize < tpacketFrameSize || settings.ringBlockSize%os.Getpagesize() != 0 {
+			return settings, fmt.Errorf("ring block size must be a multiple of the page size: %d", settings.ringBlockSize)
+		}
+		settings.ringBlocks = config.Collectors.AfpacketLiveCapture.RingBlocks
+		if settings.ringBlocks < 1 {
+			return settings, fmt.Errorf("invalid number of ring blocks: %d", settings.ringBlocks)
+		}
+	}
+
+	settings.statsInterval = time.Duration(config.Collectors.AfpacketLiveCapture.StatsInterval) * time.Second
+	return settings, nil
+}
+
+func (c *AfpacketSniffer) setSettings(settings afpacketSettings) {
+	c.identityLock.Lock()
+	defer c.identityLock.Unlock()
+	c.afpacketSettings = settings
+}
+
+func (c *AfpacketSniffer) getIdentity() string {
+	c.identityLock.RLock()
+	defer c.identityLock.RUnlock()
+	return c.identity
+}
+
+// applySettings uses the new settings once the readers are stopped. The sockets are opened again
+// if the devices or their options are changed, otherwise the new filter is attached to the open sockets.
+// The current settings are kept on error.
+func (c *AfpacketSniffer) applySettings(settings afpacketSettings) error {
+	if settings.sameSockets(&c.afpacketSettings) {
+		if err := c.ApplyFilter(settings.filter); err != nil {
+			return fmt.Errorf("unable to apply the bpf filter: %w", err)
+		}
+	} else {
+		sockets, err := c.openSockets(&settings)
+		if err != nil {
+			return fmt.Errorf("unable to open the sockets: %w", err)
+		}
+		c.closeSockets()
+		c.setSockets(sockets)
+	}
+	c.setSettings(settings)
+	return nil
+}
+
+// ApplyFilter attaches the filter to the open sockets, the current filter is restored on error
+func (c *AfpacketSniffer) ApplyFilter(filter []bpf.Instruction) error {
+	for i, s := range c.sockets {
+		if err := ApplyBpfFilter(filter, s.fd); err != nil {
+			for _, s := range c.sockets[:i] {
+				ApplyBpfFilter(c.filter, s.fd)
+			}
+			return err
+		}
+	}
+	return nil
 }
 
 func (c *AfpacketSniffer) ReloadConfig(config *dnsutils.Config) {
@@ -222,18 +362,41 @@ func (c *AfpacketSniffer) Stop() {
 	close(c.done)
 }
 
-func (c *AfpacketSniffer) Listen() error {
-	// raw socket
+// OpenSocket opens a raw socket on the device, with the bpf filter and the optional
+// ring buffer. The socket joins the fanout group if several sockets are used per device.
+func (c *AfpacketSniffer) OpenSocket(settings *afpacketSettings, device string, fanoutGroup int) (*AfpacketSocket, error) {
 	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, Htons(syscall.ETH_P_ALL))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	s := &AfpacketSocket{fd: fd, device: device}
+
+	// filter before the bind, the packets not matching are never queued
+	if err := ApplyBpfFilter(settings.filter, fd); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if settings.tpacketV3 {
+		s.ring, err = NewTpacketRing(fd, settings.ringBlockSize, settings.ringBlocks)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	} else {
+		// set nano timestamp
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1); err != nil {
+			s.Close()
+			return nil, err
+		}
 	}
 
 	// bind to device ?
-	if c.config.Collectors.AfpacketLiveCapture.Device != "" {
-		iface, err := net.InterfaceByName(c.config.Collectors.AfpacketLiveCapture.Device)
+	if device != "" {
+		iface, err := net.InterfaceByName(device)
 		if err != nil {
-			return err
+			s.Close()
+			return nil, err
 		}
 
 		ll := syscall.SockaddrLinklayer{
@@ -241,196 +404,502 @@ func (c *AfpacketSniffer) Listen() error {
 		}
 
 		if err := syscall.Bind(fd, &ll); err != nil {
-			return err
+			s.Close()
+			return nil, err
 		}
+	}
 
-		c.LogInfo("binding with success to iface %q (index %d)", iface.Name, iface.Index)
+	// the flow hash sends both directions of a flow to the same socket,
+	// the ipv4 fragments are reassembled by the kernel before the hash
+	if settings.fanoutSockets > 1 {
+		fanout := fanoutGroup | (unix.PACKET_FANOUT_HASH|unix.PACKET_FANOUT_FLAG_DEFRAG)<<16
+		if err := syscall.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_FANOUT, fanout); err != nil {
+			s.Close()
+			return nil, err
+		}
 	}
 
-	// set nano timestamp
-	err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)
+	return s, nil
+}
+
+func (c *AfpacketSniffer) Listen() error {
+	sockets, err := c.openSockets(&c.afpacketSettings)
 	if err != nil {
 		return err
 	}
+	c.setSockets(sockets)
+	c.LogInfo("BPF filter applied")
+	return nil
+}
 
-	filter := GetBpfFilter(c.config.Collectors.AfpacketLiveCapture.Port)
-	err = ApplyBpfFilter(filter, fd)
-	if err != nil {
-		return err
+// openSockets opens the sockets of the devices, all the sockets are closed on error
+func (c *AfpacketSniffer) openSockets(settings *afpacketSettings) ([]*AfpacketSocket, error) {
+	sockets := []*AfpacketSocket{}
+	for _, device := range settings.devices {
+		// one fanout group per device
+		fanoutGroup := int((uint32(os.Getpid()) + afpacketFanoutGroups.Add(1)) & 0xffff)
+
+		for n := 0; n < settings.fanoutSockets; n++ {
+			s, err := c.OpenSocket(settings, device, fanoutGroup)
+			if err != nil {
+				for _, s := range sockets {
+					s.Close()
+				}
+				return nil, err
+			}
+			sockets = append(sockets, s)
+		}
+
+		if device != "" {
+			c.LogInfo("binding with success to iface %q with %d socket(s)", device, settings.fanoutSockets)
+		} else {
+			c.LogInfo("binding with success to all interfaces with %d socket(s)", settings.fanoutSockets)
+		}
 	}
+	return sockets, nil
+}
 
-	c.LogInfo("BPF filter applied")
+func (c *AfpacketSniffer) setSockets(sockets []*AfpacketSocket) {
+	c.statsLock.Lock()
+	defer c.statsLock.Unlock()
+	c.sockets = sockets
+}
 
-	c.fd = fd
-	return nil
+// closeSockets closes the sockets once their readers are stopped,
+// their kernel counters are kept in the totals
+func (c *AfpacketSniffer) closeSockets() {
+	c.statsLock.Lock()
+	defer c.statsLock.Unlock()
+	for _, s := range c.sockets {
+		if err := s.ReadStats(); err != nil {
+			c.LogError("unable to read the kernel statistics: %v", err)
+		}
+		c.closedStats.received += s.received
+		c.closedStats.dropped += s.dropped
+		c.closedStats.freezes += s.freezes
+		s.Close()
+	}
+	c.sockets = nil
 }
 
-func (c *AfpacketSniffer) Run() {
-	c.LogInfo("starting collector...")
-	defer RemoveBpfFilter(c.fd)
-	defer syscall.Close(c.fd)
+// ReadStats collects the kernel counters of the sockets, by device
+func (c *AfpacketSniffer) ReadStats() map[string]*AfpacketSocket {
+	c.statsLock.Lock()
+	defer c.statsLock.Unlock()
 
-	if c.fd == 0 {
-		if err := c.Listen(); err != nil {
-			c.LogError("init raw socket failed: %v\n", err)
-			os.Exit(1)
+	stats := make(map[string]*AfpacketSocket)
+	for _, s := range c.sockets {
+		if err := s.ReadStats(); err != nil {
+			c.LogError("unable to read the kernel statistics: %v", err)
 		}
+		device := s.device
+		if device == "" {
+			device = "all"
+		}
+		if _, ok := stats[device]; !ok {
+			stats[device] = &AfpacketSocket{device: device}
+		}
+		stats[device].received += s.received
+		stats[device].dropped += s.dropped
+		stats[device].freezes += s.freezes
+	}
+	return stats
+}
+
+// Stats returns the kernel counters of all the sockets, the tcp reassembly and the ip defragmentation counters, for the metrics
+func (c *AfpacketSniffer) Stats() map[string]uint64 {
+	counters := c.tcpStats.Counters()
+	for name, value := range c.ipStats.Counters() {
+		counters[name] = value
 	}
+	stats := c.ReadStats()
+
+	// the counters of the sockets closed on a reload
+	c.statsLock.Lock()
+	counters["kernel_packets_received"] = c.closedStats.received
+	counters["kernel_packets_dropped"] = c.closedStats.dropped
+	counters["kernel_queue_freezes"] = c.closedStats.freezes
+	c.statsLock.Unlock()
+
+	for _, s := range stats {
+		counters["kernel_packets_received"] += s.received
+		counters["kernel_packets_dropped"] += s.dropped
+		counters["kernel_queue_freezes"] += s.freezes
+	}
+	return counters
+}
 
-	dnsProcessor := processors.NewDnsProcessor(c.config, c.logger, c.name, c.config.Collectors.AfpacketLiveCapture.ChannelBufferSize)
-	go dnsProcessor.Run(c.Loggers())
+// LogStats logs the packets received and dropped by the kernel, the tcp streams and the ip fragments during the interval
+func (c *AfpacketSniffer) LogStats(interval time.Duration, stop chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	dnsChan := make(chan netlib.DnsPacket)
+	// the counters of the sockets kept on a reload are logged from the start of the interval
+	last := make(map[string]AfpacketSocket)
+	for device, s := range c.ReadStats() {
+		last[device] = *s
+	}
+	lastTcp := c.tcpStats.Counters()
+	lastIp := c.ipStats.Counters()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			for device, s := range c.ReadStats() {
+				prev := last[device]
+				c.LogInfo("device %s - packets received: %d, dropped by the kernel: %d, queue freezes: %d",
+					device, s.received-prev.received, s.dropped-prev.dropped, s.freezes-prev.freezes)
+				last[device] = *s
+			}
+
+			tcp := c.tcpStats.Counters()
+			c.LogInfo("tcp streams created: %d, evicted: %d, truncated: %d, packets rejected: %d",
+				tcp["tcp_streams_created"]-lastTcp["tcp_streams_created"],
+				tcp["tcp_streams_evicted"]-lastTcp["tcp_streams_evicted"],
+				tcp["tcp_streams_truncated"]-lastTcp["tcp_streams_truncated"],
+				tcp["tcp_packets_rejected"]-lastTcp["tcp_packets_rejected"])
+			lastTcp = tcp
+
+			ip := c.ipStats.Counters()
+			c.LogInfo("ip fragments received: %d, invalid: %d, dropped queues full: %d - datagrams reassembled: %d, timed out: %d, too many fragments: %d",
+				ip["ipdefrag_fragments_received"]-lastIp["ipdefrag_fragments_received"],
+				ip["ipdefrag_fragments_invalid"]-lastIp["ipdefrag_fragments_invalid"],
+				ip["ipdefrag_fragments_queues_full"]-lastIp["ipdefrag_fragments_queues_full"],
+				ip["ipdefrag_datagrams_reassembled"]-lastIp["ipdefrag_datagrams_reassembled"],
+				ip["ipdefrag_datagrams_timedout"]-lastIp["ipdefrag_datagrams_timedout"],
+				ip["ipdefrag_datagrams_too_many_fragments"]-lastIp["ipdefrag_datagrams_too_many_fragments"])
+			lastIp = ip
+		}
+	}
+}
+
+// PacketHandler starts the defragmentation, the tcp reassembly and the udp processing
+// of a socket and returns the handler of its packets, with the function to stop them
+// once the reader of the socket is stopped
+func (c *AfpacketSniffer) PacketHandler(dnsChan chan netlib.DnsPacket) (func([]byte, time.Time), func()) {
 	udpChan := make(chan gopacket.Packet)
 	tcpChan := make(chan gopacket.Packet)
 	fragIp4Chan := make(chan gopacket.Packet)
 	fragIp6Chan := make(chan gopacket.Packet)
 
-	netDecoder := &netlib.NetDecoder{}
+	netDecoder := &netlib.NetDecoder{Decap: c.decap}
+
+	// the dns ports are filtered by bpf, except for the encapsulated packets
+	// and the packets of the encrypted dns ports
+	portFilter := []int{}
+	if c.decap.Enabled() || len(c.tlsPorts) > 0 {
+		portFilter = c.ports
+	}
 
+	var defraggers, processors sync.WaitGroup
+	defraggers.Add(2)
+	processors.Add(2)
 	// defrag ipv4
-	go netlib.IpDefragger(fragIp4Chan, udpChan, tcpChan)
+	go func() {
+		defer defraggers.Done()
+		netlib.IpDefraggerWithOptions(fragIp4Chan, udpChan, tcpChan, c.ipOptions)
+	}()
 	// defrag ipv6
-	go netlib.IpDefragger(fragIp6Chan, udpChan, tcpChan)
+	go func() {
+		defer defraggers.Done()
+		netlib.IpDefraggerWithOptions(fragIp6Chan, udpChan, tcpChan, c.ipOptions)
+	}()
 	// tcp assembly
-	go netlib.TcpAssembler(tcpChan, dnsChan, 0)
+	go func() {
+		defer processors.Done()
+		netlib.TcpAssemblerWithOptions(tcpChan, dnsChan, c.tcpOptions, portFilter...)
+	}()
 	// udp processor
-	go netlib.UdpProcessor(udpChan, dnsChan, 0)
-
-	// goroutine to read all packets reassembled
 	go func() {
-		// prepare dns message
-		dm := dnsutils.DnsMessage{}
-
-		for {
-			select {
-			// new config provided?
-			case cfg, opened := <-c.configChan:
-				if !opened {
-					return
-				}
-				c.config = cfg
-				c.ReadConfig()
-
-				// send the config to the dns processor
-				dnsProcessor.ConfigChan <- cfg
+		defer processors.Done()
+		netlib.UdpProcessor(udpChan, dnsChan, portFilter...)
+	}()
 
-			// dns message to read ?
-			case dnsPacket := <-dnsChan:
-				// reset
-				dm.Init()
+	// the defraggers send to the tcp and udp processors, they are stopped first
+	stop := func() {
+		close(fragIp4Chan)
+		close(fragIp6Chan)
+		defraggers.Wait()
+		close(tcpChan)
+		close(udpChan)
+		processors.Wait()
+	}
 
-				dm.NetworkInfo.Family = dnsPacket.IpLayer.EndpointType().String()
-				dm.NetworkInfo.QueryIp = dnsPacket.IpLayer.Src().String()
-				dm.NetworkInfo.ResponseIp = dnsPacket.IpLayer.Dst().String()
-				dm.NetworkInfo.QueryPort = dnsPacket.TransportLayer.Src().String()
-				dm.NetworkInfo.ResponsePort = dnsPacket.TransportLayer.Dst().String()
-				dm.NetworkInfo.Protocol = dnsPacket.TransportLayer.EndpointType().String()
+	handler := func(data []byte, timestamp time.Time) {
+		// copy packet data from buffer
+		pkt := make([]byte, len(data))
+		copy(pkt, data)
 
-				dm.DNS.Payload = dnsPacket.Payload
-				dm.DNS.Length = len(dnsPacket.Payload)
+		// decode minimal layers
+		packet := gopacket.NewPacket(pkt, netDecoder, gopacket.NoCopy)
+		packet.Metadata().CaptureLength = len(packet.Data())
+		packet.Metadata().Length = len(packet.Data())
+		packet.Metadata().Timestamp = timestamp
 
-				dm.DnsTap.Identity = c.identity
+		// some security checks
+		if packet.NetworkLayer() == nil {
+			return
+		}
+		if packet.TransportLayer() == nil {
+			return
+		}
 
-				timestamp := dnsPacket.Timestamp.UnixNano()
-				seconds := timestamp / int64(time.Second)
-				dm.DnsTap.TimeSec = int(seconds)
-				dm.DnsTap.TimeNsec = int(timestamp - seconds*int64(time.Second)*int64(time.Nanosecond))
+		// ipv4 fragmented packet ?
+		if packet.NetworkLayer().LayerType() == layers.LayerTypeIPv4 {
+			ip4 := packet.NetworkLayer().(*layers.IPv4)
+			if ip4.Flags&layers.IPv4MoreFragments == 1 || ip4.FragOffset > 0 {
+				fragIp4Chan <- packet
+				return
+			}
+		}
 
-				// send DNS message to DNS processor
-				dnsProcessor.GetChannel() <- dm
+		// ipv6 fragmented packet ?
+		if packet.NetworkLayer().LayerType() == layers.LayerTypeIPv6 {
+			v6frag := packet.Layer(layers.LayerTypeIPv6Fragment)
+			if v6frag != nil {
+				fragIp6Chan <- packet
+				return
 			}
 		}
-	}()
 
-	go func() {
-		buf := make([]byte, 65536)
-		oob := make([]byte, 100)
+		// tcp or udp packets ?
+		if packet.TransportLayer().LayerType() == layers.LayerTypeUDP {
+			udpChan <- packet
+		}
 
-		for {
-			//flags, from
-			bufN, oobn, _, _, err := syscall.Recvmsg(c.fd, buf, oob, 0)
-			if err != nil {
-				if errors.Is(err, syscall.EINTR) {
-					continue
-				} else {
-					panic(err)
-				}
-			}
-			if bufN == 0 {
-				panic("buf empty")
-			}
-			if bufN > len(buf) {
-				panic("buf overflow")
-			}
-			if oobn == 0 {
-				panic("oob missing")
-			}
+		if packet.TransportLayer().LayerType() == layers.LayerTypeTCP {
+			tcpChan <- packet
+		}
+	}
+	return handler, stop
+}
 
-			scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
-			if err != nil {
-				panic(err)
-			}
-			if len(scms) != 1 {
+// ReadSocket reads the packets with recvmsg, when the ring buffer is not used.
+// The socket is polled with a timeout to check the stop channel, so the reader
+// returns before the socket is closed.
+func (c *AfpacketSniffer) ReadSocket(s *AfpacketSocket, handler func([]byte, time.Time), stop chan bool) {
+	buf := make([]byte, 65536)
+	oob := make([]byte, 100)
+	pfd := []unix.PollFd{{Fd: int32(s.fd), Events: unix.POLLIN}}
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		// wait for the next packet
+		n, err := unix.Poll(pfd, tpacketBlockTimeout)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) {
 				continue
 			}
-			scm := scms[0]
-			if scm.Header.Type != syscall.SCM_TIMESTAMPNS {
-				panic("scm timestampns missing")
-			}
-			tsec := binary.LittleEndian.Uint32(scm.Data[:4])
-			nsec := binary.LittleEndian.Uint32(scm.Data[8:12])
-			timestamp := time.Unix(int64(tsec), int64(nsec))
-
-			// copy packet data from buffer
-			pkt := make([]byte, bufN)
-			copy(pkt, buf[:bufN])
-
-			// decode minimal layers
-			packet := gopacket.NewPacket(pkt, netDecoder, gopacket.NoCopy)
-			packet.Metadata().CaptureLength = len(packet.Data())
-			packet.Metadata().Length = len(packet.Data())
-			packet.Metadata().Timestamp = timestamp
-
-			// some security checks
-			if packet.NetworkLayer() == nil {
+			c.LogError("socket poll error: %v", err)
+			return
+		}
+		if n == 0 {
+			continue
+		}
+
+		//flags, from
+		bufN, oobn, _, _, err := syscall.Recvmsg(s.fd, buf, oob, syscall.MSG_DONTWAIT)
+		if err != nil {
+			if errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) {
 				continue
 			}
-			if packet.TransportLayer() == nil {
-				continue
+			c.LogError("socket read error: %v", err)
+			if errors.Is(err, syscall.EBADF) {
+				return
 			}
+			continue
+		}
+		if bufN == 0 || bufN > len(buf) {
+			c.LogError("socket read error: invalid packet length %d", bufN)
+			continue
+		}
+		if oobn == 0 {
+			c.LogError("socket read error: control message missing")
+			continue
+		}
+
+		scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			c.LogError("socket read error: %v", err)
+			continue
+		}
+		if len(scms) != 1 {
+			continue
+		}
+		scm := scms[0]
+		if scm.Header.Type != syscall.SCM_TIMESTAMPNS || len(scm.Data) < 12 {
+			c.LogError("socket read error: timestamp missing")
+			continue
+		}
+		tsec := binary.LittleEndian.Uint32(scm.Data[:4])
+		nsec := binary.LittleEndian.Uint32(scm.Data[8:12])
+		timestamp := time.Unix(int64(tsec), int64(nsec))
+
+		handler(buf[:bufN], timestamp)
+	}
+}
+
+// StartStats exposes the counters of the sockets to the loggers and logs them at the interval,
+// and returns the function to stop them
+func (c *AfpacketSniffer) StartStats() func() {
+	dnsutils.RegisterCollectorStats(c.name, c.Stats)
+	stop := make(chan bool)
+	if c.statsInterval > 0 {
+		go c.LogStats(c.statsInterval, stop)
+	}
+	return func() {
+		close(stop)
+		dnsutils.UnregisterCollectorStats(c.name)
+	}
+}
 
-			// ipv4 fragmented packet ?
-			if packet.NetworkLayer().LayerType() == layers.LayerTypeIPv4 {
-				ip4 := packet.NetworkLayer().(*layers.IPv4)
-				if ip4.Flags&layers.IPv4MoreFragments == 1 || ip4.FragOffset > 0 {
-					fragIp4Chan <- packet
-					continue
+// StartReaders starts one reader per socket, with its own decoding and reassembly,
+// and returns the function to stop them. The sockets are closed and the ring buffers
+// unmapped after the end of their readers.
+func (c *AfpacketSniffer) StartReaders(dnsChan chan netlib.DnsPacket) func() {
+	stop := make(chan bool)
+	var readers sync.WaitGroup
+	handlers := []func(){}
+
+	for _, s := range c.sockets {
+		handler, stopHandler := c.PacketHandler(dnsChan)
+		handlers = append(handlers, stopHandler)
+
+		readers.Add(1)
+		go func(s *AfpacketSocket) {
+			defer readers.Done()
+			if s.ring != nil {
+				if err := s.ring.ReadPackets(handler, stop); err != nil {
+					c.LogError("ring buffer read error: %v", err)
 				}
+				return
 			}
+			c.ReadSocket(s, handler, stop)
+		}(s)
+	}
+
+	return func() {
+		close(stop)
+		readers.Wait()
+		for _, stopHandler := range handlers {
+			stopHandler()
+		}
+	}
+}
+
+func (c *AfpacketSniffer) Run() {
+	c.LogInfo("starting collector...")
+
+	if len(c.sockets) == 0 {
+		if err := c.Listen(); err != nil {
+			c.LogError("init raw socket failed: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-			// ipv6 fragmented packet ?
-			if packet.NetworkLayer().LayerType() == layers.LayerTypeIPv6 {
-				v6frag := packet.Layer(layers.LayerTypeIPv6Fragment)
-				if v6frag != nil {
-					fragIp6Chan <- packet
-					continue
+	// kernel counters
+	stopStats := c.StartStats()
+
+	dnsProcessor := processors.NewDnsProcessor(c.config, c.logger, c.name, c.config.Collectors.AfpacketLiveCapture.ChannelBufferSize)
+	go dnsProcessor.Run(c.Loggers())
+
+	dnsChan := make(chan netlib.DnsPacket)
+
+	// goroutine to read all packets reassembled
+	go func() {
+		// prepare dns message
+		dm := dnsutils.DnsMessage{}
+
+		for dnsPacket := range dnsChan {
+			// reset
+			dm.Init()
+
+			dm.NetworkInfo.Family = dnsPacket.IpLayer.EndpointType().String()
+			dm.NetworkInfo.QueryIp = dnsPacket.IpLayer.Src().String()
+			dm.NetworkInfo.ResponseIp = dnsPacket.IpLayer.Dst().String()
+			dm.NetworkInfo.QueryPort = dnsPacket.TransportLayer.Src().String()
+			dm.NetworkInfo.ResponsePort = dnsPacket.TransportLayer.Dst().String()
+			dm.NetworkInfo.Protocol = dnsPacket.TransportLayer.EndpointType().String()
+			if dnsPacket.Tunnel != nil {
+				dm.NetworkInfo.Tunnel = &dnsutils.DnsTunnel{
+					Encapsulations: dnsPacket.Tunnel.Encapsulations,
+					VlanIds:        dnsPacket.Tunnel.VlanIds,
+					Vni:            dnsPacket.Tunnel.Vni,
+					ErspanSession:  dnsPacket.Tunnel.ErspanSession,
 				}
 			}
+			if dnsPacket.Tls != nil {
+				dm.NetworkInfo.Protocol = dnsPacket.Tls.Protocol
+				dm.NetworkInfo.Tls = &dnsutils.DnsTls{
+					Event:       dnsPacket.Tls.Event,
+					ServerName:  dnsPacket.Tls.ServerName,
+					Alpn:        dnsPacket.Tls.Alpn,
+					Version:     dnsPacket.Tls.Version,
+					ClientBytes: dnsPacket.Tls.ClientBytes,
+					ServerBytes: dnsPacket.Tls.ServerBytes,
+				}
+			}
+
+			dm.DNS.Payload = dnsPacket.Payload
+			dm.DNS.Length = len(dnsPacket.Payload)
+
+			dm.DnsTap.Identity = c.getIdentity()
 
-			// tcp or udp packets ?
-			if packet.TransportLayer().LayerType() == layers.LayerTypeUDP {
-				udpChan <- packet
+			timestamp := dnsPacket.Timestamp.UnixNano()
+			seconds := timestamp / int64(time.Second)
+			dm.DnsTap.TimeSec = int(seconds)
+			dm.DnsTap.TimeNsec = int(timestamp - seconds*int64(time.Second)*int64(time.Nanosecond))
+
+			// send DNS message to DNS processor
+			dnsProcessor.GetChannel() <- dm
+		}
+	}()
+
+	stopReaders := c.StartReaders(dnsChan)
+
+RUN_LOOP:
+	for {
+		select {
+		case <-c.exit:
+			break RUN_LOOP
+
+		// new config provided?
+		case cfg := <-c.configChan:
+			settings, err := c.parseConfig(cfg)
+			if err != nil {
+				c.LogError("%v, the current configuration is kept", err)
+				continue
 			}
 
-			if packet.TransportLayer().LayerType() == layers.LayerTypeTCP {
-				tcpChan <- packet
+			// the readers are restarted with the new ports and sockets
+			stopReaders()
+			if err := c.applySettings(settings); err != nil {
+				c.LogError("%v, the current configuration is kept", err)
+			} else {
+				c.config = cfg
+
+				// the counters of the new devices
+				stopStats()
+				stopStats = c.StartStats()
+
+				// send the config to the dns processor
+				dnsProcessor.ConfigChan <- cfg
 			}
+			stopReaders = c.StartReaders(dnsChan)
 		}
+	}
 
-	}()
+	// stop the readers before closing their sockets
+	stopReaders()
+	stopStats()
+	c.closeSockets()
 
-	<-c.exit
 	close(dnsChan)
 	close(c.configChan)
 
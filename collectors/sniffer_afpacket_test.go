@@ -7,12 +7,49 @@ import (
 	"log"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
 	"github.com/dmachard/go-dnscollector/loggers"
 	"github.com/dmachard/go-logger"
+	"github.com/miekg/dns"
 )
 
+// sendDnsQuery sends a dns query to the ip on the loopback interface, without server
+func sendDnsQuery(t *testing.T, ip string, qname string) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(qname), dns.TypeA)
+	payload, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(ip, "53"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitDnsQuery returns the qname of the first query received among the qnames
+func waitDnsQuery(t *testing.T, g *loggers.FakeLogger, qnames ...string) string {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-g.Channel():
+			for _, qname := range qnames {
+				if msg.DnsTap.Operation == dnsutils.DNSTAP_CLIENT_QUERY && msg.DNS.Qname == qname {
+					return qname
+				}
+			}
+		case <-timeout:
+			t.Fatalf("no query received for %v", qnames)
+		}
+	}
+}
+
 func TestAfpacketSnifferRun(t *testing.T) {
 	g := loggers.NewFakeLogger()
 	c := NewAfpacketSniffer([]dnsutils.Worker{g}, dnsutils.GetFakeConfig(), logger.New(false), "test")
@@ -32,3 +69,126 @@ func TestAfpacketSnifferRun(t *testing.T) {
 		}
 	}
 }
+
+func TestAfpacketSnifferRun_FanoutRing(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.AfpacketLiveCapture.FanoutSockets = 2
+	config.Collectors.AfpacketLiveCapture.TpacketV3 = true
+	config.Collectors.AfpacketLiveCapture.RingBlocks = 4
+
+	g := loggers.NewFakeLogger()
+	c := NewAfpacketSniffer([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		log.Fatal("collector sniffer listening error: ", err)
+	}
+	go c.Run()
+
+	// send dns query
+	net.LookupIP("dns.collector")
+
+	// waiting message in channel
+	for {
+		msg := <-g.Channel()
+		if msg.DnsTap.Operation == dnsutils.DNSTAP_CLIENT_QUERY && msg.DNS.Qname == "dns.collector" {
+			break
+		}
+	}
+
+	// the packets are counted by the kernel
+	if stats := c.Stats(); stats["kernel_packets_received"] == 0 {
+		t.Errorf("no packet received in the kernel counters: %v", stats)
+	}
+}
+
+func TestAfpacketSniffer_ReloadFilter(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.AfpacketLiveCapture.Device = "lo"
+
+	g := loggers.NewFakeLogger()
+	c := NewAfpacketSniffer([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		t.Fatal("collector sniffer listening error: ", err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	// the second reload is received once the first one is applied
+	reload := func(filter string) {
+		cfg := dnsutils.GetFakeConfig()
+		cfg.Collectors.AfpacketLiveCapture.Device = "lo"
+		cfg.Collectors.AfpacketLiveCapture.Filter = filter
+		c.ReloadConfig(cfg)
+		c.ReloadConfig(cfg)
+	}
+
+	// an invalid filter is ignored, the collector is still running
+	reload("dst host")
+	sendDnsQuery(t, "127.0.0.1", "invalid.collector")
+	waitDnsQuery(t, g, "invalid.collector")
+
+	// the new filter is attached to the open socket
+	reload("dst host 127.0.0.2")
+	sendDnsQuery(t, "127.0.0.1", "filtered.collector")
+	sendDnsQuery(t, "127.0.0.2", "kept.collector")
+	if qname := waitDnsQuery(t, g, "filtered.collector", "kept.collector"); qname != "kept.collector" {
+		t.Errorf("the query to 127.0.0.1 should be filtered")
+	}
+}
+
+func TestAfpacketSniffer_ReloadDevices(t *testing.T) {
+	newConfig := func(devices ...string) *dnsutils.Config {
+		config := dnsutils.GetFakeConfig()
+		config.Collectors.AfpacketLiveCapture.Devices = devices
+		config.Collectors.AfpacketLiveCapture.FanoutSockets = 2
+		return config
+	}
+	// the second reload is received once the first one is applied
+	reload := func(c *AfpacketSniffer, devices ...string) {
+		c.ReloadConfig(newConfig(devices...))
+		c.ReloadConfig(newConfig(devices...))
+	}
+	checkDevices := func(c *AfpacketSniffer, devices ...string) {
+		stats := c.ReadStats()
+		if len(stats) != len(devices) {
+			t.Errorf("want devices %v, got %d devices", devices, len(stats))
+		}
+		for _, device := range devices {
+			if _, ok := stats[device]; !ok {
+				t.Errorf("want devices %v, %s not found", devices, device)
+			}
+		}
+	}
+
+	g := loggers.NewFakeLogger()
+	c := NewAfpacketSniffer([]dnsutils.Worker{g}, newConfig("lo"), logger.New(false), "test")
+	if err := c.Listen(); err != nil {
+		t.Fatal("collector sniffer listening error: ", err)
+	}
+	go c.Run()
+	defer c.Stop()
+
+	sendDnsQuery(t, "127.0.0.1", "before.collector")
+	waitDnsQuery(t, g, "before.collector")
+	received := c.Stats()["kernel_packets_received"]
+
+	// new sockets on the new devices
+	reload(c, "lo", "")
+	checkDevices(c, "lo", "all")
+	sendDnsQuery(t, "127.0.0.1", "after.collector")
+	waitDnsQuery(t, g, "after.collector")
+	if _, ok := dnsutils.GetCollectorStats()["test"]; !ok {
+		t.Errorf("the counters of the collector should be registered")
+	}
+	if stats := c.Stats(); stats["kernel_packets_received"] < received {
+		t.Errorf("the counters of the closed sockets should be kept: %d < %d", stats["kernel_packets_received"], received)
+	}
+
+	// the current sockets are kept if the new ones can not be opened
+	reload(c, "dnscollector0")
+	checkDevices(c, "lo", "all")
+
+	reload(c, "lo")
+	checkDevices(c, "lo")
+	sendDnsQuery(t, "127.0.0.1", "last.collector")
+	waitDnsQuery(t, g, "last.collector")
+}
@@ -0,0 +1,111 @@
+//go:build linux
+// +build linux
+
+package collectors
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// max size of a frame in the blocks, the frames have a variable size with TPACKET_V3
+	tpacketFrameSize = 2048
+	// the kernel releases a block not full after this timeout, in milliseconds
+	tpacketBlockTimeout = 100
+	// offset of the TpacketHdrV1 header in the block descriptor
+	tpacketBlockHdrOffset = 8
+)
+
+// TpacketRing is the TPACKET_V3 ring buffer of the packets received on an AF_PACKET socket,
+// the kernel fills the blocks shared with the userspace without any syscall per packet
+type TpacketRing struct {
+	fd        int
+	ring      []byte
+	blockSize int
+	blocks    int
+	current   int
+}
+
+// NewTpacketRing setups the ring on the socket, must be called before the bind
+func NewTpacketRing(fd int, blockSize int, blocks int) (*TpacketRing, error) {
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+		return nil, err
+	}
+
+	req := &unix.TpacketReq3{
+		Block_size:     uint32(blockSize),
+		Block_nr:       uint32(blocks),
+		Frame_size:     tpacketFrameSize,
+		Frame_nr:       uint32(blockSize / tpacketFrameSize * blocks),
+		Retire_blk_tov: tpacketBlockTimeout,
+	}
+	if err := unix.SetsockoptTpacketReq3(fd, unix.SOL_PACKET, unix.PACKET_RX_RING, req); err != nil {
+		return nil, err
+	}
+
+	ring, err := unix.Mmap(fd, 0, blockSize*blocks, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TpacketRing{fd: fd, ring: ring, blockSize: blockSize, blocks: blocks}, nil
+}
+
+// ReadPackets calls the handler for each packet of the ring, the data is only valid during the call.
+// Returns when the stop channel is closed.
+func (r *TpacketRing) ReadPackets(handler func(data []byte, timestamp time.Time), stop chan bool) error {
+	pfd := []unix.PollFd{{Fd: int32(r.fd), Events: unix.POLLIN | unix.POLLERR}}
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		block := r.ring[r.current*r.blockSize : (r.current+1)*r.blockSize]
+		hdr := (*unix.TpacketHdrV1)(unsafe.Pointer(&block[tpacketBlockHdrOffset]))
+
+		// wait for the next block to be released by the kernel
+		if atomic.LoadUint32(&hdr.Block_status)&unix.TP_STATUS_USER == 0 {
+			if _, err := unix.Poll(pfd, tpacketBlockTimeout); err != nil && !errors.Is(err, syscall.EINTR) {
+				return err
+			}
+			continue
+		}
+
+		offset := int(hdr.Offset_to_first_pkt)
+		for i := uint32(0); i < hdr.Num_pkts; i++ {
+			if offset+int(unsafe.Sizeof(unix.Tpacket3Hdr{})) > len(block) {
+				break
+			}
+			pkt := (*unix.Tpacket3Hdr)(unsafe.Pointer(&block[offset]))
+
+			start := offset + int(pkt.Mac)
+			end := start + int(pkt.Snaplen)
+			if end > len(block) {
+				break
+			}
+			handler(block[start:end], time.Unix(int64(pkt.Sec), int64(pkt.Nsec)))
+
+			if pkt.Next_offset == 0 {
+				break
+			}
+			offset += int(pkt.Next_offset)
+		}
+
+		// give back the block to the kernel
+		atomic.StoreUint32(&hdr.Block_status, unix.TP_STATUS_KERNEL)
+		r.current = (r.current + 1) % r.blocks
+	}
+}
+
+func (r *TpacketRing) Close() error {
+	return unix.Munmap(r.ring)
+}
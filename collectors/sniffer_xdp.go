@@ -9,8 +9,10 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sync"
 	"time"
 
+	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
 	"github.com/cilium/ebpf/perf"
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -43,6 +45,9 @@ type XdpSniffer struct {
 	done       chan bool
 	exit       chan bool
 	identity   string
+	ports      map[uint16]bool
+	portsLock  sync.RWMutex
+	stats      *ebpf.Map
 	loggers    []dnsutils.Worker
 	config     *dnsutils.Config
 	configChan chan *dnsutils.Config
@@ -91,6 +96,119 @@ func (c *XdpSniffer) Loggers() ([]chan dnsutils.DnsMessage, []string) {
 
 func (c *XdpSniffer) ReadConfig() {
 	c.identity = c.config.GetServerIdentity()
+
+	// list of ports or the single port
+	ports := c.config.Collectors.XdpLiveCapture.Ports
+	if len(ports) == 0 {
+		ports = []int{c.config.Collectors.XdpLiveCapture.Port}
+	}
+
+	// an invalid list does not stop the collector on a reload, the current ports are kept
+	portSet, err := parseXdpPorts(ports)
+	if err != nil {
+		c.LogError("%s, the current ports are kept", err)
+		return
+	}
+
+	c.portsLock.Lock()
+	c.ports = portSet
+	c.portsLock.Unlock()
+}
+
+// parseXdpPorts returns the set of the dns ports to capture
+func parseXdpPorts(ports []int) (map[uint16]bool, error) {
+	if len(ports) > xdp.MaxPorts {
+		return nil, fmt.Errorf("too many ports, max %d", xdp.MaxPorts)
+	}
+
+	portSet := make(map[uint16]bool)
+	for _, port := range ports {
+		if port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port: %d", port)
+		}
+		portSet[uint16(port)] = true
+	}
+	return portSet, nil
+}
+
+// IsDnsPort returns true if the port is one of the dns ports
+func (c *XdpSniffer) IsDnsPort(port uint16) bool {
+	c.portsLock.RLock()
+	defer c.portsLock.RUnlock()
+	return c.ports[port]
+}
+
+// UpdatePorts writes the dns ports in the map used by the XDP program
+func (c *XdpSniffer) UpdatePorts(m *ebpf.Map) error {
+	c.portsLock.RLock()
+	defer c.portsLock.RUnlock()
+
+	// remove the ports of the previous configuration
+	var port uint16
+	var value uint8
+	stale := []uint16{}
+	iter := m.Iterate()
+	for iter.Next(&port, &value) {
+		if !c.ports[port] {
+			stale = append(stale, port)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	for _, port := range stale {
+		if err := m.Delete(port); err != nil {
+			return err
+		}
+	}
+
+	for port := range c.ports {
+		if err := m.Put(port, uint8(1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats returns the per-cpu counters of the XDP program, summed
+func (c *XdpSniffer) Stats() map[string]uint64 {
+	counters := map[string]uint64{}
+	for name, index := range map[string]uint32{
+		"xdp_packets_seen":    xdp.StatsSeen,
+		"xdp_packets_matched": xdp.StatsMatched,
+		"xdp_perf_lost":       xdp.StatsLost,
+	} {
+		var values []uint64
+		if err := c.stats.Lookup(index, &values); err != nil {
+			c.LogError("unable to read the BPF counters: %s", err)
+			continue
+		}
+		for _, v := range values {
+			counters[name] += v
+		}
+	}
+	return counters
+}
+
+// LogStats logs the packets seen, matched and lost during the interval
+func (c *XdpSniffer) LogStats(interval time.Duration, stop chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := map[string]uint64{}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			stats := c.Stats()
+			c.LogInfo("packets seen: %d, matched: %d, lost by the perf buffer: %d",
+				stats["xdp_packets_seen"]-last["xdp_packets_seen"],
+				stats["xdp_packets_matched"]-last["xdp_packets_matched"],
+				stats["xdp_perf_lost"]-last["xdp_perf_lost"])
+			last = stats
+		}
+	}
 }
 
 func (c *XdpSniffer) ReloadConfig(config *dnsutils.Config) {
@@ -133,6 +251,27 @@ func (c *XdpSniffer) Run() {
 	}
 	defer objs.Close()
 
+	// dns ports to capture
+	if len(c.ports) == 0 {
+		c.LogError("no valid dns port to capture")
+		os.Exit(1)
+	}
+	if err := c.UpdatePorts(objs.Ports); err != nil {
+		c.LogError("could not update the ports: %s", err)
+		os.Exit(1)
+	}
+
+	// per-cpu counters
+	c.stats = objs.Stats
+	dnsutils.RegisterCollectorStats(c.name, c.Stats)
+	defer dnsutils.UnregisterCollectorStats(c.name)
+
+	stopStats := make(chan bool)
+	defer close(stopStats)
+	if c.config.Collectors.XdpLiveCapture.StatsInterval > 0 {
+		go c.LogStats(time.Duration(c.config.Collectors.XdpLiveCapture.StatsInterval)*time.Second, stopStats)
+	}
+
 	// Attach the program.
 	l, err := link.AttachXDP(link.XDPOptions{
 		Program:   objs.XdpSniffer,
@@ -164,6 +303,9 @@ func (c *XdpSniffer) Run() {
 				}
 				c.config = cfg
 				c.ReadConfig()
+				if err := c.UpdatePorts(objs.Ports); err != nil {
+					c.LogError("could not update the ports: %s", err)
+				}
 
 				// send the config to the dns processor
 				dnsProcessor.ConfigChan <- cfg
@@ -226,7 +368,7 @@ func (c *XdpSniffer) Run() {
 			dm.DnsTap.TimeSec = int(tsAdjusted.Unix())
 			dm.DnsTap.TimeNsec = int(tsAdjusted.UnixNano() - tsAdjusted.Unix()*1e9)
 
-			if pkt.SrcPort == 53 {
+			if c.IsDnsPort(pkt.SrcPort) {
 				dm.DnsTap.Operation = dnsutils.DNSTAP_CLIENT_RESPONSE
 			} else {
 				dm.DnsTap.Operation = dnsutils.DNSTAP_CLIENT_QUERY
@@ -0,0 +1,34 @@
+//go:build linux
+// +build linux
+
+package collectors
+
+import (
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-logger"
+)
+
+func TestXdpSniffer_ReloadInvalidPorts(t *testing.T) {
+	g := loggers.NewFakeLogger()
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.XdpLiveCapture.Ports = []int{53, 5353}
+	c := NewXdpSniffer([]dnsutils.Worker{g}, config, logger.New(false), "test")
+
+	// the invalid ports are ignored, the current ports are kept
+	for _, ports := range [][]int{{53, 70000}, make([]int, 65)} {
+		config.Collectors.XdpLiveCapture.Ports = ports
+		c.ReadConfig()
+		if !c.IsDnsPort(53) || !c.IsDnsPort(5353) {
+			t.Errorf("ports %v: the current ports should be kept", ports)
+		}
+	}
+
+	config.Collectors.XdpLiveCapture.Ports = []int{853}
+	c.ReadConfig()
+	if c.IsDnsPort(53) || !c.IsDnsPort(853) {
+		t.Errorf("the new ports should be used")
+	}
+}
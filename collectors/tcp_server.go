@@ -0,0 +1,355 @@
+package collectors
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/processors"
+	"github.com/dmachard/go-logger"
+)
+
+type TcpServer struct {
+	doneRun       chan bool
+	stopRun       chan bool
+	listen        net.Listener
+	conns         []net.Conn
+	wgConns       sync.WaitGroup
+	sockPath      string
+	loggers       []dnsutils.Worker
+	config        *dnsutils.Config
+	configChan    chan *dnsutils.Config
+	logger        *logger.Logger
+	name          string
+	connMode      string
+	connId        int
+	textFormat    []string
+	msgProcessors []processors.DnsMessageProcessor
+	sync.RWMutex
+}
+
+func NewTcpServer(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *TcpServer {
+	logger.Info("[%s] collector=tcpserver - enabled", name)
+	s := &TcpServer{
+		doneRun:    make(chan bool),
+		stopRun:    make(chan bool),
+		config:     config,
+		configChan: make(chan *dnsutils.Config),
+		loggers:    loggers,
+		logger:     logger,
+		name:       name,
+	}
+	s.ReadConfig()
+	return s
+}
+
+func (c *TcpServer) GetName() string { return c.name }
+
+func (c *TcpServer) SetLoggers(loggers []dnsutils.Worker) {
+	c.loggers = loggers
+}
+
+func (c *TcpServer) Loggers() ([]chan dnsutils.DnsMessage, []string) {
+	channels := []chan dnsutils.DnsMessage{}
+	names := []string{}
+	for _, p := range c.loggers {
+		channels = append(channels, p.Channel())
+		names = append(names, p.GetName())
+	}
+	return channels, names
+}
+
+func (c *TcpServer) ReadConfig() {
+	if !dnsutils.IsValidMode(c.config.Collectors.TcpServer.Mode) {
+		c.logger.Fatal("collector=tcpserver - invalid mode: ", c.config.Collectors.TcpServer.Mode)
+	}
+	if !dnsutils.IsValidTLS(c.config.Collectors.TcpServer.TlsMinVersion) {
+		c.logger.Fatal("collector=tcpserver - invalid tls min version")
+	}
+	if len(c.config.Collectors.TcpServer.PayloadDelimiter) == 0 {
+		c.logger.Fatal("collector=tcpserver - delimiter can not be empty")
+	}
+
+	if len(c.config.Collectors.TcpServer.TextFormat) > 0 {
+		c.textFormat = strings.Fields(c.config.Collectors.TcpServer.TextFormat)
+	} else {
+		c.textFormat = strings.Fields(c.config.Global.TextFormat)
+	}
+
+	c.sockPath = c.config.Collectors.TcpServer.SockPath
+
+	if len(c.config.Collectors.TcpServer.SockPath) > 0 {
+		c.connMode = "unix"
+	} else if c.config.Collectors.TcpServer.TlsSupport {
+		c.connMode = "tls"
+	} else {
+		c.connMode = "tcp"
+	}
+}
+
+func (c *TcpServer) ReloadConfig(config *dnsutils.Config) {
+	c.LogInfo("reload configuration...")
+	c.configChan <- config
+}
+
+func (c *TcpServer) LogInfo(msg string, v ...interface{}) {
+	c.logger.Info("["+c.name+"] collector=tcpserver - "+msg, v...)
+}
+
+func (c *TcpServer) LogError(msg string, v ...interface{}) {
+	c.logger.Error("["+c.name+"] collector=tcpserver - "+msg, v...)
+}
+
+func (c *TcpServer) LogConnInfo(connId int, msg string, v ...interface{}) {
+	prefix := fmt.Sprintf("[%s] collector=tcpserver#%d - ", c.name, connId)
+	c.logger.Info(prefix+msg, v...)
+}
+
+func (c *TcpServer) LogConnError(connId int, msg string, v ...interface{}) {
+	prefix := fmt.Sprintf("[%s] collector=tcpserver#%d - ", c.name, connId)
+	c.logger.Error(prefix+msg, v...)
+}
+
+// SplitDelimiter returns a split function for bufio.Scanner,
+// to read the payloads separated by the provided delimiter
+func SplitDelimiter(delimiter []byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.Index(data, delimiter); i >= 0 {
+			return i + len(delimiter), data[:i], nil
+		}
+		// last payload without delimiter
+		if atEOF {
+			return len(data), data, nil
+		}
+		// request more data
+		return 0, nil, nil
+	}
+}
+
+func (c *TcpServer) HandleConn(conn net.Conn) {
+	defer c.wgConns.Done()
+
+	// close connection on function exit
+	defer conn.Close()
+
+	var connId int
+	c.Lock()
+	c.connId++
+	connId = c.connId
+	c.Unlock()
+
+	// get peer address
+	peer := conn.RemoteAddr().String()
+	c.LogConnInfo(connId, "new connection from %s", peer)
+
+	// start the processor to decode messages in the tcpclient format
+	msgProcessor := processors.NewDnsMessageProcessor(connId, c.config.Collectors.TcpServer.Mode, c.textFormat,
+		c.config, c.logger, c.name, c.config.Collectors.TcpServer.ChannelBufferSize)
+	c.Lock()
+	c.msgProcessors = append(c.msgProcessors, msgProcessor)
+	c.Unlock()
+	go msgProcessor.Run(c.Loggers())
+
+	// split the stream on the delimiter
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	scanner.Split(SplitDelimiter([]byte(c.config.Collectors.TcpServer.PayloadDelimiter)))
+
+	for scanner.Scan() {
+		// ignore empty payloads, the json encoder of the
+		// tcpclient logger adds a newline before the delimiter
+		payload := bytes.TrimSpace(scanner.Bytes())
+		if len(payload) == 0 {
+			continue
+		}
+
+		// the scanner reuses its buffer
+		data := make([]byte, len(payload))
+		copy(data, payload)
+		msgProcessor.GetChannel() <- data
+	}
+
+	err := scanner.Err()
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		c.LogConnInfo(connId, "connection closed with peer %s", peer)
+	} else {
+		c.LogConnError(connId, "reader error: %s", err)
+	}
+
+	// stop processor
+	msgProcessor.Stop()
+
+	// here the connection is closed,
+	// then removes the current processor from the list
+	c.Lock()
+	for i, p := range c.msgProcessors {
+		if p.ConnId == connId {
+			c.msgProcessors = append(c.msgProcessors[:i], c.msgProcessors[i+1:]...)
+			break
+		}
+	}
+
+	// finnaly removes the current connection from the list
+	for j, cn := range c.conns {
+		if cn == conn {
+			c.conns = append(c.conns[:j], c.conns[j+1:]...)
+			break
+		}
+	}
+	c.Unlock()
+
+	c.LogConnInfo(connId, "connection handler terminated")
+}
+
+func (c *TcpServer) Channel() chan dnsutils.DnsMessage {
+	return nil
+}
+
+func (c *TcpServer) Stop() {
+	// close the listener to unblock accept
+	c.LogInfo("stop listening...")
+	c.listen.Close()
+
+	// read done channel and block until run is terminated
+	c.LogInfo("stopping run...")
+	c.stopRun <- true
+	<-c.doneRun
+
+	// closing properly current connections if exists
+	c.LogInfo("closing connected peers...")
+	c.Lock()
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+	c.Unlock()
+
+	// wait the end of connection handlers, processors are stopped
+	c.wgConns.Wait()
+}
+
+func (c *TcpServer) Listen() error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.LogInfo("running in background...")
+
+	var err error
+	var listener net.Listener
+	addrlisten := c.config.Collectors.TcpServer.ListenIP + ":" + strconv.Itoa(c.config.Collectors.TcpServer.ListenPort)
+
+	if len(c.sockPath) > 0 {
+		_ = os.Remove(c.sockPath)
+	}
+
+	// listening with tls enabled ?
+	if c.config.Collectors.TcpServer.TlsSupport {
+		c.LogInfo("tls support enabled")
+
+		tlsOptions := dnsutils.TlsOptions{
+			MinVersion: c.config.Collectors.TcpServer.TlsMinVersion,
+			CAFile:     c.config.Collectors.TcpServer.CAFile,
+			CertFile:   c.config.Collectors.TcpServer.CertFile,
+			KeyFile:    c.config.Collectors.TcpServer.KeyFile,
+		}
+
+		var tlsConfig *tls.Config
+		tlsConfig, err = dnsutils.TlsServerConfig(tlsOptions)
+		if err != nil {
+			c.logger.Fatal("collector=tcpserver - tls config failed:", err)
+		}
+
+		if len(c.sockPath) > 0 {
+			listener, err = tls.Listen(dnsutils.SOCKET_UNIX, c.sockPath, tlsConfig)
+		} else {
+			listener, err = tls.Listen(dnsutils.SOCKET_TCP, addrlisten, tlsConfig)
+		}
+
+	} else {
+		// basic listening
+		if len(c.sockPath) > 0 {
+			listener, err = net.Listen(dnsutils.SOCKET_UNIX, c.sockPath)
+		} else {
+			listener, err = net.Listen(dnsutils.SOCKET_TCP, addrlisten)
+		}
+	}
+
+	// something is wrong ?
+	if err != nil {
+		return err
+	}
+	c.LogInfo("is listening on %s://%s", c.connMode, listener.Addr())
+	c.listen = listener
+	return nil
+}
+
+func (c *TcpServer) Run() {
+	c.LogInfo("starting collector...")
+	if c.listen == nil {
+		if err := c.Listen(); err != nil {
+			prefixlog := fmt.Sprintf("[%s] ", c.name)
+			c.logger.Fatal(prefixlog+"collector=tcpserver listening failed: ", err)
+		}
+	}
+
+	// goroutine to Accept() blocks waiting for new connection.
+	acceptChan := make(chan net.Conn)
+	acceptDone := make(chan bool)
+	go func() {
+		for {
+			conn, err := c.listen.Accept()
+			if err != nil {
+				return
+			}
+			select {
+			case acceptChan <- conn:
+			case <-acceptDone:
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+RUN_LOOP:
+	for {
+		select {
+		case <-c.stopRun:
+			close(acceptDone)
+			c.doneRun <- true
+			break RUN_LOOP
+
+		case cfg := <-c.configChan:
+
+			// save the new config
+			c.config = cfg
+			c.ReadConfig()
+
+			// refresh config for all conns
+			c.RLock()
+			for i := range c.msgProcessors {
+				c.msgProcessors[i].ConfigChan <- cfg
+			}
+			c.RUnlock()
+
+		case conn := <-acceptChan:
+			c.Lock()
+			c.conns = append(c.conns, conn)
+			c.wgConns.Add(1)
+			c.Unlock()
+			go c.HandleConn(conn)
+		}
+
+	}
+	c.LogInfo("run terminated")
+}
@@ -0,0 +1,114 @@
+package collectors
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-logger"
+)
+
+func Test_TcpServerCollector(t *testing.T) {
+	testcases := []struct {
+		name      string
+		transport string
+		mode      string
+		delimiter string
+	}{
+		{
+			name:      "tcp_json",
+			transport: dnsutils.SOCKET_TCP,
+			mode:      dnsutils.MODE_JSON,
+			delimiter: "\n",
+		},
+		{
+			name:      "tcp_flatjson",
+			transport: dnsutils.SOCKET_TCP,
+			mode:      dnsutils.MODE_FLATJSON,
+			delimiter: "\n",
+		},
+		{
+			name:      "tcp_text",
+			transport: dnsutils.SOCKET_TCP,
+			mode:      dnsutils.MODE_TEXT,
+			delimiter: "\n",
+		},
+		{
+			name:      "tcp_custom_delimiter",
+			transport: dnsutils.SOCKET_TCP,
+			mode:      dnsutils.MODE_JSON,
+			delimiter: "\x00",
+		},
+		{
+			name:      "unix_json",
+			transport: dnsutils.SOCKET_UNIX,
+			mode:      dnsutils.MODE_JSON,
+			delimiter: "\n",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := loggers.NewFakeLogger()
+
+			config := dnsutils.GetFakeConfig()
+			config.Collectors.TcpServer.ListenIP = dnsutils.LOCALHOST_IP
+			config.Collectors.TcpServer.ListenPort = 0
+			config.Collectors.TcpServer.Mode = tc.mode
+			config.Collectors.TcpServer.PayloadDelimiter = tc.delimiter
+			if tc.transport == dnsutils.SOCKET_UNIX {
+				config.Collectors.TcpServer.SockPath = "/tmp/dnscollector_tcpserver.sock"
+			}
+
+			c := NewTcpServer([]dnsutils.Worker{g}, config, logger.New(false), "test")
+			if err := c.Listen(); err != nil {
+				t.Fatal("collector listening error: ", err)
+			}
+			go c.Run()
+
+			conn, err := net.Dial(tc.transport, c.listen.Addr().String())
+			if err != nil {
+				t.Fatal("could not connect: ", err)
+			}
+
+			// send two messages in the tcpclient format
+			dm := dnsutils.GetFakeDnsMessage()
+			dm.DNS.Qname = "tcp.collector.test"
+
+			w := bufio.NewWriter(conn)
+			for i := 0; i < 2; i++ {
+				switch tc.mode {
+				case dnsutils.MODE_TEXT:
+					w.Write(dm.Bytes(strings.Fields(config.Global.TextFormat), config.Global.TextFormatDelimiter, config.Global.TextFormatBoundary))
+				case dnsutils.MODE_JSON:
+					json.NewEncoder(w).Encode(dm)
+				case dnsutils.MODE_FLATJSON:
+					flat, _ := dm.Flatten()
+					json.NewEncoder(w).Encode(flat)
+				}
+				w.WriteString(tc.delimiter)
+			}
+			w.Flush()
+
+			// waiting messages in channel
+			for i := 0; i < 2; i++ {
+				select {
+				case msg := <-g.Channel():
+					if msg.DNS.Qname != dm.DNS.Qname {
+						t.Errorf("want qname %s, got %s", dm.DNS.Qname, msg.DNS.Qname)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("dns message %d not received", i)
+				}
+			}
+
+			conn.Close()
+			c.Stop()
+		})
+	}
+}
@@ -12,22 +12,24 @@ import (
 	"syscall"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
 	"github.com/dmachard/go-dnscollector/processors"
 	"github.com/dmachard/go-logger"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"github.com/rs/tzsp"
 )
 
 type TzspSniffer struct {
-	done     chan bool
-	exit     chan bool
-	listen   net.UDPConn
-	loggers  []dnsutils.Worker
-	config   *dnsutils.Config
-	logger   *logger.Logger
-	name     string
-	identity string
+	done       chan bool
+	exit       chan bool
+	listen     net.UDPConn
+	loggers    []dnsutils.Worker
+	config     *dnsutils.Config
+	logger     *logger.Logger
+	name       string
+	identity   string
+	identities map[string]string
+	decap      netlib.DecapOptions
 }
 
 func NewTzsp(loggers []dnsutils.Worker, config *dnsutils.Config, logger *logger.Logger, name string) *TzspSniffer {
@@ -70,6 +72,39 @@ func (c *TzspSniffer) LogError(msg string, v ...interface{}) {
 
 func (c *TzspSniffer) ReadConfig() {
 	c.identity = c.config.GetServerIdentity()
+
+	decap, err := netlib.NewDecapOptions(c.config.Collectors.Tzsp.Decapsulation)
+	if err != nil {
+		c.logger.Fatal("collector=tzsp - ", err)
+	}
+	c.decap = decap
+
+	// identities of the sensors, by ip or mac address
+	c.identities = make(map[string]string)
+	for sensor, identity := range c.config.Collectors.Tzsp.Identities {
+		if ip := net.ParseIP(sensor); ip != nil {
+			c.identities[ip.String()] = identity
+		} else if mac, err := net.ParseMAC(sensor); err == nil {
+			c.identities[mac.String()] = identity
+		} else {
+			c.logger.Fatal("collector=tzsp - invalid sensor address in identities: ", sensor)
+		}
+	}
+}
+
+// GetIdentity returns the identity of a sensor from the mapping table, with its ip address
+// or its mac address, the sensor address with the sender-identity option or the server identity
+func (c *TzspSniffer) GetIdentity(sensor string, sensorMac string) string {
+	if identity, ok := c.identities[sensor]; ok {
+		return identity
+	}
+	if identity, ok := c.identities[sensorMac]; ok && len(sensorMac) > 0 {
+		return identity
+	}
+	if c.config.Collectors.Tzsp.SenderIdentity {
+		return sensor
+	}
+	return c.identity
 }
 
 func (c *TzspSniffer) ReloadConfig(config *dnsutils.Config) {
@@ -129,12 +164,15 @@ func (c *TzspSniffer) Run() {
 	dnsProcessor := processors.NewDnsProcessor(c.config, c.logger, c.name, c.config.Collectors.Tzsp.ChannelBufferSize)
 	go dnsProcessor.Run(c.Loggers())
 
+	// one decoder per encapsulation of the captured frames
+	netDecoders := make(map[int]*netlib.NetDecoder)
+
 	go func() {
 		buf := make([]byte, 65536)
 		oob := make([]byte, 100)
 		for {
 			//flags, from
-			bufN, oobn, _, _, err := c.listen.ReadMsgUDPAddrPort(buf, oob)
+			bufN, oobn, _, from, err := c.listen.ReadMsgUDPAddrPort(buf, oob)
 			if err != nil {
 				panic(err)
 			}
@@ -167,41 +205,59 @@ func (c *TzspSniffer) Run() {
 			pkt := make([]byte, bufN)
 			copy(pkt, buf[:bufN])
 
-			tzsp_packet, err := tzsp.Parse(pkt)
-
+			frame, tzspInfo, err := netlib.ParseTzsp(pkt)
 			if err != nil {
-				c.LogError("Failed to parse packet: ", err)
+				c.LogError("Failed to parse packet: %s", err)
 				continue
 			}
 
-			var eth layers.Ethernet
-			var ip4 layers.IPv4
-			var ip6 layers.IPv6
-			var tcp layers.TCP
-			var udp layers.UDP
-			parser := gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet, &eth, &ip4, &ip6, &tcp, &udp)
-			decodedLayers := make([]gopacket.LayerType, 0, 4)
+			netDecoder, ok := netDecoders[tzspInfo.LinkType]
+			if !ok {
+				netDecoder, _ = netlib.NewNetDecoder(tzspInfo.LinkType)
+				netDecoder.Decap = c.decap
+				netDecoders[tzspInfo.LinkType] = netDecoder
+			}
 
-			// decode-it
-			parser.DecodeLayers(tzsp_packet.Data, &decodedLayers)
+			// decode-it, with the encapsulations removed
+			packet := gopacket.NewPacket(frame, netDecoder, gopacket.NoCopy)
 
 			dm := dnsutils.DnsMessage{}
 			dm.Init()
 
+			sensor := from.Addr().Unmap().String()
+			dm.NetworkInfo.Tzsp = &dnsutils.DnsTzsp{
+				Sensor:        sensor,
+				SensorMac:     tzspInfo.SensorMac,
+				Encapsulation: tzspInfo.Encapsulation,
+				Timestamp:     tzspInfo.Timestamp,
+			}
+
+			if tunnel := netlib.GetTunnel(packet); tunnel != nil {
+				dm.NetworkInfo.Tunnel = &dnsutils.DnsTunnel{
+					Encapsulations: tunnel.Encapsulations,
+					VlanIds:        tunnel.VlanIds,
+					Vni:            tunnel.Vni,
+					ErspanSession:  tunnel.ErspanSession,
+				}
+			}
+
 			ignore_packet := false
-			for _, layertyp := range decodedLayers {
-				switch layertyp {
+			for _, layer := range packet.Layers() {
+				switch layer.LayerType() {
 				case layers.LayerTypeIPv4:
+					ip4 := layer.(*layers.IPv4)
 					dm.NetworkInfo.Family = dnsutils.PROTO_IPV4
 					dm.NetworkInfo.QueryIp = ip4.SrcIP.String()
 					dm.NetworkInfo.ResponseIp = ip4.DstIP.String()
 
 				case layers.LayerTypeIPv6:
+					ip6 := layer.(*layers.IPv6)
 					dm.NetworkInfo.QueryIp = ip6.SrcIP.String()
 					dm.NetworkInfo.ResponseIp = ip6.DstIP.String()
 					dm.NetworkInfo.Family = dnsutils.PROTO_IPV6
 
 				case layers.LayerTypeUDP:
+					udp := layer.(*layers.UDP)
 					dm.NetworkInfo.QueryPort = fmt.Sprint(int(udp.SrcPort))
 					dm.NetworkInfo.ResponsePort = fmt.Sprint(int(udp.DstPort))
 					dm.DNS.Payload = udp.Payload
@@ -209,6 +265,7 @@ func (c *TzspSniffer) Run() {
 					dm.NetworkInfo.Protocol = dnsutils.PROTO_UDP
 
 				case layers.LayerTypeTCP:
+					tcp := layer.(*layers.TCP)
 					// ignore SYN/ACK packet
 					// Note: disabled because SYN/SYN+Ack might contain data if TCP Fast open is used
 					// if !tcp.PSH {
@@ -235,7 +292,7 @@ func (c *TzspSniffer) Run() {
 			}
 
 			if !ignore_packet {
-				dm.DnsTap.Identity = c.identity
+				dm.DnsTap.Identity = c.GetIdentity(sensor, tzspInfo.SensorMac)
 
 				// set timestamp
 				dm.DnsTap.TimeSec = int(tsec)
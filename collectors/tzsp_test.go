@@ -0,0 +1,94 @@
+//go:build linux
+// +build linux
+
+package collectors
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/loggers"
+	"github.com/dmachard/go-logger"
+)
+
+func TestTzspRun(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Tzsp.ListenIp = "127.0.0.1"
+	config.Collectors.Tzsp.ListenPort = 10010
+	config.Collectors.Tzsp.Identities = map[string]string{"127.0.0.1": "mikrotik-1"}
+
+	g := loggers.NewFakeLogger()
+	c := NewTzsp([]dnsutils.Worker{g}, config, logger.New(false), "test")
+	go c.Run()
+
+	// tzsp header with a raw ip frame and the sensor mac
+	packet := []byte{1, 0, 0, 7, 0x3c, 6, 0x00, 0x0c, 0x29, 0x8a, 0x5d, 0xd7, 0x01,
+		// ipv4
+		0x45, 0x00, 0x00, 0x3b, 0x00, 0x00, 0x00, 0x00, 0x40, 0x11, 0x00, 0x00,
+		0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x35,
+		// udp
+		0x8c, 0xa0, 0x00, 0x35, 0x00, 0x27, 0x00, 0x00,
+		// dns query
+		0xd4, 0x3f, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x64,
+		0x6e, 0x73, 0x09, 0x63, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x00, 0x00,
+		0x01, 0x00, 0x01,
+	}
+
+	conn, err := net.Dial("udp", "127.0.0.1:10010")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the collector starts to listen in background
+	timeout := time.After(5 * time.Second)
+	for {
+		conn.Write(packet)
+		select {
+		case msg := <-g.Channel():
+			if msg.DNS.Qname != "dns.collector" || msg.NetworkInfo.QueryIp != "10.0.0.1" {
+				t.Errorf("invalid dns message: %s %s", msg.DNS.Qname, msg.NetworkInfo.QueryIp)
+			}
+			if msg.DnsTap.Identity != "mikrotik-1" {
+				t.Errorf("want the identity of the sensor, got %s", msg.DnsTap.Identity)
+			}
+			if msg.NetworkInfo.Tzsp == nil || msg.NetworkInfo.Tzsp.Sensor != "127.0.0.1" ||
+				msg.NetworkInfo.Tzsp.SensorMac != "00:0c:29:8a:5d:d7" || msg.NetworkInfo.Tzsp.Encapsulation != "raw-ip" {
+				t.Errorf("invalid tzsp metadata: %+v", msg.NetworkInfo.Tzsp)
+			}
+			return
+		case <-time.After(100 * time.Millisecond):
+		case <-timeout:
+			t.Fatal("no dns message received")
+		}
+	}
+}
+
+func TestTzspIdentity(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	config.Collectors.Tzsp.Identities = map[string]string{
+		"192.168.1.1":       "router-1",
+		"00:0C:29:8A:5D:D7": "router-2",
+	}
+
+	c := NewTzsp([]dnsutils.Worker{}, config, logger.New(false), "test")
+
+	testcases := []struct {
+		sensor, sensorMac string
+		senderIdentity    bool
+		expected          string
+	}{
+		{"192.168.1.1", "", false, "router-1"},
+		{"192.168.1.2", "00:0c:29:8a:5d:d7", false, "router-2"},
+		{"192.168.1.2", "", false, config.GetServerIdentity()},
+		{"192.168.1.2", "", true, "192.168.1.2"},
+	}
+	for _, tc := range testcases {
+		config.Collectors.Tzsp.SenderIdentity = tc.senderIdentity
+		if identity := c.GetIdentity(tc.sensor, tc.sensorMac); identity != tc.expected {
+			t.Errorf("sensor %s %s: want %s, got %s", tc.sensor, tc.sensorMac, tc.expected, identity)
+		}
+	}
+}
@@ -255,6 +255,24 @@ func main() {
 		if subcfg.Collectors.Tzsp.Enable && IsCollectorRouted(config, input.Name) {
 			mapCollectors[input.Name] = collectors.NewTzsp(nil, subcfg, logger, input.Name)
 		}
+		if subcfg.Collectors.KafkaConsumer.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewKafkaConsumer(nil, subcfg, logger, input.Name)
+		}
+		if subcfg.Collectors.RedisSub.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewRedisSub(nil, subcfg, logger, input.Name)
+		}
+		if subcfg.Collectors.HttpIngest.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewHttpIngest(nil, subcfg, logger, input.Name)
+		}
+		if subcfg.Collectors.TcpServer.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewTcpServer(nil, subcfg, logger, input.Name)
+		}
+		if subcfg.Collectors.Fluentd.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewFluentd(nil, subcfg, logger, input.Name)
+		}
+		if subcfg.Collectors.DnsProxy.Enable && IsCollectorRouted(config, input.Name) {
+			mapCollectors[input.Name] = collectors.NewDnsProxy(nil, subcfg, logger, input.Name)
+		}
 	}
 
 	// here the multiplexer logic
@@ -65,6 +65,12 @@ type ConfigTransformers struct {
 		KeepQueryIpFile string   `yaml:"keep-queryip-file"`
 		KeepRdataFile   string   `yaml:"keep-rdata-file"`
 		DropRcodes      []string `yaml:"drop-rcodes,flow"`
+		DropOpcodes     []string `yaml:"drop-opcodes,flow"`
+		KeepOpcodes     []string `yaml:"keep-opcodes,flow"`
+		DropZones       []string `yaml:"drop-zones,flow"`
+		KeepZones       []string `yaml:"keep-zones,flow"`
+		DropUpdates     []string `yaml:"drop-updates,flow"`
+		KeepUpdates     []string `yaml:"keep-updates,flow"`
 		LogQueries      bool     `yaml:"log-queries"`
 		LogReplies      bool     `yaml:"log-replies"`
 		Downsample      int      `yaml:"downsample"`
@@ -134,6 +140,12 @@ func (c *ConfigTransformers) SetDefault() {
 	c.Filtering.KeepDomainFile = ""
 	c.Filtering.DropQueryIpFile = ""
 	c.Filtering.DropRcodes = []string{}
+	c.Filtering.DropOpcodes = []string{}
+	c.Filtering.KeepOpcodes = []string{}
+	c.Filtering.DropZones = []string{}
+	c.Filtering.KeepZones = []string{}
+	c.Filtering.DropUpdates = []string{}
+	c.Filtering.KeepUpdates = []string{}
 	c.Filtering.LogQueries = true
 	c.Filtering.LogReplies = true
 	c.Filtering.Downsample = 0
@@ -169,6 +181,8 @@ type Config struct {
 	Collectors struct {
 		Tail struct {
 			Enable       bool   `yaml:"enable"`
+			Mode         string `yaml:"mode"`
+			Preset       string `yaml:"preset"`
 			TimeLayout   string `yaml:"time-layout"`
 			PatternQuery string `yaml:"pattern-query"`
 			PatternReply string `yaml:"pattern-reply"`
@@ -186,6 +200,10 @@ type Config struct {
 			RcvBufSize        int    `yaml:"sock-rcvbuf"`
 			ResetConn         bool   `yaml:"reset-conn"`
 			ChannelBufferSize int    `yaml:"chan-buffer-size"`
+			Listeners         int    `yaml:"listeners"`
+			Workers           int    `yaml:"workers"`
+			Ordered           bool   `yaml:"ordered"`
+			StatsInterval     int    `yaml:"stats-interval"`
 		} `yaml:"dnstap"`
 		DnstapProxifier struct {
 			Enable        bool   `yaml:"enable"`
@@ -198,15 +216,34 @@ type Config struct {
 			KeyFile       string `yaml:"key-file"`
 		} `yaml:"dnstap-proxifier"`
 		AfpacketLiveCapture struct {
-			Enable            bool   `yaml:"enable"`
-			Port              int    `yaml:"port"`
-			Device            string `yaml:"device"`
-			ChannelBufferSize int    `yaml:"chan-buffer-size"`
+			Enable              bool     `yaml:"enable"`
+			Port                int      `yaml:"port"`
+			Ports               []int    `yaml:"ports,flow"`
+			TlsPorts            []int    `yaml:"tls-ports,flow"`
+			Filter              string   `yaml:"filter"`
+			Device              string   `yaml:"device"`
+			Devices             []string `yaml:"devices,flow"`
+			FanoutSockets       int      `yaml:"fanout-sockets"`
+			TpacketV3           bool     `yaml:"tpacket-v3"`
+			RingBlockSize       int      `yaml:"ring-block-size"`
+			RingBlocks          int      `yaml:"ring-blocks"`
+			StatsInterval       int      `yaml:"stats-interval"`
+			Decapsulation       []string `yaml:"decapsulation,flow"`
+			TcpMaxConnections   int      `yaml:"tcp-max-connections"`
+			TcpMaxBufferedBytes int      `yaml:"tcp-max-buffered-bytes"`
+			TcpFlushInterval    int      `yaml:"tcp-flush-interval"`
+			TcpIdleTimeout      int      `yaml:"tcp-idle-timeout"`
+			IpDefragMaxQueues   int      `yaml:"ipdefrag-max-queues"`
+			IpDefragMaxFrags    int      `yaml:"ipdefrag-max-fragments"`
+			IpDefragTimeout     int      `yaml:"ipdefrag-timeout"`
+			ChannelBufferSize   int      `yaml:"chan-buffer-size"`
 		} `yaml:"afpacket-sniffer"`
 		XdpLiveCapture struct {
 			Enable            bool   `yaml:"enable"`
 			Port              int    `yaml:"port"`
+			Ports             []int  `yaml:"ports,flow"`
 			Device            string `yaml:"device"`
+			StatsInterval     int    `yaml:"stats-interval"`
 			ChannelBufferSize int    `yaml:"chan-buffer-size"`
 		} `yaml:"xdp-sniffer"`
 		PowerDNS struct {
@@ -223,19 +260,125 @@ type Config struct {
 			ChannelBufferSize int    `yaml:"chan-buffer-size"`
 		} `yaml:"powerdns"`
 		FileIngestor struct {
-			Enable            bool   `yaml:"enable"`
-			WatchDir          string `yaml:"watch-dir"`
-			WatchMode         string `yaml:"watch-mode"`
-			PcapDnsPort       int    `yaml:"pcap-dns-port"`
-			DeleteAfter       bool   `yaml:"delete-after"`
-			ChannelBufferSize int    `yaml:"chan-buffer-size"`
+			Enable              bool     `yaml:"enable"`
+			WatchDir            string   `yaml:"watch-dir"`
+			WatchMode           string   `yaml:"watch-mode"`
+			PcapDnsPort         int      `yaml:"pcap-dns-port"`
+			PcapTlsPorts        []int    `yaml:"pcap-tls-ports,flow"`
+			DeleteAfter         bool     `yaml:"delete-after"`
+			Decapsulation       []string `yaml:"decapsulation,flow"`
+			TcpMaxConnections   int      `yaml:"tcp-max-connections"`
+			TcpMaxBufferedBytes int      `yaml:"tcp-max-buffered-bytes"`
+			TcpFlushInterval    int      `yaml:"tcp-flush-interval"`
+			TcpIdleTimeout      int      `yaml:"tcp-idle-timeout"`
+			IpDefragMaxQueues   int      `yaml:"ipdefrag-max-queues"`
+			IpDefragMaxFrags    int      `yaml:"ipdefrag-max-fragments"`
+			IpDefragTimeout     int      `yaml:"ipdefrag-timeout"`
+			ChannelBufferSize   int      `yaml:"chan-buffer-size"`
 		} `yaml:"file-ingestor"`
 		Tzsp struct {
+			Enable            bool              `yaml:"enable"`
+			ListenIp          string            `yaml:"listen-ip"`
+			ListenPort        int               `yaml:"listen-port"`
+			Decapsulation     []string          `yaml:"decapsulation,flow"`
+			SenderIdentity    bool              `yaml:"sender-identity"`
+			Identities        map[string]string `yaml:"identities"`
+			ChannelBufferSize int               `yaml:"chan-buffer-size"`
+		} `yaml:"tzsp"`
+		KafkaConsumer struct {
 			Enable            bool   `yaml:"enable"`
-			ListenIp          string `yaml:"listen-ip"`
+			RemoteAddress     string `yaml:"remote-address"`
+			RemotePort        int    `yaml:"remote-port"`
+			RetryInterval     int    `yaml:"retry-interval"`
+			TlsSupport        bool   `yaml:"tls-support"`
+			TlsInsecure       bool   `yaml:"tls-insecure"`
+			TlsMinVersion     string `yaml:"tls-min-version"`
+			CAFile            string `yaml:"ca-file"`
+			CertFile          string `yaml:"cert-file"`
+			KeyFile           string `yaml:"key-file"`
+			SaslSupport       bool   `yaml:"sasl-support"`
+			SaslUsername      string `yaml:"sasl-username"`
+			SaslPassword      string `yaml:"sasl-password"`
+			SaslMechanism     string `yaml:"sasl-mechanism"`
+			Mode              string `yaml:"mode"`
+			TextFormat        string `yaml:"text-format"`
+			ConnectTimeout    int    `yaml:"connect-timeout"`
+			Topic             string `yaml:"topic"`
+			Partition         int    `yaml:"partition"`
+			ConsumerGroup     string `yaml:"consumer-group"`
+			ChannelBufferSize int    `yaml:"chan-buffer-size"`
+		} `yaml:"kafkaconsumer"`
+		RedisSub struct {
+			Enable            bool     `yaml:"enable"`
+			RemoteAddress     string   `yaml:"remote-address"`
+			RemotePort        int      `yaml:"remote-port"`
+			RetryInterval     int      `yaml:"retry-interval"`
+			Transport         string   `yaml:"transport"`
+			TlsInsecure       bool     `yaml:"tls-insecure"`
+			TlsMinVersion     string   `yaml:"tls-min-version"`
+			CAFile            string   `yaml:"ca-file"`
+			CertFile          string   `yaml:"cert-file"`
+			KeyFile           string   `yaml:"key-file"`
+			Mode              string   `yaml:"mode"`
+			TextFormat        string   `yaml:"text-format"`
+			ConnectTimeout    int      `yaml:"connect-timeout"`
+			RedisChannels     []string `yaml:"redis-channels,flow"`
+			RedisPatterns     []string `yaml:"redis-patterns,flow"`
+			ChannelBufferSize int      `yaml:"chan-buffer-size"`
+		} `yaml:"redissub"`
+		HttpIngest struct {
+			Enable            bool   `yaml:"enable"`
+			ListenIP          string `yaml:"listen-ip"`
 			ListenPort        int    `yaml:"listen-port"`
+			BasicAuthLogin    string `yaml:"basic-auth-login"`
+			BasicAuthPwd      string `yaml:"basic-auth-pwd"`
+			TlsSupport        bool   `yaml:"tls-support"`
+			TlsMinVersion     string `yaml:"tls-min-version"`
+			CAFile            string `yaml:"ca-file"`
+			CertFile          string `yaml:"cert-file"`
+			KeyFile           string `yaml:"key-file"`
+			Mode              string `yaml:"mode"`
 			ChannelBufferSize int    `yaml:"chan-buffer-size"`
-		} `yaml:"tzsp"`
+			MaxBodySize       int    `yaml:"max-body-size"`
+		} `yaml:"http-ingest"`
+		TcpServer struct {
+			Enable            bool   `yaml:"enable"`
+			ListenIP          string `yaml:"listen-ip"`
+			ListenPort        int    `yaml:"listen-port"`
+			SockPath          string `yaml:"sock-path"`
+			TlsSupport        bool   `yaml:"tls-support"`
+			TlsMinVersion     string `yaml:"tls-min-version"`
+			CAFile            string `yaml:"ca-file"`
+			CertFile          string `yaml:"cert-file"`
+			KeyFile           string `yaml:"key-file"`
+			Mode              string `yaml:"mode"`
+			TextFormat        string `yaml:"text-format"`
+			PayloadDelimiter  string `yaml:"delimiter"`
+			ChannelBufferSize int    `yaml:"chan-buffer-size"`
+		} `yaml:"tcpserver"`
+		Fluentd struct {
+			Enable            bool   `yaml:"enable"`
+			ListenIP          string `yaml:"listen-ip"`
+			ListenPort        int    `yaml:"listen-port"`
+			TlsSupport        bool   `yaml:"tls-support"`
+			TlsMinVersion     string `yaml:"tls-min-version"`
+			CAFile            string `yaml:"ca-file"`
+			CertFile          string `yaml:"cert-file"`
+			KeyFile           string `yaml:"key-file"`
+			SharedKey         string `yaml:"shared-key"`
+			SelfHostname      string `yaml:"self-hostname"`
+			ChannelBufferSize int    `yaml:"chan-buffer-size"`
+		} `yaml:"fluentd"`
+		DnsProxy struct {
+			Enable            bool     `yaml:"enable"`
+			ListenIP          string   `yaml:"listen-ip"`
+			ListenPort        int      `yaml:"listen-port"`
+			Upstreams         []string `yaml:"upstreams,flow"`
+			Timeout           int      `yaml:"timeout"`
+			MaxQueries        int      `yaml:"max-concurrent-queries"`
+			IdleTimeout       int      `yaml:"idle-timeout"`
+			ChannelBufferSize int      `yaml:"chan-buffer-size"`
+		} `yaml:"dnsproxy"`
 	} `yaml:"collectors"`
 
 	IngoingTransformers ConfigTransformers `yaml:"collectors-transformers"`
@@ -533,6 +676,8 @@ func (c *Config) SetDefault() {
 
 	// Collectors
 	c.Collectors.Tail.Enable = false
+	c.Collectors.Tail.Mode = MODE_REGEX
+	c.Collectors.Tail.Preset = ""
 	c.Collectors.Tail.TimeLayout = ""
 	c.Collectors.Tail.PatternQuery = ""
 	c.Collectors.Tail.PatternReply = ""
@@ -549,6 +694,10 @@ func (c *Config) SetDefault() {
 	c.Collectors.Dnstap.RcvBufSize = 0
 	c.Collectors.Dnstap.ResetConn = true
 	c.Collectors.Dnstap.ChannelBufferSize = 65535
+	c.Collectors.Dnstap.Listeners = 1
+	c.Collectors.Dnstap.Workers = 1
+	c.Collectors.Dnstap.Ordered = true
+	c.Collectors.Dnstap.StatsInterval = 60
 
 	c.Collectors.DnstapProxifier.Enable = false
 	c.Collectors.DnstapProxifier.ListenIP = ANY_IP
@@ -560,12 +709,32 @@ func (c *Config) SetDefault() {
 	c.Collectors.DnstapProxifier.KeyFile = ""
 
 	c.Collectors.XdpLiveCapture.Enable = false
+	c.Collectors.XdpLiveCapture.Port = 53
+	c.Collectors.XdpLiveCapture.Ports = []int{}
 	c.Collectors.XdpLiveCapture.Device = ""
+	c.Collectors.XdpLiveCapture.StatsInterval = 60
 	c.Collectors.XdpLiveCapture.ChannelBufferSize = 65535
 
 	c.Collectors.AfpacketLiveCapture.Enable = false
 	c.Collectors.AfpacketLiveCapture.Port = 53
+	c.Collectors.AfpacketLiveCapture.Ports = []int{}
+	c.Collectors.AfpacketLiveCapture.TlsPorts = []int{}
+	c.Collectors.AfpacketLiveCapture.Filter = ""
 	c.Collectors.AfpacketLiveCapture.Device = ""
+	c.Collectors.AfpacketLiveCapture.Devices = []string{}
+	c.Collectors.AfpacketLiveCapture.FanoutSockets = 1
+	c.Collectors.AfpacketLiveCapture.TpacketV3 = false
+	c.Collectors.AfpacketLiveCapture.RingBlockSize = 1048576
+	c.Collectors.AfpacketLiveCapture.RingBlocks = 64
+	c.Collectors.AfpacketLiveCapture.StatsInterval = 60
+	c.Collectors.AfpacketLiveCapture.Decapsulation = []string{}
+	c.Collectors.AfpacketLiveCapture.TcpMaxConnections = 65536
+	c.Collectors.AfpacketLiveCapture.TcpMaxBufferedBytes = 131072
+	c.Collectors.AfpacketLiveCapture.TcpFlushInterval = 60
+	c.Collectors.AfpacketLiveCapture.TcpIdleTimeout = 120
+	c.Collectors.AfpacketLiveCapture.IpDefragMaxQueues = 8192
+	c.Collectors.AfpacketLiveCapture.IpDefragMaxFrags = 0
+	c.Collectors.AfpacketLiveCapture.IpDefragTimeout = 30
 	c.Collectors.AfpacketLiveCapture.ChannelBufferSize = 65535
 
 	c.Collectors.PowerDNS.Enable = false
@@ -583,15 +752,115 @@ func (c *Config) SetDefault() {
 	c.Collectors.FileIngestor.Enable = false
 	c.Collectors.FileIngestor.WatchDir = ""
 	c.Collectors.FileIngestor.PcapDnsPort = 53
+	c.Collectors.FileIngestor.PcapTlsPorts = []int{}
 	c.Collectors.FileIngestor.WatchMode = MODE_PCAP
 	c.Collectors.FileIngestor.DeleteAfter = false
+	c.Collectors.FileIngestor.Decapsulation = []string{"vlan"}
+	c.Collectors.FileIngestor.TcpMaxConnections = 65536
+	c.Collectors.FileIngestor.TcpMaxBufferedBytes = 131072
+	c.Collectors.FileIngestor.TcpFlushInterval = 60
+	c.Collectors.FileIngestor.TcpIdleTimeout = 120
+	c.Collectors.FileIngestor.IpDefragMaxQueues = 8192
+	c.Collectors.FileIngestor.IpDefragMaxFrags = 0
+	c.Collectors.FileIngestor.IpDefragTimeout = 30
 	c.Collectors.FileIngestor.ChannelBufferSize = 65535
 
 	c.Collectors.Tzsp.Enable = false
 	c.Collectors.Tzsp.ListenIp = ANY_IP
 	c.Collectors.Tzsp.ListenPort = 10000
+	c.Collectors.Tzsp.Decapsulation = []string{}
+	c.Collectors.Tzsp.SenderIdentity = false
+	c.Collectors.Tzsp.Identities = map[string]string{}
 	c.Collectors.Tzsp.ChannelBufferSize = 65535
 
+	c.Collectors.KafkaConsumer.Enable = false
+	c.Collectors.KafkaConsumer.RemoteAddress = LOCALHOST_IP
+	c.Collectors.KafkaConsumer.RemotePort = 9092
+	c.Collectors.KafkaConsumer.RetryInterval = 10
+	c.Collectors.KafkaConsumer.TlsSupport = false
+	c.Collectors.KafkaConsumer.TlsInsecure = false
+	c.Collectors.KafkaConsumer.TlsMinVersion = TLS_v12
+	c.Collectors.KafkaConsumer.CAFile = ""
+	c.Collectors.KafkaConsumer.CertFile = ""
+	c.Collectors.KafkaConsumer.KeyFile = ""
+	c.Collectors.KafkaConsumer.SaslSupport = false
+	c.Collectors.KafkaConsumer.SaslUsername = ""
+	c.Collectors.KafkaConsumer.SaslPassword = ""
+	c.Collectors.KafkaConsumer.SaslMechanism = SASL_MECHANISM_PLAIN
+	c.Collectors.KafkaConsumer.Mode = MODE_FLATJSON
+	c.Collectors.KafkaConsumer.TextFormat = ""
+	c.Collectors.KafkaConsumer.ConnectTimeout = 5
+	c.Collectors.KafkaConsumer.Topic = "dnscollector"
+	c.Collectors.KafkaConsumer.Partition = 0
+	c.Collectors.KafkaConsumer.ConsumerGroup = "dnscollector"
+	c.Collectors.KafkaConsumer.ChannelBufferSize = 65535
+
+	c.Collectors.RedisSub.Enable = false
+	c.Collectors.RedisSub.RemoteAddress = LOCALHOST_IP
+	c.Collectors.RedisSub.RemotePort = 6379
+	c.Collectors.RedisSub.RetryInterval = 10
+	c.Collectors.RedisSub.Transport = SOCKET_TCP
+	c.Collectors.RedisSub.TlsInsecure = false
+	c.Collectors.RedisSub.TlsMinVersion = TLS_v12
+	c.Collectors.RedisSub.CAFile = ""
+	c.Collectors.RedisSub.CertFile = ""
+	c.Collectors.RedisSub.KeyFile = ""
+	c.Collectors.RedisSub.Mode = MODE_FLATJSON
+	c.Collectors.RedisSub.TextFormat = ""
+	c.Collectors.RedisSub.ConnectTimeout = 5
+	c.Collectors.RedisSub.RedisChannels = []string{"dns_collector"}
+	c.Collectors.RedisSub.RedisPatterns = []string{}
+	c.Collectors.RedisSub.ChannelBufferSize = 65535
+
+	c.Collectors.HttpIngest.Enable = false
+	c.Collectors.HttpIngest.ListenIP = LOCALHOST_IP
+	c.Collectors.HttpIngest.ListenPort = 8081
+	c.Collectors.HttpIngest.BasicAuthLogin = "admin"
+	c.Collectors.HttpIngest.BasicAuthPwd = "changeme"
+	c.Collectors.HttpIngest.TlsSupport = false
+	c.Collectors.HttpIngest.TlsMinVersion = TLS_v12
+	c.Collectors.HttpIngest.CAFile = ""
+	c.Collectors.HttpIngest.CertFile = ""
+	c.Collectors.HttpIngest.KeyFile = ""
+	c.Collectors.HttpIngest.Mode = MODE_JSON
+	c.Collectors.HttpIngest.ChannelBufferSize = 65535
+	c.Collectors.HttpIngest.MaxBodySize = 10485760
+
+	c.Collectors.TcpServer.Enable = false
+	c.Collectors.TcpServer.ListenIP = ANY_IP
+	c.Collectors.TcpServer.ListenPort = 9999
+	c.Collectors.TcpServer.SockPath = ""
+	c.Collectors.TcpServer.TlsSupport = false
+	c.Collectors.TcpServer.TlsMinVersion = TLS_v12
+	c.Collectors.TcpServer.CAFile = ""
+	c.Collectors.TcpServer.CertFile = ""
+	c.Collectors.TcpServer.KeyFile = ""
+	c.Collectors.TcpServer.Mode = MODE_FLATJSON
+	c.Collectors.TcpServer.TextFormat = ""
+	c.Collectors.TcpServer.PayloadDelimiter = "\n"
+	c.Collectors.TcpServer.ChannelBufferSize = 65535
+
+	c.Collectors.Fluentd.Enable = false
+	c.Collectors.Fluentd.ListenIP = ANY_IP
+	c.Collectors.Fluentd.ListenPort = 24224
+	c.Collectors.Fluentd.TlsSupport = false
+	c.Collectors.Fluentd.TlsMinVersion = TLS_v12
+	c.Collectors.Fluentd.CAFile = ""
+	c.Collectors.Fluentd.CertFile = ""
+	c.Collectors.Fluentd.KeyFile = ""
+	c.Collectors.Fluentd.SharedKey = ""
+	c.Collectors.Fluentd.SelfHostname = PROG_NAME
+	c.Collectors.Fluentd.ChannelBufferSize = 65535
+
+	c.Collectors.DnsProxy.Enable = false
+	c.Collectors.DnsProxy.ListenIP = LOCALHOST_IP
+	c.Collectors.DnsProxy.ListenPort = 53
+	c.Collectors.DnsProxy.Upstreams = []string{}
+	c.Collectors.DnsProxy.Timeout = 5
+	c.Collectors.DnsProxy.MaxQueries = 1000
+	c.Collectors.DnsProxy.IdleTimeout = 10
+	c.Collectors.DnsProxy.ChannelBufferSize = 65535
+
 	// Transformers for collectors
 	c.IngoingTransformers.SetDefault()
 
@@ -23,6 +23,11 @@ const (
 	MODE_FLATJSON = "flat-json"
 	MODE_PCAP     = "pcap"
 	MODE_DNSTAP   = "dnstap"
+	MODE_MSGPACK  = "msgpack"
+	MODE_ZEEK     = "zeek"
+
+	MODE_REGEX       = "regex"
+	MODE_WINDOWS_DNS = "windows-dns"
 
 	SASL_MECHANISM_PLAIN = "PLAIN"
 	SASL_MECHANISM_SCRAM = "SCRAM-SHA-512"
@@ -37,6 +42,9 @@ const (
 	DNSTAP_CLIENT_RESPONSE = "CLIENT_RESPONSE"
 	DNSTAP_CLIENT_QUERY    = "CLIENT_QUERY"
 
+	DNSTAP_TLS_HANDSHAKE = "TLS_HANDSHAKE"
+	DNSTAP_TLS_CLOSE     = "TLS_CLOSE"
+
 	DNSTAP_IDENTITY_TEST = "test_id"
 
 	PROTO_INET  = "INET"
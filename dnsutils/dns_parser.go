@@ -2,6 +2,7 @@ package dnsutils
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
@@ -61,7 +62,7 @@ var (
 		49:    "DHCID",
 		50:    "NSEC3",
 		51:    "NSEC3PARAM",
-		52:    "TSLA",
+		52:    "TLSA",
 		53:    "SMIMEA",
 		55:    "HIP",
 		56:    "NINFO",
@@ -89,6 +90,14 @@ var (
 		32768: "TA",
 		32769: "DLV",
 	}
+	Opcodes = map[int]string{
+		0: "QUERY",
+		1: "IQUERY",
+		2: "STATUS",
+		4: "NOTIFY",
+		5: "UPDATE",
+		6: "DSO",
+	}
 	Rcodes = map[int]string{
 		0:  "NOERROR",
 		1:  "FORMERR",
@@ -130,6 +139,13 @@ func RdatatypeToString(rrtype int) string {
 	return UNKNOWN
 }
 
+func OpcodeToString(opcode int) string {
+	if value, ok := Opcodes[opcode]; ok {
+		return value
+	}
+	return UNKNOWN
+}
+
 func RcodeToString(rcode int) string {
 	if value, ok := Rcodes[rcode]; ok {
 		return value
@@ -237,12 +253,19 @@ func DecodePayload(dm *DnsMessage, header *DnsHeader, config *Config) error {
 	dm.DNS.Id = header.Id
 	dm.DNS.Rcode = RcodeToString(header.Rcode)
 	dm.DNS.Opcode = header.Opcode
+	dm.DNS.OpcodeName = OpcodeToString(header.Opcode)
+	dm.DNS.SectionCounts = DnsSectionCounts{
+		Question:   header.Qdcount,
+		Answer:     header.Ancount,
+		Authority:  header.Nscount,
+		Additional: header.Arcount,
+	}
 
 	// update dnstap operation if the opcode is equal to 5 (dns update)
-	if dm.DNS.Opcode == 5 && header.Qr == 1 {
+	if dm.DNS.Opcode == OpcodeUpdate && header.Qr == 0 {
 		dm.DnsTap.Operation = "UPDATE_QUERY"
 	}
-	if dm.DNS.Opcode == 5 && header.Qr == 0 {
+	if dm.DNS.Opcode == OpcodeUpdate && header.Qr == 1 {
 		dm.DnsTap.Operation = "UPDATE_RESPONSE"
 	}
 
@@ -261,8 +284,19 @@ func DecodePayload(dm *DnsMessage, header *DnsHeader, config *Config) error {
 	if header.Ad == 1 {
 		dm.DNS.Flags.AD = true
 	}
+	if header.Rd == 1 {
+		dm.DNS.Flags.RD = true
+	}
+	if header.Cd == 1 {
+		dm.DNS.Flags.CD = true
+	}
+	if header.Z == 1 {
+		dm.DNS.Flags.Z = true
+	}
 
-	var payload_offset int
+	// the records follow the header when there is no question, as in the
+	// following messages of the zone transfers
+	payload_offset := DnsLen
 	// decode DNS question
 	if header.Qdcount > 0 {
 		dns_qname, dns_rrtype, offsetrr, err := DecodeQuestion(header.Qdcount, dm.DNS.Payload)
@@ -334,6 +368,9 @@ func DecodePayload(dm *DnsMessage, header *DnsHeader, config *Config) error {
 			return &decodingError{part: "edns options", err: err}
 		}
 	}
+
+	// zone of the dynamic updates, notifies and zone transfers
+	dm.DNS.Zone = DecodeZone(dm)
 	return nil
 }
 
@@ -441,11 +478,15 @@ func DecodeAnswer(ancount int, start_offset int, payload []byte) ([]DnsAnswer, i
 			offset = offset_next + 10 + int(rdlength)
 			continue
 		}
-		// parse rdata
+		// parse rdata, the prerequisites and deletes of the dynamic updates
+		// are without rdata and with the class ANY or NONE (RFC 2136)
 		rdatatype := RdatatypeToString(int(t))
-		parsed, err := ParseRdata(rdatatype, rdata, payload[:offset_next+10+int(rdlength)], offset_next+10)
-		if err != nil {
-			return answers, offset, err
+		parsed := "-"
+		if rdlength > 0 || (class != ClassAny && class != ClassNone) {
+			parsed, err = ParseRdata(rdatatype, rdata, payload[:offset_next+10+int(rdlength)], offset_next+10)
+			if err != nil {
+				return answers, offset, err
+			}
 		}
 
 		// finnally append answer to the list
@@ -559,8 +600,38 @@ func ParseRdata(rdatatype string, rdata []byte, payload []byte, rdata_offset int
 		ret, err = ParseSOA(rdata_offset, payload)
 	case "HTTPS", "SVCB":
 		ret, err = ParseSVCB(rdata)
+	case "DNSKEY", "CDNSKEY":
+		ret, err = ParseDNSKEY(rdata)
+	case "DS", "CDS":
+		ret, err = ParseDS(rdata)
+	case "RRSIG":
+		ret, err = ParseRRSIG(rdata)
+	case "NSEC":
+		ret, err = ParseNSEC(rdata)
+	case "NSEC3":
+		ret, err = ParseNSEC3(rdata)
+	case "NSEC3PARAM":
+		ret, err = ParseNSEC3PARAM(rdata)
+	case "CAA":
+		ret, err = ParseCAA(rdata)
+	case "TLSA", "SMIMEA":
+		ret, err = ParseTLSA(rdata)
+	case "SSHFP":
+		ret, err = ParseSSHFP(rdata)
+	case "NAPTR":
+		ret, err = ParseNAPTR(rdata_offset, payload)
+	case "DNAME":
+		ret, err = ParseDNAME(rdata_offset, payload)
+	case "LOC":
+		ret, err = ParseLOC(rdata)
+	case "URI":
+		ret, err = ParseURI(rdata)
+	case "HINFO":
+		ret, err = ParseHINFO(rdata)
+	case "RP":
+		ret, err = ParseRP(rdata_offset, payload)
 	default:
-		ret = "-"
+		ret = ParseGeneric(rdata)
 		err = nil
 	}
 	return ret, err
@@ -930,6 +1001,315 @@ func ParseSVCParam(svcParamKey uint16, paramData []byte) (string, error) {
 	}
 }
 
+/*
+CAA
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|     FLAGS     |  TAG LENGTH   |     TAG       /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    VALUE                      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseCAA(rdata []byte) (string, error) {
+	if len(rdata) < 2 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	flags := rdata[0]
+	tagLength := int(rdata[1])
+	if len(rdata) < 2+tagLength {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	tag := string(rdata[2 : 2+tagLength])
+	value := quoteCharacterString(rdata[2+tagLength:])
+
+	caa := fmt.Sprintf("%d %s %s", flags, tag, value)
+	return caa, nil
+}
+
+/*
+TLSA, SMIMEA
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  CERT. USAGE  |   SELECTOR    | MATCHING TYPE |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/       CERTIFICATE ASSOCIATION DATA            /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseTLSA(rdata []byte) (string, error) {
+	if len(rdata) < 4 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	usage := rdata[0]
+	selector := rdata[1]
+	matchingType := rdata[2]
+	data := strings.ToUpper(hex.EncodeToString(rdata[3:]))
+
+	tlsa := fmt.Sprintf("%d %d %d %s", usage, selector, matchingType, data)
+	return tlsa, nil
+}
+
+/*
+SSHFP
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|   ALGORITHM   |    FP TYPE    |               /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                  FINGERPRINT                  /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseSSHFP(rdata []byte) (string, error) {
+	if len(rdata) < 3 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	algorithm := rdata[0]
+	fpType := rdata[1]
+	fingerprint := strings.ToUpper(hex.EncodeToString(rdata[2:]))
+
+	sshfp := fmt.Sprintf("%d %d %s", algorithm, fpType, fingerprint)
+	return sshfp, nil
+}
+
+/*
+NAPTR
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                     ORDER                     |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   PREFERENCE                  |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                     FLAGS                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                   SERVICES                    /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    REGEXP                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                  REPLACEMENT                  /
+/                                               /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNAPTR(rdata_offset int, payload []byte) (string, error) {
+	if len(payload) < rdata_offset+4 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	order := binary.BigEndian.Uint16(payload[rdata_offset : rdata_offset+2])
+	preference := binary.BigEndian.Uint16(payload[rdata_offset+2 : rdata_offset+4])
+
+	// flags, services and regexp
+	offset := rdata_offset + 4
+	var strs []string
+	for i := 0; i < 3; i++ {
+		str, next, err := parseCharacterString(offset, payload)
+		if err != nil {
+			return "", err
+		}
+		strs = append(strs, str)
+		offset = next
+	}
+
+	replacement, _, err := ParseLabels(offset, payload)
+	if err != nil {
+		return "", err
+	}
+	if replacement == "" {
+		replacement = "."
+	}
+
+	naptr := fmt.Sprintf("%d %d %s %s", order, preference, strings.Join(strs, " "), replacement)
+	return naptr, nil
+}
+
+/*
+DNAME
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    TARGET                     /
+/                                               /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseDNAME(rdata_offset int, payload []byte) (string, error) {
+	dname, _, err := ParseLabels(rdata_offset, payload)
+	if err != nil {
+		return "", err
+	}
+	return dname, err
+}
+
+/*
+LOC
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|        VERSION        |         SIZE          |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|       HORIZ PRE       |       VERT PRE        |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   LATITUDE                    |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   LONGITUDE                   |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   ALTITUDE                    |
+|                                               |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseLOC(rdata []byte) (string, error) {
+	if len(rdata) < 16 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	// only the version 0 is defined, RFC 1876
+	if rdata[0] != 0 {
+		return ParseGeneric(rdata), nil
+	}
+	size := rdata[1]
+	horizPre := rdata[2]
+	vertPre := rdata[3]
+	latitude := binary.BigEndian.Uint32(rdata[4:8])
+	longitude := binary.BigEndian.Uint32(rdata[8:12])
+	altitude := binary.BigEndian.Uint32(rdata[12:16])
+
+	loc := fmt.Sprintf("%s %s %.2fm %sm %sm %sm",
+		locCoordinate(latitude, "N", "S"), locCoordinate(longitude, "E", "W"),
+		float64(altitude)/100-100000,
+		locPrecision(size), locPrecision(horizPre), locPrecision(vertPre))
+	return loc, nil
+}
+
+// locCoordinate returns the degrees, minutes and seconds of a latitude or longitude
+// in thousandths of a second of arc, 2^31 is the equator or the prime meridian
+func locCoordinate(value uint32, positive string, negative string) string {
+	hemisphere := positive
+	var arc uint32
+	if value >= 1<<31 {
+		arc = value - 1<<31
+	} else {
+		hemisphere = negative
+		arc = 1<<31 - value
+	}
+	degrees := arc / 3600000
+	arc %= 3600000
+	minutes := arc / 60000
+	arc %= 60000
+	return fmt.Sprintf("%d %d %.3f %s", degrees, minutes, float64(arc)/1000, hemisphere)
+}
+
+// locPrecision returns in meters a size or a precision encoded
+// in centimeters with a mantissa and a power of ten
+func locPrecision(value byte) string {
+	mantissa := int(value >> 4)
+	exponent := int(value & 0x0f)
+	if exponent < 2 {
+		if exponent == 1 {
+			mantissa *= 10
+		}
+		return fmt.Sprintf("0.%02d", mantissa)
+	}
+	return fmt.Sprintf("%d", mantissa) + strings.Repeat("0", exponent-2)
+}
+
+/*
+URI
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                   PRIORITY                    |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                    WEIGHT                     |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                    TARGET                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseURI(rdata []byte) (string, error) {
+	if len(rdata) < 4 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	priority := binary.BigEndian.Uint16(rdata[0:2])
+	weight := binary.BigEndian.Uint16(rdata[2:4])
+	target := quoteCharacterString(rdata[4:])
+
+	uri := fmt.Sprintf("%d %d %s", priority, weight, target)
+	return uri, nil
+}
+
+/*
+HINFO
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                      CPU                      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                       OS                      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseHINFO(rdata []byte) (string, error) {
+	cpu, offset, err := parseCharacterString(0, rdata)
+	if err != nil {
+		return "", err
+	}
+	os, _, err := parseCharacterString(offset, rdata)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s", cpu, os), nil
+}
+
+/*
+RP
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                   MBOX-DNAME                  /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                   TXT-DNAME                   /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseRP(rdata_offset int, payload []byte) (string, error) {
+	mbox, offset, err := ParseLabels(rdata_offset, payload)
+	if err != nil {
+		return "", err
+	}
+	txt, _, err := ParseLabels(offset, payload)
+	if err != nil {
+		return "", err
+	}
+	if mbox == "" {
+		mbox = "."
+	}
+	if txt == "" {
+		txt = "."
+	}
+	return fmt.Sprintf("%s %s", mbox, txt), nil
+}
+
+// ParseGeneric returns the generic encoding of the rdata of the unsupported types, RFC 3597
+func ParseGeneric(rdata []byte) string {
+	if len(rdata) == 0 {
+		return "\\# 0"
+	}
+	return fmt.Sprintf("\\# %d %s", len(rdata), strings.ToUpper(hex.EncodeToString(rdata)))
+}
+
+// parseCharacterString returns the quoted character string at the offset
+// and the offset of the next field
+func parseCharacterString(offset int, data []byte) (string, int, error) {
+	if len(data) < offset+1 {
+		return "", 0, ErrDecodeDnsAnswerRdataTooShort
+	}
+	length := int(data[offset])
+	if len(data) < offset+1+length {
+		return "", 0, ErrDecodeDnsAnswerRdataTooShort
+	}
+	return quoteCharacterString(data[offset+1 : offset+1+length]), offset + 1 + length, nil
+}
+
+// quoteCharacterString returns the string between quotes,
+// with the quotes, backslashes and non printable bytes escaped
+func quoteCharacterString(s []byte) string {
+	var str strings.Builder
+	str.Grow(len(s) + 2)
+	str.WriteByte('"')
+	for _, e := range s {
+		switch {
+		case e == '"' || e == '\\':
+			str.WriteByte('\\')
+			str.WriteByte(e)
+		case ' ' <= e && e <= '~':
+			str.WriteByte(e)
+		default:
+			str.WriteString(escapeByte(e))
+		}
+	}
+	str.WriteByte('"')
+	return str.String()
+}
+
 // These functions and consts have been taken from miekg/dns
 const (
 	escapedByteSmall = "" +
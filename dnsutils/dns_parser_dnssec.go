@@ -0,0 +1,279 @@
+package dnsutils
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// time format of the signature validity window of the RRSIG records
+const rrsigTimeLayout = "20060102150405"
+
+// base32 encoding with extended hex alphabet of the hashed owner names of NSEC3
+var nsec3Encoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+/*
+DNSKEY, CDNSKEY
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|              FLAGS                            |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|       PROTOCOL        |       ALGORITHM       |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/                  PUBLIC KEY                   /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+
+The key tag of the key is added as a comment, as dig does
+*/
+func ParseDNSKEY(rdata []byte) (string, error) {
+	if len(rdata) < 5 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	flags := binary.BigEndian.Uint16(rdata[0:2])
+	protocol := rdata[2]
+	algorithm := rdata[3]
+	publicKey := base64.StdEncoding.EncodeToString(rdata[4:])
+
+	dnskey := fmt.Sprintf("%d %d %d %s ; key id = %d", flags, protocol, algorithm, publicKey, KeyTag(rdata))
+	return dnskey, nil
+}
+
+// KeyTag computes the key tag of the rdata of a DNSKEY record, RFC 4034 appendix B
+func KeyTag(rdata []byte) uint16 {
+	// RSA/MD5, the key tag is the most significant 16 bits of the least significant 24 bits of the modulus
+	if len(rdata) > 4 && rdata[3] == 1 {
+		if len(rdata) < 7 {
+			return 0
+		}
+		return binary.BigEndian.Uint16(rdata[len(rdata)-3 : len(rdata)-1])
+	}
+
+	var ac uint32
+	for i, b := range rdata {
+		if i&1 == 1 {
+			ac += uint32(b)
+		} else {
+			ac += uint32(b) << 8
+		}
+	}
+	ac += ac >> 16 & 0xffff
+	return uint16(ac & 0xffff)
+}
+
+/*
+DS, CDS
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|           KEY TAG             |   ALGORITHM   |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  DIGEST TYPE  |                               /
++--+--+--+--+--+--+--+--+                       /
+/                    DIGEST                     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseDS(rdata []byte) (string, error) {
+	if len(rdata) < 5 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	keyTag := binary.BigEndian.Uint16(rdata[0:2])
+	algorithm := rdata[2]
+	digestType := rdata[3]
+	digest := strings.ToUpper(hex.EncodeToString(rdata[4:]))
+
+	ds := fmt.Sprintf("%d %d %d %s", keyTag, algorithm, digestType, digest)
+	return ds, nil
+}
+
+/*
+RRSIG
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|        TYPE COVERED           |  ALGORITHM    |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|    LABELS     |         ORIGINAL TTL          |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|                               |   SIGNATURE   |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|             EXPIRATION                        |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|             INCEPTION         |   KEY TAG     |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|               |                               /
++--+--+--+--+--+--+--+--+                       /
+/                SIGNER'S NAME                  /
+/                  SIGNATURE                    /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseRRSIG(rdata []byte) (string, error) {
+	// fixed fields and at least the root label of the signer
+	if len(rdata) < 19 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	typeCovered := rrtypeToString(binary.BigEndian.Uint16(rdata[0:2]))
+	algorithm := rdata[2]
+	labels := rdata[3]
+	originalTtl := binary.BigEndian.Uint32(rdata[4:8])
+	expiration := binary.BigEndian.Uint32(rdata[8:12])
+	inception := binary.BigEndian.Uint32(rdata[12:16])
+	keyTag := binary.BigEndian.Uint16(rdata[16:18])
+
+	// the signer name is not compressed
+	signer, offset, err := ParseLabels(18, rdata)
+	if err != nil {
+		return "", err
+	}
+	if signer == "" {
+		signer = "."
+	}
+	signature := base64.StdEncoding.EncodeToString(rdata[offset:])
+
+	rrsig := fmt.Sprintf("%s %d %d %d %s %s %d %s %s", typeCovered, algorithm, labels, originalTtl,
+		rrsigTime(expiration), rrsigTime(inception), keyTag, signer, signature)
+	return rrsig, nil
+}
+
+// rrsigTime returns the signature expiration or inception in the YYYYMMDDHHmmSS format
+func rrsigTime(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format(rrsigTimeLayout)
+}
+
+/*
+NSEC
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/              NEXT DOMAIN NAME                 /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/              TYPE BIT MAPS                    /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNSEC(rdata []byte) (string, error) {
+	// the next domain name is not compressed
+	next, offset, err := ParseLabels(0, rdata)
+	if err != nil {
+		return "", err
+	}
+	if next == "" {
+		next = "."
+	}
+	types, err := ParseTypeBitMaps(rdata[offset:])
+	if err != nil {
+		return "", err
+	}
+	if len(types) == 0 {
+		return next, nil
+	}
+	return fmt.Sprintf("%s %s", next, strings.Join(types, " ")), nil
+}
+
+/*
+NSEC3
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|   HASH ALG    |     FLAGS     |  ITERATIONS   |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|               |  SALT LENGTH  |     SALT      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|  HASH LENGTH  |      NEXT HASHED OWNER NAME   /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+/              TYPE BIT MAPS                    /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNSEC3(rdata []byte) (string, error) {
+	params, offset, err := parseNSEC3Params(rdata)
+	if err != nil {
+		return "", err
+	}
+
+	// next hashed owner name
+	if len(rdata) < offset+1 {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	hashLength := int(rdata[offset])
+	offset++
+	if len(rdata) < offset+hashLength {
+		return "", ErrDecodeDnsAnswerRdataTooShort
+	}
+	next := nsec3Encoding.EncodeToString(rdata[offset : offset+hashLength])
+	offset += hashLength
+
+	types, err := ParseTypeBitMaps(rdata[offset:])
+	if err != nil {
+		return "", err
+	}
+	if len(types) == 0 {
+		return fmt.Sprintf("%s %s", params, next), nil
+	}
+	return fmt.Sprintf("%s %s %s", params, next, strings.Join(types, " ")), nil
+}
+
+/*
+NSEC3PARAM
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|   HASH ALG    |     FLAGS     |  ITERATIONS   |
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+|               |  SALT LENGTH  |     SALT      /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseNSEC3PARAM(rdata []byte) (string, error) {
+	params, _, err := parseNSEC3Params(rdata)
+	return params, err
+}
+
+// parseNSEC3Params decodes the hash algorithm, flags, iterations and salt
+// of the NSEC3 and NSEC3PARAM records, the offset of the next field is returned
+func parseNSEC3Params(rdata []byte) (string, int, error) {
+	if len(rdata) < 5 {
+		return "", 0, ErrDecodeDnsAnswerRdataTooShort
+	}
+	hashAlgorithm := rdata[0]
+	flags := rdata[1]
+	iterations := binary.BigEndian.Uint16(rdata[2:4])
+	saltLength := int(rdata[4])
+	if len(rdata) < 5+saltLength {
+		return "", 0, ErrDecodeDnsAnswerRdataTooShort
+	}
+	salt := "-"
+	if saltLength > 0 {
+		salt = strings.ToUpper(hex.EncodeToString(rdata[5 : 5+saltLength]))
+	}
+	params := fmt.Sprintf("%d %d %d %s", hashAlgorithm, flags, iterations, salt)
+	return params, 5 + saltLength, nil
+}
+
+/*
+Type bit maps of NSEC and NSEC3
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+| WINDOW BLOCK  |  BITMAP LEN   |    BITMAP     /
++--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+*/
+func ParseTypeBitMaps(rdata []byte) ([]string, error) {
+	types := []string{}
+	offset := 0
+	for offset < len(rdata) {
+		if len(rdata) < offset+2 {
+			return nil, ErrDecodeDnsAnswerRdataTooShort
+		}
+		window := int(rdata[offset])
+		length := int(rdata[offset+1])
+		offset += 2
+		if length == 0 || length > 32 || len(rdata) < offset+length {
+			return nil, ErrDecodeDnsAnswerRdataTooShort
+		}
+		for i, b := range rdata[offset : offset+length] {
+			for bit := 0; bit < 8; bit++ {
+				if b&(0x80>>bit) != 0 {
+					types = append(types, rrtypeToString(uint16(window*256+i*8+bit)))
+				}
+			}
+		}
+		offset += length
+	}
+	return types, nil
+}
+
+// rrtypeToString returns the mnemonic of the type or its generic form TYPExxx, RFC 3597
+func rrtypeToString(rrtype uint16) string {
+	if value, ok := Rdatatypes[int(rrtype)]; ok {
+		return value
+	}
+	return fmt.Sprintf("TYPE%d", rrtype)
+}
@@ -0,0 +1,92 @@
+package dnsutils
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDecodeRdataDNSSEC(t *testing.T) {
+	fqdn := TEST_QNAME
+
+	testcases := []struct {
+		rrtype string
+		rdata  string
+	}{
+		// root zone KSK
+		{"DNSKEY", "257 3 8 AwEAAaz/tAm8yTn4Mfeh5eyI96WSVexTBAvkMgJzkKTOiW1vkIbzxeF3+/4RgWOq7HrxRixHlFlExOLAJr5emLvN7SWXgnLh4+B5xQlNVz8Og8kvArMtNROxVQuCaSnIDdD5LKyWbRd2n9WGe2R8PzgCmr3EgVLrjyBxWezF0jLHwVN8efS3rCj/EWgvIWgb9tarpVUDK/b58Da+sqqls3eNbuv7pr+eoZG+SrDK6nWeL3c6H5Apxz7LjVc1uTIdsIXxuOLYA4/ilBmSVIzuDWfdRUfhHdY6+cn8HFRm+2hM8AnXGXws9555KrUB5qihylGa8subX2Nn6UwNR1AkUTV74bU= ; key id = 20326"},
+		{"CDNSKEY", "257 3 13 mdsswUyr3DPW132mOi8V9xESWE8jTo0dxCjjnopKl+GqJxpVXckHAeF+KkxLbxILfDLUT0rAK9iUzy1L53eKGQ== ; key id = 2371"},
+		{"DS", "20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"},
+		{"CDS", "2371 13 2 C988EC423E3880EB8DD8A46E4E8C2A9B0D1A2E0D8EE2B6A6A33E4D6B1E1F8A4B"},
+		{"RRSIG", "A 13 2 300 20231107120000 20231017120000 2371 example.com rTfNMZuL8XRsmSk4hvJy0iUG3l8yA1h7XkbRAzTbZlGOo+wvgU3t2GxiAfpw3K1T+Xgn9WqfXcNPA9wRhYs4Hg=="},
+		{"NSEC", "host.example.com A MX RRSIG NSEC TYPE1234"},
+		{"NSEC", ". NS SOA RRSIG NSEC DNSKEY"},
+		{"NSEC3", "1 1 12 AABBCCDD 2T7B4G4VSA5SMI47K61MV5BV1A22BOJR A RRSIG"},
+		{"NSEC3", "1 0 0 - 2VPTU5TIMAMQTTGL4LUU9KG21E0AOR3S"},
+		{"NSEC3PARAM", "1 0 10 AABBCCDD"},
+		{"NSEC3PARAM", "1 0 0 -"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.rrtype, func(t *testing.T) {
+			dm := new(dns.Msg)
+			dm.SetQuestion(fqdn, dns.TypeA)
+
+			rr, err := dns.NewRR(fmt.Sprintf("%s %s %s", fqdn, tc.rrtype, tc.rdata))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dm.Answer = append(dm.Answer, rr)
+			payload, _ := dm.Pack()
+
+			_, _, offset_rr, _ := DecodeQuestion(1, payload)
+			answer, _, err := DecodeAnswer(len(dm.Answer), offset_rr, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if answer[0].Rdatatype != tc.rrtype || answer[0].Rdata != tc.rdata {
+				t.Errorf("invalid decode for rdata %s, want %s, got: %s %s", tc.rrtype, tc.rdata, answer[0].Rdatatype, answer[0].Rdata)
+			}
+		})
+	}
+}
+
+func TestDecodeRdataDNSSEC_Short(t *testing.T) {
+	testcases := []struct {
+		rrtype string
+		rdata  []byte
+	}{
+		{"DNSKEY", []byte{1, 1, 3, 8}},
+		{"DS", []byte{0x4f, 0x66, 8, 2}},
+		{"RRSIG", []byte{0, 1, 13, 2, 0, 0, 1, 44}},
+		{"NSEC", []byte{4, 'h', 'o', 's', 't', 0, 0, 2}},
+		{"NSEC", []byte{4, 'h', 'o', 's', 't', 0, 0, 33, 0xff}},
+		{"NSEC3", []byte{1, 0, 0, 0, 4, 0xaa}},
+		{"NSEC3", []byte{1, 0, 0, 0, 0, 20, 0xaa}},
+		{"NSEC3PARAM", []byte{1, 0, 0, 0}},
+	}
+
+	for _, tc := range testcases {
+		if _, err := ParseRdata(tc.rrtype, tc.rdata, nil, 0); !errors.Is(err, ErrDecodeDnsAnswerRdataTooShort) {
+			t.Errorf("%s %v: want rdata too short error, got %v", tc.rrtype, tc.rdata, err)
+		}
+	}
+}
+
+func TestKeyTag(t *testing.T) {
+	rr, err := dns.NewRR(". DNSKEY 257 3 8 AwEAAaz/tAm8yTn4Mfeh5eyI96WSVexTBAvkMgJzkKTOiW1vkIbzxeF3+/4RgWOq7HrxRixHlFlExOLAJr5emLvN7SWXgnLh4+B5xQlNVz8Og8kvArMtNROxVQuCaSnIDdD5LKyWbRd2n9WGe2R8PzgCmr3EgVLrjyBxWezF0jLHwVN8efS3rCj/EWgvIWgb9tarpVUDK/b58Da+sqqls3eNbuv7pr+eoZG+SrDK6nWeL3c6H5Apxz7LjVc1uTIdsIXxuOLYA4/ilBmSVIzuDWfdRUfhHdY6+cn8HFRm+2hM8AnXGXws9555KrUB5qihylGa8subX2Nn6UwNR1AkUTV74bU=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, dns.Len(rr))
+	off, err := dns.PackRR(rr, buf, 0, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdata := buf[off-int(rr.Header().Rdlength) : off]
+	if tag := KeyTag(rdata); tag != rr.(*dns.DNSKEY).KeyTag() || tag != 20326 {
+		t.Errorf("want key tag 20326, got %d", tag)
+	}
+}
@@ -1369,9 +1369,16 @@ func TestDecodePayload_QueryHappy(t *testing.T) {
 		dm.DNS.Flags.TC ||
 		dm.DNS.Flags.AA ||
 		!dm.DNS.Flags.AD ||
-		dm.DNS.Flags.RA {
+		dm.DNS.Flags.RA ||
+		!dm.DNS.Flags.RD ||
+		dm.DNS.Flags.CD ||
+		dm.DNS.Flags.Z ||
+		dm.DNS.OpcodeName != "QUERY" {
 		t.Error("Invalid DNS header data in message")
 	}
+	if dm.DNS.SectionCounts != (DnsSectionCounts{Question: 1, Additional: 1}) {
+		t.Errorf("Invalid section counts: %+v", dm.DNS.SectionCounts)
+	}
 
 	if dm.DNS.Qname != "sensorfleet.com" {
 		t.Errorf("Unexpected query name: %s", dm.DNS.Qname)
@@ -1395,6 +1402,43 @@ func TestDecodePayload_QueryHappy(t *testing.T) {
 	}
 
 }
+func TestDecodePayload_AnswerWithoutQuestion(t *testing.T) {
+	payload := []byte{
+		// header, response without question and with one answer
+		0xc0, 0xd4, 0x84, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00,
+		// answer section
+		// name
+		0x04, 0x6d, 0x61, 0x69, 0x6c, 0x07, 0x65, 0x78,
+		0x61, 0x6d, 0x70, 0x6c, 0x65, 0x03, 0x63, 0x6f,
+		0x6d, 0x00,
+		// type A, class IN, ttl 300, rdata 192.0.2.2
+		0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c,
+		0x00, 0x04, 0xc0, 0x00, 0x02, 0x02,
+	}
+
+	dm := DnsMessage{}
+	dm.DNS.Payload = payload
+	dm.DNS.Length = len(payload)
+
+	header, err := DecodeDns(payload)
+	if err != nil {
+		t.Errorf("unexpected error when decoding header: %v", err)
+	}
+
+	if err = DecodePayload(&dm, &header, GetFakeConfig()); err != nil {
+		t.Errorf("Unexpected error while decoding payload: %v", err)
+	}
+	if dm.DNS.MalformedPacket {
+		t.Errorf("did not expect packet to be malformed")
+	}
+	if len(dm.DNS.DnsRRs.Answers) != 1 ||
+		dm.DNS.DnsRRs.Answers[0].Name != "mail.example.com" ||
+		dm.DNS.DnsRRs.Answers[0].Rdata != "192.0.2.2" {
+		t.Errorf("Unexpected answers: %+v", dm.DNS.DnsRRs.Answers)
+	}
+}
+
 func TestDecodePayload_QueryInvalid(t *testing.T) {
 	payload := []byte{
 		//header
@@ -2319,3 +2363,189 @@ func TestDecodePayload_Truncated(t *testing.T) {
 	}
 
 }
+
+func TestDecodeRdata_CommonTypes(t *testing.T) {
+	fqdn := TEST_QNAME
+
+	testcases := []struct {
+		rrtype string
+		rdata  string
+	}{
+		{"CAA", `0 issue "letsencrypt.org"`},
+		{"CAA", `128 iodef "mailto:security@example.com"`},
+		{"TLSA", "3 1 1 0C72AC70B745AC19998811B131D662C9AC69DBDBE7CB23E5B514B56664C5D3D6"},
+		{"SSHFP", "4 2 123456789ABCDEF67890123456789ABCDEF67890123456789ABCDEF123456789"},
+		{"NAPTR", `100 10 "S" "SIP+D2U" "" _sip._udp.example.com`},
+		{"NAPTR", `100 50 "u" "E2U+sip" "!^.*$!sip:info@example.com!" .`},
+		{"DNAME", "example.net"},
+		{"URI", `10 1 "ftp://ftp1.example.com/public"`},
+		{"HINFO", `"INTEL-386" "Linux"`},
+		{"RP", "admin.example.com txt.example.com"},
+		{"RP", "admin.example.com ."},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.rrtype, func(t *testing.T) {
+			dm := new(dns.Msg)
+			dm.SetQuestion(fqdn, dns.TypeA)
+
+			rr, err := dns.NewRR(fmt.Sprintf("%s %s %s", fqdn, tc.rrtype, tc.rdata))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dm.Answer = append(dm.Answer, rr)
+			payload, _ := dm.Pack()
+
+			_, _, offset_rr, _ := DecodeQuestion(1, payload)
+			answer, _, err := DecodeAnswer(len(dm.Answer), offset_rr, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if answer[0].Rdatatype != tc.rrtype || answer[0].Rdata != tc.rdata {
+				t.Errorf("invalid decode for rdata %s, want %s, got: %s %s", tc.rrtype, tc.rdata, answer[0].Rdatatype, answer[0].Rdata)
+			}
+		})
+	}
+}
+
+func TestDecodeRdataLOC(t *testing.T) {
+	fqdn := TEST_QNAME
+
+	testcases := []struct {
+		rdata    string
+		expected string
+	}{
+		{"52 22 23.000 N 4 53 32.000 E -2.00m 0.00m 10000m 10m", "52 22 23.000 N 4 53 32.000 E -2.00m 0.00m 10000m 10m"},
+		{"42 21 54 N 71 06 18 W -24m 30m", "42 21 54.000 N 71 6 18.000 W -24.00m 30m 10000m 10m"},
+		{"33 51 0 S 151 12 0 E 10m", "33 51 0.000 S 151 12 0.000 E 10.00m 1m 10000m 10m"},
+	}
+
+	for _, tc := range testcases {
+		dm := new(dns.Msg)
+		dm.SetQuestion(fqdn, dns.TypeA)
+		rr, err := dns.NewRR(fmt.Sprintf("%s LOC %s", fqdn, tc.rdata))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dm.Answer = append(dm.Answer, rr)
+		payload, _ := dm.Pack()
+
+		_, _, offset_rr, _ := DecodeQuestion(1, payload)
+		answer, _, err := DecodeAnswer(len(dm.Answer), offset_rr, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if answer[0].Rdata != tc.expected {
+			t.Errorf("invalid decode for rdata LOC, want %s, got: %s", tc.expected, answer[0].Rdata)
+		}
+	}
+}
+
+func TestDecodeRdata_Generic(t *testing.T) {
+	fqdn := TEST_QNAME
+
+	testcases := []struct {
+		rr       string
+		rrtype   string
+		expected string
+	}{
+		{fqdn + " TYPE731 \\# 6 ABCDEF012345", "UNKNOWN", "\\# 6 ABCDEF012345"},
+		{fqdn + " TYPE731 \\# 0", "UNKNOWN", "\\# 0"},
+		{fqdn + " EUI48 00-00-5e-00-53-2a", "EUI48", "\\# 6 00005E00532A"},
+	}
+
+	for _, tc := range testcases {
+		dm := new(dns.Msg)
+		dm.SetQuestion(fqdn, dns.TypeA)
+		rr, err := dns.NewRR(tc.rr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dm.Answer = append(dm.Answer, rr)
+		payload, _ := dm.Pack()
+
+		_, _, offset_rr, _ := DecodeQuestion(1, payload)
+		answer, _, err := DecodeAnswer(len(dm.Answer), offset_rr, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if answer[0].Rdatatype != tc.rrtype || answer[0].Rdata != tc.expected {
+			t.Errorf("invalid generic decode, want %s %s, got: %s %s", tc.rrtype, tc.expected, answer[0].Rdatatype, answer[0].Rdata)
+		}
+	}
+}
+
+func TestDecodeRdata_CommonTypes_Short(t *testing.T) {
+	testcases := []struct {
+		rrtype string
+		rdata  []byte
+	}{
+		{"CAA", []byte{0, 5, 'i', 's'}},
+		{"TLSA", []byte{3, 1, 1}},
+		{"SSHFP", []byte{4, 2}},
+		{"NAPTR", []byte{0, 100, 0, 10, 1}},
+		{"LOC", []byte{0, 0x12, 0x16, 0x13}},
+		{"URI", []byte{0, 10, 0}},
+		{"HINFO", []byte{9, 'I', 'N', 'T', 'E', 'L'}},
+	}
+
+	for _, tc := range testcases {
+		if _, err := ParseRdata(tc.rrtype, tc.rdata, tc.rdata, 0); !errors.Is(err, ErrDecodeDnsAnswerRdataTooShort) {
+			t.Errorf("%s %v: want rdata too short error, got %v", tc.rrtype, tc.rdata, err)
+		}
+	}
+}
+
+func TestQuoteCharacterString(t *testing.T) {
+	if s := quoteCharacterString([]byte("a \"b\"\\c\x00")); s != `"a \"b\"\\c\000"` {
+		t.Errorf("invalid escaping: %s", s)
+	}
+}
+
+func TestDecodePayload_UpdateOperation(t *testing.T) {
+	update := new(dns.Msg)
+	update.SetUpdate("example.com.")
+	update.Insert([]dns.RR{newRR(t, "host1.example.com. 300 IN A 192.0.2.1")})
+	updateResponse := new(dns.Msg)
+	updateResponse.SetReply(update)
+	query := new(dns.Msg)
+	query.SetQuestion("example.com.", dns.TypeSOA)
+
+	testcases := []struct {
+		name      string
+		msg       *dns.Msg
+		operation string
+		want      string
+	}{
+		{name: "update query", msg: update, operation: "AUTH_QUERY", want: "UPDATE_QUERY"},
+		{name: "update response", msg: updateResponse, operation: "AUTH_RESPONSE", want: "UPDATE_RESPONSE"},
+		{name: "standard query", msg: query, operation: "AUTH_QUERY", want: "AUTH_QUERY"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			payload, err := tc.msg.Pack()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the operation of the collector is replaced for the dynamic updates
+			dm := DnsMessage{}
+			dm.Init()
+			dm.DnsTap.Operation = tc.operation
+			dm.DNS.Payload = payload
+			dm.DNS.Length = len(payload)
+
+			header, err := DecodeDns(payload)
+			if err != nil {
+				t.Fatalf("unexpected error when decoding header: %v", err)
+			}
+			if err = DecodePayload(&dm, &header, GetFakeConfig()); err != nil {
+				t.Fatalf("unexpected error while decoding payload: %v", err)
+			}
+			if dm.DnsTap.Operation != tc.want {
+				t.Errorf("want the operation %s, got %s", tc.want, dm.DnsTap.Operation)
+			}
+		})
+	}
+}
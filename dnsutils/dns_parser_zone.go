@@ -0,0 +1,242 @@
+package dnsutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OpcodeNotify = 4
+	OpcodeUpdate = 5
+
+	ClassNone = 254
+	ClassAny  = 255
+
+	// a zone transfer without message since this duration is forgotten
+	ZoneTransferTimeout = 2 * time.Minute
+	// maximum number of zone transfers followed at the same time
+	ZoneTransfersMax = 1024
+)
+
+var (
+	Classes = map[int]string{
+		1:         "IN",
+		3:         "CH",
+		4:         "HS",
+		ClassNone: "NONE",
+		ClassAny:  "ANY",
+	}
+)
+
+// ClassToString returns the mnemonic of the class or its generic form CLASSxxx, RFC 3597
+func ClassToString(class int) string {
+	if value, ok := Classes[class]; ok {
+		return value
+	}
+	return fmt.Sprintf("CLASS%d", class)
+}
+
+// DecodeZone returns the zone of the dynamic updates, notifies and zone transfers,
+// nil is returned for the other messages.
+//
+// Only the messages with a question section are recognized, the following messages
+// of a zone transfer on the same tcp stream are tagged by ZoneTransfers.
+func DecodeZone(dm *DnsMessage) *DnsZone {
+	switch {
+	// dynamic update, the sections are zone, prerequisite, update and additional data
+	case dm.DNS.Opcode == OpcodeUpdate:
+		zone := &DnsZone{Name: dm.DNS.Qname, Transfer: "-"}
+		zone.Prerequisites = make([]DnsUpdateRecord, 0, len(dm.DNS.DnsRRs.Answers))
+		for _, rr := range dm.DNS.DnsRRs.Answers {
+			zone.Prerequisites = append(zone.Prerequisites, newUpdateRecord(rr, prerequisiteOperation(rr)))
+		}
+		zone.Updates = make([]DnsUpdateRecord, 0, len(dm.DNS.DnsRRs.Nameservers))
+		for _, rr := range dm.DNS.DnsRRs.Nameservers {
+			zone.Updates = append(zone.Updates, newUpdateRecord(rr, updateOperation(rr)))
+		}
+		return zone
+
+	// notify, the answer section can contain the new SOA of the zone
+	case dm.DNS.Opcode == OpcodeNotify:
+		zone := &DnsZone{Name: dm.DNS.Qname, Transfer: "-",
+			Prerequisites: []DnsUpdateRecord{}, Updates: []DnsUpdateRecord{}}
+		zone.Serial = soaSerial(dm.DNS.DnsRRs.Answers)
+		return zone
+
+	// zone transfers, the serial of an incremental transfer query is in the authority section
+	case dm.DNS.Qtype == "AXFR" || dm.DNS.Qtype == "IXFR":
+		zone := &DnsZone{Name: dm.DNS.Qname, Transfer: dm.DNS.Qtype,
+			Prerequisites: []DnsUpdateRecord{}, Updates: []DnsUpdateRecord{}}
+		if dm.DNS.Flags.QR {
+			zone.Serial = soaSerial(dm.DNS.DnsRRs.Answers)
+		} else {
+			zone.Serial = soaSerial(dm.DNS.DnsRRs.Nameservers)
+		}
+		return zone
+	}
+	return nil
+}
+
+type zoneTransferKey struct {
+	queryIp      string
+	queryPort    string
+	responseIp   string
+	responsePort string
+	id           int
+}
+
+type zoneTransfer struct {
+	zone     DnsZone
+	lastSeen time.Time
+}
+
+// ZoneTransfers follows the zone transfers in progress, the responses of a transfer can span
+// many messages on the same tcp stream, with the same id and without question section
+type ZoneTransfers struct {
+	transfers map[zoneTransferKey]*zoneTransfer
+}
+
+func NewZoneTransfers() *ZoneTransfers {
+	return &ZoneTransfers{transfers: make(map[zoneTransferKey]*zoneTransfer)}
+}
+
+// Track keeps the zone of the first response of a transfer and adds it to the following messages,
+// until the closing SOA record. Must be called after the decoding of the payload.
+func (z *ZoneTransfers) Track(dm *DnsMessage) {
+	if !dm.DNS.Flags.QR || dm.DNS.MalformedPacket {
+		return
+	}
+	key := zoneTransferKey{
+		queryIp:      dm.NetworkInfo.QueryIp,
+		queryPort:    dm.NetworkInfo.QueryPort,
+		responseIp:   dm.NetworkInfo.ResponseIp,
+		responsePort: dm.NetworkInfo.ResponsePort,
+		id:           dm.DNS.Id,
+	}
+	timestamp := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
+	answers := dm.DNS.DnsRRs.Answers
+
+	// first response of a transfer, the serial is the one of the first SOA
+	if zone := dm.DNS.Zone; zone != nil {
+		if zone.Transfer != "AXFR" && zone.Transfer != "IXFR" {
+			return
+		}
+		// the full transfer is in the message, or the zone of an incremental transfer is up to date
+		if isClosingSoa(answers, zone.Serial) && (len(answers) > 1 || zone.Transfer == "IXFR") {
+			return
+		}
+		if len(z.transfers) >= ZoneTransfersMax {
+			z.expire(timestamp)
+		}
+		if len(z.transfers) < ZoneTransfersMax {
+			z.transfers[key] = &zoneTransfer{
+				zone:     DnsZone{Name: zone.Name, Serial: zone.Serial, Transfer: zone.Transfer},
+				lastSeen: timestamp,
+			}
+		}
+		return
+	}
+
+	// following messages without question
+	transfer, ok := z.transfers[key]
+	if !ok || dm.DNS.SectionCounts.Question != 0 {
+		return
+	}
+	if timestamp.Sub(transfer.lastSeen) > ZoneTransferTimeout {
+		delete(z.transfers, key)
+		return
+	}
+	transfer.lastSeen = timestamp
+	dm.DNS.Zone = &DnsZone{Name: transfer.zone.Name, Serial: transfer.zone.Serial, Transfer: transfer.zone.Transfer,
+		Prerequisites: []DnsUpdateRecord{}, Updates: []DnsUpdateRecord{}}
+
+	if isClosingSoa(answers, transfer.zone.Serial) {
+		delete(z.transfers, key)
+	}
+}
+
+// Transfers returns the number of zone transfers in progress
+func (z *ZoneTransfers) Transfers() int {
+	return len(z.transfers)
+}
+
+// expire forgets the transfers without message since the timeout
+func (z *ZoneTransfers) expire(now time.Time) {
+	for key, transfer := range z.transfers {
+		if now.Sub(transfer.lastSeen) > ZoneTransferTimeout {
+			delete(z.transfers, key)
+		}
+	}
+}
+
+// isClosingSoa returns true if the last record is the SOA with the serial of the transfer
+func isClosingSoa(records []DnsAnswer, serial uint32) bool {
+	if len(records) == 0 {
+		return false
+	}
+	last := records[len(records)-1:]
+	return last[0].Rdatatype == "SOA" && soaSerial(last) == serial
+}
+
+func newUpdateRecord(rr DnsAnswer, operation string) DnsUpdateRecord {
+	return DnsUpdateRecord{
+		Operation: operation,
+		Name:      rr.Name,
+		Rdatatype: rr.Rdatatype,
+		Class:     ClassToString(rr.Class),
+		Ttl:       rr.Ttl,
+		Rdata:     rr.Rdata,
+	}
+}
+
+// prerequisiteOperation returns the kind of a prerequisite, RFC 2136 section 2.4
+func prerequisiteOperation(rr DnsAnswer) string {
+	switch {
+	case rr.Class == ClassAny && rr.Rdatatype == "ANY":
+		return "name-in-use"
+	case rr.Class == ClassAny:
+		return "rrset-exists"
+	case rr.Class == ClassNone && rr.Rdatatype == "ANY":
+		return "name-not-in-use"
+	case rr.Class == ClassNone:
+		return "rrset-not-exists"
+	default:
+		return "rrset-exists-value"
+	}
+}
+
+// updateOperation returns the kind of an update, RFC 2136 section 2.5
+func updateOperation(rr DnsAnswer) string {
+	switch {
+	case rr.Class == ClassAny && rr.Rdatatype == "ANY":
+		return "delete-all-rrsets"
+	case rr.Class == ClassAny:
+		return "delete-rrset"
+	case rr.Class == ClassNone:
+		return "delete-rr"
+	default:
+		return "add"
+	}
+}
+
+// soaSerial returns the serial of the first SOA record, or zero if there is none
+func soaSerial(records []DnsAnswer) uint32 {
+	for _, rr := range records {
+		if rr.Rdatatype != "SOA" {
+			continue
+		}
+		// mname rname serial refresh retry expire minimum
+		fields := strings.Fields(rr.Rdata)
+		if len(fields) != 7 {
+			return 0
+		}
+		serial, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return 0
+		}
+		return uint32(serial)
+	}
+	return 0
+}
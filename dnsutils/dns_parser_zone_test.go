@@ -0,0 +1,263 @@
+package dnsutils
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// decodeZoneMsg packs the message and decodes its header and sections
+func decodeZoneMsg(t *testing.T, msg *dns.Msg) DnsMessage {
+	payload, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dm := DnsMessage{}
+	dm.Init()
+	dm.DNS.Payload = payload
+	dm.DNS.Length = len(payload)
+
+	header, err := DecodeDns(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodePayload(&dm, &header, GetFakeConfig()); err != nil {
+		t.Fatalf("unexpected error while decoding payload: %v", err)
+	}
+	return dm
+}
+
+func newRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestDecodeZone_Update(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetUpdate("example.com.")
+	msg.NameUsed([]dns.RR{newRR(t, "host1.example.com. 0 IN A 0.0.0.0")})
+	msg.RRsetNotUsed([]dns.RR{newRR(t, "host2.example.com. 0 IN A 0.0.0.0")})
+	msg.RemoveRRset([]dns.RR{newRR(t, "host2.example.com. 0 IN A 0.0.0.0")})
+	msg.Insert([]dns.RR{newRR(t, "host2.example.com. 300 IN A 192.0.2.2")})
+	msg.Remove([]dns.RR{newRR(t, "host3.example.com. 0 IN A 192.0.2.3")})
+	msg.RemoveName([]dns.RR{newRR(t, "host4.example.com. 0 IN A 0.0.0.0")})
+
+	dm := decodeZoneMsg(t, msg)
+	if dm.DNS.MalformedPacket {
+		t.Fatalf("update should not be malformed")
+	}
+	if dm.DNS.OpcodeName != "UPDATE" || dm.DnsTap.Operation != "UPDATE_QUERY" {
+		t.Errorf("invalid opcode %s or operation %s", dm.DNS.OpcodeName, dm.DnsTap.Operation)
+	}
+
+	zone := dm.DNS.Zone
+	if zone == nil {
+		t.Fatal("zone expected")
+	}
+	if zone.Name != "example.com" || zone.Transfer != "-" {
+		t.Errorf("invalid zone: %+v", zone)
+	}
+
+	prerequisites := []DnsUpdateRecord{
+		{Operation: "name-in-use", Name: "host1.example.com", Rdatatype: "ANY", Class: "ANY", Rdata: "-"},
+		{Operation: "rrset-not-exists", Name: "host2.example.com", Rdatatype: "A", Class: "NONE", Rdata: "-"},
+	}
+	if len(zone.Prerequisites) != len(prerequisites) {
+		t.Fatalf("want %d prerequisites, got %+v", len(prerequisites), zone.Prerequisites)
+	}
+	for i, p := range prerequisites {
+		if zone.Prerequisites[i] != p {
+			t.Errorf("prerequisite %d: want %+v, got %+v", i, p, zone.Prerequisites[i])
+		}
+	}
+
+	updates := []DnsUpdateRecord{
+		{Operation: "delete-rrset", Name: "host2.example.com", Rdatatype: "A", Class: "ANY", Rdata: "-"},
+		{Operation: "add", Name: "host2.example.com", Rdatatype: "A", Class: "IN", Ttl: 300, Rdata: "192.0.2.2"},
+		{Operation: "delete-rr", Name: "host3.example.com", Rdatatype: "A", Class: "NONE", Rdata: "192.0.2.3"},
+		{Operation: "delete-all-rrsets", Name: "host4.example.com", Rdatatype: "ANY", Class: "ANY", Rdata: "-"},
+	}
+	if len(zone.Updates) != len(updates) {
+		t.Fatalf("want %d updates, got %+v", len(updates), zone.Updates)
+	}
+	for i, u := range updates {
+		if zone.Updates[i] != u {
+			t.Errorf("update %d: want %+v, got %+v", i, u, zone.Updates[i])
+		}
+	}
+
+	// all updates are rendered in text format
+	expected := "\"delete-rrset host2.example.com 0 ANY A -,add host2.example.com 300 IN A 192.0.2.2," +
+		"delete-rr host3.example.com 0 NONE A 192.0.2.3,delete-all-rrsets host4.example.com 0 ANY ANY -\""
+	if line := dm.String([]string{"zone-update"}, " ", "\""); line != expected {
+		t.Errorf("want %s, got %s", expected, line)
+	}
+}
+
+func TestDecodeZone_UpdateResponse(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetUpdate("example.com.")
+	msg := new(dns.Msg)
+	msg.SetReply(query)
+
+	dm := decodeZoneMsg(t, msg)
+	if dm.DnsTap.Operation != "UPDATE_RESPONSE" {
+		t.Errorf("want update response, got %s", dm.DnsTap.Operation)
+	}
+	if dm.DNS.Zone == nil || dm.DNS.Zone.Name != "example.com" || len(dm.DNS.Zone.Updates) != 0 {
+		t.Errorf("invalid zone: %+v", dm.DNS.Zone)
+	}
+}
+
+func TestDecodeZone_Notify(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetNotify("example.com.")
+	msg.Answer = []dns.RR{newRR(t, "example.com. 3600 IN SOA ns1.example.com. admin.example.com. 2023010101 7200 3600 1209600 300")}
+
+	dm := decodeZoneMsg(t, msg)
+	if dm.DNS.OpcodeName != "NOTIFY" {
+		t.Errorf("want notify, got %s", dm.DNS.OpcodeName)
+	}
+	if dm.DNS.Zone == nil || dm.DNS.Zone.Name != "example.com" || dm.DNS.Zone.Serial != 2023010101 {
+		t.Errorf("invalid zone: %+v", dm.DNS.Zone)
+	}
+}
+
+func TestDecodeZone_Transfer(t *testing.T) {
+	soa := newRR(t, "example.com. 3600 IN SOA ns1.example.com. admin.example.com. 2023010102 7200 3600 1209600 300")
+
+	// full transfer, first message of the response
+	query := new(dns.Msg)
+	query.SetAxfr("example.com.")
+	msg := new(dns.Msg)
+	msg.SetReply(query)
+	msg.Answer = []dns.RR{soa, newRR(t, "www.example.com. 300 IN A 192.0.2.1")}
+
+	dm := decodeZoneMsg(t, msg)
+	if dm.DNS.Zone == nil || dm.DNS.Zone.Transfer != "AXFR" || dm.DNS.Zone.Serial != 2023010102 {
+		t.Errorf("invalid zone: %+v", dm.DNS.Zone)
+	}
+
+	// incremental transfer query, with the serial of the client
+	query = new(dns.Msg)
+	query.SetIxfr("example.com.", 2023010101, "ns1.example.com.", "admin.example.com.")
+
+	dm = decodeZoneMsg(t, query)
+	if dm.DNS.Zone == nil || dm.DNS.Zone.Transfer != "IXFR" || dm.DNS.Zone.Serial != 2023010101 {
+		t.Errorf("invalid zone: %+v", dm.DNS.Zone)
+	}
+}
+
+func TestZoneTransfers_Axfr(t *testing.T) {
+	soa := newRR(t, "example.com. 3600 IN SOA ns1.example.com. admin.example.com. 2023010102 7200 3600 1209600 300")
+	query := new(dns.Msg)
+	query.SetAxfr("example.com.")
+
+	// the response spans 3 messages, the following ones without question
+	messages := [][]dns.RR{
+		{soa, newRR(t, "www.example.com. 300 IN A 192.0.2.1")},
+		{newRR(t, "mail.example.com. 300 IN A 192.0.2.2")},
+		{newRR(t, "ftp.example.com. 300 IN A 192.0.2.3"), soa},
+		// a message after the end of the transfer
+		{newRR(t, "other.example.com. 300 IN A 192.0.2.4")},
+	}
+
+	transfers := NewZoneTransfers()
+	for i, answers := range messages {
+		msg := new(dns.Msg)
+		msg.SetReply(query)
+		if i > 0 {
+			msg.Question = nil
+		}
+		msg.Answer = answers
+
+		dm := decodeZoneMsg(t, msg)
+		dm.NetworkInfo = DnsNetInfo{QueryIp: "192.0.2.53", QueryPort: "36000", ResponseIp: "192.0.2.1", ResponsePort: "53"}
+		transfers.Track(&dm)
+
+		if i == len(messages)-1 {
+			if dm.DNS.Zone != nil {
+				t.Errorf("message %d: no zone expected after the end of the transfer: %+v", i, dm.DNS.Zone)
+			}
+			continue
+		}
+		if dm.DNS.Zone == nil || dm.DNS.Zone.Name != "example.com" || dm.DNS.Zone.Transfer != "AXFR" || dm.DNS.Zone.Serial != 2023010102 {
+			t.Errorf("message %d: invalid zone: %+v", i, dm.DNS.Zone)
+		}
+	}
+	if n := transfers.Transfers(); n != 0 {
+		t.Errorf("want no transfer in progress, got %d", n)
+	}
+}
+
+func TestZoneTransfers_OtherStream(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetAxfr("example.com.")
+	first := new(dns.Msg)
+	first.SetReply(query)
+	first.Answer = []dns.RR{newRR(t, "example.com. 3600 IN SOA ns1.example.com. admin.example.com. 1 7200 3600 1209600 300")}
+	next := new(dns.Msg)
+	next.SetReply(query)
+	next.Question = nil
+	next.Answer = []dns.RR{newRR(t, "www.example.com. 300 IN A 192.0.2.1")}
+
+	transfers := NewZoneTransfers()
+	dm := decodeZoneMsg(t, first)
+	dm.NetworkInfo = DnsNetInfo{QueryIp: "192.0.2.53", QueryPort: "36000", ResponseIp: "192.0.2.1", ResponsePort: "53"}
+	transfers.Track(&dm)
+
+	// same id on another tcp stream
+	dm = decodeZoneMsg(t, next)
+	dm.NetworkInfo = DnsNetInfo{QueryIp: "192.0.2.53", QueryPort: "36001", ResponseIp: "192.0.2.1", ResponsePort: "53"}
+	transfers.Track(&dm)
+	if dm.DNS.Zone != nil {
+		t.Errorf("no zone expected on another stream: %+v", dm.DNS.Zone)
+	}
+
+	// after the timeout
+	dm = decodeZoneMsg(t, next)
+	dm.NetworkInfo = DnsNetInfo{QueryIp: "192.0.2.53", QueryPort: "36000", ResponseIp: "192.0.2.1", ResponsePort: "53"}
+	dm.DnsTap.TimeSec = int(ZoneTransferTimeout.Seconds()) + 1
+	transfers.Track(&dm)
+	if dm.DNS.Zone != nil || transfers.Transfers() != 0 {
+		t.Errorf("no zone expected after the timeout: %+v", dm.DNS.Zone)
+	}
+}
+
+func TestDecodeZone_StandardQuery(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.example.com.", dns.TypeA)
+
+	dm := decodeZoneMsg(t, msg)
+	if dm.DNS.Zone != nil {
+		t.Errorf("no zone expected: %+v", dm.DNS.Zone)
+	}
+}
+
+func TestDecodeAnswer_EmptyRdata(t *testing.T) {
+	// an empty rdata is only valid with the class ANY or NONE
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.example.com.", dns.TypeA)
+	msg.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET}}}
+	payload, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, offset, _ := DecodeQuestion(1, payload)
+	if _, _, err := DecodeAnswer(1, offset, payload); err == nil {
+		t.Errorf("error expected for an empty rdata with the class IN")
+	}
+}
+
+func TestClassToString(t *testing.T) {
+	for class, expected := range map[int]string{1: "IN", 254: "NONE", 255: "ANY", 42: "CLASS42"} {
+		if value := ClassToString(class); value != expected {
+			t.Errorf("class %d: want %s, got %s", class, expected, value)
+		}
+	}
+}
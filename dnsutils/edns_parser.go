@@ -2,9 +2,12 @@ package dnsutils
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 )
 
 var ErrDecodeEdnsBadRootDomain = errors.New("edns, name MUST be 0 (root domain)")
@@ -12,16 +15,23 @@ var ErrDecodeEdnsDataTooShort = errors.New("edns, not enough data to decode rdat
 var ErrDecodeEdnsOptionTooShort = errors.New("edns, not enough data to decode option answer")
 var ErrDecodeEdnsOptionCsubnetBadFamily = errors.New("edns, csubnet option bad family")
 var ErrDecodeEdnsTooManyOpts = errors.New("edns, packet contained too many OPT RRs")
+var ErrDecodeEdnsOptionBadLength = errors.New("edns, invalid option length")
 
 var (
 	OptCodes = map[int]string{
 		3:  "NSID",
+		5:  "DAU",
+		6:  "DHU",
+		7:  "N3U",
 		8:  "CSUBNET",
 		9:  "EXPIRE",
 		10: "COOKIE",
 		11: "KEEPALIVE",
 		12: "PADDING",
+		13: "CHAIN",
+		14: "KEY-TAG",
 		15: "ERRORS",
+		19: "ZONEVERSION",
 	}
 	ErrorCodeToString = map[int]string{
 		0:  "Other",
@@ -183,6 +193,24 @@ func ParseOption(optName string, optData []byte) (string, error) {
 		ret, err = ParseErrors(optData)
 	case "CSUBNET":
 		ret, err = ParseCsubnet(optData)
+	case "COOKIE":
+		ret, err = ParseCookie(optData)
+	case "NSID":
+		ret, err = ParseNsid(optData)
+	case "PADDING":
+		ret, err = ParsePadding(optData)
+	case "KEEPALIVE":
+		ret, err = ParseKeepalive(optData)
+	case "EXPIRE":
+		ret, err = ParseExpire(optData)
+	case "CHAIN":
+		ret, err = ParseChain(optData)
+	case "KEY-TAG":
+		ret, err = ParseKeyTags(optData)
+	case "DAU", "DHU", "N3U":
+		ret, err = ParseAlgorithms(optData)
+	case "ZONEVERSION":
+		ret, err = ParseZoneVersion(optData)
 	default:
 		ret = "-"
 		err = nil
@@ -260,3 +288,197 @@ func ParseCsubnet(d []byte) (string, error) {
 		return "-", ErrDecodeEdnsOptionCsubnetBadFamily
 	}
 }
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7873
+
+Cookie EDNS0 option format
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                                                               |
++-+    Client Cookie (fixed size, 8 bytes)                    +-+
+|                                                               |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                                                               |
+/       Server Cookie  (variable size, 8 to 32 bytes)           /
+/                                                               /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+
+The cookies with an invalid length are kept as they are, to investigate spoofing
+*/
+func ParseCookie(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "", ErrDecodeEdnsOptionTooShort
+	}
+	if len(d) <= 8 {
+		return fmt.Sprintf("client=%s", hex.EncodeToString(d)), nil
+	}
+	cookie := fmt.Sprintf("client=%s server=%s", hex.EncodeToString(d[:8]), hex.EncodeToString(d[8:]))
+	return cookie, nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc5001
+
+The NSID is rendered in hex and with the printable characters, as dig does
+*/
+func ParseNsid(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "-", nil
+	}
+	printable := make([]byte, len(d))
+	for i, b := range d {
+		if b < ' ' || b > '~' {
+			b = '.'
+		}
+		printable[i] = b
+	}
+	nsid := fmt.Sprintf("%s (%s)", hex.EncodeToString(d), printable)
+	return nsid, nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7830
+
+The length of the padding is returned, the content is ignored
+*/
+func ParsePadding(d []byte) (string, error) {
+	return strconv.Itoa(len(d)), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7828
+
+TCP keepalive EDNS0 option format, the timeout is empty in the queries
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                           TIMEOUT                             |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseKeepalive(d []byte) (string, error) {
+	switch len(d) {
+	case 0:
+		return "-", nil
+	case 2:
+		// units of 100 milliseconds
+		timeout := binary.BigEndian.Uint16(d)
+		return fmt.Sprintf("%.1fs", float64(timeout)/10), nil
+	default:
+		return "", ErrDecodeEdnsOptionBadLength
+	}
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7314
+
+Expire EDNS0 option format, the expire is empty in the queries
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                            EXPIRE                             |
+|                                                               |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseExpire(d []byte) (string, error) {
+	switch len(d) {
+	case 0:
+		return "-", nil
+	case 4:
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(d)), 10), nil
+	default:
+		return "", ErrDecodeEdnsOptionBadLength
+	}
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc7901
+
+Chain EDNS0 option format, the name is not compressed
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+/                   Closest trust point                         /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseChain(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "", ErrDecodeEdnsOptionTooShort
+	}
+	name, _, err := ParseLabels(0, d)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = "."
+	}
+	return name, nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc8145
+
+Key tag EDNS0 option format
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                           KEY-TAG                             |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                             ...                               /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseKeyTags(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "", ErrDecodeEdnsOptionTooShort
+	}
+	if len(d)%2 != 0 {
+		return "", ErrDecodeEdnsOptionBadLength
+	}
+	var tags []string
+	for i := 0; i < len(d); i += 2 {
+		tags = append(tags, strconv.Itoa(int(binary.BigEndian.Uint16(d[i:i+2]))))
+	}
+	return strings.Join(tags, ","), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc6975
+
+DAU, DHU and N3U EDNS0 options format
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|       ALG-CODE        |          ...                          /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseAlgorithms(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "-", nil
+	}
+	var algs []string
+	for _, alg := range d {
+		algs = append(algs, strconv.Itoa(int(alg)))
+	}
+	return strings.Join(algs, ","), nil
+}
+
+/*
+https://datatracker.ietf.org/doc/html/rfc9660
+
+Zone version EDNS0 option format, empty in the queries
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|      LABELCOUNT       |            TYPE                       |
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+|                           VERSION                             |
+/                                                               /
++---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+*/
+func ParseZoneVersion(d []byte) (string, error) {
+	if len(d) == 0 {
+		return "-", nil
+	}
+	if len(d) < 2 {
+		return "", ErrDecodeEdnsOptionTooShort
+	}
+	labels := d[0]
+	versionType := d[1]
+	version := d[2:]
+
+	// the serial of the SOA record
+	if versionType == 0 {
+		if len(version) != 4 {
+			return "", ErrDecodeEdnsOptionBadLength
+		}
+		return fmt.Sprintf("%d SOA-SERIAL %d", labels, binary.BigEndian.Uint32(version)), nil
+	}
+	return fmt.Sprintf("%d %d %s", labels, versionType, hex.EncodeToString(version)), nil
+}
@@ -863,3 +863,80 @@ func TestDecodeEdns_invalidRRFollows(t *testing.T) {
 		t.Errorf("bad error received: %v", err)
 	}
 }
+
+func TestDecodeEdns_Options(t *testing.T) {
+	testcases := []struct {
+		name     string
+		code     uint16
+		data     []byte
+		expected string
+	}{
+		{"COOKIE", 10, []byte{1, 2, 3, 4, 5, 6, 7, 8}, "client=0102030405060708"},
+		{"COOKIE", 10, []byte{1, 2, 3, 4, 5, 6, 7, 8, 0xa, 0xb, 0xc, 0xd, 0xe, 0xf, 0x10, 0x11}, "client=0102030405060708 server=0a0b0c0d0e0f1011"},
+		{"NSID", 3, []byte("ns1-ams\x00"), "6e73312d616d7300 (ns1-ams.)"},
+		{"NSID", 3, []byte{}, "-"},
+		{"PADDING", 12, make([]byte, 468), "468"},
+		{"KEEPALIVE", 11, []byte{}, "-"},
+		{"KEEPALIVE", 11, []byte{0x01, 0x2c}, "30.0s"},
+		{"EXPIRE", 9, []byte{}, "-"},
+		{"EXPIRE", 9, []byte{0x00, 0x09, 0x3a, 0x80}, "604800"},
+		{"CHAIN", 13, []byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0}, "example.com"},
+		{"CHAIN", 13, []byte{0}, "."},
+		{"KEY-TAG", 14, []byte{0x4f, 0x66, 0x97, 0x28}, "20326,38696"},
+		{"DAU", 5, []byte{8, 10, 13, 14, 15}, "8,10,13,14,15"},
+		{"DHU", 6, []byte{1, 2, 4}, "1,2,4"},
+		{"N3U", 7, []byte{1}, "1"},
+		{"ZONEVERSION", 19, []byte{}, "-"},
+		{"ZONEVERSION", 19, []byte{2, 0, 0x78, 0x9a, 0xbc, 0xde}, "2 SOA-SERIAL 2023406814"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dm := new(dns.Msg)
+			dm.SetQuestion("dnstapcollector.test.", dns.TypeA)
+
+			e := &dns.OPT{}
+			e.Hdr.Name = "."
+			e.Hdr.Rrtype = dns.TypeOPT
+			e.Option = append(e.Option, &dns.EDNS0_LOCAL{Code: tc.code, Data: tc.data})
+			dm.Extra = append(dm.Extra, e)
+
+			payload, _ := dm.Pack()
+			_, _, offset_rr, _ := DecodeQuestion(1, payload)
+			edns, _, err := DecodeEDNS(len(dm.Extra), offset_rr, payload)
+			if err != nil {
+				t.Fatalf("edns error returned: %v", err)
+			}
+			if len(edns.Options) != 1 {
+				t.Fatalf("want one option, got %d", len(edns.Options))
+			}
+			opt := edns.Options[0]
+			if opt.Code != int(tc.code) || opt.Name != tc.name || opt.Data != tc.expected {
+				t.Errorf("want %d %s %s, got %d %s %s", tc.code, tc.name, tc.expected, opt.Code, opt.Name, opt.Data)
+			}
+		})
+	}
+}
+
+func TestDecodeEdns_OptionsInvalid(t *testing.T) {
+	testcases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"COOKIE", []byte{}, ErrDecodeEdnsOptionTooShort},
+		{"KEEPALIVE", []byte{1}, ErrDecodeEdnsOptionBadLength},
+		{"EXPIRE", []byte{0, 0, 1}, ErrDecodeEdnsOptionBadLength},
+		{"CHAIN", []byte{}, ErrDecodeEdnsOptionTooShort},
+		{"CHAIN", []byte{7, 'e', 'x'}, ErrDecodeDnsLabelTooShort},
+		{"KEY-TAG", []byte{0x4f, 0x66, 0x97}, ErrDecodeEdnsOptionBadLength},
+		{"ZONEVERSION", []byte{2}, ErrDecodeEdnsOptionTooShort},
+		{"ZONEVERSION", []byte{2, 0, 0x78}, ErrDecodeEdnsOptionBadLength},
+	}
+
+	for _, tc := range testcases {
+		if _, err := ParseOption(tc.name, tc.data); !errors.Is(err, tc.err) {
+			t.Errorf("%s %v: want error %v, got %v", tc.name, tc.data, tc.err, err)
+		}
+	}
+}
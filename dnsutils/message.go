@@ -17,7 +17,9 @@ import (
 	"github.com/dmachard/go-dnstap-protobuf"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
+	"github.com/miekg/dns"
 	"github.com/nqd/flat"
+	"github.com/vmihailenco/msgpack"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -31,6 +33,9 @@ var (
 	ExtractedDirectives       = regexp.MustCompile(`^extracted-*`)
 	ReducerDirectives         = regexp.MustCompile(`^reducer-*`)
 	MachineLearningDirectives = regexp.MustCompile(`^ml-*`)
+	TlsDirectives             = regexp.MustCompile(`^tls-*`)
+	TzspDirectives            = regexp.MustCompile(`^tzsp-*`)
+	ZoneDirectives            = regexp.MustCompile(`^zone-*`)
 )
 
 func GetIpPort(dm *DnsMessage) (string, int, string, int) {
@@ -72,17 +77,78 @@ type DnsFlags struct {
 	AA bool `json:"aa" msgpack:"aa"`
 	RA bool `json:"ra" msgpack:"ra"`
 	AD bool `json:"ad" msgpack:"ad"`
+	RD bool `json:"rd" msgpack:"rd"`
+	CD bool `json:"cd" msgpack:"cd"`
+	Z  bool `json:"z" msgpack:"z"`
 }
 
 type DnsNetInfo struct {
-	Family         string `json:"family" msgpack:"family"`
-	Protocol       string `json:"protocol" msgpack:"protocol"`
-	QueryIp        string `json:"query-ip" msgpack:"query-ip"`
-	QueryPort      string `json:"query-port" msgpack:"query-port"`
-	ResponseIp     string `json:"response-ip" msgpack:"response-ip"`
-	ResponsePort   string `json:"response-port" msgpack:"response-port"`
-	IpDefragmented bool   `json:"ip-defragmented" msgpack:"ip-defragmented"`
-	TcpReassembled bool   `json:"tcp-reassembled" msgpack:"tcp-reassembled"`
+	Family         string     `json:"family" msgpack:"family"`
+	Protocol       string     `json:"protocol" msgpack:"protocol"`
+	QueryIp        string     `json:"query-ip" msgpack:"query-ip"`
+	QueryPort      string     `json:"query-port" msgpack:"query-port"`
+	ResponseIp     string     `json:"response-ip" msgpack:"response-ip"`
+	ResponsePort   string     `json:"response-port" msgpack:"response-port"`
+	IpDefragmented bool       `json:"ip-defragmented" msgpack:"ip-defragmented"`
+	TcpReassembled bool       `json:"tcp-reassembled" msgpack:"tcp-reassembled"`
+	Tunnel         *DnsTunnel `json:"tunnel,omitempty" msgpack:"tunnel"`
+	Tls            *DnsTls    `json:"tls,omitempty" msgpack:"tls"`
+	Tzsp           *DnsTzsp   `json:"tzsp,omitempty" msgpack:"tzsp"`
+}
+
+type DnsTunnel struct {
+	Encapsulations []string `json:"encapsulations" msgpack:"encapsulations"`
+	VlanIds        []int    `json:"vlan-ids" msgpack:"vlan-ids"`
+	Vni            int      `json:"vni" msgpack:"vni"`
+	ErspanSession  int      `json:"erspan-session" msgpack:"erspan-session"`
+}
+
+type DnsTls struct {
+	Event       string   `json:"event" msgpack:"event"`
+	ServerName  string   `json:"sni" msgpack:"sni"`
+	Alpn        []string `json:"alpn" msgpack:"alpn"`
+	Version     string   `json:"version" msgpack:"version"`
+	ClientBytes int      `json:"client-bytes" msgpack:"client-bytes"`
+	ServerBytes int      `json:"server-bytes" msgpack:"server-bytes"`
+}
+
+type DnsTzsp struct {
+	Sensor        string `json:"sensor" msgpack:"sensor"`
+	SensorMac     string `json:"sensor-mac" msgpack:"sensor-mac"`
+	Encapsulation string `json:"encapsulation" msgpack:"encapsulation"`
+	Timestamp     uint32 `json:"timestamp" msgpack:"timestamp"`
+}
+
+// DnsSectionCounts are the number of records of each section, from the dns header
+type DnsSectionCounts struct {
+	Question   int `json:"qd" msgpack:"qd"`
+	Answer     int `json:"an" msgpack:"an"`
+	Authority  int `json:"ns" msgpack:"ns"`
+	Additional int `json:"ar" msgpack:"ar"`
+}
+
+// DnsUpdateRecord is a prerequisite or an update of a dynamic update, RFC 2136
+type DnsUpdateRecord struct {
+	Operation string `json:"operation" msgpack:"operation"`
+	Name      string `json:"name" msgpack:"name"`
+	Rdatatype string `json:"rdatatype" msgpack:"rdatatype"`
+	Class     string `json:"class" msgpack:"class"`
+	Ttl       int    `json:"ttl" msgpack:"ttl"`
+	Rdata     string `json:"rdata" msgpack:"rdata"`
+}
+
+// String returns the record in the presentation format, after its operation
+func (u DnsUpdateRecord) String() string {
+	return fmt.Sprintf("%s %s %d %s %s %s", u.Operation, u.Name, u.Ttl, u.Class, u.Rdatatype, u.Rdata)
+}
+
+// DnsZone is the zone of the dynamic updates, notifies and zone transfers
+type DnsZone struct {
+	Name          string            `json:"name" msgpack:"name"`
+	Serial        uint32            `json:"serial" msgpack:"serial"`
+	Transfer      string            `json:"transfer" msgpack:"transfer"`
+	Prerequisites []DnsUpdateRecord `json:"prerequisites" msgpack:"prerequisites"`
+	Updates       []DnsUpdateRecord `json:"updates" msgpack:"updates"`
 }
 
 type DnsRRs struct {
@@ -92,18 +158,21 @@ type DnsRRs struct {
 }
 
 type Dns struct {
-	Type    string `json:"-" msgpack:"-"`
-	Payload []byte `json:"-" msgpack:"-"`
-	Length  int    `json:"length" msgpack:"-"`
-	Id      int    `json:"-" msgpack:"-"`
-	Opcode  int    `json:"opcode" msgpack:"opcode"`
-	Rcode   string `json:"rcode" msgpack:"rcode"`
-	Qname   string `json:"qname" msgpack:"qname"`
-
-	Qtype           string   `json:"qtype" msgpack:"qtype"`
-	Flags           DnsFlags `json:"flags" msgpack:"flags"`
-	DnsRRs          DnsRRs   `json:"resource-records" msgpack:"resource-records"`
-	MalformedPacket bool     `json:"malformed-packet" msgpack:"malformed-packet"`
+	Type       string `json:"-" msgpack:"-"`
+	Payload    []byte `json:"-" msgpack:"-"`
+	Length     int    `json:"length" msgpack:"-"`
+	Id         int    `json:"-" msgpack:"-"`
+	Opcode     int    `json:"opcode" msgpack:"opcode"`
+	OpcodeName string `json:"opcode-name" msgpack:"opcode-name"`
+	Rcode      string `json:"rcode" msgpack:"rcode"`
+	Qname      string `json:"qname" msgpack:"qname"`
+
+	Qtype           string           `json:"qtype" msgpack:"qtype"`
+	Flags           DnsFlags         `json:"flags" msgpack:"flags"`
+	SectionCounts   DnsSectionCounts `json:"counts" msgpack:"counts"`
+	DnsRRs          DnsRRs           `json:"resource-records" msgpack:"resource-records"`
+	Zone            *DnsZone         `json:"zone,omitempty" msgpack:"zone"`
+	MalformedPacket bool             `json:"malformed-packet" msgpack:"malformed-packet"`
 }
 
 type DnsOption struct {
@@ -236,6 +305,7 @@ func (dm *DnsMessage) Init() {
 	dm.DNS = Dns{
 		Type:            "-",
 		MalformedPacket: false,
+		OpcodeName:      "-",
 		Rcode:           "-",
 		Qtype:           "-",
 		Qname:           "-",
@@ -252,6 +322,106 @@ func (dm *DnsMessage) Init() {
 	}
 }
 
+// IsTlsEvent returns true for the events of the encrypted dns connections, they are not dns messages
+func (dm *DnsMessage) IsTlsEvent() bool {
+	return dm.DnsTap.Operation == DNSTAP_TLS_HANDSHAKE || dm.DnsTap.Operation == DNSTAP_TLS_CLOSE
+}
+
+func (dm *DnsMessage) handleTzspDirectives(directives []string, s *strings.Builder) {
+	if dm.NetworkInfo.Tzsp == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "tzsp-sensor":
+			s.WriteString(dm.NetworkInfo.Tzsp.Sensor)
+		case directive == "tzsp-sensor-mac":
+			if len(dm.NetworkInfo.Tzsp.SensorMac) == 0 {
+				s.WriteString("-")
+			} else {
+				s.WriteString(dm.NetworkInfo.Tzsp.SensorMac)
+			}
+		case directive == "tzsp-encapsulation":
+			s.WriteString(dm.NetworkInfo.Tzsp.Encapsulation)
+		case directive == "tzsp-timestamp":
+			s.WriteString(strconv.FormatUint(uint64(dm.NetworkInfo.Tzsp.Timestamp), 10))
+		}
+	}
+}
+
+func (dm *DnsMessage) handleZoneDirectives(directives []string, s *strings.Builder, fieldDelimiter string, fieldBoundary string) {
+	if dm.DNS.Zone == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "zone-name":
+			s.WriteString(dm.DNS.Zone.Name)
+		case directive == "zone-serial":
+			s.WriteString(strconv.FormatUint(uint64(dm.DNS.Zone.Serial), 10))
+		case directive == "zone-transfer":
+			s.WriteString(dm.DNS.Zone.Transfer)
+		case directive == "zone-prerequisites":
+			s.WriteString(strconv.Itoa(len(dm.DNS.Zone.Prerequisites)))
+		case directive == "zone-updates":
+			s.WriteString(strconv.Itoa(len(dm.DNS.Zone.Updates)))
+		case directive == "zone-update":
+			updates := dm.DNS.Zone.Updates
+			// all updates separated by comma, or the update of the provided index
+			if len(directives) == 2 {
+				index, err := strconv.Atoi(directives[1])
+				if err != nil || index < 0 || index >= len(updates) {
+					updates = nil
+				} else {
+					updates = updates[index : index+1]
+				}
+			}
+			if len(updates) == 0 {
+				s.WriteString("-")
+			} else {
+				records := make([]string, 0, len(updates))
+				for _, u := range updates {
+					records = append(records, u.String())
+				}
+				update := strings.Join(records, ",")
+				if strings.Contains(update, fieldDelimiter) {
+					update = strings.ReplaceAll(update, fieldBoundary, "\\"+fieldBoundary)
+					s.WriteString(fieldBoundary + update + fieldBoundary)
+				} else {
+					s.WriteString(update)
+				}
+			}
+		}
+	}
+}
+
+func (dm *DnsMessage) handleTlsDirectives(directives []string, s *strings.Builder) {
+	if dm.NetworkInfo.Tls == nil {
+		s.WriteString("-")
+	} else {
+		switch directive := directives[0]; {
+		case directive == "tls-event":
+			s.WriteString(dm.NetworkInfo.Tls.Event)
+		case directive == "tls-sni":
+			if len(dm.NetworkInfo.Tls.ServerName) == 0 {
+				s.WriteString("-")
+			} else {
+				s.WriteString(dm.NetworkInfo.Tls.ServerName)
+			}
+		case directive == "tls-alpn":
+			if len(dm.NetworkInfo.Tls.Alpn) == 0 {
+				s.WriteString("-")
+			} else {
+				s.WriteString(strings.Join(dm.NetworkInfo.Tls.Alpn, ","))
+			}
+		case directive == "tls-version":
+			s.WriteString(dm.NetworkInfo.Tls.Version)
+		case directive == "tls-client-bytes":
+			s.WriteString(strconv.Itoa(dm.NetworkInfo.Tls.ClientBytes))
+		case directive == "tls-server-bytes":
+			s.WriteString(strconv.Itoa(dm.NetworkInfo.Tls.ServerBytes))
+		}
+	}
+}
+
 func (dm *DnsMessage) handleGeoIPDirectives(directives []string, s *strings.Builder) {
 	if dm.Geo == nil {
 		s.WriteString("-")
@@ -537,6 +707,16 @@ func (dm *DnsMessage) Bytes(format []string, fieldDelimiter string, fieldBoundar
 			s.WriteString(dm.DNS.Type)
 		case directive == "opcode":
 			s.WriteString(strconv.Itoa(dm.DNS.Opcode))
+		case directive == "opcode-name":
+			s.WriteString(dm.DNS.OpcodeName)
+		case directive == "qdcount":
+			s.WriteString(strconv.Itoa(dm.DNS.SectionCounts.Question))
+		case directive == "ancount":
+			s.WriteString(strconv.Itoa(dm.DNS.SectionCounts.Answer))
+		case directive == "nscount":
+			s.WriteString(strconv.Itoa(dm.DNS.SectionCounts.Authority))
+		case directive == "arcount":
+			s.WriteString(strconv.Itoa(dm.DNS.SectionCounts.Additional))
 		case directive == "tr":
 			if dm.NetworkInfo.TcpReassembled {
 				s.WriteString("TR")
@@ -573,9 +753,33 @@ func (dm *DnsMessage) Bytes(format []string, fieldDelimiter string, fieldBoundar
 			} else {
 				s.WriteByte('-')
 			}
+		case directive == "rd":
+			if dm.DNS.Flags.RD {
+				s.WriteString("RD")
+			} else {
+				s.WriteByte('-')
+			}
+		case directive == "cd":
+			if dm.DNS.Flags.CD {
+				s.WriteString("CD")
+			} else {
+				s.WriteByte('-')
+			}
+		case directive == "z":
+			if dm.DNS.Flags.Z {
+				s.WriteString("Z")
+			} else {
+				s.WriteByte('-')
+			}
 		// more directives from collectors
 		case PdnsDirectives.MatchString(directive):
 			dm.handlePdnsDirectives(directives, &s)
+		case TlsDirectives.MatchString(directive):
+			dm.handleTlsDirectives(directives, &s)
+		case TzspDirectives.MatchString(directive):
+			dm.handleTzspDirectives(directives, &s)
+		case ZoneDirectives.MatchString(directive):
+			dm.handleZoneDirectives(directives, &s, fieldDelimiter, fieldBoundary)
 		// more directives from transformers
 		case ReducerDirectives.MatchString(directive):
 			dm.handleReducerDirectives(directives, &s)
@@ -622,11 +826,320 @@ func (dm *DnsMessage) ToFlattenJson() (string, error) {
 	return buffer.String(), nil
 }
 
+func (dm *DnsMessage) FromJson(payload []byte) error {
+	if err := json.Unmarshal(payload, dm); err != nil {
+		return err
+	}
+	dm.restoreHiddenFields()
+	return nil
+}
+
+func (dm *DnsMessage) FromFlattenJson(payload []byte) error {
+	var flatten map[string]interface{}
+	if err := json.Unmarshal(payload, &flatten); err != nil {
+		return err
+	}
+
+	nested, err := flat.Unflatten(flatten, nil)
+	if err != nil {
+		return err
+	}
+
+	// the flat library rebuilds arrays as maps indexed by position
+	tmp, err := json.Marshal(unflattenArrays(nested))
+	if err != nil {
+		return err
+	}
+	return dm.FromJson(tmp)
+}
+
+func (dm *DnsMessage) FromMsgpack(payload []byte) error {
+	if err := msgpack.Unmarshal(payload, dm); err != nil {
+		return err
+	}
+	dm.restoreHiddenFields()
+	return nil
+}
+
+// splitTextFields splits a line produced by the text format, fields containing
+// the delimiter are surrounded by the boundary one
+func splitTextFields(line string, fieldDelimiter string, fieldBoundary string) []string {
+	fields := []string{}
+	for {
+		var field strings.Builder
+		if len(fieldBoundary) > 0 && strings.HasPrefix(line, fieldBoundary) {
+			line = line[len(fieldBoundary):]
+			for len(line) > 0 && !strings.HasPrefix(line, fieldBoundary) {
+				if strings.HasPrefix(line, "\\"+fieldBoundary) {
+					field.WriteString(fieldBoundary)
+					line = line[1+len(fieldBoundary):]
+					continue
+				}
+				field.WriteByte(line[0])
+				line = line[1:]
+			}
+			line = strings.TrimPrefix(line, fieldBoundary)
+			fields = append(fields, field.String())
+		} else {
+			i := strings.Index(line, fieldDelimiter)
+			if len(fieldDelimiter) == 0 || i == -1 {
+				return append(fields, line)
+			}
+			fields = append(fields, line[:i])
+			line = line[i:]
+		}
+
+		if !strings.HasPrefix(line, fieldDelimiter) || len(fieldDelimiter) == 0 {
+			return fields
+		}
+		line = line[len(fieldDelimiter):]
+	}
+}
+
+// FromText decodes a line produced by the text format, directives which can not
+// be reversed (answercount, transformers and collectors directives) are ignored
+func (dm *DnsMessage) FromText(line string, format []string, fieldDelimiter string, fieldBoundary string) error {
+	fields := splitTextFields(strings.TrimRight(line, "\r\n"), fieldDelimiter, fieldBoundary)
+	if len(fields) != len(format) {
+		return fmt.Errorf("invalid number of fields, want %d got %d", len(format), len(fields))
+	}
+
+	answer := DnsAnswer{Rdata: "-"}
+	for i, word := range format {
+		value := fields[i]
+		directives := strings.SplitN(word, ":", 2)
+		switch directive := directives[0]; {
+		case directive == "ttl":
+			answer.Ttl, _ = strconv.Atoi(value)
+		case directive == "answer":
+			answer.Rdata = value
+		case directive == "edns-csubnet":
+			if value != "-" {
+				dm.EDNS.Options = append(dm.EDNS.Options, DnsOption{Code: 0x0008, Name: "CSUBNET", Data: value})
+			}
+		case directive == "id":
+			dm.DNS.Id, _ = strconv.Atoi(value)
+		case directive == "timestamp-rfc3339ns", directive == "timestamp":
+			dm.DnsTap.TimestampRFC3339 = value
+		case directive == "timestamp-unixms":
+			if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+				dm.DnsTap.Timestamp = ts * 1000000
+			}
+		case directive == "timestamp-unixus":
+			if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+				dm.DnsTap.Timestamp = ts * 1000
+			}
+		case directive == "timestamp-unixns":
+			if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+				dm.DnsTap.Timestamp = ts
+			}
+		case directive == "localtime":
+			if ts, err := time.ParseInLocation("2006-01-02 15:04:05.999999999", value, time.Local); err == nil {
+				dm.DnsTap.Timestamp = ts.UnixNano()
+			}
+		case directive == "identity":
+			dm.DnsTap.Identity = value
+		case directive == "version":
+			dm.DnsTap.Version = value
+		case directive == "extra":
+			dm.DnsTap.Extra = value
+		case directive == "operation":
+			dm.DnsTap.Operation = value
+		case directive == "rcode":
+			dm.DNS.Rcode = value
+		case directive == "queryip":
+			dm.NetworkInfo.QueryIp = value
+		case directive == "queryport":
+			dm.NetworkInfo.QueryPort = value
+		case directive == "responseip":
+			dm.NetworkInfo.ResponseIp = value
+		case directive == "responseport":
+			dm.NetworkInfo.ResponsePort = value
+		case directive == "family":
+			dm.NetworkInfo.Family = value
+		case directive == "protocol":
+			dm.NetworkInfo.Protocol = value
+		case directive == "length":
+			dm.DNS.Length, _ = strconv.Atoi(strings.TrimSuffix(value, "b"))
+		case directive == "qname":
+			if value == "." {
+				dm.DNS.Qname = ""
+			} else {
+				dm.DNS.Qname = value
+			}
+		case directive == "qtype":
+			dm.DNS.Qtype = value
+		case directive == "latency":
+			dm.DnsTap.LatencySec = value
+		case directive == "malformed":
+			dm.DNS.MalformedPacket = value == "PKTERR"
+		case directive == "qr":
+			dm.DNS.Type = value
+		case directive == "opcode":
+			dm.DNS.Opcode, _ = strconv.Atoi(value)
+		case directive == "opcode-name":
+			dm.DNS.OpcodeName = value
+		case directive == "qdcount":
+			dm.DNS.SectionCounts.Question, _ = strconv.Atoi(value)
+		case directive == "ancount":
+			dm.DNS.SectionCounts.Answer, _ = strconv.Atoi(value)
+		case directive == "nscount":
+			dm.DNS.SectionCounts.Authority, _ = strconv.Atoi(value)
+		case directive == "arcount":
+			dm.DNS.SectionCounts.Additional, _ = strconv.Atoi(value)
+		case directive == "tr":
+			dm.NetworkInfo.TcpReassembled = value == "TR"
+		case directive == "df":
+			dm.NetworkInfo.IpDefragmented = value == "DF"
+		case directive == "tc":
+			dm.DNS.Flags.TC = value == "TC"
+		case directive == "aa":
+			dm.DNS.Flags.AA = value == "AA"
+		case directive == "ra":
+			dm.DNS.Flags.RA = value == "RA"
+		case directive == "ad":
+			dm.DNS.Flags.AD = value == "AD"
+		case directive == "rd":
+			dm.DNS.Flags.RD = value == "RD"
+		case directive == "cd":
+			dm.DNS.Flags.CD = value == "CD"
+		case directive == "z":
+			dm.DNS.Flags.Z = value == "Z"
+		}
+	}
+
+	// only the first answer is available in the text format
+	if answer.Rdata != "-" {
+		answer.Name = dm.DNS.Qname
+		answer.Rdatatype = dm.DNS.Qtype
+		dm.DNS.DnsRRs.Answers = append(dm.DNS.DnsRRs.Answers, answer)
+	}
+
+	dm.restoreHiddenFields()
+	return nil
+}
+
+// restoreHiddenFields computes the fields not exported in the JSON encoding
+// (dns type, timestamps, latency and payload) from the decoded ones
+func (dm *DnsMessage) restoreHiddenFields() {
+	switch {
+	case dm.DNS.Type == DnsQuery, dm.DNS.Type == DnsReply:
+	case strings.HasSuffix(dm.DnsTap.Operation, "_RESPONSE"), dm.DNS.Flags.QR:
+		dm.DNS.Type = DnsReply
+	default:
+		dm.DNS.Type = DnsQuery
+	}
+
+	if ts, err := time.Parse(time.RFC3339Nano, dm.DnsTap.TimestampRFC3339); err == nil {
+		dm.DnsTap.Timestamp = ts.UnixNano()
+	}
+	if dm.DnsTap.Timestamp > 0 {
+		ts := time.Unix(0, dm.DnsTap.Timestamp)
+		dm.DnsTap.TimeSec = int(ts.Unix())
+		dm.DnsTap.TimeNsec = ts.Nanosecond()
+		dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
+	}
+
+	if latency, err := strconv.ParseFloat(dm.DnsTap.LatencySec, 64); err == nil {
+		dm.DnsTap.Latency = latency
+	}
+
+	if dm.Extracted != nil && len(dm.Extracted.Base64Payload) > 0 {
+		dm.DNS.Payload = dm.Extracted.Base64Payload
+	}
+}
+
+func unflattenArrays(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+
+	for k, item := range m {
+		m[k] = unflattenArrays(item)
+	}
+
+	if len(m) == 0 {
+		return m
+	}
+	arr := make([]interface{}, len(m))
+	for k, item := range m {
+		i, err := strconv.Atoi(k)
+		if err != nil || i < 0 || i >= len(m) {
+			return m
+		}
+		arr[i] = item
+	}
+	return arr
+}
+
+// EncodeDnsPayload rebuilds a dns payload from the decoded fields, for the messages
+// received without payload (json, text...). The records which can not be
+// parsed are ignored, the section counts are the ones of the rebuilt payload.
+func (dm *DnsMessage) EncodeDnsPayload() ([]byte, error) {
+	msg := new(dns.Msg)
+	msg.Id = uint16(dm.DNS.Id)
+	msg.Opcode = dm.DNS.Opcode
+	msg.Response = dm.DNS.Flags.QR || dm.DNS.Type == DnsReply
+	msg.Truncated = dm.DNS.Flags.TC
+	msg.Authoritative = dm.DNS.Flags.AA
+	msg.RecursionAvailable = dm.DNS.Flags.RA
+	msg.AuthenticatedData = dm.DNS.Flags.AD
+	msg.RecursionDesired = dm.DNS.Flags.RD
+	msg.CheckingDisabled = dm.DNS.Flags.CD
+	msg.Zero = dm.DNS.Flags.Z
+	for rcode, name := range Rcodes {
+		// the extended rcodes require the edns record
+		if name == dm.DNS.Rcode && rcode <= 0xF {
+			msg.Rcode = rcode
+		}
+	}
+
+	if dm.DNS.Qname != "-" {
+		msg.Question = []dns.Question{{Name: dns.Fqdn(dm.DNS.Qname), Qtype: dns.StringToType[dm.DNS.Qtype], Qclass: dns.ClassINET}}
+	}
+
+	toRRs := func(answers []DnsAnswer) []dns.RR {
+		rrs := []dns.RR{}
+		for _, answer := range answers {
+			rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(answer.Name), answer.Ttl, answer.Rdatatype, answer.Rdata))
+			if err == nil && rr != nil {
+				rrs = append(rrs, rr)
+			}
+		}
+		return rrs
+	}
+	msg.Answer = toRRs(dm.DNS.DnsRRs.Answers)
+	msg.Ns = toRRs(dm.DNS.DnsRRs.Nameservers)
+	msg.Extra = toRRs(dm.DNS.DnsRRs.Records)
+
+	if dm.EDNS.UdpSize > 0 {
+		opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+		opt.SetUDPSize(uint16(dm.EDNS.UdpSize))
+		opt.SetVersion(uint8(dm.EDNS.Version))
+		if dm.EDNS.Do == 1 {
+			opt.SetDo()
+		}
+		msg.Extra = append(msg.Extra, opt)
+	}
+
+	return msg.Pack()
+}
+
 func (dm *DnsMessage) ToDnstap() ([]byte, error) {
 	if len(dm.DnsTap.Payload) > 0 {
 		return dm.DnsTap.Payload, nil
 	}
 
+	// the payload is rebuilt to carry the header and the records
+	payload := dm.DNS.Payload
+	if len(payload) == 0 {
+		var err error
+		if payload, err = dm.EncodeDnsPayload(); err != nil {
+			return nil, err
+		}
+	}
+
 	dt := &dnstap.Dnstap{}
 	t := dnstap.Dnstap_MESSAGE
 	dt.Identity = []byte(dm.DnsTap.Identity)
@@ -683,13 +1196,13 @@ func (dm *DnsMessage) ToDnstap() ([]byte, error) {
 	msg.ResponsePort = &rport
 
 	if dm.DNS.Type == DnsQuery {
-		msg.QueryMessage = dm.DNS.Payload
+		msg.QueryMessage = payload
 		msg.QueryTimeSec = &tsec
 		msg.QueryTimeNsec = &tnsec
 	} else {
 		msg.ResponseTimeSec = &tsec
 		msg.ResponseTimeNsec = &tnsec
-		msg.ResponseMessage = dm.DNS.Payload
+		msg.ResponseMessage = payload
 	}
 
 	dt.Message = msg
@@ -5,6 +5,10 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/dmachard/go-dnstap-protobuf"
+	"github.com/vmihailenco/msgpack"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestDnsMessage_Json_Reference(t *testing.T) {
@@ -26,6 +30,7 @@ func TestDnsMessage_Json_Reference(t *testing.T) {
 				"dns": {
 				  "length": 0,
 				  "opcode": 0,
+				  "opcode-name": "-",
 				  "rcode": "-",
 				  "qname": "-",
 				  "qtype": "-",
@@ -34,7 +39,16 @@ func TestDnsMessage_Json_Reference(t *testing.T) {
 					"tc": false,
 					"aa": false,
 					"ra": false,
-					"ad": false
+					"ad": false,
+					"rd": false,
+					"cd": false,
+					"z": false
+				  },
+				  "counts": {
+					"qd": 0,
+					"an": 0,
+					"ns": 0,
+					"ar": 0
 				  },
 				  "resource-records": {
 					"an": [],
@@ -85,14 +99,22 @@ func TestDnsMessage_Json_Flatten_Reference(t *testing.T) {
 
 	refJson := `
 				{
+					"dns.counts.an": 0,
+					"dns.counts.ar": 0,
+					"dns.counts.ns": 0,
+					"dns.counts.qd": 0,
 					"dns.flags.aa": false,
 					"dns.flags.ad": false,
+					"dns.flags.cd": false,
 					"dns.flags.qr": false,
 					"dns.flags.ra": false,
+					"dns.flags.rd": false,
 					"dns.flags.tc": false,
+					"dns.flags.z": false,
 					"dns.length": 0,
 					"dns.malformed-packet": false,
 					"dns.opcode": 0,
+					"dns.opcode-name": "-",
 					"dns.qname": "-",
 					"dns.qtype": "-",
 					"dns.rcode": "-",
@@ -138,6 +160,145 @@ func TestDnsMessage_Json_Flatten_Reference(t *testing.T) {
 
 }
 
+func TestDnsMessage_Json_Decode(t *testing.T) {
+	dm := GetFakeDnsMessage()
+	dm.DnsTap.Operation = DNSTAP_CLIENT_RESPONSE
+	dm.DnsTap.TimestampRFC3339 = "2023-03-31T10:14:46.664534902Z"
+	dm.DnsTap.LatencySec = "0.000125"
+	dm.DNS.DnsRRs.Answers = append(dm.DNS.DnsRRs.Answers, DnsAnswer{Name: "dns.collector", Rdatatype: "A", Ttl: 300, Rdata: "1.2.3.4"})
+	dm.Geo = &TransformDnsGeo{CountryIsoCode: "FR"}
+
+	decoded := DnsMessage{}
+	decoded.Init()
+	if err := decoded.FromJson([]byte(dm.ToJson())); err != nil {
+		t.Fatalf("could not decode json: %s\n", err)
+	}
+
+	if decoded.DNS.Qname != dm.DNS.Qname || decoded.NetworkInfo.QueryIp != dm.NetworkInfo.QueryIp {
+		t.Errorf("invalid dns message decoded: %v", decoded)
+	}
+	if decoded.DNS.Type != DnsReply {
+		t.Errorf("dns type invalid want: %s got: %s", DnsReply, decoded.DNS.Type)
+	}
+	if decoded.DnsTap.Timestamp != 1680257686664534902 || decoded.DnsTap.TimeNsec != 664534902 {
+		t.Errorf("invalid timestamp decoded: %d", decoded.DnsTap.Timestamp)
+	}
+	if decoded.DnsTap.Latency != 0.000125 {
+		t.Errorf("invalid latency decoded: %f", decoded.DnsTap.Latency)
+	}
+	if len(decoded.DNS.DnsRRs.Answers) != 1 || decoded.DNS.DnsRRs.Answers[0].Rdata != "1.2.3.4" {
+		t.Errorf("invalid answers decoded: %v", decoded.DNS.DnsRRs.Answers)
+	}
+	if decoded.Geo == nil || decoded.Geo.CountryIsoCode != "FR" {
+		t.Errorf("geoip metadata not decoded")
+	}
+}
+
+func TestDnsMessage_Json_Flatten_Decode(t *testing.T) {
+	dm := GetFakeDnsMessage()
+	dm.DNS.DnsRRs.Answers = append(dm.DNS.DnsRRs.Answers,
+		DnsAnswer{Name: "dns.collector", Rdatatype: "A", Ttl: 300, Rdata: "1.2.3.4"},
+		DnsAnswer{Name: "dns.collector", Rdatatype: "A", Ttl: 300, Rdata: "4.3.2.1"},
+	)
+	dm.EDNS.Options = append(dm.EDNS.Options, DnsOption{Code: 8, Name: "CSUBNET", Data: "1.2.3.0/24"})
+
+	flatJson, err := dm.ToFlattenJson()
+	if err != nil {
+		t.Fatalf("could not flat json: %s\n", err)
+	}
+
+	decoded := DnsMessage{}
+	decoded.Init()
+	if err := decoded.FromFlattenJson([]byte(flatJson)); err != nil {
+		t.Fatalf("could not decode flat json: %s\n", err)
+	}
+
+	if decoded.DNS.Type != DnsQuery {
+		t.Errorf("dns type invalid want: %s got: %s", DnsQuery, decoded.DNS.Type)
+	}
+	if !reflect.DeepEqual(decoded.DNS.DnsRRs, dm.DNS.DnsRRs) {
+		t.Errorf("resource records different, want: %v got: %v", dm.DNS.DnsRRs, decoded.DNS.DnsRRs)
+	}
+	if !reflect.DeepEqual(decoded.EDNS, dm.EDNS) {
+		t.Errorf("edns different, want: %v got: %v", dm.EDNS, decoded.EDNS)
+	}
+	if decoded.NetworkInfo != dm.NetworkInfo {
+		t.Errorf("network info different, want: %v got: %v", dm.NetworkInfo, decoded.NetworkInfo)
+	}
+}
+
+func TestDnsMessage_Msgpack_Decode(t *testing.T) {
+	dm := GetFakeDnsMessage()
+	dm.DnsTap.TimestampRFC3339 = "2023-03-31T10:14:46.664534902Z"
+	dm.DNS.DnsRRs.Answers = append(dm.DNS.DnsRRs.Answers, DnsAnswer{Name: "dns.collector", Rdatatype: "A", Ttl: 300, Rdata: "1.2.3.4"})
+
+	payload, err := msgpack.Marshal(dm)
+	if err != nil {
+		t.Fatalf("could not encode msgpack: %s\n", err)
+	}
+
+	decoded := DnsMessage{}
+	decoded.Init()
+	if err := decoded.FromMsgpack(payload); err != nil {
+		t.Fatalf("could not decode msgpack: %s\n", err)
+	}
+
+	if decoded.DNS.Qname != dm.DNS.Qname {
+		t.Errorf("qname invalid want: %s got: %s", dm.DNS.Qname, decoded.DNS.Qname)
+	}
+	if !reflect.DeepEqual(decoded.DNS.DnsRRs, dm.DNS.DnsRRs) {
+		t.Errorf("resource records different, want: %v got: %v", dm.DNS.DnsRRs, decoded.DNS.DnsRRs)
+	}
+	if decoded.DnsTap.TimeSec != 1680257686 {
+		t.Errorf("time sec invalid want: %d got: %d", 1680257686, decoded.DnsTap.TimeSec)
+	}
+}
+
+func TestDnsMessage_TextFormat_Decode(t *testing.T) {
+	config := GetFakeConfig()
+
+	dm := GetFakeDnsMessage()
+	dm.DnsTap.TimestampRFC3339 = "2023-03-31T10:14:46.664534902Z"
+	dm.DNS.Qname = "dns collector.\"test\""
+	dm.DNS.Flags.AA = true
+	dm.DNS.Flags.CD = true
+	dm.DNS.SectionCounts = DnsSectionCounts{Question: 1, Answer: 1}
+	dm.DNS.DnsRRs.Answers = append(dm.DNS.DnsRRs.Answers, DnsAnswer{Name: dm.DNS.Qname, Rdatatype: "A", Ttl: 300, Rdata: "1.2.3.4"})
+
+	format := strings.Fields(config.Global.TextFormat + " aa rd cd qdcount ancount ttl answer")
+	line := dm.String(format, config.Global.TextFormatDelimiter, config.Global.TextFormatBoundary)
+
+	decoded := DnsMessage{}
+	decoded.Init()
+	if err := decoded.FromText(line, format, config.Global.TextFormatDelimiter, config.Global.TextFormatBoundary); err != nil {
+		t.Fatalf("could not decode text: %s\n", err)
+	}
+
+	if decoded.DNS.Qname != dm.DNS.Qname {
+		t.Errorf("qname invalid want: %s got: %s", dm.DNS.Qname, decoded.DNS.Qname)
+	}
+	if decoded.NetworkInfo.QueryIp != dm.NetworkInfo.QueryIp || decoded.NetworkInfo.QueryPort != dm.NetworkInfo.QueryPort {
+		t.Errorf("invalid query ip/port decoded: %v", decoded.NetworkInfo)
+	}
+	if decoded.DnsTap.Timestamp != 1680257686664534902 {
+		t.Errorf("invalid timestamp decoded: %d", decoded.DnsTap.Timestamp)
+	}
+	if !decoded.DNS.Flags.AA || decoded.DNS.Flags.RD || !decoded.DNS.Flags.CD {
+		t.Errorf("flags not decoded: %+v", decoded.DNS.Flags)
+	}
+	if decoded.DNS.SectionCounts != dm.DNS.SectionCounts {
+		t.Errorf("section counts invalid want: %+v got: %+v", dm.DNS.SectionCounts, decoded.DNS.SectionCounts)
+	}
+	if !reflect.DeepEqual(decoded.DNS.DnsRRs.Answers, dm.DNS.DnsRRs.Answers) {
+		t.Errorf("answers invalid want: %v got: %v", dm.DNS.DnsRRs.Answers, decoded.DNS.DnsRRs.Answers)
+	}
+
+	// the number of fields must match the format
+	if err := decoded.FromText("a b", format, config.Global.TextFormatDelimiter, config.Global.TextFormatBoundary); err == nil {
+		t.Errorf("error expected with invalid number of fields")
+	}
+}
+
 func TestDnsMessage_TextFormat_ToString(t *testing.T) {
 
 	config := GetFakeConfig()
@@ -261,6 +422,21 @@ func TestDnsMessage_TextFormat_DefaultDirectives(t *testing.T) {
 			dm:       DnsMessage{DNS: Dns{Flags: DnsFlags{TC: true, AA: true, RA: true, AD: true}}},
 			expected: "TC AA RA AD",
 		},
+		{
+			format:   "rd cd z",
+			dm:       DnsMessage{DNS: Dns{Flags: DnsFlags{RD: true, CD: true, Z: true}}},
+			expected: "RD CD Z",
+		},
+		{
+			format:   "rd cd z",
+			dm:       DnsMessage{DNS: Dns{Flags: DnsFlags{}}},
+			expected: "- - -",
+		},
+		{
+			format:   "opcode-name qdcount ancount nscount arcount",
+			dm:       DnsMessage{DNS: Dns{OpcodeName: "NOTIFY", SectionCounts: DnsSectionCounts{Question: 1, Answer: 2, Authority: 3, Additional: 4}}},
+			expected: "NOTIFY 1 2 3 4",
+		},
 		{
 			format:   "df tr",
 			dm:       DnsMessage{NetworkInfo: DnsNetInfo{IpDefragmented: true, TcpReassembled: true}},
@@ -363,6 +539,146 @@ func TestDnsMessage_TextFormat_Directives_Geo(t *testing.T) {
 	}
 }
 
+func TestDnsMessage_TextFormat_Directives_Tls(t *testing.T) {
+	config := GetFakeConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DnsMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "tls-sni",
+			dm:       DnsMessage{},
+			expected: "-",
+		},
+		{
+			name:   "default",
+			format: "tls-event tls-sni tls-alpn tls-version tls-client-bytes tls-server-bytes",
+			dm: DnsMessage{NetworkInfo: DnsNetInfo{Tls: &DnsTls{Event: "CLOSE", ServerName: "dns.collector", Alpn: []string{"h2", "http/1.1"},
+				Version: "TLS1.3", ClientBytes: 517, ServerBytes: 3120}}},
+			expected: "CLOSE dns.collector h2,http/1.1 TLS1.3 517 3120",
+		},
+		{
+			name:     "without sni and alpn",
+			format:   "tls-sni tls-alpn tls-version",
+			dm:       DnsMessage{NetworkInfo: DnsNetInfo{Tls: &DnsTls{Version: "TLS1.2"}}},
+			expected: "- - TLS1.2",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
+func TestDnsMessage_TextFormat_Directives_Tzsp(t *testing.T) {
+	config := GetFakeConfig()
+
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DnsMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "tzsp-sensor",
+			dm:       DnsMessage{},
+			expected: "-",
+		},
+		{
+			name:   "default",
+			format: "tzsp-sensor tzsp-sensor-mac tzsp-encapsulation tzsp-timestamp",
+			dm: DnsMessage{NetworkInfo: DnsNetInfo{Tzsp: &DnsTzsp{Sensor: "192.168.1.1", SensorMac: "00:0c:29:8a:5d:d7",
+				Encapsulation: "ethernet", Timestamp: 1234}}},
+			expected: "192.168.1.1 00:0c:29:8a:5d:d7 ethernet 1234",
+		},
+		{
+			name:     "without sensor mac",
+			format:   "tzsp-sensor-mac tzsp-encapsulation",
+			dm:       DnsMessage{NetworkInfo: DnsNetInfo{Tzsp: &DnsTzsp{Sensor: "192.168.1.1", Encapsulation: "raw-ip"}}},
+			expected: "- raw-ip",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
+func TestDnsMessage_TextFormat_Directives_Zone(t *testing.T) {
+	config := GetFakeConfig()
+
+	update := DnsUpdateRecord{Operation: "add", Name: "host.example.com", Rdatatype: "A", Class: "IN", Ttl: 300, Rdata: "192.0.2.1"}
+	testcases := []struct {
+		name     string
+		format   string
+		dm       DnsMessage
+		expected string
+	}{
+		{
+			name:     "undefined",
+			format:   "zone-name",
+			dm:       DnsMessage{},
+			expected: "-",
+		},
+		{
+			name:   "update",
+			format: "zone-name zone-transfer zone-prerequisites zone-updates zone-update",
+			dm: DnsMessage{DNS: Dns{Zone: &DnsZone{Name: "example.com", Transfer: "-",
+				Prerequisites: []DnsUpdateRecord{}, Updates: []DnsUpdateRecord{update}}}},
+			expected: "example.com - 0 1 \"add host.example.com 300 IN A 192.0.2.1\"",
+		},
+		{
+			name:   "several updates",
+			format: "zone-updates zone-update zone-update:1 zone-update:2",
+			dm: DnsMessage{DNS: Dns{Zone: &DnsZone{Name: "example.com", Transfer: "-", Prerequisites: []DnsUpdateRecord{},
+				Updates: []DnsUpdateRecord{update, {Operation: "delete-rrset", Name: "old.example.com", Class: "ANY", Rdatatype: "A", Rdata: "-"}}}}},
+			expected: "2 \"add host.example.com 300 IN A 192.0.2.1,delete-rrset old.example.com 0 ANY A -\" \"delete-rrset old.example.com 0 ANY A -\" -",
+		},
+		{
+			name:     "transfer",
+			format:   "zone-name zone-serial zone-transfer zone-update",
+			dm:       DnsMessage{DNS: Dns{Zone: &DnsZone{Name: "example.com", Serial: 2023010101, Transfer: "AXFR"}}},
+			expected: "example.com 2023010101 AXFR -",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := tc.dm.String(
+				strings.Fields(tc.format),
+				config.Global.TextFormatDelimiter,
+				config.Global.TextFormatBoundary,
+			)
+			if line != tc.expected {
+				t.Errorf("Want: %s, got: %s", tc.expected, line)
+			}
+		})
+	}
+}
+
 func TestDnsMessage_TextFormat_Directives_Pdns(t *testing.T) {
 	config := GetFakeConfig()
 
@@ -573,3 +889,49 @@ func TestDnsMessage_TextFormat_Directives_Extracted(t *testing.T) {
 		})
 	}
 }
+
+func TestDnsMessage_ToDnstap_WithoutPayload(t *testing.T) {
+	dm := GetFakeDnsMessage()
+	dm.NetworkInfo.Family = PROTO_IPV4
+	dm.NetworkInfo.Protocol = PROTO_UDP
+	dm.DnsTap.Operation = "CLIENT_RESPONSE"
+	dm.DNS.Type = DnsReply
+	dm.DNS.Id = 42
+	dm.DNS.Flags = DnsFlags{QR: true, RA: true, RD: false, CD: true}
+	dm.DNS.DnsRRs.Answers = append(dm.DNS.DnsRRs.Answers, DnsAnswer{Name: "dns.collector", Rdatatype: "A", Ttl: 300, Rdata: "1.2.3.4"})
+	dm.EDNS.UdpSize = 1232
+
+	data, err := dm.ToDnstap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dt := &dnstap.Dnstap{}
+	if err := proto.Unmarshal(data, dt); err != nil {
+		t.Fatal(err)
+	}
+
+	// the header and the records are carried by the rebuilt payload
+	decoded := DnsMessage{}
+	decoded.Init()
+	decoded.DNS.Payload = dt.GetMessage().GetResponseMessage()
+	header, err := DecodeDns(decoded.DNS.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodePayload(&decoded, &header, GetFakeConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.DNS.Id != 42 || decoded.DNS.Flags != dm.DNS.Flags || decoded.DNS.OpcodeName != "QUERY" {
+		t.Errorf("invalid header: %d %+v %s", decoded.DNS.Id, decoded.DNS.Flags, decoded.DNS.OpcodeName)
+	}
+	if decoded.DNS.SectionCounts != (DnsSectionCounts{Question: 1, Answer: 1, Additional: 1}) {
+		t.Errorf("invalid section counts: %+v", decoded.DNS.SectionCounts)
+	}
+	if decoded.DNS.Qname != "dns.collector" || decoded.DNS.Rcode != "NOERROR" || decoded.EDNS.UdpSize != 1232 {
+		t.Errorf("invalid message: %s %s %d", decoded.DNS.Qname, decoded.DNS.Rcode, decoded.EDNS.UdpSize)
+	}
+	if !reflect.DeepEqual(decoded.DNS.DnsRRs.Answers, []DnsAnswer{{Name: "dns.collector", Rdatatype: "A", Class: 1, Ttl: 300, Rdata: "1.2.3.4"}}) {
+		t.Errorf("invalid answers: %+v", decoded.DNS.DnsRRs.Answers)
+	}
+}
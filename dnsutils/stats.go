@@ -0,0 +1,43 @@
+package dnsutils
+
+import "sync"
+
+// CollectorStats returns the internal counters of a collector, by counter name,
+// as the packets dropped by the kernel
+type CollectorStats func() map[string]uint64
+
+var (
+	collectorStatsLock sync.RWMutex
+	collectorStats     = make(map[string]CollectorStats)
+)
+
+// RegisterCollectorStats exposes the counters of the collector to the loggers
+// reporting metrics, the counters of a previous collector with the same name are replaced
+func RegisterCollectorStats(name string, stats CollectorStats) {
+	collectorStatsLock.Lock()
+	defer collectorStatsLock.Unlock()
+	collectorStats[name] = stats
+}
+
+func UnregisterCollectorStats(name string) {
+	collectorStatsLock.Lock()
+	defer collectorStatsLock.Unlock()
+	delete(collectorStats, name)
+}
+
+// GetCollectorStats returns the counters of all the registered collectors, by collector name
+func GetCollectorStats() map[string]map[string]uint64 {
+	// the counters are read without the lock, a collector can take some time to collect them
+	collectorStatsLock.RLock()
+	funcs := make(map[string]CollectorStats, len(collectorStats))
+	for name, f := range collectorStats {
+		funcs[name] = f
+	}
+	collectorStatsLock.RUnlock()
+
+	stats := make(map[string]map[string]uint64, len(funcs))
+	for name, f := range funcs {
+		stats[name] = f()
+	}
+	return stats
+}
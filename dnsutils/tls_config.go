@@ -71,3 +71,38 @@ func TlsClientConfig(options TlsOptions) (*tls.Config, error) {
 
 	return tlsConfig, nil
 }
+
+func TlsServerConfig(options TlsOptions) (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	cer, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate failed: %w", err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{cer}
+
+	// client certificate is required and verified with the provided CA
+	if len(options.CAFile) > 0 {
+		CAs := x509.NewCertPool()
+		pemData, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA certificate %q: %w", options.CAFile, err)
+		}
+		if !CAs.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("failed to append certificates from PEM file: %q", options.CAFile)
+		}
+		tlsConfig.ClientCAs = CAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	if tlsVersion, ok := TLS_VERSION[options.MinVersion]; ok {
+		tlsConfig.MinVersion = tlsVersion
+	} else {
+		return nil, fmt.Errorf("invalid minimum TLS version: %x", options.MinVersion)
+	}
+
+	return tlsConfig, nil
+}
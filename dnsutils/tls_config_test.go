@@ -24,3 +24,26 @@ func TestConfigClientTLSNoVerify(t *testing.T) {
 		t.Fatal("Somehow client certificates were set")
 	}
 }
+
+func TestConfigServerTLS(t *testing.T) {
+	tlsConfig, err := TlsServerConfig(TlsOptions{
+		CertFile:   "../testsdata/certs/server.crt",
+		KeyFile:    "../testsdata/certs/server.key",
+		CAFile:     "../testsdata/certs/ca.crt",
+		MinVersion: TLS_v13,
+	})
+
+	if err != nil || tlsConfig == nil {
+		t.Fatal("Unable to configure server TLS", err)
+	}
+
+	if len(tlsConfig.Certificates) != 1 {
+		t.Fatal("Server certificate not loaded")
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS13 {
+		t.Fatal("Unexpected server TLS version")
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatal("Client certificate verification not enabled")
+	}
+}
@@ -1032,6 +1032,33 @@ func (o *Prometheus) InitProm() {
 		o.catalogueLabels,
 	)
 	o.promRegistry.MustRegister(o.histogramLatencies)
+
+	// internal counters of the collectors
+	o.promRegistry.MustRegister(&CollectorStatsExporter{prefix: prom_prefix})
+}
+
+/*
+CollectorStatsExporter exports the internal counters registered by the collectors,
+as <prefix>_collector_<counter>_total{collector="<name>"}.
+The counters are not known in advance, the exporter is an unchecked collector.
+*/
+type CollectorStatsExporter struct {
+	prefix string
+}
+
+func (e *CollectorStatsExporter) Describe(ch chan<- *prometheus.Desc) {}
+
+func (e *CollectorStatsExporter) Collect(ch chan<- prometheus.Metric) {
+	for collector, counters := range dnsutils.GetCollectorStats() {
+		for counter, value := range counters {
+			desc := prometheus.NewDesc(
+				fmt.Sprintf("%s_collector_%s_total", e.prefix, SanitizeMetricName(counter)),
+				fmt.Sprintf("Collector internal counter %s", counter),
+				[]string{"collector"}, nil,
+			)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), collector)
+		}
+	}
 }
 
 func (o *Prometheus) ReadConfig() {
@@ -1072,6 +1099,11 @@ func (o *Prometheus) Stop() {
 }
 
 func (o *Prometheus) Record(dm dnsutils.DnsMessage) {
+	// the tls events are not dns messages
+	if dm.IsTlsEvent() {
+		return
+	}
+
 	// record stream identity
 	o.Lock()
 
@@ -217,6 +217,46 @@ func TestPrometheus_BuildInfo(t *testing.T) {
 
 }
 
+func TestPrometheus_CollectorStats(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	g := NewPrometheus(config, logger.New(false), "test")
+
+	dnsutils.RegisterCollectorStats("sniffer", func() map[string]uint64 {
+		return map[string]uint64{"kernel_packets_dropped": 42}
+	})
+	defer dnsutils.UnregisterCollectorStats("sniffer")
+
+	mf := getMetrics(g, t)
+	if !ensureMetricValue(t, mf, "dnscollector_collector_kernel_packets_dropped_total", map[string]string{"collector": "sniffer"}, 42) {
+		t.Errorf("Cannot validate collector counter!")
+	}
+}
+
+func TestPrometheus_TlsEvents(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	g := NewPrometheus(config, logger.New(false), "test")
+
+	// one dns query
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Type = dnsutils.DnsQuery
+	g.Record(dm)
+
+	// the tls events of the encrypted dns connections are not counted
+	dm.DnsTap.Operation = dnsutils.DNSTAP_TLS_HANDSHAKE
+	dm.NetworkInfo.Tls = &dnsutils.DnsTls{Event: "HANDSHAKE"}
+	g.Record(dm)
+	dm.DnsTap.Operation = dnsutils.DNSTAP_TLS_CLOSE
+	g.Record(dm)
+
+	mf := getMetrics(g, t)
+	if !ensureMetricValue(t, mf, "dnscollector_dnsmessages_total", map[string]string{"stream_id": "collector"}, 1) {
+		t.Errorf("tls events counted as dns messages")
+	}
+	if !ensureMetricValue(t, mf, "dnscollector_queries_total", map[string]string{"stream_id": "collector"}, 1) {
+		t.Errorf("tls events counted as queries")
+	}
+}
+
 func TestPrometheus_ConfirmDifferentResolvers(t *testing.T) {
 	config := dnsutils.GetFakeConfig()
 	config.Loggers.Prometheus.LabelsList = []string{"resolver"}
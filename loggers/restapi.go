@@ -524,6 +524,11 @@ func (s *RestAPI) GetStreamsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *RestAPI) RecordDnsMessage(dm dnsutils.DnsMessage) {
+	// the tls events are not dns messages
+	if dm.IsTlsEvent() {
+		return
+	}
+
 	s.Lock()
 	defer s.Unlock()
 
@@ -311,3 +311,18 @@ func TestRestAPI_GetMethod(t *testing.T) {
 		})
 	}
 }
+
+func TestRestAPI_TlsEvents(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	g := NewRestAPI(config, logger.New(false), "test")
+
+	// the tls events of the encrypted dns connections are not counted
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DnsTap.Operation = dnsutils.DNSTAP_TLS_HANDSHAKE
+	dm.NetworkInfo.Tls = &dnsutils.DnsTls{Event: "HANDSHAKE"}
+	g.RecordDnsMessage(dm)
+
+	if len(g.Streams) != 0 || len(g.HitsUniq.Clients) != 0 || len(g.HitsUniq.Domains) != 0 {
+		t.Errorf("tls event recorded as a dns message")
+	}
+}
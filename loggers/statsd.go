@@ -118,6 +118,11 @@ func (o *StatsdClient) Stop() {
 }
 
 func (o *StatsdClient) RecordDnsMessage(dm dnsutils.DnsMessage) {
+	// the tls events are not dns messages
+	if dm.IsTlsEvent() {
+		return
+	}
+
 	o.Lock()
 	defer o.Unlock()
 
@@ -41,3 +41,18 @@ func TestStatsdRun(t *testing.T) {
 	}
 
 }
+
+func TestStatsd_TlsEvents(t *testing.T) {
+	config := dnsutils.GetFakeConfig()
+	g := NewStatsdClient(config, logger.New(false), "test")
+
+	// the tls events of the encrypted dns connections are not counted
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DnsTap.Operation = dnsutils.DNSTAP_TLS_CLOSE
+	dm.NetworkInfo.Tls = &dnsutils.DnsTls{Event: "CLOSE"}
+	g.RecordDnsMessage(dm)
+
+	if len(g.Stats.Streams) != 0 {
+		t.Errorf("tls event recorded as a dns message")
+	}
+}
@@ -0,0 +1,604 @@
+package netlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/bpf"
+)
+
+// Compiler of a subset of the tcpdump filter expressions (pcap-filter) to classic BPF,
+// for ethernet frames without vlan tag.
+//
+// Supported primitives:
+//   - ip, ip6, udp, tcp
+//   - [src|dst] host <ipv4|ipv6>
+//   - [src|dst] net <cidr>
+//   - [udp|tcp] [src|dst] port <port>
+//   - [udp|tcp] [src|dst] portrange <port>-<port>
+//
+// combined with and (&&), or (||), not (!) and parentheses.
+
+const (
+	bpfDirAny = iota
+	bpfDirSrc
+	bpfDirDst
+
+	bpfMaxInstructions = 4096
+
+	ethTypeOffset   = 12
+	ip4ProtoOffset  = 23
+	ip4FragOffset   = 20
+	ip4HeaderOffset = 14
+	ip4SrcOffset    = 26
+	ip4DstOffset    = 30
+	ip6ProtoOffset  = 20
+	ip6SrcOffset    = 22
+	ip6DstOffset    = 38
+	ip6SportOffset  = 54
+	ip6DportOffset  = 56
+)
+
+// BpfNode is a node of a filter expression
+type BpfNode interface {
+	gen(c *bpfCompiler, t, f int)
+}
+
+type bpfAnd struct{ nodes []BpfNode }
+type bpfOr struct{ nodes []BpfNode }
+type bpfNot struct{ node BpfNode }
+type bpfEtherType struct{ etherTypes []uint16 }
+type bpfIPProto struct{ proto uint8 }
+type bpfNet struct {
+	prefix netip.Prefix
+	dir    int
+}
+type bpfPort struct {
+	proto  uint8
+	lo, hi uint16
+	dir    int
+}
+
+// BpfAnd returns a node matching if all the nodes match
+func BpfAnd(nodes ...BpfNode) BpfNode { return &bpfAnd{nodes: nodes} }
+
+// BpfOr returns a node matching if one of the nodes matches
+func BpfOr(nodes ...BpfNode) BpfNode { return &bpfOr{nodes: nodes} }
+
+// BpfEtherTypes returns a node matching the frames with one of the ethertypes
+func BpfEtherTypes(etherTypes ...uint16) BpfNode { return &bpfEtherType{etherTypes: etherTypes} }
+
+// BpfPorts returns a node matching the udp or tcp packets with one of the ports,
+// as source or destination
+func BpfPorts(ports ...int) BpfNode {
+	nodes := []BpfNode{}
+	for _, port := range ports {
+		nodes = append(nodes, &bpfPort{lo: uint16(port), hi: uint16(port), dir: bpfDirAny})
+	}
+	return BpfOr(nodes...)
+}
+
+// ParseBpfExpression parses a tcpdump filter expression
+func ParseBpfExpression(expr string) (BpfNode, error) {
+	p := &bpfParser{tokens: tokenizeBpf(expr)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty filter expression")
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token %q in filter expression", p.tokens[p.pos])
+	}
+	return node, nil
+}
+
+// CompileBpf compiles the expression to classic BPF, the matching packets are kept up to snaplen bytes
+func CompileBpf(node BpfNode, snaplen uint32) ([]bpf.Instruction, error) {
+	c := &bpfCompiler{labels: make(map[int]int)}
+	accept, drop := c.newLabel(), c.newLabel()
+	node.gen(c, accept, drop)
+	c.setLabel(accept)
+	c.emit(bpf.RetConstant{Val: snaplen})
+	c.setLabel(drop)
+	c.emit(bpf.RetConstant{Val: 0})
+	return c.assemble()
+}
+
+// tokenizer and parser
+
+func tokenizeBpf(expr string) []string {
+	for _, op := range []string{"(", ")", "!", "&&", "||"} {
+		expr = strings.ReplaceAll(expr, op, " "+op+" ")
+	}
+	return strings.Fields(expr)
+}
+
+type bpfParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *bpfParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *bpfParser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *bpfParser) parseOr() (BpfNode, error) {
+	node, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []BpfNode{node}
+	for p.peek() == "or" || p.peek() == "||" {
+		p.next()
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return BpfOr(nodes...), nil
+}
+
+func (p *bpfParser) parseAnd() (BpfNode, error) {
+	node, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []BpfNode{node}
+	for p.peek() == "and" || p.peek() == "&&" {
+		p.next()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return BpfAnd(nodes...), nil
+}
+
+func (p *bpfParser) parseNot() (BpfNode, error) {
+	switch p.peek() {
+	case "not", "!":
+		p.next()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &bpfNot{node: node}, nil
+	case "(":
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis in filter expression")
+		}
+		return node, nil
+	}
+	return p.parsePrimitive()
+}
+
+func (p *bpfParser) parsePrimitive() (BpfNode, error) {
+	var proto uint8
+	switch p.peek() {
+	case "ip":
+		p.next()
+		return BpfEtherTypes(0x0800), nil
+	case "ip6":
+		p.next()
+		return BpfEtherTypes(0x86dd), nil
+	case "udp", "tcp":
+		if p.peek() == "udp" {
+			proto = uint8(IPv4ProtocolUDP)
+		} else {
+			proto = uint8(IPv4ProtocolTCP)
+		}
+		p.next()
+		// protocol alone or protocol qualifier of the port
+		switch p.peek() {
+		case "src", "dst", "port", "portrange":
+		default:
+			return &bpfIPProto{proto: proto}, nil
+		}
+	}
+
+	dir := bpfDirAny
+	switch p.peek() {
+	case "src":
+		p.next()
+		dir = bpfDirSrc
+	case "dst":
+		p.next()
+		dir = bpfDirDst
+	}
+
+	keyword := p.next()
+	value := p.next()
+	if value == "" {
+		return nil, fmt.Errorf("missing value after %q in filter expression", keyword)
+	}
+
+	switch keyword {
+	case "host":
+		if proto != 0 {
+			break
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host %q in filter expression", value)
+		}
+		return &bpfNet{prefix: netip.PrefixFrom(addr, addr.BitLen()), dir: dir}, nil
+	case "net":
+		if proto != 0 {
+			break
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid net %q in filter expression", value)
+		}
+		return &bpfNet{prefix: prefix.Masked(), dir: dir}, nil
+	case "port":
+		port, err := parseBpfPort(value)
+		if err != nil {
+			return nil, err
+		}
+		return &bpfPort{proto: proto, lo: port, hi: port, dir: dir}, nil
+	case "portrange":
+		bounds := strings.SplitN(value, "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid portrange %q in filter expression", value)
+		}
+		lo, err := parseBpfPort(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		hi, err := parseBpfPort(bounds[1])
+		if err != nil {
+			return nil, err
+		}
+		if lo > hi {
+			return nil, fmt.Errorf("invalid portrange %q in filter expression", value)
+		}
+		return &bpfPort{proto: proto, lo: lo, hi: hi, dir: dir}, nil
+	}
+	return nil, fmt.Errorf("unsupported primitive %q in filter expression", keyword)
+}
+
+func parseBpfPort(value string) (uint16, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q in filter expression", value)
+	}
+	return uint16(port), nil
+}
+
+// code generation, each node jumps to the label t if it matches, to f otherwise
+
+type bpfInstruction struct {
+	ins         bpf.Instruction
+	cond        bpf.JumpTest
+	val         uint32
+	conditional bool
+	t, f        int
+}
+
+type bpfCompiler struct {
+	program []bpfInstruction
+	labels  map[int]int
+	nLabels int
+}
+
+func (c *bpfCompiler) newLabel() int {
+	c.nLabels++
+	return c.nLabels
+}
+
+func (c *bpfCompiler) setLabel(label int) {
+	c.labels[label] = len(c.program)
+}
+
+func (c *bpfCompiler) emit(ins bpf.Instruction) {
+	c.program = append(c.program, bpfInstruction{ins: ins})
+}
+
+func (c *bpfCompiler) jump(cond bpf.JumpTest, val uint32, t, f int) {
+	c.program = append(c.program, bpfInstruction{conditional: true, cond: cond, val: val, t: t, f: f})
+}
+
+// assemble resolves the labels. The conditional jumps skip at most 255 instructions,
+// the longer jumps go through unconditional jumps (trampolines) inserted after them.
+func (c *bpfCompiler) assemble() ([]bpf.Instruction, error) {
+	longTrue := make([]bool, len(c.program))
+	longFalse := make([]bool, len(c.program))
+
+	// the trampolines move the next instructions, the layout is computed again
+	// until all the conditional jumps are in range
+	var positions []int
+	for changed := true; changed; {
+		changed = false
+		positions = c.layout(longTrue, longFalse)
+		for i, ins := range c.program {
+			if !ins.conditional {
+				continue
+			}
+			next := positions[i] + 1
+			if !longTrue[i] && c.target(positions, ins.t)-next > 255 {
+				longTrue[i], changed = true, true
+			}
+			if !longFalse[i] && c.target(positions, ins.f)-next > 255 {
+				longFalse[i], changed = true, true
+			}
+		}
+	}
+	if size := positions[len(c.program)]; size > bpfMaxInstructions {
+		return nil, fmt.Errorf("filter too large: %d instructions", size)
+	}
+
+	instructions := []bpf.Instruction{}
+	for i, ins := range c.program {
+		if !ins.conditional {
+			instructions = append(instructions, ins.ins)
+			continue
+		}
+		next := positions[i] + 1
+		skipTrue := c.target(positions, ins.t) - next
+		skipFalse := c.target(positions, ins.f) - next
+		if skipTrue < 0 || skipFalse < 0 {
+			return nil, fmt.Errorf("invalid backward jump in filter")
+		}
+
+		trampolines := []bpf.Instruction{}
+		if longTrue[i] {
+			trampolines = append(trampolines, bpf.Jump{Skip: uint32(skipTrue - len(trampolines) - 1)})
+			skipTrue = len(trampolines) - 1
+		}
+		if longFalse[i] {
+			trampolines = append(trampolines, bpf.Jump{Skip: uint32(skipFalse - len(trampolines) - 1)})
+			skipFalse = len(trampolines) - 1
+		}
+		instructions = append(instructions, bpf.JumpIf{Cond: ins.cond, Val: ins.val,
+			SkipTrue: uint8(skipTrue), SkipFalse: uint8(skipFalse)})
+		instructions = append(instructions, trampolines...)
+	}
+	return instructions, nil
+}
+
+// layout returns the position of each instruction with the trampolines,
+// and the size of the program at the end
+func (c *bpfCompiler) layout(longTrue, longFalse []bool) []int {
+	positions := make([]int, len(c.program)+1)
+	position := 0
+	for i := range c.program {
+		positions[i] = position
+		position++
+		if longTrue[i] {
+			position++
+		}
+		if longFalse[i] {
+			position++
+		}
+	}
+	positions[len(c.program)] = position
+	return positions
+}
+
+func (c *bpfCompiler) target(positions []int, label int) int {
+	return positions[c.labels[label]]
+}
+
+func (n *bpfAnd) gen(c *bpfCompiler, t, f int) {
+	for i, node := range n.nodes {
+		if i == len(n.nodes)-1 {
+			node.gen(c, t, f)
+			break
+		}
+		next := c.newLabel()
+		node.gen(c, next, f)
+		c.setLabel(next)
+	}
+}
+
+func (n *bpfOr) gen(c *bpfCompiler, t, f int) {
+	for i, node := range n.nodes {
+		if i == len(n.nodes)-1 {
+			node.gen(c, t, f)
+			break
+		}
+		next := c.newLabel()
+		node.gen(c, t, next)
+		c.setLabel(next)
+	}
+}
+
+func (n *bpfNot) gen(c *bpfCompiler, t, f int) {
+	n.node.gen(c, f, t)
+}
+
+func (n *bpfEtherType) gen(c *bpfCompiler, t, f int) {
+	c.emit(bpf.LoadAbsolute{Off: ethTypeOffset, Size: 2})
+	for i, etherType := range n.etherTypes {
+		if i == len(n.etherTypes)-1 {
+			c.jump(bpf.JumpEqual, uint32(etherType), t, f)
+			break
+		}
+		next := c.newLabel()
+		c.jump(bpf.JumpEqual, uint32(etherType), t, next)
+		c.setLabel(next)
+	}
+}
+
+func (n *bpfIPProto) gen(c *bpfCompiler, t, f int) {
+	ip4, checkIp6, ip6 := c.newLabel(), c.newLabel(), c.newLabel()
+	c.emit(bpf.LoadAbsolute{Off: ethTypeOffset, Size: 2})
+	c.jump(bpf.JumpEqual, 0x0800, ip4, checkIp6)
+	c.setLabel(checkIp6)
+	c.jump(bpf.JumpEqual, 0x86dd, ip6, f)
+	c.setLabel(ip4)
+	c.emit(bpf.LoadAbsolute{Off: ip4ProtoOffset, Size: 1})
+	c.jump(bpf.JumpEqual, uint32(n.proto), t, f)
+	c.setLabel(ip6)
+	c.emit(bpf.LoadAbsolute{Off: ip6ProtoOffset, Size: 1})
+	c.jump(bpf.JumpEqual, uint32(n.proto), t, f)
+}
+
+func (n *bpfNet) gen(c *bpfCompiler, t, f int) {
+	etherType, srcOffset, dstOffset := uint32(0x0800), uint32(ip4SrcOffset), uint32(ip4DstOffset)
+	if n.prefix.Addr().Is6() {
+		etherType, srcOffset, dstOffset = 0x86dd, ip6SrcOffset, ip6DstOffset
+	}
+
+	match := c.newLabel()
+	c.emit(bpf.LoadAbsolute{Off: ethTypeOffset, Size: 2})
+	c.jump(bpf.JumpEqual, etherType, match, f)
+	c.setLabel(match)
+
+	switch n.dir {
+	case bpfDirSrc:
+		n.genAddr(c, srcOffset, t, f)
+	case bpfDirDst:
+		n.genAddr(c, dstOffset, t, f)
+	default:
+		checkDst := c.newLabel()
+		n.genAddr(c, srcOffset, t, checkDst)
+		c.setLabel(checkDst)
+		n.genAddr(c, dstOffset, t, f)
+	}
+}
+
+// genAddr compares the address at the offset with the prefix, word by word
+func (n *bpfNet) genAddr(c *bpfCompiler, offset uint32, t, f int) {
+	addr := n.prefix.Addr().AsSlice()
+	bits := n.prefix.Bits()
+
+	words := []int{}
+	for i := 0; i < len(addr)/4 && i*32 < bits; i++ {
+		words = append(words, i)
+	}
+	// match all the addresses
+	if len(words) == 0 {
+		c.jump(bpf.JumpEqual, 0, t, t)
+		return
+	}
+
+	for j, i := range words {
+		value := binary.BigEndian.Uint32(addr[i*4 : i*4+4])
+		mask := uint32(0xffffffff)
+		if remaining := bits - i*32; remaining < 32 {
+			mask = ^(uint32(0xffffffff) >> remaining)
+		}
+		c.emit(bpf.LoadAbsolute{Off: offset + uint32(i*4), Size: 4})
+		if mask != 0xffffffff {
+			c.emit(bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: mask})
+		}
+		if j == len(words)-1 {
+			c.jump(bpf.JumpEqual, value&mask, t, f)
+			break
+		}
+		next := c.newLabel()
+		c.jump(bpf.JumpEqual, value&mask, next, f)
+		c.setLabel(next)
+	}
+}
+
+func (n *bpfPort) gen(c *bpfCompiler, t, f int) {
+	ip4, checkIp6, ip6 := c.newLabel(), c.newLabel(), c.newLabel()
+	ip4Ports, ip6Ports := c.newLabel(), c.newLabel()
+
+	c.emit(bpf.LoadAbsolute{Off: ethTypeOffset, Size: 2})
+	c.jump(bpf.JumpEqual, 0x0800, ip4, checkIp6)
+	c.setLabel(checkIp6)
+	c.jump(bpf.JumpEqual, 0x86dd, ip6, f)
+
+	// ipv4, the fragments are ignored and the ip header length is variable
+	c.setLabel(ip4)
+	c.emit(bpf.LoadAbsolute{Off: ip4ProtoOffset, Size: 1})
+	n.genProto(c, ip4Ports, f)
+	c.setLabel(ip4Ports)
+	notFragmented := c.newLabel()
+	c.emit(bpf.LoadAbsolute{Off: ip4FragOffset, Size: 2})
+	c.jump(bpf.JumpBitsSet, 0x1fff, f, notFragmented)
+	c.setLabel(notFragmented)
+	c.emit(bpf.LoadMemShift{Off: ip4HeaderOffset})
+	n.genPorts(c, func(dst bool) bpf.Instruction {
+		if dst {
+			return bpf.LoadIndirect{Off: ip4HeaderOffset + 2, Size: 2}
+		}
+		return bpf.LoadIndirect{Off: ip4HeaderOffset, Size: 2}
+	}, t, f)
+
+	// ipv6 without extension headers
+	c.setLabel(ip6)
+	c.emit(bpf.LoadAbsolute{Off: ip6ProtoOffset, Size: 1})
+	n.genProto(c, ip6Ports, f)
+	c.setLabel(ip6Ports)
+	n.genPorts(c, func(dst bool) bpf.Instruction {
+		if dst {
+			return bpf.LoadAbsolute{Off: ip6DportOffset, Size: 2}
+		}
+		return bpf.LoadAbsolute{Off: ip6SportOffset, Size: 2}
+	}, t, f)
+}
+
+// genProto checks the protocol loaded in the register, udp or tcp if not specified
+func (n *bpfPort) genProto(c *bpfCompiler, t, f int) {
+	if n.proto != 0 {
+		c.jump(bpf.JumpEqual, uint32(n.proto), t, f)
+		return
+	}
+	checkTcp := c.newLabel()
+	c.jump(bpf.JumpEqual, uint32(IPv4ProtocolUDP), t, checkTcp)
+	c.setLabel(checkTcp)
+	c.jump(bpf.JumpEqual, uint32(IPv4ProtocolTCP), t, f)
+}
+
+func (n *bpfPort) genPorts(c *bpfCompiler, load func(dst bool) bpf.Instruction, t, f int) {
+	switch n.dir {
+	case bpfDirSrc:
+		c.emit(load(false))
+		n.genRange(c, t, f)
+	case bpfDirDst:
+		c.emit(load(true))
+		n.genRange(c, t, f)
+	default:
+		checkDst := c.newLabel()
+		c.emit(load(false))
+		n.genRange(c, t, checkDst)
+		c.setLabel(checkDst)
+		c.emit(load(true))
+		n.genRange(c, t, f)
+	}
+}
+
+func (n *bpfPort) genRange(c *bpfCompiler, t, f int) {
+	if n.lo == n.hi {
+		c.jump(bpf.JumpEqual, uint32(n.lo), t, f)
+		return
+	}
+	checkHi := c.newLabel()
+	c.jump(bpf.JumpGreaterOrEqual, uint32(n.lo), checkHi, f)
+	c.setLabel(checkHi)
+	c.jump(bpf.JumpGreaterThan, uint32(n.hi), f, t)
+}
@@ -0,0 +1,206 @@
+package netlib
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+func udpPacket(t *testing.T, src, dst net.IP, srcPort, dstPort layers.UDPPort) []byte {
+	eth := &layers.Ethernet{SrcMAC: outerMac, DstMAC: outerMac, EthernetType: layers.EthernetTypeIPv4}
+	var ip gopacket.NetworkLayer
+	if src.To4() != nil {
+		ip = &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+	} else {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		ip = &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+	}
+	udp := &layers.UDP{SrcPort: srcPort, DstPort: dstPort}
+	udp.SetNetworkLayerForChecksum(ip)
+	return serialize(t, eth, ip.(gopacket.SerializableLayer), udp, gopacket.Payload(dnsQuery))
+}
+
+func tcpPacket(t *testing.T, src, dst net.IP, srcPort, dstPort layers.TCPPort) []byte {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: src, DstIP: dst}
+	tcp := &layers.TCP{SrcPort: srcPort, DstPort: dstPort, PSH: true, ACK: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+	return serialize(t, &layers.Ethernet{SrcMAC: outerMac, DstMAC: outerMac, EthernetType: layers.EthernetTypeIPv4},
+		ip, tcp, gopacket.Payload(dnsQuery))
+}
+
+func TestBpf_Expressions(t *testing.T) {
+	client4, server4 := net.IP{10, 0, 0, 1}, net.IP{192, 168, 1, 53}
+	client6, server6 := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8:1::53")
+
+	udp4 := udpPacket(t, client4, server4, 36000, 53)
+	udp6 := udpPacket(t, client6, server6, 36000, 53)
+	mdns4 := udpPacket(t, client4, server4, 5353, 5353)
+	tcp4 := tcpPacket(t, server4, client4, 53, 36000)
+
+	// ipv4 fragment, not the first one
+	fragment := append([]byte{}, udp4...)
+	fragment[20], fragment[21] = 0x00, 0x10
+
+	testcases := []struct {
+		expr   string
+		packet []byte
+		match  bool
+	}{
+		{"port 53", udp4, true},
+		{"port 53", udp6, true},
+		{"port 53", tcp4, true},
+		{"port 53", mdns4, false},
+		{"port 53", fragment, false},
+		{"udp port 53", tcp4, false},
+		{"tcp src port 53", tcp4, true},
+		{"tcp dst port 53", tcp4, false},
+		{"portrange 5300-5400", mdns4, true},
+		{"portrange 5300-5400", udp4, false},
+		{"udp", udp6, true},
+		{"tcp", udp6, false},
+		{"ip", udp4, true},
+		{"ip6", udp4, false},
+		{"host 10.0.0.1", udp4, true},
+		{"src host 10.0.0.1", tcp4, false},
+		{"dst host 10.0.0.1", tcp4, true},
+		{"host 2001:db8:1::53", udp6, true},
+		{"net 192.168.0.0/16", udp4, true},
+		{"src net 192.168.0.0/16", udp4, false},
+		{"net 2001:db8::/32", udp6, true},
+		{"net 2001:db8:2::/48", udp6, false},
+		{"net 0.0.0.0/0", udp4, true},
+		{"net 10.0.0.0/8 and not port 5353", udp4, true},
+		{"net 10.0.0.0/8 and not port 5353", mdns4, false},
+		{"!(udp) || host 192.168.1.53", tcp4, true},
+		{"(ip6 or tcp) and port 53", udp4, false},
+		{"ip6 && (tcp || udp)", udp6, true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.expr, func(t *testing.T) {
+			node, err := ParseBpfExpression(tc.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filter, err := CompileBpf(node, 0xFFFF)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vm, err := bpf.NewVM(filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := vm.Run(tc.packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (n > 0) != tc.match {
+				t.Errorf("want match %v, got %d", tc.match, n)
+			}
+		})
+	}
+}
+
+func TestBpf_Ports(t *testing.T) {
+	filter, err := CompileBpf(BpfPorts(53, 5353, 5300), 0xFFFF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := bpf.NewVM(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for port, match := range map[layers.UDPPort]bool{53: true, 5353: true, 5300: true, 853: false} {
+		n, _ := vm.Run(udpPacket(t, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 36000, port))
+		if (n > 0) != match {
+			t.Errorf("port %d: want match %v, got %d", port, match, n)
+		}
+	}
+}
+
+func TestBpf_ManyPorts(t *testing.T) {
+	// the jumps to the end of the filter are longer than 255 instructions
+	ports := []int{}
+	for port := 5300; port < 5340; port++ {
+		ports = append(ports, port)
+	}
+	expr, err := ParseBpfExpression("net 10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := CompileBpf(BpfAnd(BpfPorts(ports...), expr), 0xFFFF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filter) < 256 {
+		t.Fatalf("filter too short to test the long jumps: %d instructions", len(filter))
+	}
+	vm, err := bpf.NewVM(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		src   net.IP
+		port  layers.UDPPort
+		match bool
+	}{
+		{net.IP{10, 0, 0, 1}, 5300, true},
+		{net.IP{10, 0, 0, 1}, 5339, true},
+		{net.IP{10, 0, 0, 1}, 5340, false},
+		{net.IP{192, 168, 0, 1}, 5300, false},
+		{net.IP{192, 168, 0, 1}, 5339, false},
+	}
+	for _, tc := range testcases {
+		n, err := vm.Run(udpPacket(t, tc.src, net.IP{192, 168, 1, 53}, 36000, tc.port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (n > 0) != tc.match {
+			t.Errorf("%s port %d: want match %v, got %d", tc.src, tc.port, tc.match, n)
+		}
+	}
+
+	// above the limit of the kernel
+	for port := 5340; port < 5600; port++ {
+		ports = append(ports, port)
+	}
+	if _, err := CompileBpf(BpfPorts(ports...), 0xFFFF); err == nil {
+		t.Errorf("want error for a filter too large")
+	}
+}
+
+func TestBpf_InvalidExpressions(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"port",
+		"port 70000",
+		"host dns.collector",
+		"net 10.0.0.0/33",
+		"portrange 5400-5300",
+		"(udp",
+		"udp)",
+		"vlan 100",
+		"tcp host 10.0.0.1",
+	} {
+		if _, err := ParseBpfExpression(expr); err == nil {
+			t.Errorf("%q: want error", expr)
+		}
+	}
+}
+
+func TestMatchPorts(t *testing.T) {
+	if !MatchPorts(36000, 53) || !MatchPorts(36000, 53, 0) {
+		t.Errorf("all the ports must match without filter")
+	}
+	if !MatchPorts(5353, 36000, 53, 5353) {
+		t.Errorf("source port must match")
+	}
+	if MatchPorts(36000, 853, 53, 5353) {
+		t.Errorf("port 853 must not match")
+	}
+}
@@ -2,8 +2,10 @@ package netlib
 
 import (
 	"container/list"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
@@ -20,13 +22,78 @@ const (
 	IPv4MaximumSize            = 65535 // Maximum size of a fragment (2^16)
 	IPv4MaximumFragmentOffset  = 8183  // Maximum offset of a fragment
 	IPv4MaximumFragmentListLen = 8192  // Back out if we get more than this many fragments
+
+	DefaultIpDefragTimeout = 30 * time.Second
+)
+
+var (
+	ErrFragmentInvalid  = errors.New("defrag: invalid fragment")
+	ErrTooManyFragments = errors.New("defrag: too many fragments")
+	ErrFragmentQueues   = errors.New("defrag: too many datagrams in reassembly")
 )
 
+// IpDefragStats are the counters of the ip defragmentation, shared by the defragmenters of a collector
+type IpDefragStats struct {
+	// fragments received
+	Fragments uint64
+	// datagrams reassembled
+	Reassembled uint64
+	// incomplete datagrams discarded after the timeout
+	TimedOut uint64
+	// fragments dropped by the security checks or the reassembly, as overlaps and holes
+	Invalid uint64
+	// datagrams dropped with more fragments than the maximum
+	TooManyFragments uint64
+	// fragments of new datagrams dropped above the maximum number of datagrams in reassembly
+	QueuesFull uint64
+}
+
+func (s *IpDefragStats) add(counter *uint64, n int) {
+	atomic.AddUint64(counter, uint64(n))
+}
+
+// Counters returns the current values of the counters, by name
+func (s *IpDefragStats) Counters() map[string]uint64 {
+	return map[string]uint64{
+		"ipdefrag_fragments_received":           atomic.LoadUint64(&s.Fragments),
+		"ipdefrag_datagrams_reassembled":        atomic.LoadUint64(&s.Reassembled),
+		"ipdefrag_datagrams_timedout":           atomic.LoadUint64(&s.TimedOut),
+		"ipdefrag_fragments_invalid":            atomic.LoadUint64(&s.Invalid),
+		"ipdefrag_datagrams_too_many_fragments": atomic.LoadUint64(&s.TooManyFragments),
+		"ipdefrag_fragments_queues_full":        atomic.LoadUint64(&s.QueuesFull),
+	}
+}
+
+// IpDefragOptions are the limits of the ip defragmentation
+type IpDefragOptions struct {
+	// Maximum number of datagrams in reassembly, the fragments of the new ones are dropped above, 0 for unlimited
+	MaxQueues int
+	// Maximum number of fragments per datagram, the defaults of each ip version are used if 0
+	MaxFragments int
+	// Incomplete datagrams are discarded after the timeout, the default is used if 0
+	Timeout time.Duration
+	// Optional counters, can be shared by several defragmenters
+	Stats *IpDefragStats
+}
+
+// fragmentOffset returns the offset in bytes of an ipv4 or ipv6 fragment
+func fragmentOffset(packet gopacket.Packet) uint16 {
+	if frag6, ok := packet.Layer(layers.LayerTypeIPv6Fragment).(*layers.IPv6Fragment); ok {
+		return frag6.FragmentOffset * 8
+	}
+	if ip4, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+		return ip4.FragOffset * 8
+	}
+	return 0
+}
+
 type fragments struct {
 	List     list.List
 	Highest  uint16
 	Current  uint16
 	LastSeen time.Time
+	// the last fragment is received, the others can be received after
+	Final bool
 }
 
 func (f *fragments) insert(in gopacket.Packet) (gopacket.Packet, error) {
@@ -53,16 +120,7 @@ func (f *fragments) insert(in gopacket.Packet) (gopacket.Packet, error) {
 	} else {
 		for e := f.List.Front(); e != nil; e = e.Next() {
 			packet, _ := e.Value.(gopacket.Packet)
-
-			var fragOffset uint16
-
-			frag6 := packet.Layer(layers.LayerTypeIPv6Fragment).(*layers.IPv6Fragment)
-			ip4, _ := e.Value.(*layers.IPv4)
-			if frag6 != nil {
-				fragOffset = frag6.FragmentOffset * 8
-			} else {
-				fragOffset = ip4.FragOffset * 8
-			}
+			fragOffset := fragmentOffset(packet)
 
 			if inFragOffset == fragOffset {
 				return nil, nil
@@ -83,7 +141,10 @@ func (f *fragments) insert(in gopacket.Packet) (gopacket.Packet, error) {
 	f.Current = f.Current + inFragLength
 
 	// Final Fragment ?
-	if !inFragMore && f.Highest == f.Current {
+	if !inFragMore {
+		f.Final = true
+	}
+	if f.Final && f.Highest == f.Current {
 		return f.build(in)
 	}
 	return nil, nil
@@ -126,14 +187,14 @@ func (f *fragments) build(in gopacket.Packet) (gopacket.Packet, error) {
 		} else if fragOffset*8 < currentOffset {
 			startAt := currentOffset - fragOffset*8
 			if startAt > fragLength-ipOffset {
-				return nil, fmt.Errorf("defrag: invalid fragment")
+				return nil, ErrFragmentInvalid
 			}
 			final = append(final, fragPayload[startAt:]...)
 			currentOffset = currentOffset + fragOffset*8
 
 		} else {
 			// Houston - we have an hole !
-			return nil, fmt.Errorf("defrag: hole found")
+			return nil, fmt.Errorf("%w: hole found", ErrFragmentInvalid)
 		}
 	}
 
@@ -241,23 +302,43 @@ func newIPv6(packet gopacket.Packet) ipFlow {
 type IpDefragmenter struct {
 	sync.RWMutex
 	ipFlows map[ipFlow]*fragments
+	options IpDefragOptions
+	stats   *IpDefragStats
 }
 
 func NewIPDefragmenter() *IpDefragmenter {
+	return NewIPDefragmenterWithOptions(IpDefragOptions{})
+}
+
+// NewIPDefragmenterWithOptions returns a defragmenter with limits, the counters are always updated
+func NewIPDefragmenterWithOptions(options IpDefragOptions) *IpDefragmenter {
+	stats := options.Stats
+	if stats == nil {
+		stats = &IpDefragStats{}
+	}
 	return &IpDefragmenter{
 		ipFlows: make(map[ipFlow]*fragments),
+		options: options,
+		stats:   stats,
 	}
 }
 
+// Stats returns the counters of the defragmenter
+func (d *IpDefragmenter) Stats() *IpDefragStats {
+	return d.stats
+}
+
 func (d *IpDefragmenter) DefragIP(in gopacket.Packet) (gopacket.Packet, error) {
 	// check if we need to defrag
 	if st := d.dontDefrag(in); st {
 		return in, nil
 	}
+	d.stats.add(&d.stats.Fragments, 1)
 
 	// perfom security checks
 	if err := d.securityChecks(in); err != nil {
-		return nil, err
+		d.stats.add(&d.stats.Invalid, 1)
+		return nil, fmt.Errorf("%w: %s", ErrFragmentInvalid, err)
 	}
 
 	// ok, got a fragment
@@ -275,9 +356,18 @@ func (d *IpDefragmenter) DefragIP(in gopacket.Packet) (gopacket.Packet, error) {
 		ipf = newIPv6(in)
 		maxFrag = IPv6MaximumFragmentListLen
 	}
+	if d.options.MaxFragments > 0 {
+		maxFrag = d.options.MaxFragments
+	}
 	d.Lock()
 	fl, exist = d.ipFlows[ipf]
 	if !exist {
+		// no more datagrams in reassembly above the limit, until the end or the timeout of the existing ones
+		if d.options.MaxQueues > 0 && len(d.ipFlows) >= d.options.MaxQueues {
+			d.Unlock()
+			d.stats.add(&d.stats.QueuesFull, 1)
+			return nil, ErrFragmentQueues
+		}
 		fl = new(fragments)
 		d.ipFlows[ipf] = fl
 	}
@@ -291,15 +381,23 @@ func (d *IpDefragmenter) DefragIP(in gopacket.Packet) (gopacket.Packet, error) {
 	// raise an error
 	if out == nil && fl.List.Len()+1 > maxFrag {
 		d.flush(ipf)
-		return nil, fmt.Errorf("fragment List hits its maximum")
+		d.stats.add(&d.stats.TooManyFragments, 1)
+		return nil, ErrTooManyFragments
 	}
 
 	// if we got a packet, it's a new one, and he is defragmented
 	// when defrag is done for a flow between two ip clean the list
 	if out != nil {
 		d.flush(ipf)
+		d.stats.add(&d.stats.Reassembled, 1)
 		return out, nil
 	}
+
+	// the datagram can not be reassembled
+	if err2 != nil {
+		d.flush(ipf)
+		d.stats.add(&d.stats.Invalid, 1)
+	}
 	return nil, err2
 }
 
@@ -346,8 +444,8 @@ func (d *IpDefragmenter) securityChecks(in gopacket.Packet) error {
 		ip4 := in.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
 		fragSize := ip4.Length - uint16(ip4.IHL)*4
 
-		// don't allow small fragments outside of specification
-		if fragSize < IPv4MinimumFragmentSize {
+		// don't allow small fragments outside of specification, except the last one
+		if ip4.Flags&layers.IPv4MoreFragments != 0 && fragSize < IPv4MinimumFragmentSize {
 			return fmt.Errorf("fragment too small(handcrafted? %d < %d)", fragSize, IPv4MinimumFragmentSize)
 		}
 
@@ -382,5 +480,13 @@ func (d *IpDefragmenter) DiscardOlderThan(t time.Time) int {
 		}
 	}
 	d.Unlock()
+	d.stats.add(&d.stats.TimedOut, nb)
 	return nb
 }
+
+// Queues returns the number of datagrams in reassembly
+func (d *IpDefragmenter) Queues() int {
+	d.RLock()
+	defer d.RUnlock()
+	return len(d.ipFlows)
+}
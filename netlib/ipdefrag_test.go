@@ -0,0 +1,198 @@
+package netlib
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// ipv4Fragments returns the fragments of an udp datagram with the dns query, of 16 bytes except the last one
+func ipv4Fragments(t *testing.T, id uint16) []gopacket.Packet {
+	udp := make([]byte, 8)
+	binary.BigEndian.PutUint16(udp[0:], 36000)
+	binary.BigEndian.PutUint16(udp[2:], 53)
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(dnsQuery)))
+	datagram := append(udp, dnsQuery...)
+
+	packets := []gopacket.Packet{}
+	for offset := 0; offset < len(datagram); offset += 16 {
+		end := offset + 16
+		flags := layers.IPv4MoreFragments
+		if end >= len(datagram) {
+			end = len(datagram)
+			flags = 0
+		}
+		ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Id: id, Flags: flags, FragOffset: uint16(offset / 8),
+			Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 53}, DstIP: net.IP{10, 0, 0, 1}}
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ip, gopacket.Payload(datagram[offset:end])); err != nil {
+			t.Fatal(err)
+		}
+		packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+		packet.Metadata().Timestamp = time.Now()
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+func TestIpDefrag_Reassemble(t *testing.T) {
+	fragments := ipv4Fragments(t, 1)
+	defragger := NewIPDefragmenter()
+
+	// out of order
+	var reassembled gopacket.Packet
+	for _, i := range []int{2, 0, 1} {
+		out, err := defragger.DefragIP(fragments[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != nil {
+			reassembled = out
+		}
+	}
+	if reassembled == nil {
+		t.Fatal("datagram not reassembled")
+	}
+	udp, ok := reassembled.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || string(udp.Payload) != string(dnsQuery) {
+		t.Errorf("invalid datagram: %s", reassembled)
+	}
+
+	counters := defragger.Stats().Counters()
+	if counters["ipdefrag_fragments_received"] != 3 || counters["ipdefrag_datagrams_reassembled"] != 1 {
+		t.Errorf("invalid counters: %v", counters)
+	}
+	if defragger.Queues() != 0 {
+		t.Errorf("want no datagram in reassembly, got %d", defragger.Queues())
+	}
+}
+
+func TestIpDefrag_MaxQueues(t *testing.T) {
+	defragger := NewIPDefragmenterWithOptions(IpDefragOptions{MaxQueues: 1})
+
+	if _, err := defragger.DefragIP(ipv4Fragments(t, 1)[0]); err != nil {
+		t.Fatal(err)
+	}
+	// the fragments of a new datagram are dropped
+	if _, err := defragger.DefragIP(ipv4Fragments(t, 2)[0]); !errors.Is(err, ErrFragmentQueues) {
+		t.Errorf("want queues error, got %v", err)
+	}
+	// the fragments of the datagram in reassembly are accepted
+	if _, err := defragger.DefragIP(ipv4Fragments(t, 1)[1]); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if n := defragger.Stats().Counters()["ipdefrag_fragments_queues_full"]; n != 1 {
+		t.Errorf("want one fragment dropped, got %d", n)
+	}
+}
+
+func TestIpDefrag_MaxFragments(t *testing.T) {
+	stats := &IpDefragStats{}
+	defragger := NewIPDefragmenterWithOptions(IpDefragOptions{MaxFragments: 2, Stats: stats})
+
+	fragments := ipv4Fragments(t, 1)
+	if _, err := defragger.DefragIP(fragments[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := defragger.DefragIP(fragments[1]); !errors.Is(err, ErrTooManyFragments) {
+		t.Errorf("want too many fragments error, got %v", err)
+	}
+	if defragger.Queues() != 0 {
+		t.Errorf("the datagram should be dropped")
+	}
+	if stats.TooManyFragments != 1 {
+		t.Errorf("want one datagram dropped, got %d", stats.TooManyFragments)
+	}
+}
+
+func TestIpDefrag_Invalid(t *testing.T) {
+	defragger := NewIPDefragmenter()
+
+	// fragment with a payload smaller than the minimum
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Id: 1, Flags: layers.IPv4MoreFragments,
+		Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 53}, DstIP: net.IP{10, 0, 0, 1}}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ip, gopacket.Payload([]byte{1, 2})); err != nil {
+		t.Fatal(err)
+	}
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+
+	if _, err := defragger.DefragIP(packet); !errors.Is(err, ErrFragmentInvalid) {
+		t.Errorf("want invalid fragment error, got %v", err)
+	}
+	if n := defragger.Stats().Counters()["ipdefrag_fragments_invalid"]; n != 1 {
+		t.Errorf("want one invalid fragment, got %d", n)
+	}
+}
+
+func TestIpDefrag_Timeout(t *testing.T) {
+	stats := &IpDefragStats{}
+	fragIp4Chan := make(chan gopacket.Packet)
+	outputChan := make(chan gopacket.Packet, 1)
+
+	go IpDefraggerWithOptions(fragIp4Chan, outputChan, outputChan, IpDefragOptions{Timeout: 100 * time.Millisecond, Stats: stats})
+
+	// the last fragment is never received
+	fragments := ipv4Fragments(t, 1)
+	fragIp4Chan <- fragments[0]
+	fragIp4Chan <- fragments[1]
+
+	time.Sleep(300 * time.Millisecond)
+	if n := stats.Counters()["ipdefrag_datagrams_timedout"]; n != 1 {
+		t.Errorf("want one datagram timed out, got %d", n)
+	}
+
+	// the end of the datagram is not reassembled after the timeout
+	fragIp4Chan <- fragments[2]
+	close(fragIp4Chan)
+	select {
+	case <-outputChan:
+		t.Errorf("no datagram expected")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestIpDefrag_ReplayOldTimestamps(t *testing.T) {
+	stats := &IpDefragStats{}
+	fragIp4Chan := make(chan gopacket.Packet)
+	outputChan := make(chan gopacket.Packet, 2)
+	done := make(chan bool)
+	go func() {
+		IpDefraggerWithOptions(fragIp4Chan, outputChan, outputChan, IpDefragOptions{Timeout: 15 * time.Second, Stats: stats})
+		done <- true
+	}()
+
+	// a capture file of 2015, the first datagram is completed before the timeout
+	// and the second one is discarded by the last fragment
+	start := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	first, second := ipv4Fragments(t, 1), ipv4Fragments(t, 2)
+	replay := []struct {
+		fragment gopacket.Packet
+		delay    time.Duration
+	}{
+		{first[0], 0},
+		{first[1], time.Second},
+		{second[0], 10 * time.Second},
+		{first[2], 12 * time.Second},
+		{second[1], 30 * time.Second},
+	}
+	for _, r := range replay {
+		r.fragment.Metadata().Timestamp = start.Add(r.delay)
+		fragIp4Chan <- r.fragment
+	}
+	close(fragIp4Chan)
+	<-done
+
+	if len(outputChan) != 1 {
+		t.Errorf("want the first datagram reassembled, got %d", len(outputChan))
+	}
+	if n := stats.Counters()["ipdefrag_datagrams_timedout"]; n != 1 {
+		t.Errorf("want one datagram timed out, got %d", n)
+	}
+}
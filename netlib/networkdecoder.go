@@ -1,13 +1,20 @@
 package netlib
 
 import (
+	"encoding/binary"
 	"fmt"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 )
 
-type NetDecoder struct{}
+// NetDecoder decodes the link, network and transport layers of a packet,
+// the zero value decodes ethernet frames
+type NetDecoder struct {
+	decodeLink func(data []byte, p gopacket.PacketBuilder, t *Tunnel) error
+	// Decap selects the encapsulations to remove before the inner ip layer
+	Decap DecapOptions
+}
 
 const (
 	IPv4ProtocolTCP      = layers.IPProtocolTCP
@@ -15,9 +22,78 @@ const (
 	IPv6ProtocolTCP      = layers.IPProtocolTCP
 	IPv6ProtocolUDP      = layers.IPProtocolUDP
 	IPv6ProtocolFragment = layers.IPProtocolIPv6Fragment
+
+	// Linux cooked capture v2 and 802.11 with AVS header, not defined by gopacket
+	LinkTypeLinuxSLL2    = 276
+	LinkTypeIEEE80211AVS = 163
+
+	linuxSLL2HeaderLen = 20
+	dot11HeaderLen     = 24
+
+	// first bytes of the AVS header, the last byte is the version
+	avsMagic = 0x80211000
 )
 
+// IsSupportedLinkType returns true if the packets with this link type can be decoded,
+// the link type is the LINKTYPE_ value of the capture because gopacket is limited to 8 bits
+func IsSupportedLinkType(linkType int) bool {
+	switch linkType {
+	case int(layers.LinkTypeEthernet),
+		int(layers.LinkTypeLinuxSLL), LinkTypeLinuxSLL2,
+		int(layers.LinkTypeRaw), int(layers.LinkTypeIPv4), int(layers.LinkTypeIPv6),
+		int(layers.LinkTypeNull), int(layers.LinkTypeLoop),
+		int(layers.LinkTypeIEEE802_11), int(layers.LinkTypeIEEE80211Radio),
+		int(layers.LinkTypePrismHeader), LinkTypeIEEE80211AVS:
+		return true
+	}
+	return false
+}
+
+// NewNetDecoder returns a decoder for the packets captured with the given link type
+func NewNetDecoder(linkType int) (*NetDecoder, error) {
+	d := &NetDecoder{}
+	switch linkType {
+	case int(layers.LinkTypeEthernet):
+		d.decodeLink = d.decodeEthernet
+	case int(layers.LinkTypeLinuxSLL):
+		d.decodeLink = d.decodeLinuxSLL
+	case LinkTypeLinuxSLL2:
+		d.decodeLink = d.decodeLinuxSLL2
+	case int(layers.LinkTypeRaw), int(layers.LinkTypeIPv4), int(layers.LinkTypeIPv6):
+		d.decodeLink = d.decodeRawIP
+	case int(layers.LinkTypeNull), int(layers.LinkTypeLoop):
+		d.decodeLink = d.decodeLoopback
+	case int(layers.LinkTypeIEEE802_11):
+		d.decodeLink = d.decodeDot11
+	case int(layers.LinkTypeIEEE80211Radio):
+		d.decodeLink = d.decodeRadioTap
+	case int(layers.LinkTypePrismHeader):
+		d.decodeLink = d.decodePrism
+	case LinkTypeIEEE80211AVS:
+		d.decodeLink = d.decodeAvs
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", linkType)
+	}
+	return d, nil
+}
+
 func (d *NetDecoder) Decode(data []byte, p gopacket.PacketBuilder) error {
+	var err error
+	t := &Tunnel{}
+	if d.decodeLink == nil {
+		err = d.decodeEthernet(data, p, t)
+	} else {
+		err = d.decodeLink(data, p, t)
+	}
+
+	// keep the identifiers of the removed encapsulations
+	if len(t.Encapsulations) > 0 {
+		p.AddLayer(t)
+	}
+	return err
+}
+
+func (d *NetDecoder) decodeEthernet(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
 	// Decode the Ethernet layer
 	ethernetLayer := &layers.Ethernet{}
 	if err := ethernetLayer.DecodeFromBytes(data, p); err != nil {
@@ -27,22 +103,172 @@ func (d *NetDecoder) Decode(data []byte, p gopacket.PacketBuilder) error {
 	p.SetLinkLayer(ethernetLayer)
 
 	// Check the EtherType of the Ethernet layer to determine the next layer
-	switch ethernetLayer.EthernetType {
+	return d.decodeEtherType(ethernetLayer.EthernetType, ethernetLayer.Payload, p, t)
+}
+
+func (d *NetDecoder) decodeEtherType(etherType layers.EthernetType, data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
+	switch etherType {
 	case layers.EthernetTypeIPv4:
-		return d.decodeIPv4(ethernetLayer.Payload, p)
+		return d.decodeIPv4(data, p, t)
 	case layers.EthernetTypeIPv6:
-		return d.decodeIPv6(ethernetLayer.Payload, p)
+		return d.decodeIPv6(data, p, t)
+	case layers.EthernetTypeDot1Q, layers.EthernetTypeQinQ, EthernetTypeQinQLegacy:
+		if d.Decap.Vlan {
+			return d.decodeDot1Q(data, p, t)
+		}
 	}
+	return nil
+}
+
+func (d *NetDecoder) decodeLinuxSLL(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
+	// Decode the Linux cooked capture header (tcpdump -i any)
+	sllLayer := &layers.LinuxSLL{}
+	if err := sllLayer.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(sllLayer)
+	p.SetLinkLayer(sllLayer)
 
+	return d.decodeEtherType(sllLayer.EthernetType, sllLayer.Payload, p, t)
+}
+
+func (d *NetDecoder) decodeLinuxSLL2(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
+	// Linux cooked capture v2 header, the protocol type is the first field
+	if len(data) < linuxSLL2HeaderLen {
+		return fmt.Errorf("linux sll2 header too short")
+	}
+	etherType := layers.EthernetType(binary.BigEndian.Uint16(data[0:2]))
+	return d.decodeEtherType(etherType, data[linuxSLL2HeaderLen:], p, t)
+}
+
+func (d *NetDecoder) decodeRawIP(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
+	// No link layer, the ip version is given by the first nibble
+	if len(data) == 0 {
+		return fmt.Errorf("empty raw ip packet")
+	}
+	switch data[0] >> 4 {
+	case 4:
+		return d.decodeIPv4(data, p, t)
+	case 6:
+		return d.decodeIPv6(data, p, t)
+	}
 	return nil
 }
 
-func (d *NetDecoder) decodeIPv4(data []byte, p gopacket.PacketBuilder) error {
+func (d *NetDecoder) decodeLoopback(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
+	// Decode the BSD loopback header, the family is in the host byte order of the capture
+	loopbackLayer := &layers.Loopback{}
+	if err := loopbackLayer.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(loopbackLayer)
+
+	switch loopbackLayer.Family {
+	case layers.ProtocolFamilyIPv4:
+		return d.decodeIPv4(loopbackLayer.Payload, p, t)
+	case layers.ProtocolFamilyIPv6BSD, layers.ProtocolFamilyIPv6FreeBSD,
+		layers.ProtocolFamilyIPv6Darwin, layers.ProtocolFamilyIPv6Linux:
+		return d.decodeIPv6(loopbackLayer.Payload, p, t)
+	}
+	return nil
+}
+
+func (d *NetDecoder) decodeRadioTap(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
+	// Skip the radiotap header, the length is in little endian
+	if len(data) < 4 {
+		return fmt.Errorf("radiotap header too short")
+	}
+	length := int(binary.LittleEndian.Uint16(data[2:4]))
+	if len(data) < length {
+		return fmt.Errorf("radiotap header too short")
+	}
+	return d.decodeDot11(data[length:], p, t)
+}
+
+func (d *NetDecoder) decodePrism(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
+	// Skip the prism header, the length is in little endian. Some drivers
+	// send an AVS header with the prism link type
+	if len(data) < 8 {
+		return fmt.Errorf("prism header too short")
+	}
+	if binary.BigEndian.Uint32(data[0:4])&0xfffffff0 == avsMagic {
+		return d.decodeAvs(data, p, t)
+	}
+	length := int(binary.LittleEndian.Uint32(data[4:8]))
+	if len(data) < length {
+		return fmt.Errorf("prism header too short")
+	}
+	return d.decodeDot11(data[length:], p, t)
+}
+
+func (d *NetDecoder) decodeAvs(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
+	// Skip the AVS header, the length is in big endian
+	if len(data) < 8 {
+		return fmt.Errorf("avs header too short")
+	}
+	length := int(binary.BigEndian.Uint32(data[4:8]))
+	if len(data) < length {
+		return fmt.Errorf("avs header too short")
+	}
+	return d.decodeDot11(data[length:], p, t)
+}
+
+func (d *NetDecoder) decodeDot11(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
+	// Only the unprotected data frames are decoded, the optional FCS at the end
+	// is removed later by the ip layer with the total length
+	if len(data) < dot11HeaderLen {
+		return fmt.Errorf("802.11 header too short")
+	}
+	frameType := (data[0] >> 2) & 0x3
+	subType := (data[0] >> 4) & 0xf
+	flags := data[1]
+	if frameType != 2 || flags&0x40 != 0 {
+		return nil
+	}
+
+	headerLen := dot11HeaderLen
+	// ToDS and FromDS, fourth address
+	if flags&0x3 == 0x3 {
+		headerLen += 6
+	}
+	// QoS data, with HT control if the order bit is set
+	if subType&0x8 != 0 {
+		headerLen += 2
+		if flags&0x80 != 0 {
+			headerLen += 4
+		}
+	}
+	// null data frames without payload
+	if subType&0x4 != 0 {
+		return nil
+	}
+
+	// LLC and SNAP headers
+	if len(data) < headerLen+8 {
+		return fmt.Errorf("802.11 llc header too short")
+	}
+	llc := data[headerLen:]
+	if llc[0] != 0xaa || llc[1] != 0xaa || llc[2] != 0x03 {
+		return nil
+	}
+	etherType := layers.EthernetType(binary.BigEndian.Uint16(llc[6:8]))
+	return d.decodeEtherType(etherType, llc[8:], p, t)
+}
+
+func (d *NetDecoder) decodeIPv4(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
 	// Decode the IPv4 layer
 	ipv4Layer := &layers.IPv4{}
 	if err := ipv4Layer.DecodeFromBytes(data, p); err != nil {
 		return err
 	}
+
+	// outer ip layer of a tunnel ?
+	if ipv4Layer.FragOffset == 0 && ipv4Layer.Flags&layers.IPv4MoreFragments == 0 {
+		if decap, err := d.decodeTunnel(ipv4Layer.Protocol, ipv4Layer.Payload, p, t); decap {
+			return err
+		}
+	}
+
 	p.AddLayer(ipv4Layer)
 	p.SetNetworkLayer(ipv4Layer)
 
@@ -57,12 +283,18 @@ func (d *NetDecoder) decodeIPv4(data []byte, p gopacket.PacketBuilder) error {
 	return nil
 }
 
-func (d *NetDecoder) decodeIPv6(data []byte, p gopacket.PacketBuilder) error {
+func (d *NetDecoder) decodeIPv6(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
 
 	ipv6Layer := &layers.IPv6{}
 	if err := ipv6Layer.DecodeFromBytes(data, p); err != nil {
 		return err
 	}
+
+	// outer ip layer of a tunnel ?
+	if decap, err := d.decodeTunnel(ipv6Layer.NextHeader, ipv6Layer.Payload, p, t); decap {
+		return err
+	}
+
 	p.AddLayer(ipv6Layer)
 	p.SetNetworkLayer(ipv6Layer)
 
@@ -1,6 +1,7 @@
 package netlib
 
 import (
+	"encoding/binary"
 	"testing"
 
 	"github.com/google/gopacket"
@@ -324,3 +325,141 @@ func TestNetDecoder_Decode_IPv6_EndFragment(t *testing.T) {
 		t.Errorf("Expected UDP layer, got %T", packetLayers[3])
 	}
 }
+
+func TestNetDecoder_Decode_LinkTypes(t *testing.T) {
+	ipv4Udp := []byte{
+		// ipv4
+		0x45, 0x00, 0x00, 0x44, 0xe5, 0x6a, 0x00, 0x00, 0x6f, 0x11,
+		0xec, 0x11, 0xac, 0xd9, 0x28, 0x4c, 0xc1, 0x18, 0xe3, 0xee,
+		// udp
+		0xdd, 0x68, 0x00, 0x35, 0x00, 0x30, 0x0c, 0x33,
+		// udp payload (dns)
+		0xd4, 0x3f, 0x00, 0x10, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x08, 0x77,
+		0x65, 0x62, 0x65, 0x72, 0x6c, 0x61, 0x62, 0x02, 0x64, 0x65, 0x00, 0x00, 0x30, 0x00,
+		0x01, 0x00, 0x00, 0x29, 0x10, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00,
+	}
+
+	testcases := []struct {
+		name     string
+		linkType int
+		header   []byte
+		trailer  []byte
+	}{
+		{
+			name:     "linux sll",
+			linkType: int(layers.LinkTypeLinuxSLL),
+			header: []byte{0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 0x00, 0x86, 0x9c, 0xe7, 0x55, 0x14,
+				0x00, 0x00, 0x08, 0x00},
+		},
+		{
+			name:     "linux sll2",
+			linkType: LinkTypeLinuxSLL2,
+			header: []byte{0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01, 0x00, 0x06,
+				0x00, 0x86, 0x9c, 0xe7, 0x55, 0x14, 0x00, 0x00},
+		},
+		{
+			name:     "raw ip",
+			linkType: int(layers.LinkTypeRaw),
+		},
+		{
+			name:     "null little endian",
+			linkType: int(layers.LinkTypeNull),
+			header:   []byte{0x02, 0x00, 0x00, 0x00},
+		},
+		{
+			name:     "loop",
+			linkType: int(layers.LinkTypeLoop),
+			header:   []byte{0x00, 0x00, 0x00, 0x02},
+		},
+		{
+			name:     "802.11 qos data with fcs",
+			linkType: int(layers.LinkTypeIEEE802_11),
+			header: []byte{
+				// frame control (qos data, to ds), duration
+				0x88, 0x01, 0x00, 0x00,
+				// addresses and sequence
+				0x00, 0x0c, 0x29, 0x8a, 0x5d, 0xd7, 0x00, 0x86, 0x9c, 0xe7, 0x55, 0x14,
+				0x00, 0x0c, 0x29, 0x8a, 0x5d, 0xd7, 0x10, 0x00,
+				// qos control
+				0x00, 0x00,
+				// llc and snap
+				0xaa, 0xaa, 0x03, 0x00, 0x00, 0x00, 0x08, 0x00,
+			},
+			trailer: []byte{0xde, 0xad, 0xbe, 0xef},
+		},
+		{
+			name:     "radiotap 802.11 data",
+			linkType: int(layers.LinkTypeIEEE80211Radio),
+			header: []byte{
+				// radiotap header without fields
+				0x00, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00,
+				// frame control (data, from ds), duration
+				0x08, 0x02, 0x00, 0x00,
+				// addresses and sequence
+				0x00, 0x0c, 0x29, 0x8a, 0x5d, 0xd7, 0x00, 0x86, 0x9c, 0xe7, 0x55, 0x14,
+				0x00, 0x0c, 0x29, 0x8a, 0x5d, 0xd7, 0x10, 0x00,
+				// llc and snap
+				0xaa, 0xaa, 0x03, 0x00, 0x00, 0x00, 0x08, 0x00,
+			},
+		},
+	}
+
+	// data frame, from ds, with llc and snap
+	dot11Data := []byte{
+		0x08, 0x02, 0x00, 0x00,
+		0x00, 0x0c, 0x29, 0x8a, 0x5d, 0xd7, 0x00, 0x86, 0x9c, 0xe7, 0x55, 0x14,
+		0x00, 0x0c, 0x29, 0x8a, 0x5d, 0xd7, 0x10, 0x00,
+		0xaa, 0xaa, 0x03, 0x00, 0x00, 0x00, 0x08, 0x00,
+	}
+	prism := make([]byte, 144)
+	binary.LittleEndian.PutUint32(prism[0:], 0x41)
+	binary.LittleEndian.PutUint32(prism[4:], 144)
+	avs := make([]byte, 64)
+	binary.BigEndian.PutUint32(avs[0:], 0x80211001)
+	binary.BigEndian.PutUint32(avs[4:], 64)
+
+	testcases = append(testcases, []struct {
+		name     string
+		linkType int
+		header   []byte
+		trailer  []byte
+	}{
+		{name: "prism 802.11 data", linkType: int(layers.LinkTypePrismHeader), header: append(prism, dot11Data...)},
+		{name: "avs 802.11 data", linkType: LinkTypeIEEE80211AVS, header: append(avs, dot11Data...)},
+		{name: "avs with the prism link type", linkType: int(layers.LinkTypePrismHeader), header: append(avs, dot11Data...)},
+	}...)
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if !IsSupportedLinkType(tc.linkType) {
+				t.Fatalf("link type %d not supported", tc.linkType)
+			}
+			decoder, err := NewNetDecoder(tc.linkType)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkt := append(append(append([]byte{}, tc.header...), ipv4Udp...), tc.trailer...)
+			packet := gopacket.NewPacket(pkt, decoder, gopacket.NoCopy)
+
+			if packet.NetworkLayer() == nil || packet.NetworkLayer().LayerType() != layers.LayerTypeIPv4 {
+				t.Fatalf("Expected IPv4 layer, got %v", packet.Layers())
+			}
+			if packet.TransportLayer() == nil || packet.TransportLayer().LayerType() != layers.LayerTypeUDP {
+				t.Fatalf("Expected UDP layer, got %v", packet.Layers())
+			}
+			if len(packet.TransportLayer().LayerPayload()) != 40 {
+				t.Errorf("Unexpected dns payload length: %d", len(packet.TransportLayer().LayerPayload()))
+			}
+		})
+	}
+}
+
+func TestNetDecoder_UnsupportedLinkType(t *testing.T) {
+	if IsSupportedLinkType(int(layers.LinkTypeTokenRing)) {
+		t.Errorf("token ring must not be supported")
+	}
+	if _, err := NewNetDecoder(int(layers.LinkTypeTokenRing)); err == nil {
+		t.Errorf("Expected error for unsupported link type")
+	}
+}
@@ -22,16 +22,34 @@ type DnsPacket struct {
 	IpDefragmented bool
 	// TCP reassembly
 	TcpReassembled bool
+	// Outer identifiers of the encapsulated packets
+	Tunnel *Tunnel
+	// Metadata of an encrypted dns connection, without dns payload
+	Tls *TlsInfo
 }
 
-func UdpProcessor(udpInput chan gopacket.Packet, dnsOutput chan DnsPacket, portFilter int) {
+// MatchPorts returns true if the source or destination port is one of the ports,
+// the zero ports are ignored and all the packets match without port
+func MatchPorts(srcPort, dstPort int, ports ...int) bool {
+	filtered := false
+	for _, port := range ports {
+		if port <= 0 {
+			continue
+		}
+		if srcPort == port || dstPort == port {
+			return true
+		}
+		filtered = true
+	}
+	return !filtered
+}
+
+func UdpProcessor(udpInput chan gopacket.Packet, dnsOutput chan DnsPacket, portFilter ...int) {
 	for packet := range udpInput {
 		p := packet.TransportLayer().(*layers.UDP)
 
-		if portFilter > 0 {
-			if int(p.SrcPort) != portFilter && int(p.DstPort) != portFilter {
-				continue
-			}
+		if !MatchPorts(int(p.SrcPort), int(p.DstPort), portFilter...) {
+			continue
 		}
 
 		dnsOutput <- DnsPacket{
@@ -41,16 +59,115 @@ func UdpProcessor(udpInput chan gopacket.Packet, dnsOutput chan DnsPacket, portF
 			Timestamp:      packet.Metadata().Timestamp,
 			TcpReassembled: false,
 			IpDefragmented: packet.Metadata().Truncated,
+			Tunnel:         GetTunnel(packet),
 		}
 	}
 }
 
-func TcpAssembler(tcpInput chan gopacket.Packet, dnsOutput chan DnsPacket, portFilter int) {
-	streamFactory := &DnsStreamFactory{Reassembled: dnsOutput}
+const (
+	// size of the pages of the out of order segments buffered by tcpassembly
+	tcpAssemblyPageSize = 1900
+
+	DefaultTcpFlushInterval = time.Minute
+	DefaultTcpIdleTimeout   = 2 * time.Minute
+)
+
+// TcpAssemblerOptions are the optional features and the limits of the tcp reassembly
+type TcpAssemblerOptions struct {
+	// Encrypted dns ports, the tls handshakes are decoded on these ports
+	TlsPorts []int
+	// Maximum number of connections, the packets of the new connections are ignored above, 0 for unlimited
+	MaxConnections int
+	// Maximum bytes buffered per stream, out of order segments and incomplete dns message, 0 for unlimited
+	MaxBufferedBytes int
+	// Interval of the flush of the streams inactive since the idle timeout, measured with the timestamps
+	// of the packets, the defaults are used if 0
+	FlushInterval time.Duration
+	IdleTimeout   time.Duration
+	// Optional counters, can be shared by several assemblers
+	Stats *TcpAssemblerStats
+}
+
+// packetClock follows the timestamps of the packets, so the timeouts are the same with a live
+// capture and with a capture file. Between two packets, the time elapsed since the last one is added.
+type packetClock struct {
+	last     time.Time
+	received time.Time
+	next     time.Time
+}
+
+func (c *packetClock) Update(timestamp time.Time) {
+	if timestamp.After(c.last) {
+		c.last = timestamp
+		c.received = time.Now()
+	}
+}
+
+func (c *packetClock) Now() time.Time {
+	if c.last.IsZero() {
+		return time.Now()
+	}
+	return c.last.Add(time.Since(c.received))
+}
+
+// Tick returns the current time and true once per interval, the first interval starts on the first call
+func (c *packetClock) Tick(interval time.Duration) (time.Time, bool) {
+	now := c.Now()
+	if c.next.IsZero() {
+		c.next = now.Add(interval)
+	}
+	if now.Before(c.next) {
+		return now, false
+	}
+	c.next = now.Add(interval)
+	return now, true
+}
+
+func TcpAssembler(tcpInput chan gopacket.Packet, dnsOutput chan DnsPacket, portFilter ...int) {
+	TcpAssemblerWithOptions(tcpInput, dnsOutput, TcpAssemblerOptions{}, portFilter...)
+}
+
+// TcpAssemblerWithOptions reassembles the dns messages of the tcp streams on the ports of the filter
+// and the tls handshakes on the encrypted dns ports
+func TcpAssemblerWithOptions(tcpInput chan gopacket.Packet, dnsOutput chan DnsPacket, options TcpAssemblerOptions, portFilter ...int) {
+	streamFactory := &DnsStreamFactory{
+		Reassembled:      dnsOutput,
+		TlsPorts:         options.TlsPorts,
+		MaxBufferedBytes: options.MaxBufferedBytes,
+		Stats:            options.Stats,
+	}
 	streamPool := tcpassembly.NewStreamPool(streamFactory)
 	assembler := tcpassembly.NewAssembler(streamPool)
 
-	ticker := time.NewTicker(time.Minute * 1)
+	// the out of order segments are skipped above the limits
+	if options.MaxBufferedBytes > 0 {
+		assembler.MaxBufferedPagesPerConnection = (options.MaxBufferedBytes + tcpAssemblyPageSize - 1) / tcpAssemblyPageSize
+		if options.MaxConnections > 0 {
+			assembler.MaxBufferedPagesTotal = assembler.MaxBufferedPagesPerConnection * options.MaxConnections
+		}
+	}
+
+	flushInterval := options.FlushInterval
+	if flushInterval <= 0 {
+		flushInterval = DefaultTcpFlushInterval
+	}
+	idleTimeout := options.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultTcpIdleTimeout
+	}
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	// the streams are flushed on the clock of the packets, checked on each packet and on the ticker
+	// when no packet is received
+	clock := &packetClock{}
+	flushIdle := func() {
+		if now, ok := clock.Tick(flushInterval); ok {
+			// flush and close the streams without activity since the idle timeout
+			_, closed := assembler.FlushOlderThan(now.Add(-idleTimeout))
+			options.Stats.streamsEvicted(closed)
+		}
+	}
 
 	for {
 		select {
@@ -58,6 +175,9 @@ func TcpAssembler(tcpInput chan gopacket.Packet, dnsOutput chan DnsPacket, portF
 			if !more {
 				goto FLUSHALL
 			}
+			clock.Update(packet.Metadata().Timestamp)
+			flushIdle()
+
 			p := packet.TransportLayer().(*layers.TCP)
 
 			// ip fragments should not happened with tcp ...
@@ -66,20 +186,25 @@ func TcpAssembler(tcpInput chan gopacket.Packet, dnsOutput chan DnsPacket, portF
 			}
 
 			// ignore packet ?
-			if portFilter > 0 {
-				if int(p.SrcPort) != portFilter && int(p.DstPort) != portFilter {
-					continue
-				}
+			if !MatchPorts(int(p.SrcPort), int(p.DstPort), portFilter...) &&
+				!(len(options.TlsPorts) > 0 && MatchPorts(int(p.SrcPort), int(p.DstPort), options.TlsPorts...)) {
+				continue
 			}
 
-			assembler.AssembleWithTimestamp(
-				packet.NetworkLayer().NetworkFlow(),
-				packet.TransportLayer().(*layers.TCP),
-				packet.Metadata().Timestamp,
-			)
+			// no more connections above the limit, until the end or the eviction of the existing ones
+			netFlow := packet.NetworkLayer().NetworkFlow()
+			if options.MaxConnections > 0 && streamFactory.Connections() >= options.MaxConnections &&
+				!streamFactory.HasConnection(netFlow, p.TransportFlow()) {
+				options.Stats.packetRejected()
+				continue
+			}
+
+			// tunnel identifiers of the new streams
+			streamFactory.Tunnel = GetTunnel(packet)
+
+			assembler.AssembleWithTimestamp(netFlow, p, packet.Metadata().Timestamp)
 		case <-ticker.C:
-			// Every minute, flush connections that haven't seen activity in the past 2 minutes.
-			assembler.FlushOlderThan(time.Now().Add(time.Minute * -2))
+			flushIdle()
 		}
 	}
 FLUSHALL:
@@ -87,20 +212,52 @@ FLUSHALL:
 }
 
 func IpDefragger(ipInput chan gopacket.Packet, udpOutput chan gopacket.Packet, tcpOutput chan gopacket.Packet) {
-	defragger := NewIPDefragmenter()
-	for fragment := range ipInput {
-		reassembled, err := defragger.DefragIP(fragment)
-		if err != nil {
-			break
-		} else if reassembled == nil {
-			continue
-		} else {
+	IpDefraggerWithOptions(ipInput, udpOutput, tcpOutput, IpDefragOptions{})
+}
+
+// IpDefraggerWithOptions reassembles the ip fragments with limits, the incomplete datagrams are
+// discarded after the timeout, measured with the timestamps of the packets, and the invalid fragments are dropped
+func IpDefraggerWithOptions(ipInput chan gopacket.Packet, udpOutput chan gopacket.Packet, tcpOutput chan gopacket.Packet, options IpDefragOptions) {
+	defragger := NewIPDefragmenterWithOptions(options)
+
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultIpDefragTimeout
+	}
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	// the incomplete datagrams are discarded on the clock of the packets, a capture file replayed
+	// with old timestamps keeps its datagrams in reassembly
+	clock := &packetClock{}
+	discardExpired := func() {
+		if now, ok := clock.Tick(timeout / 2); ok {
+			defragger.DiscardOlderThan(now.Add(-timeout))
+		}
+	}
+
+	for {
+		select {
+		case fragment, more := <-ipInput:
+			if !more {
+				return
+			}
+			clock.Update(fragment.Metadata().Timestamp)
+			discardExpired()
+
+			reassembled, err := defragger.DefragIP(fragment)
+			if err != nil || reassembled == nil {
+				continue
+			}
 			if reassembled.TransportLayer() != nil && reassembled.TransportLayer().LayerType() == layers.LayerTypeUDP {
 				udpOutput <- reassembled
 			}
 			if reassembled.TransportLayer() != nil && reassembled.TransportLayer().LayerType() == layers.LayerTypeTCP {
 				tcpOutput <- reassembled
 			}
+
+		case <-ticker.C:
+			discardExpired()
 		}
 	}
 }
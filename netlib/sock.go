@@ -4,10 +4,13 @@
 package netlib
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 	"os"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 // Configure SO_RCVBUF, thanks to https://github.com/dmachard/go-dns-collector/issues/61#issuecomment-1201199895
@@ -41,3 +44,21 @@ func SetSock_RCVBUF(conn net.Conn, desired int, is_tls bool) (int, int, error) {
 	}
 	return before, actual, nil
 }
+
+// ListenReusePort announces on the address with the SO_REUSEPORT option,
+// the kernel balances the new connections between the listeners of the same address
+func ListenReusePort(network, address string) (net.Listener, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var opErr error
+			err := c.Control(func(fd uintptr) {
+				opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return opErr
+		},
+	}
+	return lc.Listen(context.Background(), network, address)
+}
@@ -5,6 +5,7 @@ package netlib
 
 import (
 	"crypto/tls"
+	"errors"
 	"net"
 	"os"
 
@@ -42,3 +43,8 @@ func SetSock_RCVBUF(conn net.Conn, desired int, is_tls bool) (int, int, error) {
 	}
 	return before, actual, nil
 }
+
+// ListenReusePort is not supported, SO_REUSEPORT does not exist on windows
+func ListenReusePort(network, address string) (net.Listener, error) {
+	return nil, errors.New("SO_REUSEPORT is not supported on windows")
+}
@@ -2,86 +2,258 @@ package netlib
 
 import (
 	"bytes"
-	"io"
+	"encoding/binary"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/tcpassembly"
 )
 
+// TcpAssemblerStats are the counters of the tcp reassembly, shared by the assemblers of a collector
+type TcpAssemblerStats struct {
+	// streams created, one per direction of a connection
+	Streams uint64
+	// streams closed after the idle timeout
+	Evicted uint64
+	// packets of the new connections ignored above the maximum number of connections
+	Rejected uint64
+	// incomplete dns messages discarded, above the maximum buffered bytes or with missing data
+	Truncated uint64
+}
+
+func (s *TcpAssemblerStats) streamCreated() {
+	if s != nil {
+		atomic.AddUint64(&s.Streams, 1)
+	}
+}
+
+func (s *TcpAssemblerStats) streamsEvicted(n int) {
+	if s != nil {
+		atomic.AddUint64(&s.Evicted, uint64(n))
+	}
+}
+
+func (s *TcpAssemblerStats) packetRejected() {
+	if s != nil {
+		atomic.AddUint64(&s.Rejected, 1)
+	}
+}
+
+func (s *TcpAssemblerStats) streamTruncated() {
+	if s != nil {
+		atomic.AddUint64(&s.Truncated, 1)
+	}
+}
+
+// Counters returns the current values of the counters, by name
+func (s *TcpAssemblerStats) Counters() map[string]uint64 {
+	return map[string]uint64{
+		"tcp_streams_created":   atomic.LoadUint64(&s.Streams),
+		"tcp_streams_evicted":   atomic.LoadUint64(&s.Evicted),
+		"tcp_packets_rejected":  atomic.LoadUint64(&s.Rejected),
+		"tcp_streams_truncated": atomic.LoadUint64(&s.Truncated),
+	}
+}
+
+// streamKey identifies the stream of one direction of a connection
+type streamKey struct {
+	net, transport gopacket.Flow
+}
+
+// connectionKey returns the same key for both directions of a connection
+func connectionKey(net, transport gopacket.Flow) streamKey {
+	switch bytes.Compare(net.Src().Raw(), net.Dst().Raw()) {
+	case 1:
+		return streamKey{net.Reverse(), transport.Reverse()}
+	case 0:
+		if bytes.Compare(transport.Src().Raw(), transport.Dst().Raw()) > 0 {
+			return streamKey{net.Reverse(), transport.Reverse()}
+		}
+	}
+	return streamKey{net, transport}
+}
+
 type DnsStreamFactory struct {
 	// Channel to send reassembled DNS data
 	Reassembled    chan DnsPacket
 	IpDefragmented bool
+	Tunnel         *Tunnel
+	// Encrypted dns ports, the tls handshakes are decoded instead of the dns messages
+	TlsPorts []int
+	// Maximum bytes of an incomplete dns message, 0 for unlimited
+	MaxBufferedBytes int
+	// Optional counters
+	Stats *TcpAssemblerStats
+	// number of streams not completed, by connection
+	connections    map[streamKey]int
+	tlsConnections map[streamKey]*tlsConnection
+}
+
+func (s *DnsStreamFactory) isTlsPort(port int) bool {
+	for _, p := range s.TlsPorts {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// Connections returns the number of connections with a stream not completed
+func (s *DnsStreamFactory) Connections() int {
+	return len(s.connections)
+}
+
+// HasConnection returns true if a stream of the connection, in any direction, is not completed
+func (s *DnsStreamFactory) HasConnection(net, transport gopacket.Flow) bool {
+	return s.connections[connectionKey(net, transport)] > 0
 }
 
 func (s *DnsStreamFactory) New(net, transport gopacket.Flow) tcpassembly.Stream {
+	if s.connections == nil {
+		s.connections = make(map[streamKey]int)
+	}
+	key := streamKey{net, transport}
+	s.connections[connectionKey(net, transport)]++
+	s.Stats.streamCreated()
+
+	srcPort := int(binary.BigEndian.Uint16(transport.Src().Raw()))
+	dstPort := int(binary.BigEndian.Uint16(transport.Dst().Raw()))
+	if s.isTlsPort(dstPort) {
+		return s.newTlsStream(key, key, dstPort, true)
+	}
+	if s.isTlsPort(srcPort) {
+		return s.newTlsStream(key, streamKey{net.Reverse(), transport.Reverse()}, srcPort, false)
+	}
+
 	return &stream{
+		key:            key,
+		factory:        s,
 		net:            net,
 		transport:      transport,
 		data:           make([]byte, 0),
 		reassembled:    s.Reassembled,
 		ipDefragmented: s.IpDefragmented,
+		tunnel:         s.Tunnel,
+	}
+}
+
+// closeStream forgets a stream completed or evicted
+func (s *DnsStreamFactory) closeStream(key streamKey) {
+	conn := connectionKey(key.net, key.transport)
+	s.connections[conn]--
+	if s.connections[conn] <= 0 {
+		delete(s.connections, conn)
 	}
 }
 
 type stream struct {
+	key            streamKey
+	factory        *DnsStreamFactory
 	net, transport gopacket.Flow
 	data           []byte
 	lenDns         int
+	// bytes to drop at the end of a dns message too large
+	discard        int
 	LastSeen       time.Time
 	reassembled    chan DnsPacket
 	tcpReassembled bool
 	ipDefragmented bool
+	tunnel         *Tunnel
+}
+
+// reset discards the incomplete dns message
+func (s *stream) reset() {
+	s.factory.Stats.streamTruncated()
+	s.data = nil
+	s.lenDns = 0
 }
 
 func (s *stream) Reassembled(rs []tcpassembly.Reassembly) {
 	for _, r := range rs {
 		if r.Skip > 0 {
+			// the incomplete dns message is lost with the missing data
+			if len(s.data) > 0 || s.discard > 0 {
+				s.reset()
+				s.discard = 0
+			}
 			continue
 		}
-		// Append the reassembled data to the existing data
-		s.data = append(s.data, r.Bytes...)
-
-		// If the length of the DNS message has not been read yet, try to read it from the TCP stream
-		if s.lenDns == 0 {
-			lenBuf := make([]byte, 2)
 
-			reader := bytes.NewReader(s.data)
-			nRead, err := io.ReadFull(reader, lenBuf)
-			if err != nil {
-				continue
+		data := r.Bytes
+		if s.discard > 0 {
+			n := s.discard
+			if n > len(data) {
+				n = len(data)
 			}
-			if nRead < 2 {
-				continue
+			data = data[n:]
+			s.discard -= n
+		}
+
+		// Append the reassembled data to the existing data
+		s.data = append(s.data, data...)
+
+		// several dns messages can be received in the same segment
+		for len(s.data) >= 2 {
+			// If the length of the DNS message has not been read yet, try to read it from the TCP stream
+			if s.lenDns == 0 {
+				s.lenDns = int(binary.BigEndian.Uint16(s.data[:2]))
+				s.tcpReassembled = false
 			}
 
-			// Convert the length of the DNS message from the buffer to a uint
-			s.lenDns = int(uint(lenBuf[0])<<8 | uint(lenBuf[1]))
-			s.tcpReassembled = false
-		}
+			if len(s.data) < s.lenDns+2 {
+				// the end of a message too large is dropped, the next message is read after
+				if s.factory.MaxBufferedBytes > 0 && len(s.data) > s.factory.MaxBufferedBytes {
+					s.discard = s.lenDns + 2 - len(s.data)
+					s.reset()
+				} else {
+					s.tcpReassembled = true
+				}
+				break
+			}
 
-		if len(s.data) == s.lenDns+2 {
 			s.LastSeen = r.Seen
 
 			// send the reassembled data to the channel
-			s.reassembled <- DnsPacket{
-				Payload:        s.data[2 : s.lenDns+2],
-				IpLayer:        s.net,
-				TransportLayer: s.transport,
-				Timestamp:      s.LastSeen,
-				IpDefragmented: s.ipDefragmented,
-				TcpReassembled: s.tcpReassembled,
+			if s.lenDns > 0 {
+				s.reassembled <- DnsPacket{
+					Payload:        s.data[2 : s.lenDns+2],
+					IpLayer:        s.net,
+					TransportLayer: s.transport,
+					Timestamp:      s.LastSeen,
+					IpDefragmented: s.ipDefragmented,
+					TcpReassembled: s.tcpReassembled,
+					Tunnel:         s.tunnel,
+				}
 			}
 
 			//Reset the buffer.
 			s.data = s.data[s.lenDns+2:]
 			s.lenDns = 0
-
-		} else {
-			s.tcpReassembled = true
 		}
 	}
 }
 
-func (s *stream) ReassemblyComplete() {}
+func (s *stream) ReassemblyComplete() {
+	s.factory.closeStream(s.key)
+}
+
+// newTlsStream returns the stream of one direction of an encrypted dns connection
+func (s *DnsStreamFactory) newTlsStream(key streamKey, connKey streamKey, serverPort int, fromClient bool) tcpassembly.Stream {
+	if s.tlsConnections == nil {
+		s.tlsConnections = make(map[streamKey]*tlsConnection)
+	}
+	conn, ok := s.tlsConnections[connKey]
+	if !ok {
+		conn = &tlsConnection{
+			key:            connKey,
+			serverPort:     serverPort,
+			ipDefragmented: s.IpDefragmented,
+			tunnel:         s.Tunnel,
+		}
+		s.tlsConnections[connKey] = conn
+	}
+	conn.streams++
+
+	return &tlsStream{key: key, conn: conn, fromClient: fromClient, factory: s}
+}
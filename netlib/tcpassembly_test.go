@@ -1,8 +1,10 @@
 package netlib
 
 import (
+	"net"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
@@ -117,3 +119,181 @@ func Test_TcpAssembly(t *testing.T) {
 		})
 	}
 }
+
+// tcpSegments returns the packets of a tcp connection from 10.0.0.1 to 10.0.0.53, the data
+// of each direction is split in segments of 100 bytes
+func tcpSegments(t *testing.T, clientPort, serverPort layers.TCPPort, fromClient []byte, fromServer []byte, fin bool) []gopacket.Packet {
+	packets := []gopacket.Packet{}
+	timestamp := time.Unix(1700000000, 0)
+
+	segment := func(client bool, syn, fin bool, seq uint32, payload []byte) {
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP,
+			SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 53}}
+		tcp := &layers.TCP{SrcPort: clientPort, DstPort: serverPort, Seq: seq, SYN: syn, FIN: fin, ACK: !syn, Window: 65535}
+		if !client {
+			ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+			tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+		}
+		tcp.SetNetworkLayerForChecksum(ip)
+		data := serialize(t, &layers.Ethernet{SrcMAC: outerMac, DstMAC: outerMac, EthernetType: layers.EthernetTypeIPv4},
+			ip, tcp, gopacket.Payload(payload))
+
+		packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+		packet.Metadata().Timestamp = timestamp
+		packets = append(packets, packet)
+	}
+
+	clientSeq, serverSeq := uint32(1000), uint32(5000)
+	segment(true, true, false, clientSeq, nil)
+	segment(false, true, false, serverSeq, nil)
+	clientSeq++
+	serverSeq++
+	for _, direction := range []struct {
+		client bool
+		data   []byte
+		seq    *uint32
+	}{{true, fromClient, &clientSeq}, {false, fromServer, &serverSeq}} {
+		for i := 0; i < len(direction.data); i += 100 {
+			end := i + 100
+			if end > len(direction.data) {
+				end = len(direction.data)
+			}
+			segment(direction.client, false, false, *direction.seq, direction.data[i:end])
+			*direction.seq += uint32(end - i)
+		}
+	}
+	if fin {
+		segment(true, false, true, clientSeq, nil)
+		segment(false, false, true, serverSeq, nil)
+	}
+	return packets
+}
+
+// assembleTcp returns the packets sent by the tcp assembler, on the port 53 and the encrypted dns ports
+func assembleTcp(packets []gopacket.Packet, options TcpAssemblerOptions) []DnsPacket {
+	tcpChan := make(chan gopacket.Packet)
+	dnsChan := make(chan DnsPacket)
+	go func() {
+		TcpAssemblerWithOptions(tcpChan, dnsChan, options, 53)
+		close(dnsChan)
+	}()
+	go func() {
+		for _, packet := range packets {
+			tcpChan <- packet
+		}
+		close(tcpChan)
+	}()
+
+	dnsPackets := []DnsPacket{}
+	for dnsPacket := range dnsChan {
+		dnsPackets = append(dnsPackets, dnsPacket)
+	}
+	return dnsPackets
+}
+
+// tcpMessage returns a dns message prefixed by its length
+func tcpMessage(payload []byte) []byte {
+	return append([]byte{byte(len(payload) >> 8), byte(len(payload))}, payload...)
+}
+
+func TestTcpAssembler_SeveralMessagesPerSegment(t *testing.T) {
+	query := tcpMessage(dnsQuery)
+	dnsPackets := assembleTcp(tcpSegments(t, 36000, 53, append(query, query...), query, true), TcpAssemblerOptions{})
+	if len(dnsPackets) != 3 {
+		t.Fatalf("want 3 dns messages, got %d", len(dnsPackets))
+	}
+	for _, dnsPacket := range dnsPackets {
+		if string(dnsPacket.Payload) != string(dnsQuery) {
+			t.Errorf("invalid payload %x", dnsPacket.Payload)
+		}
+	}
+}
+
+func TestTcpAssembler_MaxBufferedBytes(t *testing.T) {
+	// a message of 300 bytes, dropped, followed by a query
+	large := tcpMessage(make([]byte, 300))
+	stats := &TcpAssemblerStats{}
+	options := TcpAssemblerOptions{MaxBufferedBytes: 150, Stats: stats}
+
+	dnsPackets := assembleTcp(tcpSegments(t, 36000, 53, append(large, tcpMessage(dnsQuery)...), nil, true), options)
+	if len(dnsPackets) != 1 || string(dnsPackets[0].Payload) != string(dnsQuery) {
+		t.Fatalf("want the query after the large message, got %+v", dnsPackets)
+	}
+	if stats.Truncated != 1 || stats.Streams != 2 {
+		t.Errorf("invalid counters %+v", stats.Counters())
+	}
+}
+
+func TestTcpAssembler_MaxConnections(t *testing.T) {
+	query := tcpMessage(dnsQuery)
+
+	// the third connection is ignored while the first ones are open
+	stats := &TcpAssemblerStats{}
+	packets := []gopacket.Packet{}
+	for _, port := range []layers.TCPPort{36000, 36001, 36002} {
+		packets = append(packets, tcpSegments(t, port, 53, query, query, false)...)
+	}
+	dnsPackets := assembleTcp(packets, TcpAssemblerOptions{MaxConnections: 2, Stats: stats})
+	if len(dnsPackets) != 4 {
+		t.Errorf("want the messages of 2 connections, got %d", len(dnsPackets))
+	}
+	if stats.Rejected != 4 || stats.Streams != 4 {
+		t.Errorf("invalid counters %+v", stats.Counters())
+	}
+
+	// the closed connections are not counted
+	stats = &TcpAssemblerStats{}
+	packets = []gopacket.Packet{}
+	for _, port := range []layers.TCPPort{36000, 36001, 36002} {
+		packets = append(packets, tcpSegments(t, port, 53, query, query, true)...)
+	}
+	dnsPackets = assembleTcp(packets, TcpAssemblerOptions{MaxConnections: 2, Stats: stats})
+	if len(dnsPackets) != 6 || stats.Rejected != 0 {
+		t.Errorf("want the messages of 3 connections, got %d, counters %+v", len(dnsPackets), stats.Counters())
+	}
+}
+
+func TestTcpAssembler_IdleTimeout(t *testing.T) {
+	testcases := []struct {
+		name    string
+		delay   time.Duration
+		evicted uint64
+	}{
+		{"before the idle timeout", time.Minute, 0},
+		{"after the idle timeout", 3 * time.Minute, 2},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			stats := &TcpAssemblerStats{}
+			options := TcpAssemblerOptions{FlushInterval: time.Minute, IdleTimeout: 2 * time.Minute, Stats: stats}
+
+			// a query without reply and a connection never closed, followed by a packet
+			// of another connection, the streams are flushed on the timestamps of the packets
+			packets := tcpSegments(t, 36000, 53, tcpMessage(dnsQuery), nil, false)
+			other := tcpSegments(t, 36001, 80, nil, nil, false)[0]
+			other.Metadata().Timestamp = packets[0].Metadata().Timestamp.Add(tc.delay)
+			packets = append(packets, other)
+
+			tcpChan := make(chan gopacket.Packet)
+			dnsChan := make(chan DnsPacket, 10)
+			done := make(chan bool)
+			go func() {
+				TcpAssemblerWithOptions(tcpChan, dnsChan, options, 53)
+				done <- true
+			}()
+			for _, packet := range packets {
+				tcpChan <- packet
+			}
+			close(tcpChan)
+			<-done
+
+			if len(dnsChan) != 1 {
+				t.Errorf("want the query, got %d messages", len(dnsChan))
+			}
+			if stats.Evicted != tc.evicted {
+				t.Errorf("want %d streams evicted, got %+v", tc.evicted, stats.Counters())
+			}
+		})
+	}
+}
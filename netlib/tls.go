@@ -0,0 +1,383 @@
+package netlib
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+const (
+	DotPort = 853
+	DotAlpn = "dot"
+
+	tlsRecordHeaderLen    = 5
+	tlsHandshakeHeaderLen = 4
+	tlsRandomLen          = 32
+
+	tlsRecordHandshake = 22
+
+	TlsHandshakeClientHello = 1
+	TlsHandshakeServerHello = 2
+
+	tlsExtensionServerName        = 0
+	tlsExtensionAlpn              = 16
+	tlsExtensionSupportedVersions = 43
+
+	tlsServerNameHost = 0
+
+	// the hello messages are searched in the first bytes of each direction
+	tlsMaxHandshakeLen = 16384
+
+	// events of an encrypted dns connection
+	TlsEventHandshake = "HANDSHAKE"
+	TlsEventClose     = "CLOSE"
+)
+
+var (
+	ErrTlsTruncated    = errors.New("tls handshake truncated")
+	ErrTlsNotHandshake = errors.New("not a tls handshake")
+	ErrTlsMalformed    = errors.New("tls handshake malformed")
+
+	tlsVersions = map[uint16]string{
+		0x0300: "SSL3.0",
+		0x0301: "TLS1.0",
+		0x0302: "TLS1.1",
+		0x0303: "TLS1.2",
+		0x0304: "TLS1.3",
+	}
+)
+
+// TlsInfo is the metadata of an encrypted dns connection, decoded from the tls handshake
+type TlsInfo struct {
+	// TlsEventHandshake after the hello messages, TlsEventClose at the end of the connection
+	Event string
+	// PROTO_DOT or PROTO_DOH
+	Protocol string
+	// server name indication of the client hello
+	ServerName string
+	// application protocols offered by the client
+	Alpn []string
+	// version selected by the server, or the highest version offered by the client
+	Version string
+	// tcp payload sent by the client and by the server, known at the end of the connection
+	ClientBytes int
+	ServerBytes int
+}
+
+// TlsVersion returns the name of a tls protocol version
+func TlsVersion(version uint16) string {
+	if name, ok := tlsVersions[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// isGrease returns true for the reserved values sent by the clients to check the extensibility, RFC 8701
+func isGrease(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+// tlsReader reads the fields of a handshake message
+type tlsReader struct {
+	data []byte
+	err  error
+}
+
+func (r *tlsReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.data) {
+		r.err = ErrTlsMalformed
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *tlsReader) uint8() int {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])
+}
+
+func (r *tlsReader) uint16() int {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+// vector reads a field prefixed by its length on n bytes
+func (r *tlsReader) vector(n int) *tlsReader {
+	length := 0
+	switch n {
+	case 1:
+		length = r.uint8()
+	case 2:
+		length = r.uint16()
+	}
+	return &tlsReader{data: r.bytes(length), err: r.err}
+}
+
+// extensions calls the handler for each extension of a hello message
+func (r *tlsReader) extensions(handler func(extType int, ext *tlsReader)) {
+	// the extensions are optional
+	if r.err != nil || len(r.data) == 0 {
+		return
+	}
+	exts := r.vector(2)
+	for exts.err == nil && len(exts.data) > 0 {
+		extType := exts.uint16()
+		ext := exts.vector(2)
+		if ext.err == nil {
+			handler(extType, ext)
+		}
+	}
+	if exts.err != nil {
+		r.err = exts.err
+	}
+}
+
+// ReadTlsHandshake returns the first handshake message of a tls stream, the message can
+// be fragmented in several records. ErrTlsTruncated is returned until the full message is received.
+func ReadTlsHandshake(data []byte) (int, []byte, error) {
+	msg := []byte{}
+	for len(data) > 0 {
+		if len(data) < tlsRecordHeaderLen {
+			return 0, nil, ErrTlsTruncated
+		}
+		// handshake record with a major version 3
+		if data[0] != tlsRecordHandshake || data[1] != 3 {
+			return 0, nil, ErrTlsNotHandshake
+		}
+		recordLen := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < tlsRecordHeaderLen+recordLen {
+			return 0, nil, ErrTlsTruncated
+		}
+		msg = append(msg, data[tlsRecordHeaderLen:tlsRecordHeaderLen+recordLen]...)
+		data = data[tlsRecordHeaderLen+recordLen:]
+
+		if len(msg) < tlsHandshakeHeaderLen {
+			continue
+		}
+		msgLen := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+		if len(msg) >= tlsHandshakeHeaderLen+msgLen {
+			return int(msg[0]), msg[tlsHandshakeHeaderLen : tlsHandshakeHeaderLen+msgLen], nil
+		}
+	}
+	return 0, nil, ErrTlsTruncated
+}
+
+// DecodeClientHello reads the server name, the application protocols
+// and the versions offered by the client
+func (t *TlsInfo) DecodeClientHello(msg []byte) error {
+	r := &tlsReader{data: msg}
+	version := r.uint16()
+	r.bytes(tlsRandomLen)
+	r.vector(1) // session id
+	r.vector(2) // cipher suites
+	r.vector(1) // compression methods
+
+	serverName := ""
+	alpn := []string{}
+	r.extensions(func(extType int, ext *tlsReader) {
+		switch extType {
+		case tlsExtensionServerName:
+			names := ext.vector(2)
+			for names.err == nil && len(names.data) > 0 {
+				nameType := names.uint8()
+				name := names.vector(2)
+				if name.err == nil && nameType == tlsServerNameHost {
+					serverName = string(name.data)
+				}
+			}
+		case tlsExtensionAlpn:
+			protocols := ext.vector(2)
+			for protocols.err == nil && len(protocols.data) > 0 {
+				protocol := protocols.vector(1)
+				if protocol.err == nil {
+					alpn = append(alpn, string(protocol.data))
+				}
+			}
+		case tlsExtensionSupportedVersions:
+			versions := ext.vector(1)
+			for versions.err == nil && len(versions.data) > 0 {
+				v := versions.uint16()
+				if versions.err == nil && !isGrease(uint16(v)) && v > version {
+					version = v
+				}
+			}
+		}
+	})
+	if r.err != nil {
+		return r.err
+	}
+
+	t.ServerName = serverName
+	t.Alpn = alpn
+	// the version selected by the server is kept
+	if len(t.Version) == 0 {
+		t.Version = TlsVersion(uint16(version))
+	}
+	return nil
+}
+
+// DecodeServerHello reads the version selected by the server
+func (t *TlsInfo) DecodeServerHello(msg []byte) error {
+	r := &tlsReader{data: msg}
+	version := r.uint16()
+	r.bytes(tlsRandomLen)
+	r.vector(1) // session id
+	r.bytes(2)  // cipher suite
+	r.bytes(1)  // compression method
+
+	r.extensions(func(extType int, ext *tlsReader) {
+		// tls 1.3 and later
+		if extType == tlsExtensionSupportedVersions {
+			if v := ext.uint16(); ext.err == nil {
+				version = v
+			}
+		}
+	})
+	if r.err != nil {
+		return r.err
+	}
+
+	t.Version = TlsVersion(uint16(version))
+	return nil
+}
+
+// GetTlsProtocol returns PROTO_DOT for the port 853 or the dot application protocol, PROTO_DOH otherwise
+func GetTlsProtocol(serverPort int, alpn []string) string {
+	if serverPort == DotPort {
+		return dnsutils.PROTO_DOT
+	}
+	for _, protocol := range alpn {
+		if protocol == DotAlpn {
+			return dnsutils.PROTO_DOT
+		}
+	}
+	return dnsutils.PROTO_DOH
+}
+
+// tlsConnection is shared by the streams of both directions of an encrypted dns connection
+type tlsConnection struct {
+	// flows from the client to the server
+	key            streamKey
+	serverPort     int
+	info           TlsInfo
+	clientHello    bool
+	serverHello    bool
+	handshakeSent  bool
+	firstSeen      time.Time
+	lastSeen       time.Time
+	streams        int
+	ipDefragmented bool
+	tunnel         *Tunnel
+}
+
+// tlsStream decodes the hello message at the beginning of a direction and counts the bytes
+type tlsStream struct {
+	key        streamKey
+	conn       *tlsConnection
+	fromClient bool
+	data       []byte
+	done       bool
+	factory    *DnsStreamFactory
+}
+
+func (s *tlsStream) Reassembled(rs []tcpassembly.Reassembly) {
+	for _, r := range rs {
+		if s.conn.firstSeen.IsZero() {
+			s.conn.firstSeen = r.Seen
+		}
+		if r.Seen.After(s.conn.lastSeen) {
+			s.conn.lastSeen = r.Seen
+		}
+		if s.fromClient {
+			s.conn.info.ClientBytes += len(r.Bytes)
+		} else {
+			s.conn.info.ServerBytes += len(r.Bytes)
+		}
+
+		if s.done {
+			continue
+		}
+		// the hello message is lost without the beginning of the stream
+		if r.Skip != 0 {
+			s.done, s.data = true, nil
+			continue
+		}
+
+		s.data = append(s.data, r.Bytes...)
+		msgType, msg, err := ReadTlsHandshake(s.data)
+		if errors.Is(err, ErrTlsTruncated) && len(s.data) < tlsMaxHandshakeLen {
+			continue
+		}
+		s.done, s.data = true, nil
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case s.fromClient && msgType == TlsHandshakeClientHello:
+			s.conn.clientHello = s.conn.info.DecodeClientHello(msg) == nil
+		case !s.fromClient && msgType == TlsHandshakeServerHello:
+			s.conn.serverHello = s.conn.info.DecodeServerHello(msg) == nil
+		}
+
+		// the handshake is sent as soon as both hello messages are decoded
+		if s.conn.clientHello && s.conn.serverHello && !s.conn.handshakeSent {
+			s.conn.handshakeSent = true
+			s.sendEvent(TlsEventHandshake, r.Seen)
+		}
+	}
+}
+
+// ReassemblyComplete sends the byte counters after the end of both directions, preceded by the
+// handshake if one of the hello messages is missing. The connections without hello message are ignored.
+func (s *tlsStream) ReassemblyComplete() {
+	s.factory.closeStream(s.key)
+	s.conn.streams--
+	if s.conn.streams > 0 {
+		return
+	}
+	delete(s.factory.tlsConnections, s.conn.key)
+
+	if !s.conn.clientHello && !s.conn.serverHello {
+		return
+	}
+	if !s.conn.handshakeSent {
+		s.conn.handshakeSent = true
+		s.sendEvent(TlsEventHandshake, s.conn.firstSeen)
+	}
+	s.sendEvent(TlsEventClose, s.conn.lastSeen)
+}
+
+// sendEvent sends the metadata of the connection, the byte counters are only sent on close
+func (s *tlsStream) sendEvent(event string, timestamp time.Time) {
+	info := s.conn.info
+	info.Event = event
+	info.Protocol = GetTlsProtocol(s.conn.serverPort, info.Alpn)
+	if event != TlsEventClose {
+		info.ClientBytes, info.ServerBytes = 0, 0
+	}
+
+	s.factory.Reassembled <- DnsPacket{
+		IpLayer:        s.conn.key.net,
+		TransportLayer: s.conn.key.transport,
+		Timestamp:      timestamp,
+		IpDefragmented: s.conn.ipDefragmented,
+		Tunnel:         s.conn.tunnel,
+		Tls:            &info,
+	}
+}
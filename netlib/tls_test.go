@@ -0,0 +1,270 @@
+package netlib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// recordConn keeps the bytes written on the connection
+type recordConn struct {
+	net.Conn
+	sync.Mutex
+	written []byte
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.Lock()
+	c.written = append(c.written, b...)
+	c.Unlock()
+	return c.Conn.Write(b)
+}
+
+func selfSignedCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dns.collector"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// tlsExchange returns the bytes sent by the client and the server during a handshake
+// followed by a query and a reply
+func tlsExchange(t *testing.T, clientConfig *tls.Config, serverConfig *tls.Config) ([]byte, []byte) {
+	c, s := net.Pipe()
+	clientConn := &recordConn{Conn: c}
+	serverConn := &recordConn{Conn: s}
+
+	done := make(chan error)
+	go func() {
+		server := tls.Server(serverConn, serverConfig)
+		buf := make([]byte, len(dnsQuery))
+		if _, err := io.ReadFull(server, buf); err != nil {
+			done <- err
+			return
+		}
+		_, err := server.Write(buf)
+		done <- err
+	}()
+
+	client := tls.Client(clientConn, clientConfig)
+	if _, err := client.Write(dnsQuery); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, len(dnsQuery))); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	s.Close()
+
+	return clientConn.written, serverConn.written
+}
+
+func TestTls_TcpAssembler(t *testing.T) {
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{selfSignedCertificate(t)}, NextProtos: []string{"dot", "h2"}}
+
+	testcases := []struct {
+		name         string
+		serverPort   layers.TCPPort
+		clientConfig *tls.Config
+		expected     TlsInfo
+	}{
+		{
+			name:       "dot",
+			serverPort: 853,
+			clientConfig: &tls.Config{ServerName: "dns.collector", NextProtos: []string{"dot"},
+				InsecureSkipVerify: true},
+			expected: TlsInfo{Protocol: dnsutils.PROTO_DOT, ServerName: "dns.collector",
+				Alpn: []string{"dot"}, Version: "TLS1.3"},
+		},
+		{
+			name:       "doh tls 1.2",
+			serverPort: 443,
+			clientConfig: &tls.Config{ServerName: "doh.collector", NextProtos: []string{"h2", "http/1.1"},
+				MaxVersion: tls.VersionTLS12, InsecureSkipVerify: true},
+			expected: TlsInfo{Protocol: dnsutils.PROTO_DOH, ServerName: "doh.collector",
+				Alpn: []string{"h2", "http/1.1"}, Version: "TLS1.2"},
+		},
+		{
+			name:         "dot alpn on another port",
+			serverPort:   8853,
+			clientConfig: &tls.Config{NextProtos: []string{"dot"}, InsecureSkipVerify: true},
+			expected:     TlsInfo{Protocol: dnsutils.PROTO_DOT, Alpn: []string{"dot"}, Version: "TLS1.3"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			fromClient, fromServer := tlsExchange(t, tc.clientConfig, serverConfig)
+			dnsPackets := assembleTcp(tcpSegments(t, 36000, tc.serverPort, fromClient, fromServer, true),
+				TcpAssemblerOptions{TlsPorts: []int{int(tc.serverPort)}})
+
+			// the handshake is sent before the byte counters
+			if len(dnsPackets) != 2 {
+				t.Fatalf("want the handshake and close events, got %d", len(dnsPackets))
+			}
+			for _, dnsPacket := range dnsPackets {
+				if dnsPacket.Tls == nil || len(dnsPacket.Payload) != 0 {
+					t.Fatalf("tls metadata expected without payload: %+v", dnsPacket)
+				}
+			}
+
+			tc.expected.Event = TlsEventHandshake
+			if !reflect.DeepEqual(*dnsPackets[0].Tls, tc.expected) {
+				t.Errorf("want %+v, got %+v", tc.expected, *dnsPackets[0].Tls)
+			}
+			tc.expected.Event = TlsEventClose
+			tc.expected.ClientBytes = len(fromClient)
+			tc.expected.ServerBytes = len(fromServer)
+			if !reflect.DeepEqual(*dnsPackets[1].Tls, tc.expected) {
+				t.Errorf("want %+v, got %+v", tc.expected, *dnsPackets[1].Tls)
+			}
+
+			dnsPacket := dnsPackets[1]
+			// the flows are oriented from the client to the server
+			if dnsPacket.IpLayer.Src().String() != "10.0.0.1" || dnsPacket.TransportLayer.Dst().String() != strconv.Itoa(int(tc.serverPort)) {
+				t.Errorf("invalid flows %s %s", dnsPacket.IpLayer, dnsPacket.TransportLayer)
+			}
+		})
+	}
+}
+
+func TestTls_TcpAssembler_HandshakeBeforeClose(t *testing.T) {
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{selfSignedCertificate(t)}}
+	clientConfig := &tls.Config{ServerName: "dns.collector", InsecureSkipVerify: true}
+	fromClient, fromServer := tlsExchange(t, clientConfig, serverConfig)
+
+	tcpChan := make(chan gopacket.Packet)
+	dnsChan := make(chan DnsPacket)
+	go func() {
+		TcpAssemblerWithOptions(tcpChan, dnsChan, TcpAssemblerOptions{TlsPorts: []int{853}})
+		close(dnsChan)
+	}()
+	packets := tcpSegments(t, 36000, 853, fromClient, fromServer, false)
+	sent := make(chan bool)
+	go func() {
+		for _, packet := range packets {
+			tcpChan <- packet
+		}
+		close(sent)
+	}()
+
+	// the connection is still open
+	select {
+	case dnsPacket := <-dnsChan:
+		if dnsPacket.Tls == nil || dnsPacket.Tls.Event != TlsEventHandshake || dnsPacket.Tls.ServerName != "dns.collector" {
+			t.Fatalf("handshake expected, got %+v", dnsPacket)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handshake not sent before the end of the connection")
+	}
+
+	<-sent
+	close(tcpChan)
+	dnsPacket, ok := <-dnsChan
+	if !ok || dnsPacket.Tls == nil || dnsPacket.Tls.Event != TlsEventClose || dnsPacket.Tls.ClientBytes != len(fromClient) {
+		t.Errorf("close event expected, got %+v", dnsPacket)
+	}
+}
+
+func TestTls_TcpAssembler_NotTls(t *testing.T) {
+	// dns over tcp on the tls port
+	query := append([]byte{0, byte(len(dnsQuery))}, dnsQuery...)
+	dnsPackets := assembleTcp(tcpSegments(t, 36000, 853, query, query, true), TcpAssemblerOptions{TlsPorts: []int{853}})
+	if len(dnsPackets) != 0 {
+		t.Errorf("no connection expected, got %d", len(dnsPackets))
+	}
+
+	// dns over tcp on the dns port is still decoded
+	dnsPackets = assembleTcp(tcpSegments(t, 36000, 53, query, query, true), TcpAssemblerOptions{TlsPorts: []int{853}})
+	if len(dnsPackets) != 2 || dnsPackets[0].Tls != nil {
+		t.Errorf("want the dns query and reply, got %+v", dnsPackets)
+	}
+}
+
+func TestTls_ReadHandshake(t *testing.T) {
+	clientHello := []byte{TlsHandshakeClientHello, 0, 0, 4, 0x03, 0x03, 0xaa, 0xbb}
+
+	record := func(data []byte) []byte {
+		return append([]byte{tlsRecordHandshake, 3, 1, 0, byte(len(data))}, data...)
+	}
+
+	testcases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"record", record(clientHello), nil},
+		{"fragmented records", append(record(clientHello[:3]), record(clientHello[3:])...), nil},
+		{"truncated record", record(clientHello)[:7], ErrTlsTruncated},
+		{"truncated message", record(clientHello[:6]), ErrTlsTruncated},
+		{"application data", append([]byte{23, 3, 3, 0, 8}, clientHello...), ErrTlsNotHandshake},
+		{"dns over tcp", append([]byte{0, byte(len(dnsQuery))}, dnsQuery...), ErrTlsNotHandshake},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			msgType, msg, err := ReadTlsHandshake(tc.data)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("want error %v, got %v", tc.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if msgType != TlsHandshakeClientHello || !reflect.DeepEqual(msg, clientHello[4:]) {
+				t.Errorf("invalid message %d %x", msgType, msg)
+			}
+		})
+	}
+}
+
+func TestTls_DecodeMalformedHello(t *testing.T) {
+	info := &TlsInfo{}
+	if err := info.DecodeClientHello([]byte{0x03, 0x03, 0x00}); !errors.Is(err, ErrTlsMalformed) {
+		t.Errorf("want malformed error, got %v", err)
+	}
+	if err := info.DecodeServerHello(make([]byte, 10)); !errors.Is(err, ErrTlsMalformed) {
+		t.Errorf("want malformed error, got %v", err)
+	}
+}
+
+func TestTls_Version(t *testing.T) {
+	if v := TlsVersion(tls.VersionTLS13); v != "TLS1.3" {
+		t.Errorf("want TLS1.3, got %s", v)
+	}
+	if v := TlsVersion(0x7f1c); v != "0x7f1c" {
+		t.Errorf("want 0x7f1c, got %s", v)
+	}
+	if !isGrease(0x1a1a) || isGrease(0x0304) || isGrease(0x1a2a) {
+		t.Error("invalid grease values")
+	}
+}
@@ -0,0 +1,239 @@
+package netlib
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	DecapVlan   = "vlan"
+	DecapGre    = "gre"
+	DecapErspan = "erspan"
+	DecapVxlan  = "vxlan"
+	DecapGeneve = "geneve"
+
+	VxlanPort  = 4789
+	GenevePort = 6081
+
+	// ethertypes not defined by gopacket
+	EthernetTypeQinQLegacy layers.EthernetType = 0x9100
+	EthernetTypeERSPANIII  layers.EthernetType = 0x22eb
+
+	erspan3HeaderLen    = 12
+	erspan3SubHeaderLen = 8
+)
+
+// LayerTypeTunnel is the pseudo layer added by the NetDecoder with the identifiers
+// of the encapsulations removed
+var LayerTypeTunnel = gopacket.RegisterLayerType(1990, gopacket.LayerTypeMetadata{
+	Name: "Tunnel",
+	Decoder: gopacket.DecodeFunc(func(data []byte, p gopacket.PacketBuilder) error {
+		return errors.New("tunnel layer can not be decoded")
+	}),
+})
+
+// DecapOptions enables the encapsulations to remove
+type DecapOptions struct {
+	Vlan   bool
+	Gre    bool
+	Erspan bool
+	Vxlan  bool
+	Geneve bool
+}
+
+// NewDecapOptions returns the options from the list of encapsulations of the configuration
+func NewDecapOptions(encapsulations []string) (DecapOptions, error) {
+	opts := DecapOptions{}
+	for _, encap := range encapsulations {
+		switch encap {
+		case DecapVlan:
+			opts.Vlan = true
+		case DecapGre:
+			opts.Gre = true
+		case DecapErspan:
+			opts.Erspan = true
+		case DecapVxlan:
+			opts.Vxlan = true
+		case DecapGeneve:
+			opts.Geneve = true
+		default:
+			return opts, fmt.Errorf("invalid decapsulation %q", encap)
+		}
+	}
+	return opts, nil
+}
+
+// Enabled returns true if at least one encapsulation is removed
+func (o DecapOptions) Enabled() bool {
+	return o.Vlan || o.Gre || o.Erspan || o.Vxlan || o.Geneve
+}
+
+// Tunnel holds the outer identifiers of a decapsulated packet
+type Tunnel struct {
+	layers.BaseLayer
+	// Encapsulations removed, from the outer to the inner one
+	Encapsulations []string
+	// VLAN ids, from the outer to the inner tag
+	VlanIds []int
+	// VXLAN or GENEVE network identifier
+	Vni int
+	// ERSPAN session id
+	ErspanSession int
+}
+
+func (t *Tunnel) LayerType() gopacket.LayerType { return LayerTypeTunnel }
+
+func (t *Tunnel) addEncapsulation(encap string) {
+	t.Encapsulations = append(t.Encapsulations, encap)
+}
+
+// GetTunnel returns the tunnel identifiers of the packet, nil if not encapsulated
+func GetTunnel(packet gopacket.Packet) *Tunnel {
+	if l := packet.Layer(LayerTypeTunnel); l != nil {
+		return l.(*Tunnel)
+	}
+	return nil
+}
+
+func (d *NetDecoder) decodeDot1Q(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
+	// Decode the 802.1Q tag, the QinQ tags are decoded recursively
+	dot1qLayer := &layers.Dot1Q{}
+	if err := dot1qLayer.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(dot1qLayer)
+
+	if len(t.VlanIds) == 0 {
+		t.addEncapsulation(DecapVlan)
+	}
+	t.VlanIds = append(t.VlanIds, int(dot1qLayer.VLANIdentifier))
+
+	return d.decodeEtherType(dot1qLayer.Type, dot1qLayer.Payload, p, t)
+}
+
+// decodeTunnel decodes the payload of an outer ip layer, returns false if it is not
+// an encapsulation to remove
+func (d *NetDecoder) decodeTunnel(protocol layers.IPProtocol, data []byte, p gopacket.PacketBuilder, t *Tunnel) (bool, error) {
+	switch protocol {
+	case layers.IPProtocolGRE:
+		if d.Decap.Gre || d.Decap.Erspan {
+			return true, d.decodeGRE(data, p, t)
+		}
+	case layers.IPProtocolUDP:
+		if len(data) < 8 {
+			return false, nil
+		}
+		dstPort := binary.BigEndian.Uint16(data[2:4])
+		if d.Decap.Vxlan && dstPort == VxlanPort {
+			return true, d.decodeVXLAN(data[8:], p, t)
+		}
+		if d.Decap.Geneve && dstPort == GenevePort {
+			return true, d.decodeGeneve(data[8:], p, t)
+		}
+	}
+	return false, nil
+}
+
+func (d *NetDecoder) decodeGRE(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
+	if len(data) < 4 {
+		return fmt.Errorf("gre header too short")
+	}
+	greLayer := &layers.GRE{}
+	if err := greLayer.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+
+	switch greLayer.Protocol {
+	case layers.EthernetTypeERSPAN:
+		if !d.Decap.Erspan {
+			return nil
+		}
+		t.addEncapsulation(DecapErspan)
+		// ERSPAN type I has no header and no sequence number
+		if !greLayer.SeqPresent {
+			return d.decodeEthernet(greLayer.Payload, p, t)
+		}
+		erspanLayer := &layers.ERSPANII{}
+		if err := erspanLayer.DecodeFromBytes(greLayer.Payload, p); err != nil {
+			return err
+		}
+		if t.ErspanSession == 0 {
+			t.ErspanSession = int(erspanLayer.SessionID)
+		}
+		return d.decodeEthernet(erspanLayer.Payload, p, t)
+
+	case EthernetTypeERSPANIII:
+		if !d.Decap.Erspan {
+			return nil
+		}
+		t.addEncapsulation(DecapErspan)
+		return d.decodeERSPANIII(greLayer.Payload, p, t)
+
+	case layers.EthernetTypeTransparentEthernetBridging:
+		if !d.Decap.Gre {
+			return nil
+		}
+		t.addEncapsulation(DecapGre)
+		return d.decodeEthernet(greLayer.Payload, p, t)
+
+	case layers.EthernetTypeIPv4, layers.EthernetTypeIPv6:
+		if !d.Decap.Gre {
+			return nil
+		}
+		t.addEncapsulation(DecapGre)
+		return d.decodeEtherType(greLayer.Protocol, greLayer.Payload, p, t)
+	}
+	return nil
+}
+
+func (d *NetDecoder) decodeERSPANIII(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
+	// Same session id field as the type II, followed by the timestamp and
+	// the optional platform specific sub-header
+	if len(data) < erspan3HeaderLen {
+		return fmt.Errorf("erspan type III header too short")
+	}
+	if t.ErspanSession == 0 {
+		t.ErspanSession = int(binary.BigEndian.Uint16(data[2:4]) & 0x03ff)
+	}
+	headerLen := erspan3HeaderLen
+	if data[11]&0x01 != 0 {
+		headerLen += erspan3SubHeaderLen
+	}
+	if len(data) < headerLen {
+		return fmt.Errorf("erspan type III sub-header too short")
+	}
+	return d.decodeEthernet(data[headerLen:], p, t)
+}
+
+func (d *NetDecoder) decodeVXLAN(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
+	vxlanLayer := &layers.VXLAN{}
+	if err := vxlanLayer.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	t.addEncapsulation(DecapVxlan)
+	if t.Vni == 0 {
+		t.Vni = int(vxlanLayer.VNI)
+	}
+	return d.decodeEthernet(vxlanLayer.Payload, p, t)
+}
+
+func (d *NetDecoder) decodeGeneve(data []byte, p gopacket.PacketBuilder, t *Tunnel) error {
+	geneveLayer := &layers.Geneve{}
+	if err := geneveLayer.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	t.addEncapsulation(DecapGeneve)
+	if t.Vni == 0 {
+		t.Vni = int(geneveLayer.VNI)
+	}
+
+	// ethernet frame or ip packet
+	if geneveLayer.Protocol == layers.EthernetTypeTransparentEthernetBridging {
+		return d.decodeEthernet(geneveLayer.Payload, p, t)
+	}
+	return d.decodeEtherType(geneveLayer.Protocol, geneveLayer.Payload, p, t)
+}
@@ -0,0 +1,166 @@
+package netlib
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	dnsQuery = []byte{
+		0xd4, 0x3f, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x64,
+		0x6e, 0x73, 0x09, 0x63, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x00, 0x00,
+		0x01, 0x00, 0x01,
+	}
+	outerMac = net.HardwareAddr{0x00, 0x0c, 0x29, 0x8a, 0x5d, 0xd7}
+)
+
+// innerLayers returns the ethernet frame of a dns query sent to 10.0.0.53
+func innerLayers() []gopacket.SerializableLayer {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP,
+		SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 53}}
+	udp := &layers.UDP{SrcPort: 36000, DstPort: 53}
+	udp.SetNetworkLayerForChecksum(ip)
+	return []gopacket.SerializableLayer{
+		&layers.Ethernet{SrcMAC: outerMac, DstMAC: outerMac, EthernetType: layers.EthernetTypeIPv4},
+		ip, udp, gopacket.Payload(dnsQuery),
+	}
+}
+
+// outerLayers returns the headers of the outer ip packet
+func outerLayers(protocol layers.IPProtocol) []gopacket.SerializableLayer {
+	return []gopacket.SerializableLayer{
+		&layers.Ethernet{SrcMAC: outerMac, DstMAC: outerMac, EthernetType: layers.EthernetTypeIPv4},
+		&layers.IPv4{Version: 4, TTL: 64, Protocol: protocol,
+			SrcIP: net.IP{192, 168, 1, 1}, DstIP: net.IP{192, 168, 1, 2}},
+	}
+}
+
+// outerUdpLayers returns the headers of the outer udp packet
+func outerUdpLayers(dstPort layers.UDPPort) []gopacket.SerializableLayer {
+	l := outerLayers(layers.IPProtocolUDP)
+	udp := &layers.UDP{SrcPort: 50000, DstPort: dstPort}
+	udp.SetNetworkLayerForChecksum(l[1].(*layers.IPv4))
+	return append(l, udp)
+}
+
+func serialize(t *testing.T, l ...gopacket.SerializableLayer) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, l...); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestNetDecoder_Decap(t *testing.T) {
+	inner := innerLayers()
+
+	// geneve header with the vni 300 and an ethernet payload
+	geneve := gopacket.Payload{0x00, 0x00, 0x65, 0x58, 0x00, 0x01, 0x2c, 0x00}
+	// erspan type III header with the session 7 and without sub-header
+	erspan3 := gopacket.Payload{0x20, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	testcases := []struct {
+		name   string
+		layers []gopacket.SerializableLayer
+		want   Tunnel
+	}{
+		{
+			name: "qinq",
+			layers: append([]gopacket.SerializableLayer{
+				&layers.Ethernet{SrcMAC: outerMac, DstMAC: outerMac, EthernetType: layers.EthernetTypeQinQ},
+				&layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeDot1Q},
+				&layers.Dot1Q{VLANIdentifier: 200, Type: layers.EthernetTypeIPv4},
+			}, inner[1:]...),
+			want: Tunnel{Encapsulations: []string{DecapVlan}, VlanIds: []int{100, 200}},
+		},
+		{
+			name: "gre ip",
+			layers: append(append(outerLayers(layers.IPProtocolGRE),
+				&layers.GRE{Protocol: layers.EthernetTypeIPv4}), inner[1:]...),
+			want: Tunnel{Encapsulations: []string{DecapGre}},
+		},
+		{
+			name: "erspan type II",
+			layers: append(append(outerLayers(layers.IPProtocolGRE),
+				&layers.GRE{Protocol: layers.EthernetTypeERSPAN, SeqPresent: true, Seq: 1},
+				&layers.ERSPANII{Version: 1, SessionID: 42}), inner...),
+			want: Tunnel{Encapsulations: []string{DecapErspan}, ErspanSession: 42},
+		},
+		{
+			name: "erspan type III",
+			layers: append(append(outerLayers(layers.IPProtocolGRE),
+				&layers.GRE{Protocol: EthernetTypeERSPANIII, SeqPresent: true, Seq: 1}, erspan3), inner...),
+			want: Tunnel{Encapsulations: []string{DecapErspan}, ErspanSession: 7},
+		},
+		{
+			name: "vxlan",
+			layers: append(append(outerUdpLayers(VxlanPort),
+				&layers.VXLAN{ValidIDFlag: true, VNI: 5000}), inner...),
+			want: Tunnel{Encapsulations: []string{DecapVxlan}, Vni: 5000},
+		},
+		{
+			name:   "geneve",
+			layers: append(append(outerUdpLayers(GenevePort), geneve), inner...),
+			want:   Tunnel{Encapsulations: []string{DecapGeneve}, Vni: 300},
+		},
+	}
+
+	decap, err := NewDecapOptions([]string{DecapVlan, DecapGre, DecapErspan, DecapVxlan, DecapGeneve})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			decoder := &NetDecoder{Decap: decap}
+			packet := gopacket.NewPacket(serialize(t, tc.layers...), decoder, gopacket.NoCopy)
+
+			// the network and transport layers are the inner ones
+			if packet.NetworkLayer() == nil || packet.TransportLayer() == nil {
+				t.Fatalf("inner layers not decoded: %v", packet.Layers())
+			}
+			if dst := packet.NetworkLayer().NetworkFlow().Dst().String(); dst != "10.0.0.53" {
+				t.Errorf("invalid inner destination ip: %s", dst)
+			}
+			udp, ok := packet.TransportLayer().(*layers.UDP)
+			if !ok || udp.DstPort != 53 || len(udp.Payload) != len(dnsQuery) {
+				t.Errorf("invalid inner udp layer: %v", packet.TransportLayer())
+			}
+
+			tunnel := GetTunnel(packet)
+			if tunnel == nil {
+				t.Fatalf("tunnel layer missing")
+			}
+			if !reflect.DeepEqual(tunnel.Encapsulations, tc.want.Encapsulations) ||
+				!reflect.DeepEqual(tunnel.VlanIds, tc.want.VlanIds) ||
+				tunnel.Vni != tc.want.Vni || tunnel.ErspanSession != tc.want.ErspanSession {
+				t.Errorf("want tunnel %+v, got %+v", tc.want, *tunnel)
+			}
+		})
+	}
+}
+
+func TestNetDecoder_DecapDisabled(t *testing.T) {
+	// the vxlan packet is decoded as a plain udp packet
+	data := serialize(t, append(append(outerUdpLayers(VxlanPort),
+		&layers.VXLAN{ValidIDFlag: true, VNI: 5000}), innerLayers()...)...)
+
+	packet := gopacket.NewPacket(data, &NetDecoder{}, gopacket.NoCopy)
+	if GetTunnel(packet) != nil {
+		t.Errorf("unexpected tunnel layer")
+	}
+	if udp, ok := packet.TransportLayer().(*layers.UDP); !ok || udp.DstPort != VxlanPort {
+		t.Errorf("want the outer udp layer, got %v", packet.TransportLayer())
+	}
+}
+
+func TestNetDecoder_InvalidDecapOptions(t *testing.T) {
+	if _, err := NewDecapOptions([]string{"mpls"}); err == nil {
+		t.Errorf("want error for unsupported decapsulation")
+	}
+}
@@ -0,0 +1,73 @@
+package netlib
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket/layers"
+	"github.com/rs/tzsp"
+)
+
+const (
+	// raw ip encapsulation, not defined by the tzsp package
+	TzspProtoRawIP tzsp.Proto = 0x07
+)
+
+// tzspEncapsulation is the link type of the frames of a TZSP encapsulation
+type tzspEncapsulation struct {
+	name     string
+	linkType int
+}
+
+var tzspEncapsulations = map[tzsp.Proto]tzspEncapsulation{
+	tzsp.ProtoEthernet:    {"ethernet", int(layers.LinkTypeEthernet)},
+	TzspProtoRawIP:        {"raw-ip", int(layers.LinkTypeRaw)},
+	tzsp.ProtoIEEE80211:   {"802.11", int(layers.LinkTypeIEEE802_11)},
+	tzsp.ProtoPrismHeader: {"prism", int(layers.LinkTypePrismHeader)},
+	tzsp.ProtoWLANAVS:     {"avs", LinkTypeIEEE80211AVS},
+}
+
+// TzspInfo is the metadata of a TZSP packet, from the header and the tagged fields
+type TzspInfo struct {
+	// encapsulation of the captured frame
+	Encapsulation string
+	// link type of the captured frame
+	LinkType int
+	// address of the sensor, or its serial number if it is not a mac address
+	SensorMac string
+	// time the sensor received the frame, in the unit of the sensor
+	Timestamp uint32
+}
+
+// ParseTzsp returns the captured frame of a TZSP packet and its metadata,
+// an error is returned for the unsupported encapsulations
+func ParseTzsp(data []byte) ([]byte, *TzspInfo, error) {
+	packet, err := tzsp.Parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encap, ok := tzspEncapsulations[packet.Header.Proto]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported tzsp encapsulation %d", packet.Header.Proto)
+	}
+	info := &TzspInfo{Encapsulation: encap.name, LinkType: encap.linkType}
+
+	for _, tag := range packet.Tags {
+		switch tag.Type {
+		case tzsp.TagWLANRadioHDRSerial:
+			if len(tag.Data) == 6 {
+				info.SensorMac = net.HardwareAddr(tag.Data).String()
+			} else {
+				info.SensorMac = hex.EncodeToString(tag.Data)
+			}
+		case tzsp.TagTimestamp:
+			if len(tag.Data) == 4 {
+				info.Timestamp = binary.BigEndian.Uint32(tag.Data)
+			}
+		}
+	}
+	return packet.Data, info, nil
+}
@@ -0,0 +1,102 @@
+package netlib
+
+import (
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/rs/tzsp"
+)
+
+func TestTzsp_Parse(t *testing.T) {
+	frame := []byte{0x45, 0x00, 0x00, 0x14}
+
+	testcases := []struct {
+		name     string
+		packet   []byte
+		expected TzspInfo
+	}{
+		{
+			name:     "ethernet",
+			packet:   append([]byte{1, 0, 0, 1, 0x01}, frame...),
+			expected: TzspInfo{Encapsulation: "ethernet", LinkType: int(layers.LinkTypeEthernet)},
+		},
+		{
+			name: "raw ip with sensor mac and timestamp",
+			packet: append([]byte{1, 0, 0, 7,
+				0x3c, 6, 0x00, 0x0c, 0x29, 0x8a, 0x5d, 0xd7,
+				0x0d, 4, 0x00, 0x00, 0x04, 0xd2,
+				0x00, 0x01}, frame...),
+			expected: TzspInfo{Encapsulation: "raw-ip", LinkType: int(layers.LinkTypeRaw),
+				SensorMac: "00:0c:29:8a:5d:d7", Timestamp: 1234},
+		},
+		{
+			name:     "802.11 with sensor serial",
+			packet:   append([]byte{1, 0, 0, 18, 0x3c, 3, 0x01, 0x02, 0x03, 0x01}, frame...),
+			expected: TzspInfo{Encapsulation: "802.11", LinkType: int(layers.LinkTypeIEEE802_11), SensorMac: "010203"},
+		},
+		{
+			name:     "prism",
+			packet:   append([]byte{1, 0, 0, 119, 0x01}, frame...),
+			expected: TzspInfo{Encapsulation: "prism", LinkType: int(layers.LinkTypePrismHeader)},
+		},
+		{
+			name:     "avs",
+			packet:   append([]byte{1, 0, 0, 127, 0x01}, frame...),
+			expected: TzspInfo{Encapsulation: "avs", LinkType: LinkTypeIEEE80211AVS},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, info, err := ParseTzsp(tc.packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *info != tc.expected {
+				t.Errorf("want %+v, got %+v", tc.expected, *info)
+			}
+			if string(data) != string(frame) {
+				t.Errorf("invalid frame %x", data)
+			}
+			if !IsSupportedLinkType(info.LinkType) {
+				t.Errorf("link type %d not supported", info.LinkType)
+			}
+		})
+	}
+}
+
+func TestTzsp_ParseErrors(t *testing.T) {
+	// token ring
+	if _, _, err := ParseTzsp([]byte{1, 0, 0, 2, 0x01}); err == nil {
+		t.Errorf("error expected for an unsupported encapsulation")
+	}
+	// without end tag
+	if _, _, err := ParseTzsp([]byte{1, 0, 0, 1, 0x00}); err != tzsp.ErrMissingEndTag {
+		t.Errorf("want missing end tag error, got %v", err)
+	}
+}
+
+func TestTzsp_DecodeRawIP(t *testing.T) {
+	packet := append([]byte{1, 0, 0, 7, 0x01},
+		// ipv4
+		0x45, 0x00, 0x00, 0x1c+0x1f, 0x00, 0x00, 0x00, 0x00, 0x40, 0x11, 0x00, 0x00,
+		0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x35,
+		// udp
+		0x8c, 0xa0, 0x00, 0x35, 0x00, 0x08+0x1f, 0x00, 0x00)
+	packet = append(packet, dnsQuery...)
+
+	frame, info, err := ParseTzsp(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder, err := NewNetDecoder(info.LinkType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := gopacket.NewPacket(frame, decoder, gopacket.NoCopy)
+	udp, ok := decoded.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || string(udp.Payload) != string(dnsQuery) {
+		t.Errorf("invalid packet: %s", decoded)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
 	"github.com/dmachard/go-dnscollector/transformers"
 	"github.com/dmachard/go-logger"
 	"github.com/miekg/dns"
@@ -16,6 +17,15 @@ func GetFakeDns() ([]byte, error) {
 	return dnsmsg.Pack()
 }
 
+// TlsEventOperation returns the operation of an event of an encrypted dns connection,
+// these events are not dns messages
+func TlsEventOperation(tls *dnsutils.DnsTls) string {
+	if tls.Event == netlib.TlsEventClose {
+		return dnsutils.DNSTAP_TLS_CLOSE
+	}
+	return dnsutils.DNSTAP_TLS_HANDSHAKE
+}
+
 type DnsProcessor struct {
 	doneRun      chan bool
 	stopRun      chan bool
@@ -28,6 +38,7 @@ type DnsProcessor struct {
 	name         string
 	dropped      chan string
 	droppedCount map[string]int
+	transfers    *dnsutils.ZoneTransfers
 }
 
 func NewDnsProcessor(config *dnsutils.Config, logger *logger.Logger, name string, size int) DnsProcessor {
@@ -44,6 +55,7 @@ func NewDnsProcessor(config *dnsutils.Config, logger *logger.Logger, name string
 		name:         name,
 		dropped:      make(chan string),
 		droppedCount: map[string]int{},
+		transfers:    dnsutils.NewZoneTransfers(),
 	}
 	return d
 }
@@ -110,6 +122,43 @@ FOLLOW_LOOP:
 	d.LogInfo("monitor terminated")
 }
 
+// DecodeDnsMessage decodes the dns payload, the ip addresses and ports are swapped for the replies
+func (d *DnsProcessor) DecodeDnsMessage(dm *dnsutils.DnsMessage) {
+	dnsHeader, err := dnsutils.DecodeDns(dm.DNS.Payload)
+	if err != nil {
+		dm.DNS.MalformedPacket = true
+		d.LogError("dns parser malformed packet: %s - %v+", err, *dm)
+	}
+
+	// dns reply ?
+	if dnsHeader.Qr == 1 {
+		dm.DnsTap.Operation = "CLIENT_RESPONSE"
+		dm.DNS.Type = dnsutils.DnsReply
+		qip := dm.NetworkInfo.QueryIp
+		qport := dm.NetworkInfo.QueryPort
+		dm.NetworkInfo.QueryIp = dm.NetworkInfo.ResponseIp
+		dm.NetworkInfo.QueryPort = dm.NetworkInfo.ResponsePort
+		dm.NetworkInfo.ResponseIp = qip
+		dm.NetworkInfo.ResponsePort = qport
+	} else {
+		dm.DNS.Type = dnsutils.DnsQuery
+		dm.DnsTap.Operation = dnsutils.DNSTAP_CLIENT_QUERY
+	}
+
+	if err = dnsutils.DecodePayload(dm, &dnsHeader, d.config); err != nil {
+		d.LogError("%v - %v", err, *dm)
+	}
+
+	// zone of the following messages of the zone transfers
+	d.transfers.Track(dm)
+
+	if dm.DNS.MalformedPacket {
+		if d.config.Global.Trace.LogMalformed {
+			d.LogInfo("payload: %v", dm.DNS.Payload)
+		}
+	}
+}
+
 func (d *DnsProcessor) Run(loggersChannel []chan dnsutils.DnsMessage, loggersName []string) {
 	// prepare enabled transformers
 	transforms := transformers.NewTransforms(&d.config.IngoingTransformers, d.logger, d.name, loggersChannel, 0)
@@ -146,36 +195,11 @@ RUN_LOOP:
 			dm.DnsTap.Timestamp = ts.UnixNano()
 			dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
 
-			// decode the dns payload
-			dnsHeader, err := dnsutils.DecodeDns(dm.DNS.Payload)
-			if err != nil {
-				dm.DNS.MalformedPacket = true
-				d.LogError("dns parser malformed packet: %s - %v+", err, dm)
-			}
-
-			// dns reply ?
-			if dnsHeader.Qr == 1 {
-				dm.DnsTap.Operation = "CLIENT_RESPONSE"
-				dm.DNS.Type = dnsutils.DnsReply
-				qip := dm.NetworkInfo.QueryIp
-				qport := dm.NetworkInfo.QueryPort
-				dm.NetworkInfo.QueryIp = dm.NetworkInfo.ResponseIp
-				dm.NetworkInfo.QueryPort = dm.NetworkInfo.ResponsePort
-				dm.NetworkInfo.ResponseIp = qip
-				dm.NetworkInfo.ResponsePort = qport
+			// encrypted dns connection, only the tls handshake is known
+			if dm.NetworkInfo.Tls != nil {
+				dm.DnsTap.Operation = TlsEventOperation(dm.NetworkInfo.Tls)
 			} else {
-				dm.DNS.Type = dnsutils.DnsQuery
-				dm.DnsTap.Operation = dnsutils.DNSTAP_CLIENT_QUERY
-			}
-
-			if err = dnsutils.DecodePayload(&dm, &dnsHeader, d.config); err != nil {
-				d.LogError("%v - %v", err, dm)
-			}
-
-			if dm.DNS.MalformedPacket {
-				if d.config.Global.Trace.LogMalformed {
-					d.LogInfo("payload: %v", dm.DNS.Payload)
-				}
+				d.DecodeDnsMessage(&dm)
 			}
 
 			// apply all enabled transformers
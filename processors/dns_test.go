@@ -0,0 +1,51 @@
+package processors
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/netlib"
+	"github.com/dmachard/go-logger"
+)
+
+func Test_DnsProcessor_TlsEvents(t *testing.T) {
+	logger := logger.New(true)
+	var o bytes.Buffer
+	logger.SetOutput(&o)
+
+	// init the dns processor
+	consumer := NewDnsProcessor(dnsutils.GetFakeConfig(), logger, "test", 512)
+	chan_to := make(chan dnsutils.DnsMessage, 512)
+	go consumer.Run([]chan dnsutils.DnsMessage{chan_to}, []string{"test"})
+	defer consumer.Stop()
+
+	testcases := []struct {
+		event     string
+		operation string
+	}{
+		{event: netlib.TlsEventHandshake, operation: dnsutils.DNSTAP_TLS_HANDSHAKE},
+		{event: netlib.TlsEventClose, operation: dnsutils.DNSTAP_TLS_CLOSE},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.event, func(t *testing.T) {
+			// tls event of an encrypted dns connection, without dns payload
+			dm := dnsutils.DnsMessage{}
+			dm.Init()
+			dm.NetworkInfo.Tls = &dnsutils.DnsTls{Event: tc.event, ServerName: "dns.collector"}
+			consumer.GetChannel() <- dm
+
+			dm = <-chan_to
+			if dm.DnsTap.Operation != tc.operation {
+				t.Errorf("invalid operation: want %s, got %s", tc.operation, dm.DnsTap.Operation)
+			}
+			if dm.DNS.Type != "-" {
+				t.Errorf("tls event must not be a dns message, got type %s", dm.DNS.Type)
+			}
+			if !dm.IsTlsEvent() {
+				t.Errorf("tls event not detected")
+			}
+		})
+	}
+}
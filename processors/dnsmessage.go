@@ -0,0 +1,197 @@
+package processors
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-dnscollector/transformers"
+	"github.com/dmachard/go-logger"
+)
+
+type DnsMessageProcessor struct {
+	ConnId       int
+	doneRun      chan bool
+	stopRun      chan bool
+	doneMonitor  chan bool
+	stopMonitor  chan bool
+	recvFrom     chan []byte
+	logger       *logger.Logger
+	config       *dnsutils.Config
+	ConfigChan   chan *dnsutils.Config
+	name         string
+	mode         string
+	textFormat   []string
+	dropped      chan string
+	droppedCount map[string]int
+}
+
+func NewDnsMessageProcessor(connId int, mode string, textFormat []string, config *dnsutils.Config, logger *logger.Logger, name string, size int) DnsMessageProcessor {
+	logger.Info("[%s] processor=dnsmessage#%d - initialization...", name, connId)
+
+	d := DnsMessageProcessor{
+		ConnId:       connId,
+		doneMonitor:  make(chan bool),
+		doneRun:      make(chan bool),
+		stopMonitor:  make(chan bool),
+		stopRun:      make(chan bool),
+		recvFrom:     make(chan []byte, size),
+		logger:       logger,
+		config:       config,
+		ConfigChan:   make(chan *dnsutils.Config),
+		name:         name,
+		mode:         mode,
+		textFormat:   textFormat,
+		dropped:      make(chan string),
+		droppedCount: map[string]int{},
+	}
+
+	return d
+}
+
+func (c *DnsMessageProcessor) LogInfo(msg string, v ...interface{}) {
+	var log string
+	if c.ConnId == 0 {
+		log = fmt.Sprintf("[%s] processor=dnsmessage - ", c.name)
+	} else {
+		log = fmt.Sprintf("[%s] processor=dnsmessage#%d - ", c.name, c.ConnId)
+	}
+	c.logger.Info(log+msg, v...)
+}
+
+func (c *DnsMessageProcessor) LogError(msg string, v ...interface{}) {
+	var log string
+	if c.ConnId == 0 {
+		log = fmt.Sprintf("[%s] processor=dnsmessage - ", c.name)
+	} else {
+		log = fmt.Sprintf("[%s] processor=dnsmessage#%d - ", c.name, c.ConnId)
+	}
+	c.logger.Error(log+msg, v...)
+}
+
+func (d *DnsMessageProcessor) GetChannel() chan []byte {
+	return d.recvFrom
+}
+
+func (d *DnsMessageProcessor) Stop() {
+	d.LogInfo("stopping to process...")
+	d.stopRun <- true
+	<-d.doneRun
+
+	d.LogInfo("stopping to monitor loggers...")
+	d.stopMonitor <- true
+	<-d.doneMonitor
+}
+
+func (d *DnsMessageProcessor) MonitorLoggers() {
+	watchInterval := 10 * time.Second
+	bufferFull := time.NewTimer(watchInterval)
+MONITOR_LOOP:
+	for {
+		select {
+		case <-d.stopMonitor:
+			close(d.dropped)
+			bufferFull.Stop()
+			d.doneMonitor <- true
+			break MONITOR_LOOP
+
+		case loggerName := <-d.dropped:
+			if _, ok := d.droppedCount[loggerName]; !ok {
+				d.droppedCount[loggerName] = 1
+			} else {
+				d.droppedCount[loggerName]++
+			}
+
+		case <-bufferFull.C:
+			for v, k := range d.droppedCount {
+				if k > 0 {
+					d.LogError("logger[%s] buffer is full, %d packet(s) dropped", v, k)
+					d.droppedCount[v] = 0
+				}
+			}
+			bufferFull.Reset(watchInterval)
+
+		}
+	}
+	d.LogInfo("monitor terminated")
+}
+
+func (d *DnsMessageProcessor) Decode(data []byte, dm *dnsutils.DnsMessage) error {
+	switch d.mode {
+	case dnsutils.MODE_TEXT:
+		return dm.FromText(strings.TrimRight(string(data), "\r\n"), d.textFormat, d.config.Global.TextFormatDelimiter, d.config.Global.TextFormatBoundary)
+	case dnsutils.MODE_JSON:
+		return dm.FromJson(data)
+	case dnsutils.MODE_FLATJSON:
+		return dm.FromFlattenJson(data)
+	case dnsutils.MODE_MSGPACK:
+		return dm.FromMsgpack(data)
+	}
+	return fmt.Errorf("unsupported mode: %s", d.mode)
+}
+
+func (d *DnsMessageProcessor) Run(loggersChannel []chan dnsutils.DnsMessage, loggersName []string) {
+	// prepare enabled transformers
+	transforms := transformers.NewTransforms(&d.config.IngoingTransformers, d.logger, d.name, loggersChannel, d.ConnId)
+
+	// start goroutine to count dropped messsages
+	go d.MonitorLoggers()
+
+	// read incoming dns message
+	d.LogInfo("waiting dns message to process...")
+RUN_LOOP:
+	for {
+		select {
+		case cfg := <-d.ConfigChan:
+			d.config = cfg
+			transforms.ReloadConfig(&cfg.IngoingTransformers)
+
+		case <-d.stopRun:
+			transforms.Reset()
+			d.doneRun <- true
+			break RUN_LOOP
+
+		case data, opened := <-d.recvFrom:
+			if !opened {
+				d.LogInfo("channel closed, exit")
+				return
+			}
+
+			// init dns message
+			dm := dnsutils.DnsMessage{}
+			dm.Init()
+
+			// init dns message with additionnals parts
+			transforms.InitDnsMessageFormat(&dm)
+
+			// decode the message produced by a remote logger,
+			// decoded fields overwrite the default ones
+			if err := d.Decode(data, &dm); err != nil {
+				if d.config.Global.Trace.LogMalformed {
+					d.LogError("unable to decode %s message: %s", d.mode, err)
+				}
+				continue
+			}
+
+			// apply all enabled transformers
+			if transforms.ProcessMessage(&dm) == transformers.RETURN_DROP {
+				continue
+			}
+
+			// convert latency to human
+			dm.DnsTap.LatencySec = fmt.Sprintf("%.6f", dm.DnsTap.Latency)
+
+			// dispatch dns message to connected loggers
+			for i := range loggersChannel {
+				select {
+				case loggersChannel[i] <- dm: // Successful send to logger channel
+				default:
+					d.dropped <- loggersName[i]
+				}
+			}
+		}
+	}
+
+	d.LogInfo("processing terminated")
+}
@@ -0,0 +1,78 @@
+package processors
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/dmachard/go-dnscollector/dnsutils"
+	"github.com/dmachard/go-logger"
+)
+
+func Test_DnsMessageProcessor(t *testing.T) {
+	testcases := []struct {
+		mode string
+	}{
+		{mode: dnsutils.MODE_TEXT},
+		{mode: dnsutils.MODE_JSON},
+		{mode: dnsutils.MODE_FLATJSON},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.mode, func(t *testing.T) {
+			logger := logger.New(true)
+			var o bytes.Buffer
+			logger.SetOutput(&o)
+
+			// init the consumer
+			config := dnsutils.GetFakeConfig()
+			textFormat := strings.Fields(config.Global.TextFormat)
+			consumer := NewDnsMessageProcessor(0, tc.mode, textFormat, config, logger, "test", 512)
+			chan_to := make(chan dnsutils.DnsMessage, 512)
+
+			// prepare message like a remote logger
+			dm := dnsutils.GetFakeDnsMessage()
+			var data string
+			switch tc.mode {
+			case dnsutils.MODE_TEXT:
+				data = dm.String(textFormat, config.Global.TextFormatDelimiter, config.Global.TextFormatBoundary)
+			case dnsutils.MODE_JSON:
+				data = dm.ToJson()
+			case dnsutils.MODE_FLATJSON:
+				data, _ = dm.ToFlattenJson()
+			}
+
+			go consumer.Run([]chan dnsutils.DnsMessage{chan_to}, []string{"test"})
+			consumer.GetChannel() <- []byte(data)
+
+			// read dns message from consumer
+			msg := <-chan_to
+			if msg.DNS.Qname != dm.DNS.Qname {
+				t.Errorf("invalid qname in dns message: %s", msg.DNS.Qname)
+			}
+			if msg.DNS.Type != dnsutils.DnsQuery {
+				t.Errorf("invalid dns type: %s", msg.DNS.Type)
+			}
+		})
+	}
+}
+
+func Test_DnsMessageProcessor_InvalidPayload(t *testing.T) {
+	logger := logger.New(true)
+	var o bytes.Buffer
+	logger.SetOutput(&o)
+
+	consumer := NewDnsMessageProcessor(0, dnsutils.MODE_JSON, nil, dnsutils.GetFakeConfig(), logger, "test", 512)
+	chan_to := make(chan dnsutils.DnsMessage, 512)
+	go consumer.Run([]chan dnsutils.DnsMessage{chan_to}, []string{"test"})
+
+	// invalid payload is ignored, the next one is processed
+	consumer.GetChannel() <- []byte("{\"dns\": ")
+	dm := dnsutils.GetFakeDnsMessage()
+	consumer.GetChannel() <- []byte(dm.ToJson())
+
+	msg := <-chan_to
+	if msg.DNS.Qname != dm.DNS.Qname {
+		t.Errorf("invalid qname in dns message: %s", msg.DNS.Qname)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -48,6 +50,53 @@ func GetFakeDnstap(dnsquery []byte) *dnstap.Dnstap {
 	return dt_query
 }
 
+// DnstapProcessorOptions are the options of the decoding of a dnstap stream
+type DnstapProcessorOptions struct {
+	// Number of goroutines decoding the frames, 1 if 0
+	Workers int
+	// Keep the order of the frames with several workers
+	Ordered bool
+	// Interval of the logs of the throughput and of the decoding latency, disabled if 0
+	StatsInterval time.Duration
+}
+
+// dnstapStats are the counters of the decoding, updated by the workers
+type dnstapStats struct {
+	frames      uint64
+	invalid     uint64
+	decodeNs    uint64
+	maxDecodeNs uint64
+}
+
+func (s *dnstapStats) decoded(valid bool, elapsed time.Duration) {
+	atomic.AddUint64(&s.frames, 1)
+	if !valid {
+		atomic.AddUint64(&s.invalid, 1)
+	}
+	atomic.AddUint64(&s.decodeNs, uint64(elapsed))
+	for {
+		max := atomic.LoadUint64(&s.maxDecodeNs)
+		if uint64(elapsed) <= max || atomic.CompareAndSwapUint64(&s.maxDecodeNs, max, uint64(elapsed)) {
+			return
+		}
+	}
+}
+
+// reset returns the counters and starts a new interval
+func (s *dnstapStats) reset() (frames uint64, invalid uint64, decodeNs uint64, maxDecodeNs uint64) {
+	return atomic.SwapUint64(&s.frames, 0), atomic.SwapUint64(&s.invalid, 0),
+		atomic.SwapUint64(&s.decodeNs, 0), atomic.SwapUint64(&s.maxDecodeNs, 0)
+}
+
+// dnstapJob is a frame decoded by a worker
+type dnstapJob struct {
+	data  []byte
+	dm    dnsutils.DnsMessage
+	valid bool
+	// closed when decoded, in ordered mode
+	done chan bool
+}
+
 type DnstapProcessor struct {
 	ConnId       int
 	doneRun      chan bool
@@ -62,11 +111,25 @@ type DnstapProcessor struct {
 	chanSize     int
 	dropped      chan string
 	droppedCount map[string]int
+	options      DnstapProcessorOptions
+	stats        *dnstapStats
+	// config used by the workers, updated on reload
+	decodeConfig *atomic.Pointer[dnsutils.Config]
+	transfers    *dnsutils.ZoneTransfers
 }
 
 func NewDnstapProcessor(connId int, config *dnsutils.Config, logger *logger.Logger, name string, size int) DnstapProcessor {
+	return NewDnstapProcessorWithOptions(connId, config, logger, name, size, DnstapProcessorOptions{})
+}
+
+// NewDnstapProcessorWithOptions returns a processor decoding the frames with a pool of workers
+func NewDnstapProcessorWithOptions(connId int, config *dnsutils.Config, logger *logger.Logger, name string, size int, options DnstapProcessorOptions) DnstapProcessor {
 	logger.Info("[%s] processor=dnstap#%d - initialization...", name, connId)
 
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+
 	d := DnstapProcessor{
 		ConnId:       connId,
 		doneMonitor:  make(chan bool),
@@ -81,7 +144,12 @@ func NewDnstapProcessor(connId int, config *dnsutils.Config, logger *logger.Logg
 		name:         name,
 		dropped:      make(chan string),
 		droppedCount: map[string]int{},
+		options:      options,
+		stats:        &dnstapStats{},
+		decodeConfig: &atomic.Pointer[dnsutils.Config]{},
+		transfers:    dnsutils.NewZoneTransfers(),
 	}
+	d.decodeConfig.Store(config)
 
 	return d
 }
@@ -123,6 +191,14 @@ func (d *DnstapProcessor) Stop() {
 func (d *DnstapProcessor) MonitorLoggers() {
 	watchInterval := 10 * time.Second
 	bufferFull := time.NewTimer(watchInterval)
+
+	// throughput and decoding latency
+	var statsTick <-chan time.Time
+	if d.options.StatsInterval > 0 {
+		statsTicker := time.NewTicker(d.options.StatsInterval)
+		defer statsTicker.Stop()
+		statsTick = statsTicker.C
+	}
 MONITOR_LOOP:
 	for {
 		select {
@@ -132,6 +208,15 @@ MONITOR_LOOP:
 			d.doneMonitor <- true
 			break MONITOR_LOOP
 
+		case <-statsTick:
+			frames, invalid, decodeNs, maxDecodeNs := d.stats.reset()
+			var avg time.Duration
+			if frames > 0 {
+				avg = time.Duration(decodeNs / frames)
+			}
+			d.LogInfo("frames decoded: %d (%.1f/s), invalid: %d - decoding latency avg: %s, max: %s",
+				frames, float64(frames)/d.options.StatsInterval.Seconds(), invalid, avg, time.Duration(maxDecodeNs))
+
 		case loggerName := <-d.dropped:
 			if _, ok := d.droppedCount[loggerName]; !ok {
 				d.droppedCount[loggerName] = 1
@@ -153,15 +238,210 @@ MONITOR_LOOP:
 	d.LogInfo("monitor terminated")
 }
 
-func (d *DnstapProcessor) Run(loggersChannel []chan dnsutils.DnsMessage, loggersName []string) {
+// decodeFrame decodes a dnstap frame and its dns payload, before the transformers
+func (d *DnstapProcessor) decodeFrame(dt *dnstap.Dnstap, data []byte, config *dnsutils.Config) (dnsutils.DnsMessage, bool) {
+	dm := dnsutils.DnsMessage{}
+
+	err := proto.Unmarshal(data, dt)
+	if err != nil {
+		return dm, false
+	}
+
+	// init dns message
+	dm.Init()
+
+	identity := dt.GetIdentity()
+	if len(identity) > 0 {
+		dm.DnsTap.Identity = string(identity)
+	}
+	version := dt.GetVersion()
+	if len(version) > 0 {
+		dm.DnsTap.Version = string(version)
+	}
+	dm.DnsTap.Operation = dt.GetMessage().GetType().String()
+
+	extra := string(dt.GetExtra())
+	if len(extra) > 0 {
+		dm.DnsTap.Extra = extra
+	}
+
+	if ipVersion, valid := dnsutils.IP_VERSION[dt.GetMessage().GetSocketFamily().String()]; valid {
+		dm.NetworkInfo.Family = ipVersion
+	} else {
+		dm.NetworkInfo.Family = dnsutils.STR_UNKNOWN
+	}
+
+	dm.NetworkInfo.Protocol = dt.GetMessage().GetSocketProtocol().String()
+
+	// decode query address and port
+	queryip := dt.GetMessage().GetQueryAddress()
+	if len(queryip) > 0 {
+		dm.NetworkInfo.QueryIp = net.IP(queryip).String()
+	}
+	queryport := dt.GetMessage().GetQueryPort()
+	if queryport > 0 {
+		dm.NetworkInfo.QueryPort = strconv.FormatUint(uint64(queryport), 10)
+	}
+
+	// decode response address and port
+	responseip := dt.GetMessage().GetResponseAddress()
+	if len(responseip) > 0 {
+		dm.NetworkInfo.ResponseIp = net.IP(responseip).String()
+	}
+	responseport := dt.GetMessage().GetResponsePort()
+	if responseport > 0 {
+		dm.NetworkInfo.ResponsePort = strconv.FormatUint(uint64(responseport), 10)
+	}
+
+	// get dns payload and timestamp according to the type (query or response)
+	op := dnstap.Message_Type_value[dm.DnsTap.Operation]
+	if op%2 == 1 {
+		dns_payload := dt.GetMessage().GetQueryMessage()
+		dm.DNS.Payload = dns_payload
+		dm.DNS.Length = len(dns_payload)
+		dm.DNS.Type = dnsutils.DnsQuery
+		dm.DnsTap.TimeSec = int(dt.GetMessage().GetQueryTimeSec())
+		dm.DnsTap.TimeNsec = int(dt.GetMessage().GetQueryTimeNsec())
+	} else {
+		dns_payload := dt.GetMessage().GetResponseMessage()
+		dm.DNS.Payload = dns_payload
+		dm.DNS.Length = len(dns_payload)
+		dm.DNS.Type = dnsutils.DnsReply
+		dm.DnsTap.TimeSec = int(dt.GetMessage().GetResponseTimeSec())
+		dm.DnsTap.TimeNsec = int(dt.GetMessage().GetResponseTimeNsec())
+	}
+
+	// compute timestamp
+	ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
+	dm.DnsTap.Timestamp = ts.UnixNano()
+	dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
+
+	// decode the dns payload to get id, rcode and the number of question
+	// number of answer, ignore invalid packet
+	dnsHeader, err := dnsutils.DecodeDns(dm.DNS.Payload)
+	if err != nil {
+		// parser error
+		dm.DNS.MalformedPacket = true
+		d.LogInfo("dns parser malformed packet: %s", err)
+	}
+
+	if err = dnsutils.DecodePayload(&dm, &dnsHeader, config); err != nil {
+		// decoding error
+		if config.Global.Trace.LogMalformed {
+			d.LogError("%v - %v", err, dm)
+			d.LogError("dump invalid dns payload: %v", dm.DNS.Payload)
+		}
+	}
+	return dm, true
+}
+
+// decodeWorker decodes the frames, the results are sent to the channel
+// or notified to the sequencer without channel
+func (d *DnstapProcessor) decodeWorker(jobs chan *dnstapJob, results chan *dnstapJob, stop chan bool) {
 	dt := &dnstap.Dnstap{}
+	for job := range jobs {
+		start := time.Now()
+		job.dm, job.valid = d.decodeFrame(dt, job.data, d.decodeConfig.Load())
+		d.stats.decoded(job.valid, time.Since(start))
+
+		if results == nil {
+			close(job.done)
+			continue
+		}
+		select {
+		case results <- job:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// runWorkers dispatches the received frames to the workers and sends the decoded ones
+// to the channel, in the order of reception in ordered mode. The channel is closed
+// after the workers when the reception channel is closed.
+func (d *DnstapProcessor) runWorkers(decoded chan *dnstapJob, stop chan bool) {
+	jobs := make(chan *dnstapJob, d.options.Workers)
+	results := decoded
+	var wg sync.WaitGroup
+
+	// the sequencer waits the frames in the order of reception
+	var pending chan *dnstapJob
+	if d.options.Ordered && d.options.Workers > 1 {
+		pending = make(chan *dnstapJob, d.chanSize)
+		results = nil
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range pending {
+				select {
+				case <-job.done:
+				case <-stop:
+					return
+				}
+				select {
+				case decoded <- job:
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < d.options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.decodeWorker(jobs, results, stop)
+		}()
+	}
+
+	defer func() {
+		close(jobs)
+		if pending != nil {
+			close(pending)
+		}
+		wg.Wait()
+		close(decoded)
+	}()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case data, opened := <-d.recvFrom:
+			if !opened {
+				return
+			}
+			job := &dnstapJob{data: data}
+			if pending != nil {
+				job.done = make(chan bool)
+				select {
+				case pending <- job:
+				case <-stop:
+					return
+				}
+			}
+			select {
+			case jobs <- job:
+			case <-stop:
+				return
+			}
+		}
+	}
+}
 
+func (d *DnstapProcessor) Run(loggersChannel []chan dnsutils.DnsMessage, loggersName []string) {
 	// prepare enabled transformers
 	transforms := transformers.NewTransforms(&d.config.IngoingTransformers, d.logger, d.name, loggersChannel, d.ConnId)
 
 	// start goroutine to count dropped messsages
 	go d.MonitorLoggers()
 
+	// the frames are decoded by the workers, the transformers are applied here
+	decoded := make(chan *dnstapJob, d.options.Workers)
+	stopWorkers := make(chan bool)
+	go d.runWorkers(decoded, stopWorkers)
+
 	// read incoming dns message
 	d.LogInfo("waiting dns message to process...")
 RUN_LOOP:
@@ -169,114 +449,32 @@ RUN_LOOP:
 		select {
 		case cfg := <-d.ConfigChan:
 			d.config = cfg
+			d.decodeConfig.Store(cfg)
 			transforms.ReloadConfig(&cfg.IngoingTransformers)
 
 		case <-d.stopRun:
+			close(stopWorkers)
 			transforms.Reset()
 			d.doneRun <- true
 			break RUN_LOOP
 
-		case data, opened := <-d.recvFrom:
+		case job, opened := <-decoded:
 			if !opened {
 				d.LogInfo("channel closed, exit")
 				return
 			}
-
-			err := proto.Unmarshal(data, dt)
-			if err != nil {
+			if !job.valid {
 				continue
 			}
+			dm := job.dm
 
-			// init dns message
-			dm := dnsutils.DnsMessage{}
-			dm.Init()
+			// zone of the following messages of the zone transfers, after the workers
+			// to follow the transfers from a single goroutine
+			d.transfers.Track(&dm)
 
 			// init dns message with additionnals parts
 			transforms.InitDnsMessageFormat(&dm)
 
-			identity := dt.GetIdentity()
-			if len(identity) > 0 {
-				dm.DnsTap.Identity = string(identity)
-			}
-			version := dt.GetVersion()
-			if len(version) > 0 {
-				dm.DnsTap.Version = string(version)
-			}
-			dm.DnsTap.Operation = dt.GetMessage().GetType().String()
-
-			extra := string(dt.GetExtra())
-			if len(extra) > 0 {
-				dm.DnsTap.Extra = extra
-			}
-
-			if ipVersion, valid := dnsutils.IP_VERSION[dt.GetMessage().GetSocketFamily().String()]; valid {
-				dm.NetworkInfo.Family = ipVersion
-			} else {
-				dm.NetworkInfo.Family = dnsutils.STR_UNKNOWN
-			}
-
-			dm.NetworkInfo.Protocol = dt.GetMessage().GetSocketProtocol().String()
-
-			// decode query address and port
-			queryip := dt.GetMessage().GetQueryAddress()
-			if len(queryip) > 0 {
-				dm.NetworkInfo.QueryIp = net.IP(queryip).String()
-			}
-			queryport := dt.GetMessage().GetQueryPort()
-			if queryport > 0 {
-				dm.NetworkInfo.QueryPort = strconv.FormatUint(uint64(queryport), 10)
-			}
-
-			// decode response address and port
-			responseip := dt.GetMessage().GetResponseAddress()
-			if len(responseip) > 0 {
-				dm.NetworkInfo.ResponseIp = net.IP(responseip).String()
-			}
-			responseport := dt.GetMessage().GetResponsePort()
-			if responseport > 0 {
-				dm.NetworkInfo.ResponsePort = strconv.FormatUint(uint64(responseport), 10)
-			}
-
-			// get dns payload and timestamp according to the type (query or response)
-			op := dnstap.Message_Type_value[dm.DnsTap.Operation]
-			if op%2 == 1 {
-				dns_payload := dt.GetMessage().GetQueryMessage()
-				dm.DNS.Payload = dns_payload
-				dm.DNS.Length = len(dns_payload)
-				dm.DNS.Type = dnsutils.DnsQuery
-				dm.DnsTap.TimeSec = int(dt.GetMessage().GetQueryTimeSec())
-				dm.DnsTap.TimeNsec = int(dt.GetMessage().GetQueryTimeNsec())
-			} else {
-				dns_payload := dt.GetMessage().GetResponseMessage()
-				dm.DNS.Payload = dns_payload
-				dm.DNS.Length = len(dns_payload)
-				dm.DNS.Type = dnsutils.DnsReply
-				dm.DnsTap.TimeSec = int(dt.GetMessage().GetResponseTimeSec())
-				dm.DnsTap.TimeNsec = int(dt.GetMessage().GetResponseTimeNsec())
-			}
-
-			// compute timestamp
-			ts := time.Unix(int64(dm.DnsTap.TimeSec), int64(dm.DnsTap.TimeNsec))
-			dm.DnsTap.Timestamp = ts.UnixNano()
-			dm.DnsTap.TimestampRFC3339 = ts.UTC().Format(time.RFC3339Nano)
-
-			// decode the dns payload to get id, rcode and the number of question
-			// number of answer, ignore invalid packet
-			dnsHeader, err := dnsutils.DecodeDns(dm.DNS.Payload)
-			if err != nil {
-				// parser error
-				dm.DNS.MalformedPacket = true
-				d.LogInfo("dns parser malformed packet: %s", err)
-			}
-
-			if err = dnsutils.DecodePayload(&dm, &dnsHeader, d.config); err != nil {
-				// decoding error
-				if d.config.Global.Trace.LogMalformed {
-					d.LogError("%v - %v", err, dm)
-					d.LogError("dump invalid dns payload: %v", dm.DNS.Payload)
-				}
-			}
-
 			// apply all enabled transformers
 			if transforms.ProcessMessage(&dm) == transformers.RETURN_DROP {
 				continue
@@ -2,6 +2,7 @@ package processors
 
 import (
 	"bytes"
+	"fmt"
 	"testing"
 
 	"github.com/dmachard/go-dnscollector/dnsutils"
@@ -149,3 +150,158 @@ func Test_DnstapProcessor_MalformedDnsAnswer(t *testing.T) {
 		t.Errorf("malformed packet not detected")
 	}
 }
+
+func Test_DnstapProcessor_Workers(t *testing.T) {
+	logger := logger.New(false)
+
+	testcases := []struct {
+		name    string
+		ordered bool
+	}{
+		{"ordered", true},
+		{"unordered", false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			consumer := NewDnstapProcessorWithOptions(0, dnsutils.GetFakeConfig(), logger, "test", 512,
+				DnstapProcessorOptions{Workers: 4, Ordered: tc.ordered})
+			chan_to := make(chan dnsutils.DnsMessage, 512)
+			go consumer.Run([]chan dnsutils.DnsMessage{chan_to}, []string{"test"})
+
+			// send frames with a different id
+			nbFrames := 100
+			for i := 0; i < nbFrames; i++ {
+				dnsmsg := new(dns.Msg)
+				dnsmsg.SetQuestion("www.google.fr.", dns.TypeA)
+				dnsmsg.Id = uint16(i)
+				dnsquestion, _ := dnsmsg.Pack()
+
+				dt := &dnstap.Dnstap{}
+				dt.Type = dnstap.Dnstap_Type.Enum(1)
+				dt.Message = &dnstap.Message{}
+				dt.Message.Type = dnstap.Message_Type.Enum(5)
+				dt.Message.QueryMessage = dnsquestion
+				data, _ := proto.Marshal(dt)
+
+				consumer.GetChannel() <- data
+			}
+
+			// all the frames are decoded, in the order of reception if ordered
+			ids := map[int]bool{}
+			for i := 0; i < nbFrames; i++ {
+				dm := <-chan_to
+				if tc.ordered && dm.DNS.Id != i {
+					t.Errorf("want the id %d, got %d", i, dm.DNS.Id)
+				}
+				ids[dm.DNS.Id] = true
+			}
+			if len(ids) != nbFrames {
+				t.Errorf("want %d frames, got %d", nbFrames, len(ids))
+			}
+
+			frames, _, _, _ := consumer.stats.reset()
+			if frames != uint64(nbFrames) {
+				t.Errorf("want %d frames decoded, got %d", nbFrames, frames)
+			}
+			consumer.Stop()
+		})
+	}
+}
+
+func Test_DnstapProcessor_ZoneTransfer(t *testing.T) {
+	logger := logger.New(false)
+
+	// the order of the frames is kept with several workers
+	consumer := NewDnstapProcessorWithOptions(0, dnsutils.GetFakeConfig(), logger, "test", 512,
+		DnstapProcessorOptions{Workers: 4, Ordered: true})
+	chan_to := make(chan dnsutils.DnsMessage, 512)
+	go consumer.Run([]chan dnsutils.DnsMessage{chan_to}, []string{"test"})
+	defer consumer.Stop()
+
+	soa, _ := dns.NewRR("example.com. 3600 IN SOA ns1.example.com. admin.example.com. 2023010102 7200 3600 1209600 300")
+	query := new(dns.Msg)
+	query.SetAxfr("example.com.")
+
+	// the response spans 3 messages, the following ones without question
+	nbMessages := 3
+	for i := 0; i < nbMessages; i++ {
+		rr, _ := dns.NewRR(fmt.Sprintf("host%d.example.com. 300 IN A 192.0.2.%d", i, i+1))
+		msg := new(dns.Msg)
+		msg.SetReply(query)
+		msg.Answer = []dns.RR{rr}
+		switch i {
+		case 0:
+			msg.Answer = []dns.RR{soa, rr}
+		case nbMessages - 1:
+			msg.Question = nil
+			msg.Answer = []dns.RR{rr, soa}
+		default:
+			msg.Question = nil
+		}
+		payload, _ := msg.Pack()
+
+		dt := GetFakeDnstap(nil)
+		mt := dnstap.Message_AUTH_RESPONSE
+		sp := dnstap.SocketProtocol_TCP
+		dt.Message.Type = &mt
+		dt.Message.SocketProtocol = &sp
+		dt.Message.ResponseMessage = payload
+		dt.Message.ResponseTimeSec = dt.Message.QueryTimeSec
+		dt.Message.ResponseTimeNsec = dt.Message.QueryTimeNsec
+		data, _ := proto.Marshal(dt)
+
+		consumer.GetChannel() <- data
+	}
+
+	for i := 0; i < nbMessages; i++ {
+		dm := <-chan_to
+		if dm.DNS.Zone == nil || dm.DNS.Zone.Name != "example.com" || dm.DNS.Zone.Transfer != "AXFR" || dm.DNS.Zone.Serial != 2023010102 {
+			t.Errorf("message %d: invalid zone: %+v", i, dm.DNS.Zone)
+		}
+	}
+	if n := consumer.transfers.Transfers(); n != 0 {
+		t.Errorf("want no transfer in progress, got %d", n)
+	}
+}
+
+func Test_DnstapProcessor_UpdateOperation(t *testing.T) {
+	logger := logger.New(false)
+	consumer := NewDnstapProcessor(0, dnsutils.GetFakeConfig(), logger, "test", 512)
+	chan_to := make(chan dnsutils.DnsMessage, 512)
+	go consumer.Run([]chan dnsutils.DnsMessage{chan_to}, []string{"test"})
+	defer consumer.Stop()
+
+	// dynamic update sent to the authoritative server and its response
+	update := new(dns.Msg)
+	update.SetUpdate("example.com.")
+	rr, _ := dns.NewRR("host1.example.com. 300 IN A 192.0.2.1")
+	update.Insert([]dns.RR{rr})
+	response := new(dns.Msg)
+	response.SetReply(update)
+
+	updatePayload, _ := update.Pack()
+	dt := GetFakeDnstap(updatePayload)
+	mt := dnstap.Message_AUTH_QUERY
+	dt.Message.Type = &mt
+	data, _ := proto.Marshal(dt)
+	consumer.GetChannel() <- data
+
+	responsePayload, _ := response.Pack()
+	dt = GetFakeDnstap(nil)
+	mt = dnstap.Message_AUTH_RESPONSE
+	dt.Message.Type = &mt
+	dt.Message.ResponseMessage = responsePayload
+	data, _ = proto.Marshal(dt)
+	consumer.GetChannel() <- data
+
+	for _, want := range []string{"UPDATE_QUERY", "UPDATE_RESPONSE"} {
+		dm := <-chan_to
+		if dm.DnsTap.Operation != want {
+			t.Errorf("want the operation %s, got %s", want, dm.DnsTap.Operation)
+		}
+		if dm.DNS.OpcodeName != "UPDATE" || dm.DNS.Zone == nil || dm.DNS.Zone.Name != "example.com" {
+			t.Errorf("invalid update: opcode %s, zone %+v", dm.DNS.OpcodeName, dm.DNS.Zone)
+		}
+	}
+}
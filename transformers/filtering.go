@@ -19,6 +19,12 @@ type FilteringProcessor struct {
 	dropDomains          bool
 	keepDomains          bool
 	mapRcodes            map[string]bool
+	mapDropOpcodes       map[string]bool
+	mapKeepOpcodes       map[string]bool
+	mapDropZones         map[string]bool
+	mapKeepZones         map[string]bool
+	listDropUpdatesRegex []*regexp.Regexp
+	listKeepUpdatesRegex []*regexp.Regexp
 	ipsetDrop            *netaddr.IPSet
 	ipsetKeep            *netaddr.IPSet
 	rDataIpsetKeep       *netaddr.IPSet
@@ -52,6 +58,10 @@ func NewFilteringProcessor(config *dnsutils.ConfigTransformers, logger *logger.L
 		config:               config,
 		logger:               logger,
 		mapRcodes:            make(map[string]bool),
+		mapDropOpcodes:       make(map[string]bool),
+		mapKeepOpcodes:       make(map[string]bool),
+		mapDropZones:         make(map[string]bool),
+		mapKeepZones:         make(map[string]bool),
 		ipsetDrop:            &netaddr.IPSet{},
 		ipsetKeep:            &netaddr.IPSet{},
 		rDataIpsetKeep:       &netaddr.IPSet{},
@@ -103,6 +113,30 @@ func (p *FilteringProcessor) LoadActiveFilters() {
 		p.activeFilters = append(p.activeFilters, p.rCodeFilter)
 	}
 
+	if len(p.mapDropOpcodes) > 0 {
+		p.activeFilters = append(p.activeFilters, p.dropOpcodeFilter)
+	}
+
+	if len(p.mapKeepOpcodes) > 0 {
+		p.activeFilters = append(p.activeFilters, p.keepOpcodeFilter)
+	}
+
+	if len(p.mapDropZones) > 0 {
+		p.activeFilters = append(p.activeFilters, p.dropZoneFilter)
+	}
+
+	if len(p.mapKeepZones) > 0 {
+		p.activeFilters = append(p.activeFilters, p.keepZoneFilter)
+	}
+
+	if len(p.listDropUpdatesRegex) > 0 {
+		p.activeFilters = append(p.activeFilters, p.dropUpdateFilter)
+	}
+
+	if len(p.listKeepUpdatesRegex) > 0 {
+		p.activeFilters = append(p.activeFilters, p.keepUpdateFilter)
+	}
+
 	if len(p.config.Filtering.KeepQueryIpFile) > 0 {
 		p.activeFilters = append(p.activeFilters, p.keepQueryIpFilter)
 	}
@@ -152,6 +186,58 @@ func (p *FilteringProcessor) LoadRcodes() {
 	}
 }
 
+func (p *FilteringProcessor) LoadOpcodes() {
+	// empty
+	for key := range p.mapDropOpcodes {
+		delete(p.mapDropOpcodes, key)
+	}
+	for key := range p.mapKeepOpcodes {
+		delete(p.mapKeepOpcodes, key)
+	}
+
+	// add
+	for _, v := range p.config.Filtering.DropOpcodes {
+		p.mapDropOpcodes[v] = true
+	}
+	for _, v := range p.config.Filtering.KeepOpcodes {
+		p.mapKeepOpcodes[v] = true
+	}
+}
+
+func (p *FilteringProcessor) LoadZones() {
+	// empty
+	for key := range p.mapDropZones {
+		delete(p.mapDropZones, key)
+	}
+	for key := range p.mapKeepZones {
+		delete(p.mapKeepZones, key)
+	}
+	p.listDropUpdatesRegex = p.listDropUpdatesRegex[:0]
+	p.listKeepUpdatesRegex = p.listKeepUpdatesRegex[:0]
+
+	// add, the zone names are decoded without the trailing dot
+	for _, v := range p.config.Filtering.DropZones {
+		p.mapDropZones[strings.TrimSuffix(strings.ToLower(v), ".")] = true
+	}
+	for _, v := range p.config.Filtering.KeepZones {
+		p.mapKeepZones[strings.TrimSuffix(strings.ToLower(v), ".")] = true
+	}
+	p.listDropUpdatesRegex = p.loadUpdatesRegex(p.listDropUpdatesRegex, p.config.Filtering.DropUpdates)
+	p.listKeepUpdatesRegex = p.loadUpdatesRegex(p.listKeepUpdatesRegex, p.config.Filtering.KeepUpdates)
+}
+
+func (p *FilteringProcessor) loadUpdatesRegex(list []*regexp.Regexp, expressions []string) []*regexp.Regexp {
+	for _, v := range expressions {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			p.LogError("invalid update regex %s: %v", v, err)
+			continue
+		}
+		list = append(list, re)
+	}
+	return list
+}
+
 func (p *FilteringProcessor) LoadQueryIpList() {
 	if len(p.config.Filtering.DropQueryIpFile) > 0 {
 		read, err := p.loadQueryIpList(p.config.Filtering.DropQueryIpFile, true)
@@ -371,6 +457,71 @@ func (p *FilteringProcessor) rCodeFilter(dm *dnsutils.DnsMessage) bool {
 	return false
 }
 
+func (p *FilteringProcessor) dropOpcodeFilter(dm *dnsutils.DnsMessage) bool {
+	// drop according to the opcode ?
+	if _, ok := p.mapDropOpcodes[dm.DNS.OpcodeName]; ok {
+		return true
+	}
+	return false
+}
+
+func (p *FilteringProcessor) keepOpcodeFilter(dm *dnsutils.DnsMessage) bool {
+	// drop all the other opcodes
+	if _, ok := p.mapKeepOpcodes[dm.DNS.OpcodeName]; ok {
+		return false
+	}
+	return true
+}
+
+func (p *FilteringProcessor) dropZoneFilter(dm *dnsutils.DnsMessage) bool {
+	// drop according to the zone of the updates, notifies and zone transfers ?
+	if dm.DNS.Zone == nil {
+		return false
+	}
+	if _, ok := p.mapDropZones[strings.ToLower(dm.DNS.Zone.Name)]; ok {
+		return true
+	}
+	return false
+}
+
+func (p *FilteringProcessor) keepZoneFilter(dm *dnsutils.DnsMessage) bool {
+	// drop all the other zones and the messages without zone
+	if dm.DNS.Zone == nil {
+		return true
+	}
+	if _, ok := p.mapKeepZones[strings.ToLower(dm.DNS.Zone.Name)]; ok {
+		return false
+	}
+	return true
+}
+
+// matchUpdate returns true if a prerequisite or an update of the dynamic update matches one of the expressions
+func matchUpdate(dm *dnsutils.DnsMessage, list []*regexp.Regexp) bool {
+	if dm.DNS.Zone == nil {
+		return false
+	}
+	for _, records := range [][]dnsutils.DnsUpdateRecord{dm.DNS.Zone.Prerequisites, dm.DNS.Zone.Updates} {
+		for _, record := range records {
+			line := record.String()
+			for _, re := range list {
+				if re.MatchString(line) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (p *FilteringProcessor) dropUpdateFilter(dm *dnsutils.DnsMessage) bool {
+	return matchUpdate(dm, p.listDropUpdatesRegex)
+}
+
+func (p *FilteringProcessor) keepUpdateFilter(dm *dnsutils.DnsMessage) bool {
+	// drop all the other messages
+	return !matchUpdate(dm, p.listKeepUpdatesRegex)
+}
+
 func (p *FilteringProcessor) keepQueryIpFilter(dm *dnsutils.DnsMessage) bool {
 	ip, _ := netaddr.ParseIP(dm.NetworkInfo.QueryIp)
 	return !p.ipsetKeep.Contains(ip)
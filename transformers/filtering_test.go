@@ -79,6 +79,58 @@ func TestFilteringByRcodeEmpty(t *testing.T) {
 	}
 }
 
+func TestFilteringByDropOpcode(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.Enable = true
+	config.Filtering.DropOpcodes = []string{"NOTIFY"}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	filtering.LoadOpcodes()
+	filtering.LoadActiveFilters()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.OpcodeName = "NOTIFY"
+	if filtering.CheckIfDrop(&dm) == false {
+		t.Errorf("dns notify should be dropped")
+	}
+
+	dm.DNS.OpcodeName = "QUERY"
+	if filtering.CheckIfDrop(&dm) == true {
+		t.Errorf("dns query should not be dropped!")
+	}
+}
+
+func TestFilteringByKeepOpcode(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.Enable = true
+	config.Filtering.KeepOpcodes = []string{"UPDATE"}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	filtering.LoadOpcodes()
+	filtering.LoadActiveFilters()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.OpcodeName = "UPDATE"
+	if filtering.CheckIfDrop(&dm) == true {
+		t.Errorf("dns update should not be dropped!")
+	}
+
+	dm.DNS.OpcodeName = "QUERY"
+	if filtering.CheckIfDrop(&dm) == false {
+		t.Errorf("dns query should be dropped")
+	}
+}
+
 func TestFilteringByKeepQueryIp(t *testing.T) {
 	// config
 	config := dnsutils.GetFakeConfigTransformers()
@@ -465,3 +517,118 @@ func TestFilteringMultipleFilters(t *testing.T) {
 		t.Errorf("dns query should be dropped!")
 	}
 }
+
+func TestFilteringByDropZone(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.Enable = true
+	config.Filtering.DropZones = []string{"Example.com."}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	filtering.LoadZones()
+	filtering.LoadActiveFilters()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Zone = &dnsutils.DnsZone{Name: "example.com"}
+	if filtering.CheckIfDrop(&dm) == false {
+		t.Errorf("dns update of example.com should be dropped")
+	}
+
+	dm.DNS.Zone.Name = "example.org"
+	if filtering.CheckIfDrop(&dm) == true {
+		t.Errorf("dns update of example.org should not be dropped!")
+	}
+
+	dm.DNS.Zone = nil
+	if filtering.CheckIfDrop(&dm) == true {
+		t.Errorf("dns query should not be dropped!")
+	}
+}
+
+func TestFilteringByKeepZone(t *testing.T) {
+	// config
+	config := dnsutils.GetFakeConfigTransformers()
+	config.Filtering.Enable = true
+	config.Filtering.KeepZones = []string{"example.com"}
+
+	log := logger.New(false)
+	outChans := []chan dnsutils.DnsMessage{}
+
+	// init subproccesor
+	filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+	filtering.LoadZones()
+	filtering.LoadActiveFilters()
+
+	dm := dnsutils.GetFakeDnsMessage()
+	dm.DNS.Zone = &dnsutils.DnsZone{Name: "example.com"}
+	if filtering.CheckIfDrop(&dm) == true {
+		t.Errorf("dns update of example.com should not be dropped!")
+	}
+
+	dm.DNS.Zone.Name = "example.org"
+	if filtering.CheckIfDrop(&dm) == false {
+		t.Errorf("dns update of example.org should be dropped")
+	}
+
+	dm.DNS.Zone = nil
+	if filtering.CheckIfDrop(&dm) == false {
+		t.Errorf("dns query should be dropped")
+	}
+}
+
+func TestFilteringByUpdates(t *testing.T) {
+	update := dnsutils.DnsZone{
+		Name: "example.com",
+		Prerequisites: []dnsutils.DnsUpdateRecord{
+			{Operation: "name-not-in-use", Name: "host1.example.com", Class: "NONE", Rdatatype: "ANY", Rdata: "-"},
+		},
+		Updates: []dnsutils.DnsUpdateRecord{
+			{Operation: "add", Name: "host1.example.com", Ttl: 300, Class: "IN", Rdatatype: "A", Rdata: "192.0.2.1"},
+		},
+	}
+
+	testcases := []struct {
+		name        string
+		dropUpdates []string
+		keepUpdates []string
+		zone        *dnsutils.DnsZone
+		drop        bool
+	}{
+		{name: "drop matching update", dropUpdates: []string{`^add \S+ \d+ IN A `}, zone: &update, drop: true},
+		{name: "drop matching prerequisite", dropUpdates: []string{`^name-not-in-use `}, zone: &update, drop: true},
+		{name: "drop other update", dropUpdates: []string{`^add \S+ \d+ IN AAAA `}, zone: &update, drop: false},
+		{name: "drop without update", dropUpdates: []string{`.*`}, zone: nil, drop: false},
+		{name: "keep matching update", keepUpdates: []string{`192\.0\.2\.1$`}, zone: &update, drop: false},
+		{name: "keep other update", keepUpdates: []string{`^delete-rr `}, zone: &update, drop: true},
+		{name: "keep without update", keepUpdates: []string{`.*`}, zone: nil, drop: true},
+		{name: "invalid regex ignored", dropUpdates: []string{`(`, `^add `}, zone: &update, drop: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			// config
+			config := dnsutils.GetFakeConfigTransformers()
+			config.Filtering.Enable = true
+			config.Filtering.DropUpdates = tc.dropUpdates
+			config.Filtering.KeepUpdates = tc.keepUpdates
+
+			log := logger.New(false)
+			outChans := []chan dnsutils.DnsMessage{}
+
+			// init subproccesor
+			filtering := NewFilteringProcessor(config, logger.New(false), "test", 0, outChans, log.Info, log.Error)
+			filtering.LoadZones()
+			filtering.LoadActiveFilters()
+
+			dm := dnsutils.GetFakeDnsMessage()
+			dm.DNS.Zone = tc.zone
+			if drop := filtering.CheckIfDrop(&dm); drop != tc.drop {
+				t.Errorf("want drop %v, got %v", tc.drop, drop)
+			}
+		})
+	}
+}
@@ -117,6 +117,8 @@ func (p *Transforms) Prepare() error {
 		p.LogInfo(prefixlog + "enabled")
 
 		p.FilteringTransform.LoadRcodes()
+		p.FilteringTransform.LoadOpcodes()
+		p.FilteringTransform.LoadZones()
 		p.FilteringTransform.LoadDomainsList()
 		p.FilteringTransform.LoadQueryIpList()
 		p.FilteringTransform.LoadrDataIpList()
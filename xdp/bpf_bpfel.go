@@ -1,6 +1,5 @@
 // Code generated by bpf2go; DO NOT EDIT.
-//go:build 386 || amd64 || amd64p32 || arm || arm64 || mips64le || mips64p32le || mipsle || ppc64le || riscv64
-// +build 386 amd64 amd64p32 arm arm64 mips64le mips64p32le mipsle ppc64le riscv64
+//go:build 386 || amd64 || amd64p32 || arm || arm64 || loong64 || mips64le || mips64p32le || mipsle || ppc64le || riscv64
 
 package xdp
 
@@ -76,7 +75,9 @@ type bpfProgramSpecs struct {
 //
 // It can be passed ebpf.CollectionSpec.Assign.
 type bpfMapSpecs struct {
-	Pkts *ebpf.MapSpec `ebpf:"pkts"`
+	Pkts  *ebpf.MapSpec `ebpf:"pkts"`
+	Ports *ebpf.MapSpec `ebpf:"ports"`
+	Stats *ebpf.MapSpec `ebpf:"stats"`
 }
 
 // bpfObjects contains all objects after they have been loaded into the kernel.
@@ -98,12 +99,16 @@ func (o *BpfObjects) Close() error {
 //
 // It can be passed to loadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
 type bpfMaps struct {
-	Pkts *ebpf.Map `ebpf:"pkts"`
+	Pkts  *ebpf.Map `ebpf:"pkts"`
+	Ports *ebpf.Map `ebpf:"ports"`
+	Stats *ebpf.Map `ebpf:"stats"`
 }
 
 func (m *bpfMaps) Close() error {
 	return _BpfClose(
 		m.Pkts,
+		m.Ports,
+		m.Stats,
 	)
 }
 
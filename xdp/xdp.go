@@ -1,3 +1,13 @@
 package xdp
 
 //go:generate go run github.com/cilium/ebpf/cmd/bpf2go -type pkt_event bpf xdp_dns_kern.c -- -I./headers
+
+// indexes of the per-cpu counters in the stats map
+const (
+	StatsSeen uint32 = iota
+	StatsMatched
+	StatsLost
+)
+
+// MaxPorts is the size of the map of the dns ports
+const MaxPorts = 64
@@ -1,4 +1,3 @@
-
 //go:build exclude
 
 #include "vmlinux.h"
@@ -9,6 +8,29 @@ char __license[] SEC("license") = "Dual MIT/GPL";
 
 #define ETH_P_IP 0x0800
 #define ETH_P_IPV6 0x86DD
+#define ETH_P_8021Q 0x8100
+#define ETH_P_8021AD 0x88A8
+#define ETH_P_QINQ 0x9100
+
+#define IP_OFFSET 0x1FFF
+#define IP6_OFFSET 0xFFF8
+
+// ipv6 extension headers
+#define NEXTHDR_HOP 0
+#define NEXTHDR_ROUTING 43
+#define NEXTHDR_FRAGMENT 44
+#define NEXTHDR_AUTH 51
+#define NEXTHDR_DEST 60
+
+#define MAX_VLAN_TAGS 2
+#define MAX_IPV6_EXT_HEADERS 6
+#define MAX_DNS_PORTS 64
+
+// per-cpu counters
+#define STATS_SEEN 0
+#define STATS_MATCHED 1
+#define STATS_LOST 2
+#define STATS_MAX 3
 
 // packet_info
 struct pkt_event {
@@ -35,6 +57,32 @@ struct {
   __uint(max_entries, 4);
 } pkts SEC(".maps");
 
+// dns ports to capture, provided by the collector
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__type(key, __u16);
+	__type(value, __u8);
+	__uint(max_entries, MAX_DNS_PORTS);
+} ports SEC(".maps");
+
+// packets seen, matched and lost by the perf buffer
+struct {
+	__uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+	__type(key, __u32);
+	__type(value, __u64);
+	__uint(max_entries, STATS_MAX);
+} stats SEC(".maps");
+
+static __always_inline void count(__u32 index) {
+    __u64 *value = bpf_map_lookup_elem(&stats, &index);
+    if (value)
+        *value += 1;
+}
+
+static __always_inline int is_dns_port(__u16 port) {
+    return bpf_map_lookup_elem(&ports, &port) != NULL;
+}
+
 SEC("xdp")
 int xdp_sniffer(struct xdp_md *ctx) {
     void *data_end = (void *)(long)ctx->data_end;
@@ -43,10 +91,12 @@ int xdp_sniffer(struct xdp_md *ctx) {
     __u32 offset = sizeof(struct ethhdr);
 
     struct pkt_event pkt = {};
-    pkt.timestamp = bpf_ktime_get_ns(); 
+    pkt.timestamp = bpf_ktime_get_ns();
     pkt.pkt_len = data_end - data;
     pkt.pkt_offset = sizeof(struct pkt_event);
 
+    count(STATS_SEEN);
+
     // enough data to read ethernet header ?
     if (data + offset > data_end)
         return XDP_PASS;
@@ -55,7 +105,22 @@ int xdp_sniffer(struct xdp_md *ctx) {
     struct ethhdr  *eth  = data;
     pkt.ip_version = bpf_htons(eth->h_proto);
 
-    // handle only IPv4 or IPv6 traffic 
+    // skip the 802.1Q and 802.1ad tags
+    #pragma unroll
+    for (int i = 0; i < MAX_VLAN_TAGS; i++) {
+        if (pkt.ip_version != ETH_P_8021Q && pkt.ip_version != ETH_P_8021AD && pkt.ip_version != ETH_P_QINQ)
+            break;
+
+        if (data + offset + sizeof(struct vlan_hdr) > data_end)
+            return XDP_PASS;
+
+        struct vlan_hdr *vlan = data + offset;
+        pkt.ip_version = bpf_htons(vlan->h_vlan_encapsulated_proto);
+
+        offset += sizeof(struct vlan_hdr);
+    }
+
+    // handle only IPv4 or IPv6 traffic
     if (pkt.ip_version != ETH_P_IP &&  pkt.ip_version != ETH_P_IPV6)
         return XDP_PASS;
 
@@ -65,11 +130,18 @@ int xdp_sniffer(struct xdp_md *ctx) {
             return XDP_PASS;
 
         struct iphdr   *ip4h   = (data + offset);
+
+        // ignore the fragments without transport header
+        if (ip4h->frag_off & bpf_htons(IP_OFFSET))
+            return XDP_PASS;
+        if (ip4h->ihl < 5)
+            return XDP_PASS;
+
         pkt.ip_proto = ip4h->protocol;
         pkt.src_addr = bpf_htonl(ip4h->saddr);
         pkt.dst_addr = bpf_htonl(ip4h->daddr);
 
-        offset += sizeof(struct iphdr);
+        offset += ip4h->ihl * 4;
     }
 
     // IPv6 - get L4 protocol
@@ -84,10 +156,38 @@ int xdp_sniffer(struct xdp_md *ctx) {
 
         __builtin_memcpy(pkt.src_addr6, ip6h->saddr.in6_u.u6_addr32, sizeof(pkt.src_addr6));
         __builtin_memcpy(pkt.dst_addr6, ip6h->daddr.in6_u.u6_addr32, sizeof(pkt.dst_addr6));
+
+        // walk the extension headers until the L4 protocol
+        #pragma unroll
+        for (int i = 0; i < MAX_IPV6_EXT_HEADERS; i++) {
+            if (pkt.ip_proto != NEXTHDR_HOP && pkt.ip_proto != NEXTHDR_ROUTING && pkt.ip_proto != NEXTHDR_DEST &&
+                pkt.ip_proto != NEXTHDR_FRAGMENT && pkt.ip_proto != NEXTHDR_AUTH)
+                break;
+
+            if (data + offset + sizeof(struct frag_hdr) > data_end)
+                return XDP_PASS;
+
+            struct ipv6_opt_hdr *opt = data + offset;
+            __u8 nexthdr = opt->nexthdr;
+
+            if (pkt.ip_proto == NEXTHDR_FRAGMENT) {
+                // ignore the fragments without transport header
+                struct frag_hdr *frag = data + offset;
+                if (frag->frag_off & bpf_htons(IP6_OFFSET))
+                    return XDP_PASS;
+                offset += sizeof(struct frag_hdr);
+            } else if (pkt.ip_proto == NEXTHDR_AUTH) {
+                offset += (opt->hdrlen + 2) * 4;
+            } else {
+                offset += (opt->hdrlen + 1) * 8;
+            }
+
+            pkt.ip_proto = nexthdr;
+        }
     }
 
-    // handle only UDP or TCP traffic 
-    if (pkt.ip_proto != IPPROTO_UDP &&  pkt.ip_proto != IPPROTO_TCP) 
+    // handle only UDP or TCP traffic
+    if (pkt.ip_proto != IPPROTO_UDP &&  pkt.ip_proto != IPPROTO_TCP)
         return XDP_PASS;
 
     // TCP - get destination and source port
@@ -122,13 +222,18 @@ int xdp_sniffer(struct xdp_md *ctx) {
     }
 
     // handle only dns packet
-    if ( pkt.src_port != 53 && pkt.dst_port != 53)
+    if (!is_dns_port(pkt.src_port) && !is_dns_port(pkt.dst_port))
         return XDP_PASS;
 
+    count(STATS_MATCHED);
+
     pkt.payload_offset = offset;
     // write data in perf event
-    int ret = bpf_perf_event_output(ctx, &pkts, 
-                        BPF_F_CURRENT_CPU | ((__u64)pkt.pkt_len << 32), 
+    int ret = bpf_perf_event_output(ctx, &pkts,
+                        BPF_F_CURRENT_CPU | ((__u64)pkt.pkt_len << 32),
                         &pkt, sizeof(pkt));
+    if (ret < 0)
+        count(STATS_LOST);
+
     return XDP_PASS;
-}
\ No newline at end of file
+}
@@ -0,0 +1,205 @@
+//go:build linux
+// +build linux
+
+package xdp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	xdpPass = 2
+)
+
+var (
+	dnsQuery = []byte{
+		0xd4, 0x3f, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x64,
+		0x6e, 0x73, 0x09, 0x63, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x00, 0x00,
+		0x01, 0x00, 0x01,
+	}
+	mac = net.HardwareAddr{0x00, 0x0c, 0x29, 0x8a, 0x5d, 0xd7}
+)
+
+func udpHeader(srcPort, dstPort uint16) []byte {
+	h := make([]byte, 8)
+	binary.BigEndian.PutUint16(h[0:], srcPort)
+	binary.BigEndian.PutUint16(h[2:], dstPort)
+	binary.BigEndian.PutUint16(h[4:], uint16(8+len(dnsQuery)))
+	return h
+}
+
+func serialize(t *testing.T, l ...gopacket.SerializableLayer) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, l...); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func ipv4Packet(t *testing.T, ethernet []gopacket.SerializableLayer, fragOffset uint16, srcPort, dstPort uint16) []byte {
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP, FragOffset: fragOffset,
+		SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 53}}
+	return serialize(t, append(ethernet, ip, gopacket.Payload(append(udpHeader(srcPort, dstPort), dnsQuery...)))...)
+}
+
+func ipv6Packet(t *testing.T, ethernet []gopacket.SerializableLayer, nextHeader layers.IPProtocol, extensions []byte) []byte {
+	ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: nextHeader,
+		SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::53")}
+	payload := append(append(extensions, udpHeader(36000, 53)...), dnsQuery...)
+	return serialize(t, append(ethernet, ip, gopacket.Payload(payload))...)
+}
+
+func sumStats(t *testing.T, m *ebpf.Map, index uint32) uint64 {
+	var values []uint64
+	if err := m.Lookup(index, &values); err != nil {
+		t.Fatal(err)
+	}
+	var total uint64
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+func TestXdpSniffer_Decode(t *testing.T) {
+	objs := BpfObjects{}
+	if err := LoadBpfObjects(&objs, nil); err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skip("loading the bpf program requires privileges: ", err)
+		}
+		t.Fatal(err)
+	}
+	defer objs.Close()
+
+	for _, port := range []uint16{53, 5353} {
+		if err := objs.Ports.Put(port, uint8(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader, err := perf.NewReader(objs.Pkts, os.Getpagesize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	// the perf buffer of the current cpu is used, the program runs on the first one
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var cpus unix.CPUSet
+	cpus.Set(0)
+	if err := unix.SchedSetaffinity(0, &cpus); err != nil {
+		t.Fatal(err)
+	}
+
+	ethernet := func(etherType layers.EthernetType) []gopacket.SerializableLayer {
+		return []gopacket.SerializableLayer{&layers.Ethernet{SrcMAC: mac, DstMAC: mac, EthernetType: etherType}}
+	}
+	qinq := []gopacket.SerializableLayer{
+		&layers.Ethernet{SrcMAC: mac, DstMAC: mac, EthernetType: layers.EthernetTypeQinQ},
+		&layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeDot1Q},
+		&layers.Dot1Q{VLANIdentifier: 200, Type: layers.EthernetTypeIPv4},
+	}
+	vlan6 := []gopacket.SerializableLayer{
+		&layers.Ethernet{SrcMAC: mac, DstMAC: mac, EthernetType: layers.EthernetTypeDot1Q},
+		&layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeIPv6},
+	}
+
+	// hop-by-hop and destination options headers with padding
+	hopByHop := []byte{byte(layers.IPProtocolIPv6Destination), 0, 1, 4, 0, 0, 0, 0}
+	destination := []byte{byte(layers.IPProtocolUDP), 0, 1, 4, 0, 0, 0, 0}
+	// first and second fragments
+	firstFragment := []byte{byte(layers.IPProtocolUDP), 0, 0x00, 0x01, 0, 0, 0, 1}
+	nextFragment := []byte{byte(layers.IPProtocolUDP), 0, 0x00, 0x10, 0, 0, 0, 1}
+
+	testcases := []struct {
+		name          string
+		packet        []byte
+		match         bool
+		ipVersion     uint16
+		payloadOffset uint16
+	}{
+		{"ipv4", ipv4Packet(t, ethernet(layers.EthernetTypeIPv4), 0, 36000, 53), true, 0x0800, 14 + 20 + 8},
+		{"ipv4 second port", ipv4Packet(t, ethernet(layers.EthernetTypeIPv4), 0, 5353, 36000), true, 0x0800, 14 + 20 + 8},
+		{"ipv4 other port", ipv4Packet(t, ethernet(layers.EthernetTypeIPv4), 0, 36000, 123), false, 0, 0},
+		{"ipv4 fragment", ipv4Packet(t, ethernet(layers.EthernetTypeIPv4), 10, 36000, 53), false, 0, 0},
+		{"qinq", ipv4Packet(t, qinq, 0, 36000, 53), true, 0x0800, 14 + 8 + 20 + 8},
+		{"ipv6 extensions", ipv6Packet(t, vlan6, layers.IPProtocolIPv6HopByHop, append(hopByHop, destination...)), true, 0x86dd, 14 + 4 + 40 + 16 + 8},
+		{"ipv6 first fragment", ipv6Packet(t, ethernet(layers.EthernetTypeIPv6), layers.IPProtocolIPv6Fragment, firstFragment), true, 0x86dd, 14 + 40 + 8 + 8},
+		{"ipv6 next fragment", ipv6Packet(t, ethernet(layers.EthernetTypeIPv6), layers.IPProtocolIPv6Fragment, nextFragment), false, 0, 0},
+	}
+
+	matched := uint64(0)
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ret, _, err := objs.XdpSniffer.Test(tc.packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ret != xdpPass {
+				t.Errorf("want XDP_PASS, got %d", ret)
+			}
+			if !tc.match {
+				return
+			}
+			matched++
+
+			reader.SetDeadline(time.Now().Add(time.Second))
+			record, err := reader.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var pkt BpfPktEvent
+			if err := binary.Read(bytes.NewReader(record.RawSample), binary.LittleEndian, &pkt); err != nil {
+				t.Fatal(err)
+			}
+			if pkt.IpVersion != tc.ipVersion || pkt.IpProto != 17 {
+				t.Errorf("invalid ip version %x or protocol %d", pkt.IpVersion, pkt.IpProto)
+			}
+			if pkt.PayloadOffset != tc.payloadOffset {
+				t.Errorf("want payload offset %d, got %d", tc.payloadOffset, pkt.PayloadOffset)
+			}
+			if tc.ipVersion == 0x0800 && (pkt.SrcAddr != 0x0a000001 || pkt.DstAddr != 0x0a000035) {
+				t.Errorf("invalid ipv4 addresses %x -> %x", pkt.SrcAddr, pkt.DstAddr)
+			}
+			if tc.ipVersion == 0x86dd {
+				addr := make([]byte, 16)
+				for i, v := range pkt.SrcAddr6 {
+					binary.LittleEndian.PutUint32(addr[i*4:], v)
+				}
+				if !net.IP(addr).Equal(net.ParseIP("2001:db8::1")) {
+					t.Errorf("invalid ipv6 source address %s", net.IP(addr))
+				}
+			}
+
+			payload := record.RawSample[int(pkt.PktOffset)+int(pkt.PayloadOffset):]
+			if !bytes.HasPrefix(payload, dnsQuery) {
+				t.Errorf("invalid dns payload: %x", payload)
+			}
+		})
+	}
+
+	if seen := sumStats(t, objs.Stats, StatsSeen); seen != uint64(len(testcases)) {
+		t.Errorf("want %d packets seen, got %d", len(testcases), seen)
+	}
+	if n := sumStats(t, objs.Stats, StatsMatched); n != matched {
+		t.Errorf("want %d packets matched, got %d", matched, n)
+	}
+	if lost := sumStats(t, objs.Stats, StatsLost); lost != 0 {
+		t.Errorf("want no packet lost, got %d", lost)
+	}
+}